@@ -216,6 +216,10 @@ func (tc *typeChecker) checkRule(env *TypeEnv, rule *Rule) {
 }
 
 func (tc *typeChecker) checkExpr(env *TypeEnv, expr *Expr) *Error {
+	if err := tc.checkExprWith(env, expr); err != nil {
+		return err
+	}
+
 	if !expr.IsCall() {
 		return nil
 	}
@@ -228,6 +232,33 @@ func (tc *typeChecker) checkExpr(env *TypeEnv, expr *Expr) *Error {
 	return tc.checkExprBuiltin(env, expr)
 }
 
+// checkExprWith ensures that functions replaced by with modifiers are replaced
+// by functions of the same arity.
+func (tc *typeChecker) checkExprWith(env *TypeEnv, expr *Expr) *Error {
+	for _, w := range expr.With {
+		target, ok := w.Target.Value.(Ref)
+		if !ok {
+			continue
+		}
+		tf, ok := env.Get(target).(*types.Function)
+		if !ok {
+			continue
+		}
+		value, ok := w.Value.Value.(Ref)
+		if !ok {
+			continue
+		}
+		vf, ok := env.Get(value).(*types.Function)
+		if !ok {
+			continue
+		}
+		if len(tf.Args()) != len(vf.Args()) {
+			return NewError(TypeErr, w.Location, "arity mismatch: %v has arity %d, %v has arity %d", value, len(vf.Args()), target, len(tf.Args()))
+		}
+	}
+	return nil
+}
+
 func (tc *typeChecker) checkExprBuiltin(env *TypeEnv, expr *Expr) *Error {
 
 	args := expr.Operands()
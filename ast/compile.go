@@ -814,6 +814,7 @@ func (c *Compiler) checkBodySafety(safe VarSet, m *Module, b Body) Body {
 var SafetyCheckVisitorParams = VarVisitorParams{
 	SkipRefCallHead: true,
 	SkipClosures:    true,
+	SkipWithTarget:  true,
 }
 
 // checkSafetyRuleHeads ensures that variables appearing in the head of a
@@ -2173,7 +2174,16 @@ func reorderBodyForSafety(builtins map[string]*Builtin, arity func(Ref) int, glo
 	safe := VarSet{}
 
 	for _, e := range body {
-		for v := range e.Vars(SafetyCheckVisitorParams) {
+		vars := e.Vars(SafetyCheckVisitorParams)
+
+		// Built-in functions used as with modifier values are not variables.
+		for _, with := range e.With {
+			if ref, ok := with.Value.Value.(Ref); ok && builtins[ref.String()] != nil {
+				delete(vars, ref[0].Value.(Var))
+			}
+		}
+
+		for v := range vars {
 			if globals.Contains(v) {
 				safe.Add(v)
 			} else {
@@ -2384,10 +2394,14 @@ func outputVarsForExpr(expr *Expr, getArity func(Ref) int, safe VarSet) VarSet {
 		return VarSet{}
 	}
 
-	// With modifier inputs must be safe.
+	// With modifier inputs must be safe. Function references used as values
+	// are resolved by the evaluator.
 	for _, with := range expr.With {
+		if ref, ok := with.Value.Value.(Ref); ok && getArity(ref) >= 0 {
+			continue
+		}
 		unsafe := false
-		WalkVars(with, func(v Var) bool {
+		WalkVars(with.Value, func(v Var) bool {
 			if !safe.Contains(v) {
 				unsafe = true
 				return true
@@ -3577,11 +3591,17 @@ func rewriteWithModifier(c *Compiler, f *equalityFactory, expr *Expr) ([]*Expr,
 
 	var result []*Expr
 	for i := range expr.With {
-		err := validateTarget(c, expr.With[i].Target)
+		isFunc, err := validateWith(c, expr, i)
 		if err != nil {
 			return nil, err
 		}
 
+		// Function references used as replacements for other functions are
+		// resolved by the evaluator and must not be rewritten.
+		if isFunc && isFunctionRef(c, expr.With[i].Value) {
+			continue
+		}
+
 		if requiresEval(expr.With[i].Value) {
 			eq := f.Generate(expr.With[i].Value)
 			result = append(result, eq)
@@ -3598,20 +3618,35 @@ func rewriteWithModifier(c *Compiler, f *equalityFactory, expr *Expr) ([]*Expr,
 	return result, nil
 }
 
-func validateTarget(c *Compiler, term *Term) *Error {
-	if !isInputRef(term) && !isDataRef(term) {
-		return NewError(TypeErr, term.Location, "with keyword target must start with %v or %v", InputRootDocument, DefaultRootDocument)
+// validateWith checks the target of the i-th with modifier on expr. If the
+// target refers to a built-in or user-defined function, validateWith returns
+// true. Built-in function names used as targets or values are normalized to
+// refs so that later stages do not treat them as variables.
+func validateWith(c *Compiler, expr *Expr, i int) (bool, *Error) {
+
+	target := expr.With[i].Target
+
+	if isBuiltinName(c, target) {
+		expr.With[i].Target = builtinRefTerm(target)
+		if isBuiltinName(c, expr.With[i].Value) {
+			expr.With[i].Value = builtinRefTerm(expr.With[i].Value)
+		}
+		return true, nil
 	}
 
-	if isDataRef(term) {
-		ref := term.Value.(Ref)
+	if !isInputRef(target) && !isDataRef(target) {
+		return false, NewError(TypeErr, target.Location, "with keyword target must reference existing %v, %v, or a function", InputRootDocument, DefaultRootDocument)
+	}
+
+	if isDataRef(target) {
+		ref := target.Value.(Ref)
 		node := c.RuleTree
 		for i := 0; i < len(ref)-1; i++ {
 			child := node.Child(ref[i].Value)
 			if child == nil {
 				break
 			} else if len(child.Values) > 0 {
-				return NewError(CompileErr, term.Loc(), "with keyword cannot partially replace virtual document(s)")
+				return false, NewError(CompileErr, target.Loc(), "with keyword cannot partially replace virtual document(s)")
 			}
 			node = child
 		}
@@ -3620,14 +3655,55 @@ func validateTarget(c *Compiler, term *Term) *Error {
 			if child := node.Child(ref[len(ref)-1].Value); child != nil {
 				for _, value := range child.Values {
 					if len(value.(*Rule).Head.Args) > 0 {
-						return NewError(CompileErr, term.Loc(), "with keyword cannot replace functions")
+						if isBuiltinName(c, expr.With[i].Value) {
+							expr.With[i].Value = builtinRefTerm(expr.With[i].Value)
+						}
+						return true, nil
 					}
 				}
 			}
 		}
+	}
+
+	return false, nil
+}
+
+// isBuiltinName returns true if term is a var or ref that names a built-in
+// function known to the compiler.
+func isBuiltinName(c *Compiler, term *Term) bool {
+	switch v := term.Value.(type) {
+	case Var:
+		_, ok := c.builtins[v.String()]
+		return ok
+	case Ref:
+		if _, ok := v[0].Value.(Var); !ok || RootDocumentNames.Contains(v[0]) {
+			return false
+		}
+		_, ok := c.builtins[v.String()]
+		return ok
+	}
+	return false
+}
 
+func builtinRefTerm(term *Term) *Term {
+	if v, ok := term.Value.(Var); ok {
+		return &Term{Value: Ref{VarTerm(string(v))}, Location: term.Location}
 	}
-	return nil
+	return term
+}
+
+// isFunctionRef returns true if term is a ref to a built-in or user-defined
+// function.
+func isFunctionRef(c *Compiler, term *Term) bool {
+	ref, ok := term.Value.(Ref)
+	if !ok {
+		return false
+	}
+	if isDataRef(term) {
+		rules := c.GetRulesExact(ref)
+		return len(rules) > 0 && len(rules[0].Head.Args) > 0
+	}
+	return isBuiltinName(c, term)
 }
 
 func isInputRef(term *Term) bool {
@@ -3713,6 +3789,13 @@ func checkUnsafeBuiltins(unsafeBuiltinsMap map[string]struct{}, node interface{}
 				errs = append(errs, NewError(TypeErr, x.Loc(), "unsafe built-in function calls in expression: %v", operator))
 			}
 		}
+		for _, w := range x.With {
+			if ref, ok := w.Value.Value.(Ref); ok {
+				if _, ok := unsafeBuiltinsMap[ref.String()]; ok {
+					errs = append(errs, NewError(TypeErr, w.Loc(), "unsafe built-in function replacement in with modifier: %v", ref))
+				}
+			}
+		}
 		return false
 	})
 	return errs
@@ -2506,7 +2506,22 @@ func TestCompilerRewriteWithValue(t *testing.T) {
 		{
 			note:    "invalid target",
 			input:   `p { true with foo.q as 1 }`,
-			wantErr: fmt.Errorf("rego_type_error: with keyword target must start with input or data"),
+			wantErr: fmt.Errorf("rego_type_error: with keyword target must reference existing input, data, or a function"),
+		},
+		{
+			note:     "builtin function target",
+			input:    `p { true with http.send as {"body": arr} }`,
+			expected: `p { __local0__ = {"body": data.test.arr}; true with http.send as __local0__ }`,
+		},
+		{
+			note:     "function value not rewritten",
+			input:    "p { true with data.test.f as g }\nf(x) = x\ng(x) = x",
+			expected: `p { true with data.test.f as data.test.g }`,
+		},
+		{
+			note:     "function value rewritten",
+			input:    "p { true with data.test.f as arr[0] }\nf(x) = x",
+			expected: `p { __local1__ = data.test.arr[0]; true with data.test.f as __local1__ }`,
 		},
 	}
 
@@ -2531,18 +2546,74 @@ func TestCompilerRewriteWithValue(t *testing.T) {
 }
 
 func TestCompilerMockFunction(t *testing.T) {
-	c := NewCompiler()
-	c.Modules["test"] = MustParseModule(`
-	package test
+	tests := []struct {
+		note    string
+		module  string
+		wantErr string
+	}{
+		{
+			note: "function with constant",
+			module: `package test
+			is_allowed(label) { label == "test_label" }
+			p { is_allowed("x") with is_allowed as true }`,
+		},
+		{
+			note: "function with function",
+			module: `package test
+			is_allowed(label) { label == "test_label" }
+			mock_is_allowed(label) = true
+			p { is_allowed("x") with data.test.is_allowed as mock_is_allowed }`,
+		},
+		{
+			note: "builtin with function",
+			module: `package test
+			mock_send(req) = {"status_code": 200}
+			p { http.send({}).status_code == 200 with http.send as mock_send }`,
+		},
+		{
+			note: "builtin with builtin",
+			module: `package test
+			p { count([1, 2]) == 3 with count as sum }`,
+		},
+		{
+			note: "function arity mismatch",
+			module: `package test
+			f(x) = x
+			g(x, y) = x
+			p { f(1) with f as g }`,
+			wantErr: "rego_type_error: arity mismatch: data.test.g has arity 2, data.test.f has arity 1",
+		},
+		{
+			note: "builtin arity mismatch",
+			module: `package test
+			p { count([1]) with count as concat }`,
+			wantErr: "rego_type_error: arity mismatch: concat has arity 2, count has arity 1",
+		},
+	}
 
-	is_allowed(label) {
-	    label == "test_label"
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			c := NewCompiler()
+			c.Modules["test"] = MustParseModule(tc.module)
+			c.Compile(c.Modules)
+			if tc.wantErr == "" {
+				assertNotFailed(t, c)
+			} else {
+				assertCompilerErrorStrings(t, c, []string{tc.wantErr})
+			}
+		})
 	}
+}
 
-	p {true with data.test.is_allowed as "blah" }
-	`)
-	compileStages(c, c.rewriteWithModifiers)
-	assertCompilerErrorStrings(t, c, []string{"rego_compile_error: with keyword cannot replace functions"})
+func TestCompilerMockUnsafeBuiltin(t *testing.T) {
+	c := NewCompiler().WithUnsafeBuiltins(map[string]struct{}{"http.send": {}})
+	c.Compile(map[string]*Module{
+		"test": MustParseModule(`package test
+		p { count([1]) with count as http.send }`),
+	})
+	assertCompilerErrorStrings(t, c, []string{
+		"rego_type_error: unsafe built-in function replacement in with modifier: http.send",
+	})
 }
 
 func TestCompilerMockVirtualDocumentPartially(t *testing.T) {
@@ -3723,7 +3794,7 @@ func TestQueryCompiler(t *testing.T) {
 			q:        "x = 1 with foo.p as null",
 			pkg:      "",
 			imports:  nil,
-			expected: fmt.Errorf("1 error occurred: 1:12: rego_type_error: with keyword target must start with input or data"),
+			expected: fmt.Errorf("1 error occurred: 1:12: rego_type_error: with keyword target must reference existing input, data, or a function"),
 		},
 		{
 			note:     "rewrite with value",
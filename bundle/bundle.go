@@ -34,6 +34,7 @@ import (
 const (
 	RegoExt           = ".rego"
 	WasmFile          = "/policy.wasm"
+	PlanFile          = "/plan.json"
 	ManifestExt       = ".manifest"
 	SignaturesFile    = "signatures.json"
 	dataFile          = "data.json"
@@ -49,6 +50,7 @@ type Bundle struct {
 	Data       map[string]interface{}
 	Modules    []ModuleFile
 	Wasm       []byte
+	Plan       []byte
 }
 
 // SignaturesConfig represents an array of JWTs that encapsulate the signatures for the bundle.
@@ -355,6 +357,9 @@ func (r *Reader) Read() (Bundle, error) {
 		} else if path == WasmFile {
 			bundle.Wasm = buf.Bytes()
 
+		} else if path == PlanFile {
+			bundle.Plan = buf.Bytes()
+
 		} else if filepath.Base(path) == dataFile {
 			var value interface{}
 
@@ -545,6 +550,10 @@ func (w *Writer) Write(bundle Bundle) error {
 		return err
 	}
 
+	if err := writePlan(tw, bundle); err != nil {
+		return err
+	}
+
 	if err := writeManifest(tw, bundle); err != nil {
 		return err
 	}
@@ -568,6 +577,14 @@ func writeWasm(tw *tar.Writer, bundle Bundle) error {
 	return archive.WriteFile(tw, WasmFile, bundle.Wasm)
 }
 
+func writePlan(tw *tar.Writer, bundle Bundle) error {
+	if len(bundle.Plan) == 0 {
+		return nil
+	}
+
+	return archive.WriteFile(tw, PlanFile, bundle.Plan)
+}
+
 func writeManifest(tw *tar.Writer, bundle Bundle) error {
 
 	var buf bytes.Buffer
@@ -593,7 +610,7 @@ func writeSignatures(tw *tar.Writer, bundle Bundle) error {
 	return archive.WriteFile(tw, fmt.Sprintf(".%v", SignaturesFile), bs)
 }
 
-func hashBundleFiles(hash SignatureHasher, data map[string]interface{}, manifest Manifest, wasm []byte) ([]FileInfo, error) {
+func hashBundleFiles(hash SignatureHasher, data map[string]interface{}, manifest Manifest, wasm, plan []byte) ([]FileInfo, error) {
 
 	files := []FileInfo{}
 
@@ -611,6 +628,14 @@ func hashBundleFiles(hash SignatureHasher, data map[string]interface{}, manifest
 		files = append(files, NewFile(strings.TrimPrefix(WasmFile, "/"), hex.EncodeToString(bytes), defaultHashingAlg))
 	}
 
+	if len(plan) != 0 {
+		bytes, err := hash.HashFile(plan)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, NewFile(strings.TrimPrefix(PlanFile, "/"), hex.EncodeToString(bytes), defaultHashingAlg))
+	}
+
 	bytes, err = hash.HashFile(manifest)
 	if err != nil {
 		return files, err
@@ -669,7 +694,7 @@ func (b *Bundle) GenerateSignature(signingConfig *SigningConfig, keyID string, u
 		files = append(files, NewFile(strings.TrimPrefix(path, "/"), hex.EncodeToString(bytes), defaultHashingAlg))
 	}
 
-	result, err := hashBundleFiles(hash, b.Data, b.Manifest, b.Wasm)
+	result, err := hashBundleFiles(hash, b.Data, b.Manifest, b.Wasm, b.Plan)
 	if err != nil {
 		return err
 	}
@@ -730,8 +755,11 @@ func (b Bundle) Equal(other Bundle) bool {
 	if (b.Wasm == nil && other.Wasm != nil) || (b.Wasm != nil && other.Wasm == nil) {
 		return false
 	}
+	if (b.Plan == nil && other.Plan != nil) || (b.Plan != nil && other.Plan == nil) {
+		return false
+	}
 
-	return bytes.Equal(b.Wasm, other.Wasm)
+	return bytes.Equal(b.Wasm, other.Wasm) && bytes.Equal(b.Plan, other.Plan)
 }
 
 // Copy returns a deep copy of the bundle.
@@ -867,6 +895,10 @@ func Merge(bundles []*Bundle) (*Bundle, error) {
 			return nil, errors.New("wasm bundles cannot be merged")
 		}
 
+		if len(b.Plan) > 0 {
+			return nil, errors.New("plan bundles cannot be merged")
+		}
+
 		result.Modules = append(result.Modules, b.Modules...)
 
 		for _, root := range *b.Manifest.Roots {
@@ -43,6 +43,7 @@ func testReadBundle(t *testing.T, baseDir string) {
 		{"/a/b/y/data.yaml", `foo: 1`},
 		{"/example/example.rego", `package example`},
 		{"/policy.wasm", `modules-compiled-as-wasm-binary`},
+		{"/plan.json", `{"version": 1}`},
 		{"/data.json", `{"x": {"y": true}, "a": {"b": {"z": true}}}}`},
 	}
 
@@ -87,6 +88,7 @@ func testReadBundle(t *testing.T, baseDir string) {
 			},
 		},
 		Wasm: []byte("modules-compiled-as-wasm-binary"),
+		Plan: []byte(`{"version": 1}`),
 	}
 
 	if !exp.Equal(bundle) {
@@ -173,7 +175,7 @@ func TestReadWithSignatures(t *testing.T) {
 		"no_signatures": {
 			[][2]string{{"/.signatures.json", `{"signatures": []}`}},
 			NewVerificationConfig(map[string]*KeyConfig{}, "", "", nil),
-			true, fmt.Errorf(".signatures.json: missing JWT (expected exactly one)"),
+			true, fmt.Errorf(".signatures.json: missing JWT (expected at least one)"),
 		},
 		"digest_mismatch": {
 			[][2]string{
@@ -670,6 +672,7 @@ func TestRoundtrip(t *testing.T) {
 			},
 		},
 		Wasm: []byte("modules-compiled-as-wasm-binary"),
+		Plan: []byte(`{"version": 1}`),
 		Manifest: Manifest{
 			Revision: "quickbrownfaux",
 		},
@@ -871,18 +874,20 @@ func TestHashBundleFiles(t *testing.T) {
 		data     map[string]interface{}
 		manifest Manifest
 		wasm     []byte
+		plan     []byte
 		exp      int
 	}{
-		"no_content":                 {map[string]interface{}{}, Manifest{}, []byte{}, 2},
-		"data":                       {map[string]interface{}{"foo": "bar"}, Manifest{}, []byte{}, 2},
-		"data_and_manifest":          {map[string]interface{}{"foo": "bar"}, Manifest{Revision: "quickbrownfaux"}, []byte{}, 2},
-		"data_and_manifest_and_wasm": {map[string]interface{}{"foo": "bar"}, Manifest{Revision: "quickbrownfaux"}, []byte("modules-compiled-as-wasm-binary"), 3},
+		"no_content":                 {map[string]interface{}{}, Manifest{}, []byte{}, nil, 2},
+		"data":                       {map[string]interface{}{"foo": "bar"}, Manifest{}, []byte{}, nil, 2},
+		"data_and_manifest":          {map[string]interface{}{"foo": "bar"}, Manifest{Revision: "quickbrownfaux"}, []byte{}, nil, 2},
+		"data_and_manifest_and_wasm": {map[string]interface{}{"foo": "bar"}, Manifest{Revision: "quickbrownfaux"}, []byte("modules-compiled-as-wasm-binary"), nil, 3},
+		"data_and_manifest_and_plan": {map[string]interface{}{"foo": "bar"}, Manifest{Revision: "quickbrownfaux"}, nil, []byte(`{"version": 1}`), 3},
 	}
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 
-			f, err := hashBundleFiles(h, tc.data, tc.manifest, tc.wasm)
+			f, err := hashBundleFiles(h, tc.data, tc.manifest, tc.wasm, tc.plan)
 			if err != nil {
 				t.Fatal("Unexpected error:", err)
 			}
@@ -1090,6 +1095,19 @@ func TestMerge(t *testing.T) {
 			},
 			wantErr: errors.New("wasm bundles cannot be merged"),
 		},
+		{
+			note: "plan merge error",
+			bundles: []*Bundle{
+				{
+					Manifest: Manifest{Roots: &[]string{"a"}},
+					Plan:     []byte(`{"version": 1}`),
+				},
+				{
+					Manifest: Manifest{Roots: &[]string{"b"}},
+				},
+			},
+			wantErr: errors.New("plan bundles cannot be merged"),
+		},
 		{
 			note: "merge policy",
 			bundles: []*Bundle{
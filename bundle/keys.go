@@ -22,12 +22,16 @@ const (
 	defaultTokenSigningAlg = "RS256"
 )
 
-// VerificationConfig represents the key configuration used to verify a signed bundle
+// VerificationConfig represents the key configuration used to verify a signed bundle.
+// Signers lists the ids of the keys that must have signed the bundle and Threshold
+// is the minimum number of distinct keys that must have signed the bundle.
 type VerificationConfig struct {
 	PublicKeys map[string]*KeyConfig
 	KeyID      string   `json:"keyid"`
 	Scope      string   `json:"scope"`
 	Exclude    []string `json:"exclude_files"`
+	Signers    []string `json:"signers"`
+	Threshold  int      `json:"threshold"`
 }
 
 // NewVerificationConfig return a new VerificationConfig
@@ -57,6 +61,20 @@ func (vc *VerificationConfig) ValidateAndInjectDefaults(keys map[string]*KeyConf
 			return fmt.Errorf("key id %s not found", vc.KeyID)
 		}
 	}
+
+	for _, signer := range vc.Signers {
+		if _, ok := keys[signer]; !ok {
+			return fmt.Errorf("signer key id %s not found", signer)
+		}
+	}
+
+	if vc.Threshold < 0 {
+		return fmt.Errorf("signature threshold must be non-negative")
+	}
+
+	if vc.Threshold > len(keys) {
+		return fmt.Errorf("signature threshold %d exceeds the number of keys (%d)", vc.Threshold, len(keys))
+	}
 	return nil
 }
 
@@ -37,6 +37,26 @@ func TestValidateAndInjectDefaultsVerificationConfig(t *testing.T) {
 			NewVerificationConfig(map[string]*KeyConfig{"foo": {Key: "secret", Algorithm: "HS256"}}, "bar", "", nil),
 			true, fmt.Errorf("key id bar not found"),
 		},
+		"valid_config_with_signers_and_threshold": {
+			map[string]*KeyConfig{"foo": {Key: "secret", Algorithm: "HS256"}, "bar": {Key: "secret", Algorithm: "HS256"}},
+			&VerificationConfig{Signers: []string{"foo", "bar"}, Threshold: 2},
+			false, nil,
+		},
+		"signer_not_found": {
+			map[string]*KeyConfig{"foo": {Key: "secret", Algorithm: "HS256"}},
+			&VerificationConfig{Signers: []string{"foo", "bar"}},
+			true, fmt.Errorf("signer key id bar not found"),
+		},
+		"threshold_exceeds_keys": {
+			map[string]*KeyConfig{"foo": {Key: "secret", Algorithm: "HS256"}},
+			&VerificationConfig{Threshold: 2},
+			true, fmt.Errorf("signature threshold 2 exceeds the number of keys (1)"),
+		},
+		"negative_threshold": {
+			map[string]*KeyConfig{"foo": {Key: "secret", Algorithm: "HS256"}},
+			&VerificationConfig{Threshold: -1},
+			true, fmt.Errorf("signature threshold must be non-negative"),
+		},
 	}
 
 	for name, tc := range tests {
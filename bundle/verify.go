@@ -17,20 +17,21 @@ import (
 	"github.com/open-policy-agent/opa/util"
 )
 
-// VerifyBundleSignature verifies the bundle signature using the given public keys or secret.
-// If a signature is verified, it keeps track of the files specified in the JWT payload
+// VerifyBundleSignature verifies the bundle signatures using the given public keys or secret.
+// Every signature must be valid and all signatures must cover the same files. If the
+// verification config lists required signers or a threshold, the signatures of those
+// signers or the number of distinct signers are checked as well. If the signatures are
+// verified, it keeps track of the files specified in the JWT payloads
 func VerifyBundleSignature(sc SignaturesConfig, bvc *VerificationConfig) (map[string]FileInfo, error) {
 	files := map[string]FileInfo{}
 
 	if len(sc.Signatures) == 0 {
-		return files, fmt.Errorf(".signatures.json: missing JWT (expected exactly one)")
+		return files, fmt.Errorf(".signatures.json: missing JWT (expected at least one)")
 	}
 
-	if len(sc.Signatures) > 1 {
-		return files, fmt.Errorf(".signatures.json: multiple JWTs not supported (expected exactly one)")
-	}
+	signers := map[string]struct{}{}
 
-	for _, token := range sc.Signatures {
+	for i, token := range sc.Signatures {
 
 		// decode JWT to check if the payload specifies the key to use for JWT signature verification
 		parts, err := jws.SplitCompact(token)
@@ -51,27 +52,78 @@ func VerifyBundleSignature(sc SignaturesConfig, bvc *VerificationConfig) (map[st
 			return files, err
 		}
 
+		// with multiple signatures, the key id in the OPA config cannot apply to
+		// all of them so the key id in the JWT payload takes precedence
+		vc := bvc
+		if len(sc.Signatures) > 1 && jpl.KeyID != "" {
+			cpy := *bvc
+			cpy.KeyID = ""
+			vc = &cpy
+		}
+
 		// verify the JWT signature
-		err = verifyJWTSignature(token, jpl, bvc)
+		err = verifyJWTSignature(token, jpl, vc)
 		if err != nil {
 			return files, err
 		}
 
+		keyID := signatureKeyID(jpl, vc)
+		if _, ok := signers[keyID]; ok {
+			return files, fmt.Errorf(".signatures.json: multiple JWTs signed with key %v", keyID)
+		}
+		signers[keyID] = struct{}{}
+
 		// build the map of file names to their info
-		for _, file := range jpl.Files {
-			files[file.Name] = file
+		if i == 0 {
+			for _, file := range jpl.Files {
+				files[file.Name] = file
+			}
+		} else if err := compareSignedFiles(files, jpl.Files); err != nil {
+			return files, err
 		}
 	}
+
+	for _, signer := range bvc.Signers {
+		if _, ok := signers[signer]; !ok {
+			return files, fmt.Errorf(".signatures.json: missing JWT signed with required key %v", signer)
+		}
+	}
+
+	if len(signers) < bvc.Threshold {
+		return files, fmt.Errorf(".signatures.json: %v JWT(s) signed with distinct keys (expected at least %v)", len(signers), bvc.Threshold)
+	}
+
 	return files, nil
 }
 
+// compareSignedFiles checks that the files specified in a JWT payload match the
+// files specified in the previously verified payloads.
+func compareSignedFiles(files map[string]FileInfo, other []FileInfo) error {
+	if len(other) != len(files) {
+		return fmt.Errorf(".signatures.json: JWTs do not sign the same files")
+	}
+
+	for _, file := range other {
+		if files[file.Name] != file {
+			return fmt.Errorf(".signatures.json: JWTs do not sign the same files (mismatch for %v)", file.Name)
+		}
+	}
+	return nil
+}
+
+// signatureKeyID returns the id of the key to use for JWT signature verification.
+// The key id in the OPA config takes precedence over the one in the JWT payload.
+func signatureKeyID(payload DecodedSignature, bvc *VerificationConfig) string {
+	if bvc.KeyID != "" {
+		return bvc.KeyID
+	}
+	return payload.KeyID
+}
+
 func verifyJWTSignature(token string, payload DecodedSignature, bvc *VerificationConfig) error {
 	// check for the id of the key to use for JWT signature verification
 	// first in the OPA config. If not found, then check the JWT payload
-	keyID := bvc.KeyID
-	if keyID == "" {
-		keyID = payload.KeyID
-	}
+	keyID := signatureKeyID(payload, bvc)
 
 	if keyID == "" {
 		return fmt.Errorf("verification key ID is empty")
@@ -6,19 +6,27 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
+	"runtime"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 
+	fileurl "github.com/open-policy-agent/opa/internal/file/url"
 	"github.com/open-policy-agent/opa/internal/presentation"
 
+	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/util"
@@ -29,22 +37,34 @@ import (
 // ones compatible with running a benchmark.
 type benchmarkCommandParams struct {
 	evalCommandParams
-	benchMem bool
-	count    int
+	benchMem     bool
+	count        int
+	inputsDir    string
+	benchTime    time.Duration
+	baseline     string
+	saveBaseline string
+	threshold    float64
 }
 
 const (
 	benchmarkGoBenchOutput = "gobench"
 )
 
+// Inputs whose median latency exceeds the median latency of all evaluations
+// by this factor are reported as outliers.
+const benchmarkOutlierFactor = 2
+
 func newBenchmarkEvalParams() benchmarkCommandParams {
 	return benchmarkCommandParams{
+		benchTime: time.Second,
+		threshold: 10,
 		evalCommandParams: evalCommandParams{
 			outputFormat: util.NewEnumFlag(evalPrettyOutput, []string{
 				evalJSONOutput,
 				evalPrettyOutput,
 				benchmarkGoBenchOutput,
 			}),
+			profileFormat: util.NewEnumFlag(profileFormatReport, []string{profileFormatReport, profileFormatPprof, profileFormatFolded}),
 		},
 	}
 }
@@ -68,10 +88,38 @@ Example with bundle and input data:
 To enable more detailed analysis use the --metrics and --benchmem flags.
 
 The optional "gobench" output format conforms to the Go Benchmark Data Format.
+
+Input Corpus
+------------
+
+The --inputs flag benchmarks the query against every JSON or YAML file in a
+directory. The inputs are evaluated in turn for the duration set by --benchtime
+and the latency of every evaluation is recorded. The results contain the
+median (p50), p90 and p99 latency and the allocations per evaluation for each
+input and for all inputs. Inputs whose median latency is more than twice the
+median latency of all evaluations are reported as outliers.
+
+	opa bench -b ./policy-bundle --inputs ./inputs 'data.authz.allow'
+
+The JSON results can be saved as a baseline with --save-baseline. If --baseline
+is set, the p50, p90 and p99 latency and the allocations of all inputs are
+compared with the baseline and the command fails if any of them regressed by
+more than --threshold percent:
+
+	opa bench -b ./policy-bundle --inputs ./inputs --save-baseline baseline.json 'data.authz.allow'
+	opa bench -b ./policy-bundle --inputs ./inputs --baseline baseline.json --threshold 20 'data.authz.allow'
+
+Signatures of bundles loaded with -b are verified if --verification-key is set,
+see 'opa eval --help' for details:
+
+	opa bench -b bundle.tar.gz --verification-key /path/to/public_key.pem 'data.authz.allow'
 `,
 
 		PreRunE: func(_ *cobra.Command, args []string) error {
-			return validateEvalParams(&params.evalCommandParams, args)
+			if err := validateEvalParams(&params.evalCommandParams, args); err != nil {
+				return err
+			}
+			return validateBenchParams(&params)
 		},
 		Run: func(_ *cobra.Command, args []string) {
 			os.Exit(benchMain(args, params, os.Stdout, &goBenchRunner{}))
@@ -91,13 +139,49 @@ The optional "gobench" output format conforms to the Go Benchmark Data Format.
 	addOutputFormat(benchCommand.Flags(), params.outputFormat)
 	addIgnoreFlag(benchCommand.Flags(), &params.ignore)
 
+	// bundle verification config
+	addVerificationKeyFlag(benchCommand.Flags(), &params.pubKey)
+	addVerificationKeyIDFlag(benchCommand.Flags(), &params.pubKeyID, defaultPublicKeyID)
+	addSigningAlgFlag(benchCommand.Flags(), &params.algorithm, defaultTokenSigningAlg)
+	addBundleVerificationScopeFlag(benchCommand.Flags(), &params.scope)
+	addBundleVerificationExcludeFilesFlag(benchCommand.Flags(), &params.excludeVerifyFiles)
+
 	// Shared benchmark flags
 	addCountFlag(benchCommand.Flags(), &params.count, "benchmark")
 	addBenchmemFlag(benchCommand.Flags(), &params.benchMem, true)
 
+	// Input corpus flags
+	benchCommand.Flags().StringVarP(&params.inputsDir, "inputs", "", "", "benchmark each input file (JSON or YAML) in the directory")
+	benchCommand.Flags().DurationVarP(&params.benchTime, "benchtime", "", params.benchTime, "duration of the benchmark with --inputs")
+	benchCommand.Flags().StringVarP(&params.baseline, "baseline", "", "", "compare the results with the baseline file written by --save-baseline")
+	benchCommand.Flags().StringVarP(&params.saveBaseline, "save-baseline", "", "", "write the results to the baseline file")
+	benchCommand.Flags().Float64VarP(&params.threshold, "threshold", "", params.threshold, "maximum regression compared to the baseline in percent")
+
 	RootCommand.AddCommand(benchCommand)
 }
 
+func validateBenchParams(p *benchmarkCommandParams) error {
+	if p.inputsDir == "" {
+		if p.baseline != "" || p.saveBaseline != "" {
+			return errors.New("specify --inputs to use --baseline or --save-baseline")
+		}
+		return nil
+	}
+	if p.inputPath != "" || p.stdinInput {
+		return errors.New("specify --inputs or --input/--stdin-input but not both")
+	}
+	if p.count != 1 {
+		return errors.New("specify --inputs or --count but not both")
+	}
+	if p.benchTime <= 0 {
+		return errors.New("--benchtime must be positive")
+	}
+	if p.threshold < 0 {
+		return errors.New("--threshold must not be negative")
+	}
+	return nil
+}
+
 type benchRunner interface {
 	run(ctx context.Context, ectx *evalContext, pq rego.PreparedEvalQuery, params benchmarkCommandParams) (testing.BenchmarkResult, error)
 }
@@ -118,6 +202,10 @@ func benchMain(args []string, params benchmarkCommandParams, w io.Writer, r benc
 		return 1
 	}
 
+	if params.inputsDir != "" {
+		return benchCorpusMain(ctx, ectx, pq, params, w)
+	}
+
 	// Run the benchmark as many times as specified, re-use the prepared objects for each
 	for i := 0; i < params.count; i++ {
 		br, err := r.run(ctx, ectx, pq, params)
@@ -297,3 +385,396 @@ func prettyFormatFloat(x float64) string {
 	}
 	return fmt.Sprintf(format, x)
 }
+
+// benchmarkStats contains the latency percentiles and allocations of a set of
+// evaluations. Latencies are in nanoseconds.
+type benchmarkStats struct {
+	Samples     int     `json:"samples"`
+	Mean        float64 `json:"mean_ns"`
+	P50         int64   `json:"p50_ns"`
+	P90         int64   `json:"p90_ns"`
+	P99         int64   `json:"p99_ns"`
+	AllocsPerOp int64   `json:"allocs_per_op,omitempty"`
+	BytesPerOp  int64   `json:"bytes_per_op,omitempty"`
+}
+
+type benchmarkInputStats struct {
+	Input string `json:"input"`
+	benchmarkStats
+}
+
+// benchmarkComparison compares a metric with the baseline. Change is the
+// relative change in percent.
+type benchmarkComparison struct {
+	Metric     string  `json:"metric"`
+	Baseline   float64 `json:"baseline"`
+	Current    float64 `json:"current"`
+	Change     float64 `json:"change"`
+	Regression bool    `json:"regression"`
+}
+
+// corpusBenchmarkResult is the result of benchmarking an input corpus. The
+// same format is used for baseline files.
+type corpusBenchmarkResult struct {
+	Summary    benchmarkStats        `json:"summary"`
+	Inputs     []benchmarkInputStats `json:"inputs"`
+	Outliers   []string              `json:"outliers,omitempty"`
+	Comparison []benchmarkComparison `json:"comparison,omitempty"`
+}
+
+type benchmarkInput struct {
+	name  string
+	value ast.Value
+}
+
+func benchCorpusMain(ctx context.Context, ectx *evalContext, pq rego.PreparedEvalQuery, params benchmarkCommandParams, w io.Writer) int {
+
+	inputs, err := readBenchmarkInputs(params.inputsDir)
+	if err != nil {
+		renderBenchmarkError(params, err, w)
+		return 1
+	}
+
+	var baseline *corpusBenchmarkResult
+
+	if params.baseline != "" {
+		if baseline, err = readBenchmarkBaseline(params.baseline); err != nil {
+			renderBenchmarkError(params, err, w)
+			return 1
+		}
+	}
+
+	result, err := runCorpusBenchmark(ctx, ectx, pq, params, inputs)
+	if err != nil {
+		renderBenchmarkError(params, err, w)
+		return 1
+	}
+
+	if params.saveBaseline != "" {
+		bs, err := json.MarshalIndent(result, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(params.saveBaseline, bs, 0644)
+		}
+		if err != nil {
+			renderBenchmarkError(params, err, w)
+			return 1
+		}
+	}
+
+	if baseline != nil {
+		result.Comparison = compareBenchmarks(baseline.Summary, result.Summary, params.threshold)
+	}
+
+	renderCorpusBenchmarkResult(params, result, w)
+
+	for _, c := range result.Comparison {
+		if c.Regression {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// readBenchmarkInputs returns the JSON and YAML files in the directory sorted
+// by name.
+func readBenchmarkInputs(dir string) ([]benchmarkInput, error) {
+
+	dir, err := fileurl.Clean(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var inputs []benchmarkInput
+
+	for _, info := range infos {
+
+		switch filepath.Ext(info.Name()) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+
+		if info.IsDir() {
+			continue
+		}
+
+		bs, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var x interface{}
+		if err := util.Unmarshal(bs, &x); err != nil {
+			return nil, fmt.Errorf("unable to parse input %v: %v", info.Name(), err)
+		}
+
+		value, err := ast.InterfaceToValue(x)
+		if err != nil {
+			return nil, fmt.Errorf("unable to process input %v: %v", info.Name(), err)
+		}
+
+		inputs = append(inputs, benchmarkInput{name: info.Name(), value: value})
+	}
+
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no JSON or YAML input files found in %v", dir)
+	}
+
+	return inputs, nil
+}
+
+func readBenchmarkBaseline(path string) (*corpusBenchmarkResult, error) {
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var baseline corpusBenchmarkResult
+
+	if err := util.UnmarshalJSON(bs, &baseline); err != nil {
+		return nil, fmt.Errorf("unable to parse baseline %v: %v", path, err)
+	}
+
+	return &baseline, nil
+}
+
+// runCorpusBenchmark evaluates the inputs in turn until the benchmark time has
+// elapsed and records the latency of each evaluation. Allocations are
+// measured separately so that reading the memory statistics does not affect
+// the latencies.
+func runCorpusBenchmark(ctx context.Context, ectx *evalContext, pq rego.PreparedEvalQuery, params benchmarkCommandParams, inputs []benchmarkInput) (*corpusBenchmarkResult, error) {
+
+	eval := func(input benchmarkInput) error {
+		rs, err := pq.Eval(ctx, append(ectx.evalArgs, rego.EvalParsedInput(input.value))...)
+		if err != nil {
+			return fmt.Errorf("%v: %v", input.name, err)
+		}
+		if len(rs) == 0 && params.fail {
+			return fmt.Errorf("%v: undefined result", input.name)
+		}
+		return nil
+	}
+
+	// Evaluate each input once to report errors before the benchmark starts
+	// and to warm up caches.
+	for _, input := range inputs {
+		if err := eval(input); err != nil {
+			return nil, err
+		}
+	}
+
+	samples := make([][]int64, len(inputs))
+	start := time.Now()
+
+	for time.Since(start) < params.benchTime {
+		for i, input := range inputs {
+			t0 := time.Now()
+			if err := eval(input); err != nil {
+				return nil, err
+			}
+			samples[i] = append(samples[i], time.Since(t0).Nanoseconds())
+		}
+	}
+
+	result := &corpusBenchmarkResult{
+		Inputs: make([]benchmarkInputStats, len(inputs)),
+	}
+
+	var all []int64
+
+	for i, input := range inputs {
+		all = append(all, samples[i]...)
+		result.Inputs[i] = benchmarkInputStats{
+			Input:          input.name,
+			benchmarkStats: newBenchmarkStats(samples[i]),
+		}
+	}
+
+	result.Summary = newBenchmarkStats(all)
+
+	if params.benchMem {
+
+		var allocs, bytes int64
+		var before, after runtime.MemStats
+
+		for i, input := range inputs {
+
+			n := len(samples[i])
+			if n > 100 {
+				n = 100
+			}
+
+			runtime.ReadMemStats(&before)
+
+			for j := 0; j < n; j++ {
+				if err := eval(input); err != nil {
+					return nil, err
+				}
+			}
+
+			runtime.ReadMemStats(&after)
+
+			result.Inputs[i].AllocsPerOp = int64(after.Mallocs-before.Mallocs) / int64(n)
+			result.Inputs[i].BytesPerOp = int64(after.TotalAlloc-before.TotalAlloc) / int64(n)
+			allocs += result.Inputs[i].AllocsPerOp
+			bytes += result.Inputs[i].BytesPerOp
+		}
+
+		result.Summary.AllocsPerOp = allocs / int64(len(inputs))
+		result.Summary.BytesPerOp = bytes / int64(len(inputs))
+	}
+
+	for _, input := range result.Inputs {
+		if input.P50 > benchmarkOutlierFactor*result.Summary.P50 {
+			result.Outliers = append(result.Outliers, input.Input)
+		}
+	}
+
+	return result, nil
+}
+
+// newBenchmarkStats returns the stats of the latencies. Percentiles are
+// computed with the nearest-rank method.
+func newBenchmarkStats(samples []int64) benchmarkStats {
+
+	stats := benchmarkStats{Samples: len(samples)}
+
+	if len(samples) == 0 {
+		return stats
+	}
+
+	sorted := make([]int64, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum float64
+	for _, x := range sorted {
+		sum += float64(x)
+	}
+
+	percentile := func(p float64) int64 {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		if rank < 1 {
+			rank = 1
+		}
+		return sorted[rank-1]
+	}
+
+	stats.Mean = sum / float64(len(sorted))
+	stats.P50 = percentile(50)
+	stats.P90 = percentile(90)
+	stats.P99 = percentile(99)
+
+	return stats
+}
+
+// compareBenchmarks compares the current stats with the baseline. Metrics that
+// increased by more than the threshold (in percent) are regressions. Metrics
+// that are not set in the baseline are not compared.
+func compareBenchmarks(baseline, current benchmarkStats, threshold float64) []benchmarkComparison {
+
+	metrics := []struct {
+		name              string
+		baseline, current int64
+	}{
+		{"p50 ns/op", baseline.P50, current.P50},
+		{"p90 ns/op", baseline.P90, current.P90},
+		{"p99 ns/op", baseline.P99, current.P99},
+		{"allocs/op", baseline.AllocsPerOp, current.AllocsPerOp},
+		{"B/op", baseline.BytesPerOp, current.BytesPerOp},
+	}
+
+	var result []benchmarkComparison
+
+	for _, m := range metrics {
+
+		if m.baseline <= 0 {
+			continue
+		}
+
+		change := (float64(m.current) - float64(m.baseline)) / float64(m.baseline) * 100
+
+		result = append(result, benchmarkComparison{
+			Metric:     m.name,
+			Baseline:   float64(m.baseline),
+			Current:    float64(m.current),
+			Change:     change,
+			Regression: change > threshold,
+		})
+	}
+
+	return result
+}
+
+func renderCorpusBenchmarkResult(params benchmarkCommandParams, result *corpusBenchmarkResult, w io.Writer) {
+	switch params.outputFormat.String() {
+	case evalJSONOutput:
+		presentation.JSON(w, result)
+	case benchmarkGoBenchOutput:
+		for _, input := range result.Inputs {
+			fmt.Fprintf(w, "BenchmarkOPAEval/input=%s\t%8d\t%s ns/op\t%d p50-ns/op\t%d p90-ns/op\t%d p99-ns/op",
+				strings.Replace(input.Input, " ", "_", -1), input.Samples, prettyFormatFloat(input.Mean), input.P50, input.P90, input.P99)
+			if params.benchMem {
+				fmt.Fprintf(w, "\t%8d B/op\t%8d allocs/op", input.BytesPerOp, input.AllocsPerOp)
+			}
+			fmt.Fprintf(w, "\n")
+		}
+	default:
+		data := [][]string{
+			{"inputs", fmt.Sprintf("%d", len(result.Inputs))},
+			{"samples", fmt.Sprintf("%d", result.Summary.Samples)},
+			{"mean ns/op", prettyFormatFloat(result.Summary.Mean)},
+			{"p50 ns/op", fmt.Sprintf("%d", result.Summary.P50)},
+			{"p90 ns/op", fmt.Sprintf("%d", result.Summary.P90)},
+			{"p99 ns/op", fmt.Sprintf("%d", result.Summary.P99)},
+		}
+		if params.benchMem {
+			data = append(data, []string{
+				"B/op", fmt.Sprintf("%d", result.Summary.BytesPerOp),
+			}, []string{
+				"allocs/op", fmt.Sprintf("%d", result.Summary.AllocsPerOp),
+			})
+		}
+
+		table := tablewriter.NewWriter(w)
+		table.AppendBulk(data)
+		table.Render()
+
+		if len(result.Outliers) > 0 {
+			outliers := map[string]struct{}{}
+			for _, name := range result.Outliers {
+				outliers[name] = struct{}{}
+			}
+			fmt.Fprintf(w, "\nOutliers:\n")
+			table := tablewriter.NewWriter(w)
+			table.SetHeader([]string{"input", "samples", "p50 ns/op", "p99 ns/op", "allocs/op"})
+			for _, input := range result.Inputs {
+				if _, ok := outliers[input.Input]; ok {
+					table.Append([]string{input.Input, fmt.Sprintf("%d", input.Samples), fmt.Sprintf("%d", input.P50), fmt.Sprintf("%d", input.P99), fmt.Sprintf("%d", input.AllocsPerOp)})
+				}
+			}
+			table.Render()
+		}
+
+		if len(result.Comparison) > 0 {
+			fmt.Fprintf(w, "\nBaseline comparison (threshold %v%%):\n", params.threshold)
+			table := tablewriter.NewWriter(w)
+			table.SetHeader([]string{"metric", "baseline", "current", "change", ""})
+			for _, c := range result.Comparison {
+				status := "ok"
+				if c.Regression {
+					status = "REGRESSION"
+				}
+				table.Append([]string{c.Metric, fmt.Sprintf("%.0f", c.Baseline), fmt.Sprintf("%.0f", c.Current), fmt.Sprintf("%+.1f%%", c.Change), status})
+			}
+			table.Render()
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/bundle"
@@ -105,6 +106,14 @@ func TestBenchMainErrPreparing(t *testing.T) {
 	}
 }
 
+func TestBenchValidateDefaultParams(t *testing.T) {
+	params := newBenchmarkEvalParams()
+
+	if err := validateEvalParams(&params.evalCommandParams, []string{"1+1"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
 func TestBenchMainErrRunningBenchmark(t *testing.T) {
 	params := testBenchParams()
 	args := []string{"1+1"}
@@ -528,3 +537,289 @@ func fakeBenchResults() testing.BenchmarkResult {
 		},
 	}
 }
+
+func TestBenchMainWithInputs(t *testing.T) {
+	params := testBenchParams()
+	params.benchTime = 10 * time.Millisecond
+
+	files := map[string]string{
+		"/inputs/a.json":    `{"x": 1}`,
+		"/inputs/b.yaml":    `x: 2`,
+		"/inputs/README.md": `ignored`,
+	}
+
+	test.WithTempFS(files, func(path string) {
+		params.inputsDir = filepath.Join(path, "inputs")
+		params.saveBaseline = filepath.Join(path, "baseline.json")
+
+		var buf bytes.Buffer
+
+		rc := benchMain([]string{"input.x > 0"}, params, &buf, &mockBenchRunner{})
+		if rc != 0 {
+			t.Fatalf("Unexpected return code %d, expected 0. Output:\n%s", rc, buf.String())
+		}
+
+		var result corpusBenchmarkResult
+		if err := util.UnmarshalJSON(buf.Bytes(), &result); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if len(result.Inputs) != 2 || result.Inputs[0].Input != "a.json" || result.Inputs[1].Input != "b.yaml" {
+			t.Fatalf("Unexpected inputs: %+v", result.Inputs)
+		}
+
+		if result.Summary.Samples == 0 || result.Summary.Samples != result.Inputs[0].Samples+result.Inputs[1].Samples {
+			t.Fatalf("Unexpected summary: %+v", result.Summary)
+		}
+
+		if result.Summary.P50 <= 0 || result.Summary.P50 > result.Summary.P99 || result.Summary.AllocsPerOp <= 0 {
+			t.Fatalf("Unexpected summary: %+v", result.Summary)
+		}
+
+		baseline, err := readBenchmarkBaseline(params.saveBaseline)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if len(baseline.Inputs) != 2 {
+			t.Fatalf("Unexpected baseline: %+v", baseline)
+		}
+	})
+}
+
+func TestBenchMainWithInputsUndefined(t *testing.T) {
+	params := testBenchParams()
+	params.benchTime = 10 * time.Millisecond
+	params.fail = true
+
+	files := map[string]string{
+		"/inputs/a.json": `{"x": 1}`,
+		"/inputs/b.json": `{"y": 1}`,
+	}
+
+	test.WithTempFS(files, func(path string) {
+		params.inputsDir = filepath.Join(path, "inputs")
+
+		var buf bytes.Buffer
+
+		rc := benchMain([]string{"input.x"}, params, &buf, &mockBenchRunner{})
+		if rc != 1 {
+			t.Fatalf("Unexpected return code %d, expected 1", rc)
+		}
+
+		if !strings.Contains(buf.String(), "b.json: undefined result") {
+			t.Fatalf("Expected undefined result error but got:\n%s", buf.String())
+		}
+	})
+}
+
+func TestBenchMainWithInputsBaseline(t *testing.T) {
+
+	tests := []struct {
+		note     string
+		baseline string
+		exp      int
+	}{
+		{
+			note:     "pass",
+			baseline: `{"summary": {"p50_ns": 1000000000, "p90_ns": 1000000000, "p99_ns": 1000000000}}`,
+			exp:      0,
+		},
+		{
+			note:     "regression",
+			baseline: `{"summary": {"p50_ns": 1, "p90_ns": 1000000000, "p99_ns": 1000000000}}`,
+			exp:      1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			params := testBenchParams()
+			params.benchTime = 10 * time.Millisecond
+			params.benchMem = false
+
+			files := map[string]string{
+				"/inputs/a.json": `{"x": 1}`,
+				"/baseline.json": tc.baseline,
+			}
+
+			test.WithTempFS(files, func(path string) {
+				params.inputsDir = filepath.Join(path, "inputs")
+				params.baseline = filepath.Join(path, "baseline.json")
+
+				var buf bytes.Buffer
+
+				rc := benchMain([]string{"input.x > 0"}, params, &buf, &mockBenchRunner{})
+				if rc != tc.exp {
+					t.Fatalf("Unexpected return code %d, expected %d. Output:\n%s", rc, tc.exp, buf.String())
+				}
+
+				var result corpusBenchmarkResult
+				if err := util.UnmarshalJSON(buf.Bytes(), &result); err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+
+				if len(result.Comparison) != 3 || result.Comparison[0].Regression != (tc.exp == 1) {
+					t.Fatalf("Unexpected comparison: %+v", result.Comparison)
+				}
+			})
+		})
+	}
+}
+
+func TestBenchMainWithEmptyInputs(t *testing.T) {
+	params := testBenchParams()
+
+	files := map[string]string{
+		"/inputs/README.md": `ignored`,
+	}
+
+	test.WithTempFS(files, func(path string) {
+		params.inputsDir = filepath.Join(path, "inputs")
+
+		var buf bytes.Buffer
+
+		rc := benchMain([]string{"1 + 1"}, params, &buf, &mockBenchRunner{})
+		if rc != 1 {
+			t.Fatalf("Unexpected return code %d, expected 1", rc)
+		}
+	})
+}
+
+func TestValidateBenchParams(t *testing.T) {
+
+	tests := []struct {
+		note   string
+		modify func(*benchmarkCommandParams)
+		exp    string
+	}{
+		{
+			note:   "baseline without inputs",
+			modify: func(p *benchmarkCommandParams) { p.baseline = "baseline.json" },
+			exp:    "specify --inputs to use --baseline or --save-baseline",
+		},
+		{
+			note: "inputs and input",
+			modify: func(p *benchmarkCommandParams) {
+				p.inputsDir = "inputs"
+				p.inputPath = "input.json"
+			},
+			exp: "specify --inputs or --input/--stdin-input but not both",
+		},
+		{
+			note: "inputs and count",
+			modify: func(p *benchmarkCommandParams) {
+				p.inputsDir = "inputs"
+				p.count = 3
+			},
+			exp: "specify --inputs or --count but not both",
+		},
+		{
+			note: "negative threshold",
+			modify: func(p *benchmarkCommandParams) {
+				p.inputsDir = "inputs"
+				p.threshold = -1
+			},
+			exp: "--threshold must not be negative",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			params := testBenchParams()
+			tc.modify(&params)
+			err := validateBenchParams(&params)
+			if err == nil || err.Error() != tc.exp {
+				t.Fatalf("Expected error %q but got %v", tc.exp, err)
+			}
+		})
+	}
+}
+
+func TestNewBenchmarkStats(t *testing.T) {
+
+	var samples []int64
+	for i := 100; i > 0; i-- {
+		samples = append(samples, int64(i))
+	}
+
+	stats := newBenchmarkStats(samples)
+
+	if stats.Samples != 100 || stats.P50 != 50 || stats.P90 != 90 || stats.P99 != 99 || stats.Mean != 50.5 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	stats = newBenchmarkStats([]int64{7})
+
+	if stats.P50 != 7 || stats.P99 != 7 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestCompareBenchmarks(t *testing.T) {
+
+	baseline := benchmarkStats{P50: 100, P90: 200, P99: 300, AllocsPerOp: 10}
+	current := benchmarkStats{P50: 110, P90: 221, P99: 150, AllocsPerOp: 10, BytesPerOp: 1000}
+
+	result := compareBenchmarks(baseline, current, 10)
+
+	exp := map[string]bool{
+		"p50 ns/op": false,
+		"p90 ns/op": true,
+		"p99 ns/op": false,
+		"allocs/op": false,
+	}
+
+	if len(result) != len(exp) {
+		t.Fatalf("Unexpected comparison: %+v", result)
+	}
+
+	for _, c := range result {
+		if regression, ok := exp[c.Metric]; !ok || regression != c.Regression {
+			t.Fatalf("Unexpected comparison for %v: %+v", c.Metric, c)
+		}
+	}
+}
+
+func TestRenderCorpusBenchmarkResult(t *testing.T) {
+
+	result := &corpusBenchmarkResult{
+		Summary: benchmarkStats{Samples: 3, Mean: 200, P50: 100, P90: 400, P99: 400, AllocsPerOp: 10, BytesPerOp: 100},
+		Inputs: []benchmarkInputStats{
+			{Input: "a.json", benchmarkStats: benchmarkStats{Samples: 2, Mean: 100, P50: 100, P90: 100, P99: 100, AllocsPerOp: 10, BytesPerOp: 100}},
+			{Input: "b.json", benchmarkStats: benchmarkStats{Samples: 1, Mean: 400, P50: 400, P90: 400, P99: 400, AllocsPerOp: 10, BytesPerOp: 100}},
+		},
+		Outliers: []string{"b.json"},
+		Comparison: []benchmarkComparison{
+			{Metric: "p50 ns/op", Baseline: 50, Current: 100, Change: 100, Regression: true},
+		},
+	}
+
+	params := testBenchParams()
+	params.outputFormat.Set(benchmarkGoBenchOutput)
+
+	var buf bytes.Buffer
+	renderCorpusBenchmarkResult(params, result, &buf)
+
+	exp := "BenchmarkOPAEval/input=b.json\t       1\t       400 ns/op\t400 p50-ns/op\t400 p90-ns/op\t400 p99-ns/op\t     100 B/op\t      10 allocs/op\n"
+	if !strings.HasSuffix(buf.String(), exp) {
+		t.Fatalf("Expected output to end with:\n%q\n\nGot:\n%q", exp, buf.String())
+	}
+
+	params.outputFormat.Set(evalPrettyOutput)
+	buf.Reset()
+	renderCorpusBenchmarkResult(params, result, &buf)
+
+	for _, exp := range []string{"Outliers:", "| b.json |", "REGRESSION", "+100.0%"} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Fatalf("Expected output to contain %q but got:\n%s", exp, buf.String())
+		}
+	}
+}
+
+func TestValidateEvalParamsWithBenchDefaults(t *testing.T) {
+	params := newBenchmarkEvalParams()
+	if err := validateEvalParams(&params.evalCommandParams, []string{"1 + 1"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
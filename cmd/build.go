@@ -9,6 +9,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"strings"
 
@@ -17,6 +18,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/open-policy-agent/opa/compile"
+	"github.com/open-policy-agent/opa/internal/oci"
 	"github.com/open-policy-agent/opa/util"
 )
 
@@ -29,6 +31,7 @@ type buildParams struct {
 	optimizationLevel  int
 	entrypoints        repeatedStringFlag
 	outputFile         string
+	goPackage          string
 	revision           string
 	ignore             []string
 	debug              bool
@@ -39,12 +42,15 @@ type buildParams struct {
 	pubKeyID           string
 	claimsFile         string
 	excludeVerifyFiles []string
+	ociLayout          string
+	ociTag             string
 }
 
 func newBuildParams() buildParams {
 	var buildParams buildParams
 	buildParams.capabilities = newcapabilitiesFlag()
-	buildParams.target = util.NewEnumFlag(compile.TargetRego, []string{compile.TargetRego, compile.TargetWasm})
+	buildParams.target = util.NewEnumFlag(compile.TargetRego, []string{compile.TargetRego, compile.TargetWasm, compile.TargetGo, compile.TargetPlan})
+	buildParams.ociTag = oci.DefaultTag
 	return buildParams
 }
 
@@ -98,6 +104,12 @@ equivalent to the input files however the structure of the files in the bundle m
 been changed by rewriting, inlining, pruning, etc. Higher optimization levels may result
 in longer build times.
 
+For the wasm, go and plan targets, optimization also rewrites the plan that the policy is
+compiled from: at -O=1 copies are propagated, literals are folded and unused locals,
+blocks and functions are removed. At -O=2 lookups of documents that do not change
+inside a loop are also moved out of the loop. With --debug the size of the plan is
+reported before and after each pass.
+
 The 'build' command supports targets (specified by -t):
 
     rego    The default target emits a bundle containing a set of policy and data files
@@ -109,6 +121,19 @@ The 'build' command supports targets (specified by -t):
             the input files. The bundle may contain the original policy or data files.
             The wasm target requires exactly one entrypoint (-e) be supplied.
 
+    go      The go target emits the source of a Go package instead of a bundle. The
+            package is compiled from the input files and exports an Eval function
+            that evaluates the entrypoint with the input and data documents. Base
+            documents are not included in the package and have to be passed to Eval.
+            The output file defaults to policy.go and the package name is set with
+            --go-package. The go target requires exactly one entrypoint (-e) be
+            supplied.
+
+    plan    The plan target emits a bundle containing the plan that the wasm and go
+            targets are compiled from. The plan is encoded as versioned JSON in
+            /plan.json and can be executed by runtimes that do not embed OPA. The
+            plan target requires exactly one entrypoint (-e) be supplied.
+
 The -e flag tells the 'build' command which documents will be queried by the software
 asking for policy decisions, so that it can focus optimization efforts and ensure
 that document is not eliminated by the optimizer.
@@ -153,12 +178,30 @@ If the verification succeeds, the 'build' command will write out an updated ".si
 to the output bundle. It will use the key specified by the --signing-key flag to sign
 the token in the ".signatures.json" file.
 
+The --signing-key flag can also be used without --bundle and --verification-key. In that
+case the 'build' command signs the bundle it builds from the input files: it computes the
+hashes of the files in the output bundle and writes the ".signatures.json" file to the
+output bundle, so that no separate 'sign' step is needed:
+
+	$ opa build --signing-key /path/to/private_key.pem --signing-alg RS256 policy/
+
 To include additional claims in the payload use the --claims-file flag to provide a JSON file
 containing optional claims.
 
 For more information on the format of the ".signatures.json" file
 see https://www.openpolicyagent.org/docs/latest/management/#bundle-signature.
 
+## OCI
+
+The --oci-layout flag writes the bundle to an OCI image layout directory in addition
+to the output file. The bundle is stored as the layer of an OCI artifact that is tagged
+with --oci-tag ("latest" by default). Other artifacts in the layout are kept. Artifacts
+can be copied from the layout to a registry with OCI tooling, or the bundle can be
+pushed directly with the 'push' command:
+
+	$ opa build --oci-layout ./layout --oci-tag v1 policy/
+	$ opa push bundle.tar.gz registry.example.com/policies/authz:v1
+
 ## Capabilities
 
 The 'build' command can validate policies against a configurable set of OPA capabilities.
@@ -203,6 +246,9 @@ against OPA v0.22.0:
 			return nil
 		},
 		Run: func(cmd *cobra.Command, args []string) {
+			if buildParams.target.String() == compile.TargetGo && !cmd.Flags().Changed("output") {
+				buildParams.outputFile = "policy.go"
+			}
 			if err := dobuild(buildParams, args); err != nil {
 				fmt.Println("error:", err)
 				os.Exit(1)
@@ -216,6 +262,9 @@ against OPA v0.22.0:
 	buildCommand.Flags().VarP(&buildParams.entrypoints, "entrypoint", "e", "set slash separated entrypoint path")
 	buildCommand.Flags().StringVarP(&buildParams.revision, "revision", "r", "", "set output bundle revision")
 	buildCommand.Flags().StringVarP(&buildParams.outputFile, "output", "o", "bundle.tar.gz", "set the output filename")
+	buildCommand.Flags().StringVarP(&buildParams.goPackage, "go-package", "", "policy", "set the package name of the go target")
+	buildCommand.Flags().StringVarP(&buildParams.ociLayout, "oci-layout", "", "", "set the OCI image layout directory to write the bundle to")
+	buildCommand.Flags().StringVarP(&buildParams.ociTag, "oci-tag", "", buildParams.ociTag, "set the tag of the bundle in the OCI image layout")
 
 	addBundleModeFlag(buildCommand.Flags(), &buildParams.bundleMode, false)
 	addIgnoreFlag(buildCommand.Flags(), &buildParams.ignore)
@@ -240,18 +289,25 @@ func dobuild(params buildParams, args []string) error {
 	buf := bytes.NewBuffer(nil)
 
 	// generate the bundle verification and signing config
-	bvc := buildVerificationConfig(params.pubKey, params.pubKeyID, params.algorithm, params.scope, params.excludeVerifyFiles)
+	bvc, err := buildVerificationConfig(params.pubKey, params.pubKeyID, params.algorithm, params.scope, params.excludeVerifyFiles)
+	if err != nil {
+		return err
+	}
+
 	bsc := buildSigningConfig(params.key, params.algorithm, params.claimsFile)
 
-	if bvc != nil || bsc != nil {
-		if !params.bundleMode {
-			return fmt.Errorf("enable bundle mode (ie. --bundle) to verify or sign bundle files or directories")
-		}
+	if bvc != nil && !params.bundleMode {
+		return fmt.Errorf("enable bundle mode (ie. --bundle) to verify bundle files or directories")
+	}
+
+	if params.ociLayout != "" && params.target.String() == compile.TargetGo {
+		return fmt.Errorf("the go target does not produce a bundle to write to an OCI layout")
 	}
 
 	compiler := compile.New().
 		WithCapabilities(params.capabilities.C).
 		WithTarget(params.target.String()).
+		WithGoPackage(params.goPackage).
 		WithAsBundle(params.bundleMode).
 		WithOptimizationLevel(params.optimizationLevel).
 		WithOutput(buf).
@@ -266,7 +322,7 @@ func dobuild(params buildParams, args []string) error {
 		compiler = compiler.WithBundleVerificationKeyID(params.pubKeyID)
 	}
 
-	err := compiler.Build(context.Background())
+	err = compiler.Build(context.Background())
 
 	if params.debug {
 		printdebug(os.Stderr, compiler.Debug())
@@ -276,6 +332,16 @@ func dobuild(params buildParams, args []string) error {
 		return err
 	}
 
+	if params.ociLayout != "" {
+		artifact, err := oci.NewArtifact(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if err := oci.WriteLayout(params.ociLayout, params.ociTag, artifact); err != nil {
+			return err
+		}
+	}
+
 	out, err := os.Create(params.outputFile)
 	if err != nil {
 		return err
@@ -300,13 +366,26 @@ func buildCommandLoaderFilter(bundleMode bool, ignore []string) func(string, os.
 	}
 }
 
-func buildVerificationConfig(pubKey, pubKeyID, alg, scope string, excludeFiles []string) *bundle.VerificationConfig {
+// buildVerificationConfig returns the verification config for the key given on
+// the command line. If the key names an existing file, the key is read from
+// the file.
+func buildVerificationConfig(pubKey, pubKeyID, alg, scope string, excludeFiles []string) (*bundle.VerificationConfig, error) {
 	if pubKey == "" {
-		return nil
+		return nil, nil
+	}
+
+	if _, err := os.Stat(pubKey); err == nil {
+		bs, err := ioutil.ReadFile(pubKey)
+		if err != nil {
+			return nil, err
+		}
+		pubKey = string(bs)
+	} else if !os.IsNotExist(err) {
+		return nil, err
 	}
 
 	keyConfig := bundle.NewKeyConfig(pubKey, alg, scope)
-	return bundle.NewVerificationConfig(map[string]*bundle.KeyConfig{pubKeyID: keyConfig}, pubKeyID, scope, excludeFiles)
+	return bundle.NewVerificationConfig(map[string]*bundle.KeyConfig{pubKeyID: keyConfig}, pubKeyID, scope, excludeFiles), nil
 }
 
 func buildSigningConfig(key, alg, claimsFile string) *bundle.SigningConfig {
@@ -1,12 +1,15 @@
 package cmd
 
 import (
+	"io/ioutil"
 	"os"
 	"path"
 	"strings"
 	"testing"
 
+	"github.com/open-policy-agent/opa/internal/oci"
 	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/util"
 
 	"github.com/open-policy-agent/opa/util/test"
 )
@@ -36,6 +39,91 @@ func TestBuildProducesBundle(t *testing.T) {
 	})
 }
 
+func TestBuildWritesOCILayout(t *testing.T) {
+
+	files := map[string]string{
+		"test.rego": `
+			package test
+			p = 1
+		`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		params := newBuildParams()
+		params.outputFile = path.Join(root, "bundle.tar.gz")
+		params.ociLayout = path.Join(root, "layout")
+		params.ociTag = "v1"
+
+		err := dobuild(params, []string{path.Join(root, "test.rego")})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bs, err := ioutil.ReadFile(params.outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, err := oci.NewArtifact(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bs, err = ioutil.ReadFile(path.Join(params.ociLayout, "index.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var index oci.Index
+		if err := util.UnmarshalJSON(bs, &index); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(index.Manifests) != 1 || index.Manifests[0].Digest != a.Manifest.Digest || index.Manifests[0].Annotations[oci.AnnotationRefName] != "v1" {
+			t.Fatalf("Unexpected index: %s", bs)
+		}
+
+		layer := path.Join(params.ociLayout, "blobs", "sha256", strings.TrimPrefix(a.Bundle.Digest, "sha256:"))
+		if _, err := os.Stat(layer); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestBuildGoTarget(t *testing.T) {
+
+	files := map[string]string{
+		"test.rego": `
+			package test
+			p = 1
+		`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		params := newBuildParams()
+		params.outputFile = path.Join(root, "policy.go")
+		params.goPackage = "authz"
+		params.entrypoints.Set("test/p")
+		if err := params.target.Set("go"); err != nil {
+			t.Fatal(err)
+		}
+
+		err := dobuild(params, []string{root})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bs, err := ioutil.ReadFile(params.outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(bs), "package authz") {
+			t.Fatalf("expected go source but got:\n\n%s", bs)
+		}
+	})
+}
+
 func TestBuildFilesystemModeIgnoresTarGz(t *testing.T) {
 
 	files := map[string]string{
@@ -111,9 +199,56 @@ func TestBuildErrorVerifyNonBundle(t *testing.T) {
 			t.Fatal("expected error but got nil")
 		}
 
-		exp := "enable bundle mode (ie. --bundle) to verify or sign bundle files or directories"
+		exp := "enable bundle mode (ie. --bundle) to verify bundle files or directories"
 		if err.Error() != exp {
 			t.Fatalf("expected error message %v but got %v", exp, err.Error())
 		}
 	})
 }
+
+func TestBuildSignsNonBundle(t *testing.T) {
+
+	files := map[string]string{
+		"test.rego": `
+			package test
+			p = 1
+		`,
+		"data.json": `{"x": 1}`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		params := newBuildParams()
+		params.outputFile = path.Join(root, "bundle.tar.gz")
+		params.key = "secret"
+		params.algorithm = "HS256"
+		params.pubKeyID = defaultPublicKeyID
+
+		if err := dobuild(params, []string{root}); err != nil {
+			t.Fatal(err)
+		}
+
+		bvc, err := buildVerificationConfig("secret", defaultPublicKeyID, "HS256", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := loader.NewFileLoader().WithBundleVerificationConfig(bvc).AsBundle(params.outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(b.Signatures.Signatures) != 1 {
+			t.Fatalf("expected one signature but got %v", b.Signatures.Signatures)
+		}
+
+		bvc, err = buildVerificationConfig("othersecret", defaultPublicKeyID, "HS256", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = loader.NewFileLoader().WithBundleVerificationConfig(bvc).AsBundle(params.outputFile)
+		if err == nil || !strings.Contains(err.Error(), "failed to match hmac signature") {
+			t.Fatal("expected verification error but got:", err)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/open-policy-agent/opa/internal/dap"
+)
+
+func init() {
+
+	var debugCommand = &cobra.Command{
+		Use:   "debug",
+		Short: "Start a Debug Adapter Protocol server",
+		Long: `Start a Debug Adapter Protocol server.
+
+The 'debug' command starts a server that speaks the Debug Adapter Protocol over
+stdin and stdout. Editors start the server to debug the evaluation of a query.
+The query, input and data are provided by the editor's launch configuration:
+
+	{
+		"query": "data.example.allow",
+		"input": "input.json",
+		"data": ["policies/", "data.json"],
+		"bundles": [],
+		"stopOnEntry": false
+	}
+
+The server supports breakpoints on lines of policy files, stepping in, over and
+out of rule and function calls and inspecting variable bindings and the virtual
+documents cached by evaluation. Logs are written to stderr.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := dap.New(os.Stdin, os.Stdout).Serve(context.Background()); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	RootCommand.AddCommand(debugCommand)
+}
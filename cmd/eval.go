@@ -24,37 +24,48 @@ import (
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/profiler"
 	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/sql"
 	"github.com/open-policy-agent/opa/topdown"
 	"github.com/open-policy-agent/opa/topdown/lineage"
 	"github.com/open-policy-agent/opa/util"
 )
 
 type evalCommandParams struct {
-	coverage          bool
-	partial           bool
-	unknowns          []string
-	disableInlining   []string
-	shallowInlining   bool
-	disableIndexing   bool
-	dataPaths         repeatedStringFlag
-	inputPath         string
-	imports           repeatedStringFlag
-	pkg               string
-	stdin             bool
-	stdinInput        bool
-	explain           *util.EnumFlag
-	metrics           bool
-	instrument        bool
-	ignore            []string
-	outputFormat      *util.EnumFlag
-	profile           bool
-	profileTopResults bool
-	profileCriteria   repeatedStringFlag
-	profileLimit      intFlag
-	prettyLimit       intFlag
-	fail              bool
-	failDefined       bool
-	bundlePaths       repeatedStringFlag
+	coverage           bool
+	partial            bool
+	unknowns           []string
+	disableInlining    []string
+	shallowInlining    bool
+	disableIndexing    bool
+	dataPaths          repeatedStringFlag
+	inputPath          string
+	imports            repeatedStringFlag
+	pkg                string
+	stdin              bool
+	stdinInput         bool
+	explain            *util.EnumFlag
+	metrics            bool
+	instrument         bool
+	ignore             []string
+	outputFormat       *util.EnumFlag
+	profile            bool
+	profileTopResults  bool
+	profileCriteria    repeatedStringFlag
+	profileLimit       intFlag
+	profileFormat      *util.EnumFlag
+	profileOutput      string
+	prettyLimit        intFlag
+	fail               bool
+	failDefined        bool
+	bundlePaths        repeatedStringFlag
+	watch              bool
+	sqlDialect         *util.EnumFlag
+	sqlColumns         []string
+	pubKey             string
+	pubKeyID           string
+	algorithm          string
+	scope              string
+	excludeVerifyFiles []string
 }
 
 func newEvalCommandParams() evalCommandParams {
@@ -65,8 +76,11 @@ func newEvalCommandParams() evalCommandParams {
 			evalBindingsOutput,
 			evalPrettyOutput,
 			evalSourceOutput,
+			evalSQLOutput,
 		}),
-		explain: newExplainFlag([]string{explainModeOff, explainModeFull, explainModeNotes, explainModeFails}),
+		sqlDialect:    newSQLDialectFlag(),
+		explain:       newExplainFlag([]string{explainModeOff, explainModeFull, explainModeNotes, explainModeFails}),
+		profileFormat: util.NewEnumFlag(profileFormatReport, []string{profileFormatReport, profileFormatPprof, profileFormatFolded}),
 	}
 }
 
@@ -87,13 +101,26 @@ func validateEvalParams(p *evalCommandParams, cmdArgs []string) error {
 	if p.fail && p.failDefined {
 		return errors.New("specify --fail or --fail-defined but not both")
 	}
+	if p.watch {
+		if p.stdin || p.stdinInput {
+			return errors.New("specify --watch or --stdin/--stdin-input but not both")
+		}
+		if len(p.watchPaths()) == 0 {
+			return errors.New("specify --data, --bundle or --input files to watch")
+		}
+	}
 	of := p.outputFormat.String()
-	if p.partial && of != evalPrettyOutput && of != evalJSONOutput && of != evalSourceOutput {
+	if p.partial && of != evalPrettyOutput && of != evalJSONOutput && of != evalSourceOutput && of != evalSQLOutput {
 		return errors.New("invalid output format for partial evaluation")
-	} else if !p.partial && of == evalSourceOutput {
+	} else if !p.partial && (of == evalSourceOutput || of == evalSQLOutput) {
 		return errors.New("invalid output format for evaluation")
 	}
-	if p.profileLimit.isFlagSet() || p.profileCriteria.isFlagSet() {
+	for _, c := range p.sqlColumns {
+		if !strings.Contains(c, "=") {
+			return fmt.Errorf("invalid SQL column mapping %v: use <ref>=<column>", c)
+		}
+	}
+	if p.profileLimit.isFlagSet() || p.profileCriteria.isFlagSet() || p.profileFormat.String() != profileFormatReport || p.profileOutput != "" {
 		p.profile = true
 	}
 	if p.profile {
@@ -102,6 +129,9 @@ func validateEvalParams(p *evalCommandParams, cmdArgs []string) error {
 	if p.instrument {
 		p.metrics = true
 	}
+	if p.pubKey != "" && !p.bundlePaths.isFlagSet() {
+		return errors.New("specify --bundle to verify bundle files or directories")
+	}
 	return nil
 }
 
@@ -111,6 +141,11 @@ const (
 	evalBindingsOutput = "bindings"
 	evalPrettyOutput   = "pretty"
 	evalSourceOutput   = "source"
+	evalSQLOutput      = "sql"
+
+	profileFormatReport = "report"
+	profileFormatPprof  = "pprof"
+	profileFormatFolded = "folded"
 
 	// number of profile results to return by default
 	defaultProfileLimit = 10
@@ -182,6 +217,17 @@ ignored.
 See https://www.openpolicyagent.org/docs/latest/bundles/ for more details
 on bundle directory structures.
 
+Signatures of bundles loaded with --bundle are not verified unless the
+--verification-key flag is set. If set, every bundle must contain a
+".signatures.json" file that is verified with the key, the same way the
+'run' command verifies bundles:
+
+	$ opa eval --bundle bundle.tar.gz --verification-key /path/to/public_key.pem 'data'
+
+The --verification-key-id, --signing-alg, --scope and --exclude-files-verify
+flags set the name and algorithm of the key, the scope and the files to exclude
+from verification respectively.
+
 The --data flag can be used to recursively load ALL *.rego, *.json, and
 *.yaml files under the specified directory.
 
@@ -194,6 +240,29 @@ Set the output format with the --format flag.
 	--format=values    : output line separated JSON arrays containing expression values
 	--format=bindings  : output line separated JSON objects containing variable bindings
 	--format=pretty    : output query results in a human-readable format
+	--format=source    : output partial evaluation results in a source format
+	--format=sql       : output partial evaluation results as a SQL predicate
+
+SQL Output
+----------
+
+The --format=sql option translates the results of partial evaluation into a
+parameterized SQL predicate for a WHERE clause. References to unknowns are
+mapped to columns with --sql-column. If a reference is longer than the mapped
+reference, the remaining path is appended to the column name:
+
+	$ opa eval --partial --unknowns input.rows --format sql \
+		--sql-column input.rows=posts --data filters.rego 'data.filters.allow == true'
+
+The parameters of the predicate are printed as SQL comments after it. The
+dialect is selected with --sql-dialect.
+
+Watching Files
+--------------
+
+The --watch flag re-evaluates the query whenever a Rego, JSON or YAML file
+loaded with --data, --bundle or --input changes. The screen is cleared before
+each evaluation and errors are reported without exiting.
 `,
 
 		PreRunE: func(cmd *cobra.Command, args []string) error {
@@ -201,6 +270,10 @@ Set the output format with the --format flag.
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 
+			if params.watch {
+				os.Exit(watchEval(args, params))
+			}
+
 			defined, err := eval(args, params, os.Stdout)
 			if err != nil {
 				if _, ok := err.(regoError); !ok {
@@ -226,7 +299,11 @@ Set the output format with the --format flag.
 	evalCommand.Flags().BoolVarP(&params.profile, "profile", "", false, "perform expression profiling")
 	evalCommand.Flags().VarP(&params.profileCriteria, "profile-sort", "", "set sort order of expression profiler results")
 	evalCommand.Flags().VarP(&params.profileLimit, "profile-limit", "", "set number of profiling results to show")
+	evalCommand.Flags().VarP(params.profileFormat, "profile-format", "", "set profile format: {report,pprof,folded} (pprof and folded profiles replace the evaluation output unless --profile-output is set)")
+	evalCommand.Flags().StringVarP(&params.profileOutput, "profile-output", "", "", "set path of file to write pprof and folded profiles to")
 	evalCommand.Flags().VarP(&params.prettyLimit, "pretty-limit", "", "set limit after which pretty output gets truncated")
+	evalCommand.Flags().VarP(params.sqlDialect, "sql-dialect", "", "set SQL dialect of --format=sql output")
+	evalCommand.Flags().StringArrayVarP(&params.sqlColumns, "sql-column", "", []string{}, "set mapping from unknown reference to SQL column for --format=sql output (e.g., input.rows=posts)")
 	evalCommand.Flags().BoolVarP(&params.failDefined, "fail-defined", "", false, "exits with non-zero exit code on defined/non-empty result and errors")
 
 	// Shared flags
@@ -241,11 +318,59 @@ Set the output format with the --format flag.
 	addMetricsFlag(evalCommand.Flags(), &params.metrics, false)
 	addOutputFormat(evalCommand.Flags(), params.outputFormat)
 	addIgnoreFlag(evalCommand.Flags(), &params.ignore)
+	addWatchFlag(evalCommand.Flags(), &params.watch)
 	setExplainFlag(evalCommand.Flags(), params.explain)
 
+	// bundle verification config
+	addVerificationKeyFlag(evalCommand.Flags(), &params.pubKey)
+	addVerificationKeyIDFlag(evalCommand.Flags(), &params.pubKeyID, defaultPublicKeyID)
+	addSigningAlgFlag(evalCommand.Flags(), &params.algorithm, defaultTokenSigningAlg)
+	addBundleVerificationScopeFlag(evalCommand.Flags(), &params.scope)
+	addBundleVerificationExcludeFilesFlag(evalCommand.Flags(), &params.excludeVerifyFiles)
+
 	RootCommand.AddCommand(evalCommand)
 }
 
+func (p *evalCommandParams) watchPaths() []string {
+	var paths []string
+	paths = append(paths, p.dataPaths.v...)
+	paths = append(paths, p.bundlePaths.v...)
+	if p.inputPath != "" {
+		if path, err := fileurl.Clean(p.inputPath); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// watchEval evaluates the query and re-evaluates it whenever the loaded files
+// change.
+func watchEval(args []string, params evalCommandParams) int {
+
+	evalOnce := func() {
+		clearScreen(os.Stdout)
+		if _, err := eval(args, params, os.Stdout); err != nil {
+			if _, ok := err.(regoError); !ok {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		fmt.Fprintln(os.Stderr, "\nWatching for changes...")
+	}
+
+	evalOnce()
+
+	err := watchFiles(context.Background(), params.watchPaths(), func([]string) {
+		evalOnce()
+	})
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	return 0
+}
+
 func eval(args []string, params evalCommandParams, w io.Writer) (bool, error) {
 
 	ectx, err := setupEval(args, params)
@@ -293,7 +418,11 @@ func eval(args []string, params evalCommandParams, w io.Writer) (bool, error) {
 		result.Metrics = ectx.metrics
 	}
 
-	if ectx.params.profile {
+	if ectx.params.profile && ectx.params.profileFormat.String() != profileFormatReport {
+		if err := writeProfile(ectx.profiler.Profile(), ectx.params, w); err != nil {
+			return false, err
+		}
+	} else if ectx.params.profile {
 		var sortOrder = pr.DefaultProfileSortOrder
 
 		if len(ectx.params.profileCriteria.v) != 0 {
@@ -308,15 +437,19 @@ func eval(args []string, params evalCommandParams, w io.Writer) (bool, error) {
 		result.Coverage = &report
 	}
 
-	switch params.outputFormat.String() {
-	case evalBindingsOutput:
+	switch {
+	case ectx.params.profile && ectx.params.profileFormat.String() != profileFormatReport && ectx.params.profileOutput == "":
+		// The profile was written instead of the evaluation output.
+	case params.outputFormat.String() == evalBindingsOutput:
 		err = pr.Bindings(w, result)
-	case evalValuesOutput:
+	case params.outputFormat.String() == evalValuesOutput:
 		err = pr.Values(w, result)
-	case evalPrettyOutput:
+	case params.outputFormat.String() == evalPrettyOutput:
 		err = pr.Pretty(w, result)
-	case evalSourceOutput:
+	case params.outputFormat.String() == evalSourceOutput:
 		err = pr.Source(w, result)
+	case params.outputFormat.String() == evalSQLOutput:
+		err = writeSQL(w, result, params)
 	default:
 		err = pr.JSON(w, result)
 	}
@@ -335,6 +468,67 @@ func eval(args []string, params evalCommandParams, w io.Writer) (bool, error) {
 	}
 }
 
+func newSQLDialectFlag() *util.EnumFlag {
+	var dialects []string
+	for _, d := range sql.Dialects() {
+		dialects = append(dialects, string(d))
+	}
+	return util.NewEnumFlag(string(sql.Postgres), dialects)
+}
+
+// writeSQL translates the partial evaluation result into a SQL predicate and
+// writes it to w followed by its parameters as SQL comments.
+func writeSQL(w io.Writer, result pr.Output, params evalCommandParams) error {
+
+	if len(result.Errors) > 0 {
+		return pr.Source(w, result)
+	}
+
+	columns := make(map[string]string, len(params.sqlColumns))
+	for _, c := range params.sqlColumns {
+		parts := strings.SplitN(c, "=", 2)
+		columns[parts[0]] = parts[1]
+	}
+
+	translator, err := sql.New().WithDialect(sql.Dialect(params.sqlDialect.String())).WithColumns(columns)
+	if err != nil {
+		return err
+	}
+
+	pred, err := translator.Translate(result.Partial)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, pred.SQL)
+
+	for i, arg := range pred.Args {
+		fmt.Fprintf(w, "-- arg %d: %v\n", i+1, string(util.MustMarshalJSON(arg)))
+	}
+
+	return nil
+}
+
+// writeProfile writes the profile in the format selected by the parameters to
+// the profile output file or w if no file was specified.
+func writeProfile(profile *profiler.Profile, params evalCommandParams, w io.Writer) error {
+
+	if params.profileOutput != "" {
+		f, err := os.Create(params.profileOutput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if params.profileFormat.String() == profileFormatFolded {
+		return profile.WriteFolded(w)
+	}
+
+	return profile.WritePprof(w)
+}
+
 type evalContext struct {
 	params   evalCommandParams
 	metrics  metrics.Metrics
@@ -387,8 +581,17 @@ func setupEval(args []string, params evalCommandParams) (*evalContext, error) {
 		}
 	}
 
-	// skip bundle verification
-	regoArgs = append(regoArgs, rego.SkipBundleVerification(true))
+	// verify bundles only if a verification key was provided
+	bvc, err := buildVerificationConfig(params.pubKey, params.pubKeyID, params.algorithm, params.scope, params.excludeVerifyFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	if bvc != nil {
+		regoArgs = append(regoArgs, rego.BundleVerificationConfig(bvc))
+	} else {
+		regoArgs = append(regoArgs, rego.SkipBundleVerification(true))
+	}
 
 	inputBytes, err := readInputBytes(params)
 	if err != nil {
@@ -7,9 +7,15 @@ package cmd
 import (
 	"bufio"
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/open-policy-agent/opa/ast"
@@ -83,6 +89,111 @@ p = 1`,
 	})
 }
 
+func TestEvalWithProfileFormat(t *testing.T) {
+	files := map[string]string{
+		"x.rego": `package x
+
+p { q }
+q { true }`,
+	}
+
+	test.WithTempFS(files, func(path string) {
+
+		params := newEvalCommandParams()
+		params.profile = true
+		params.dataPaths = newrepeatedStringFlag([]string{path})
+		if err := params.profileFormat.Set(profileFormatFolded); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+
+		defined, err := eval([]string{"data.x.p"}, params, &buf)
+		if !defined || err != nil {
+			t.Fatalf("Unexpected undefined or error: %v", err)
+		}
+
+		if !strings.Contains(buf.String(), "query;data.x.p;data.x.q ") {
+			t.Fatalf("Expected folded stacks but got: %v", buf.String())
+		}
+
+		params.profileOutput = filepath.Join(path, "profile.pb.gz")
+		if err := params.profileFormat.Set(profileFormatPprof); err != nil {
+			t.Fatal(err)
+		}
+
+		buf.Reset()
+
+		if _, err := eval([]string{"data.x.p"}, params, &buf); err != nil {
+			t.Fatal(err)
+		}
+
+		var output presentation.Output
+
+		if err := util.NewJSONDecoder(&buf).Decode(&output); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(output.Result) != 1 || len(output.Profile) != 0 {
+			t.Fatalf("Expected result without profile report but got: %v", output)
+		}
+
+		bs, err := ioutil.ReadFile(params.profileOutput)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// gzip header
+		if len(bs) < 2 || bs[0] != 0x1f || bs[1] != 0x8b {
+			t.Fatal("Expected gzip compressed profile")
+		}
+	})
+}
+
+func TestEvalWithSQLOutput(t *testing.T) {
+	files := map[string]string{
+		"x.rego": `package x
+
+allow { input.rows.owner == "alice" }
+allow { input.rows.public; not input.rows.deleted }
+
+deny { input.rows.tags[_] == "x" }`,
+	}
+
+	test.WithTempFS(files, func(path string) {
+
+		params := newEvalCommandParams()
+		params.partial = true
+		params.unknowns = []string{"input.rows"}
+		params.sqlColumns = []string{"input.rows=posts"}
+		params.dataPaths = newrepeatedStringFlag([]string{path})
+		if err := params.outputFormat.Set(evalSQLOutput); err != nil {
+			t.Fatal(err)
+		}
+		if err := params.sqlDialect.Set("sqlite"); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+
+		if _, err := eval([]string{"data.x.allow == true"}, params, &buf); err != nil {
+			t.Fatal(err)
+		}
+
+		exp := `("posts"."public" IS TRUE AND "posts"."deleted" IS NOT TRUE) OR ("posts"."owner" = ?)
+-- arg 1: "alice"
+`
+		if buf.String() != exp {
+			t.Fatalf("Expected:\n\n%v\nGot:\n\n%v", exp, buf.String())
+		}
+
+		_, err := eval([]string{"data.x.deny == true"}, params, &buf)
+		if err == nil || !strings.Contains(err.Error(), "input.rows.tags[_] is not mapped to a column") {
+			t.Fatalf("Expected translation error but got: %v", err)
+		}
+	})
+}
+
 func TestEvalWithCoverage(t *testing.T) {
 
 	files := map[string]string{
@@ -443,3 +554,125 @@ func TestEvalDebugTraceJSONOutput(t *testing.T) {
 		}
 	}
 }
+
+func TestEvalWithBundleVerification(t *testing.T) {
+	files := map[string]string{
+		"x/x.rego":    "package x\np = 1",
+		"x/data.json": `{"b": "bar"}`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+
+		unsigned := filepath.Join(root, "unsigned.tar.gz")
+		signed := filepath.Join(root, "signed.tar.gz")
+
+		bp := newBuildParams()
+		bp.outputFile = unsigned
+		if err := dobuild(bp, []string{filepath.Join(root, "x")}); err != nil {
+			t.Fatal(err)
+		}
+
+		bp.outputFile = signed
+		bp.key = "secret"
+		bp.algorithm = "HS256"
+		bp.pubKeyID = defaultPublicKeyID
+		if err := dobuild(bp, []string{filepath.Join(root, "x")}); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			note   string
+			bundle string
+			key    string
+			err    string
+		}{
+			{note: "verified", bundle: signed, key: "secret"},
+			{note: "not verified", bundle: unsigned},
+			{note: "bad key", bundle: signed, key: "othersecret", err: "failed to match hmac signature"},
+			{note: "unsigned", bundle: unsigned, key: "secret", err: "bundle missing .signatures.json file"},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.note, func(t *testing.T) {
+				params := newEvalCommandParams()
+				params.bundlePaths = repeatedStringFlag{
+					v:     []string{tc.bundle},
+					isSet: true,
+				}
+				params.pubKey = tc.key
+				params.pubKeyID = defaultPublicKeyID
+				params.algorithm = "HS256"
+
+				var buf bytes.Buffer
+
+				defined, err := eval([]string{"data.x.p"}, params, &buf)
+				if tc.err != "" {
+					if err == nil || !strings.Contains(buf.String(), tc.err) {
+						t.Fatalf("Expected error containing %q but got %v: %s", tc.err, err, buf.String())
+					}
+					return
+				}
+				if !defined || err != nil {
+					t.Fatalf("Unexpected undefined or error: %v", err)
+				}
+			})
+		}
+	})
+}
+
+func TestEvalWithBundleVerificationKeyFile(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"x/x.rego":        "package x\np = 1",
+		"private_key.pem": string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		"public_key.pem":  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})),
+	}
+
+	test.WithTempFS(files, func(root string) {
+
+		signed := filepath.Join(root, "signed.tar.gz")
+
+		bp := newBuildParams()
+		bp.outputFile = signed
+		bp.key = filepath.Join(root, "private_key.pem")
+		bp.algorithm = "RS256"
+		bp.pubKeyID = defaultPublicKeyID
+		if err := dobuild(bp, []string{filepath.Join(root, "x")}); err != nil {
+			t.Fatal(err)
+		}
+
+		params := newEvalCommandParams()
+		params.bundlePaths = repeatedStringFlag{
+			v:     []string{signed},
+			isSet: true,
+		}
+		params.pubKey = filepath.Join(root, "public_key.pem")
+		params.pubKeyID = defaultPublicKeyID
+		params.algorithm = "RS256"
+
+		var buf bytes.Buffer
+
+		defined, err := eval([]string{"data.x.p"}, params, &buf)
+		if !defined || err != nil {
+			t.Fatalf("Unexpected undefined or error: %v: %s", err, buf.String())
+		}
+	})
+}
+
+func TestEvalVerificationRequiresBundle(t *testing.T) {
+	params := newEvalCommandParams()
+	params.pubKey = "secret"
+	err := validateEvalParams(&params, []string{"data"})
+	if err == nil || err.Error() != "specify --bundle to verify bundle files or directories" {
+		t.Fatal("Expected error but got:", err)
+	}
+}
@@ -78,6 +78,10 @@ func addCountFlag(fs *pflag.FlagSet, count *int, cmdType string) {
 	fs.IntVar(count, "count", 1, fmt.Sprintf("number of times to repeat each %s (default 1)", cmdType))
 }
 
+func addWatchFlag(fs *pflag.FlagSet, watch *bool) {
+	fs.BoolVarP(watch, "watch", "w", false, "watch command line files for changes")
+}
+
 func addMaxErrorsFlag(fs *pflag.FlagSet, errLimit *int) {
 	fs.IntVarP(errLimit, "max-errors", "m", ast.CompileErrorLimitDefault, "set the number of errors to allow before compilation fails early")
 }
@@ -0,0 +1,201 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/ref"
+	"github.com/open-policy-agent/opa/lint"
+	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/util"
+)
+
+type lintCommandParams struct {
+	format        *util.EnumFlag
+	failLevel     *util.EnumFlag
+	rulePaths     repeatedStringFlag
+	disabled      repeatedStringFlag
+	entrypoints   repeatedStringFlag
+	maxBodyLength int
+	ignore        []string
+}
+
+const (
+	lintFormatPretty = "pretty"
+	lintFormatJSON   = "json"
+	lintFormatSARIF  = "sarif"
+)
+
+func newLintCommandParams() lintCommandParams {
+	return lintCommandParams{
+		format: util.NewEnumFlag(lintFormatPretty, []string{
+			lintFormatPretty, lintFormatJSON, lintFormatSARIF,
+		}),
+		failLevel: util.NewEnumFlag(string(lint.SeverityWarning), []string{
+			string(lint.SeverityError), string(lint.SeverityWarning), string(lint.SeverityInfo),
+		}),
+		maxBodyLength: lint.DefaultMaxBodyLength,
+	}
+}
+
+func init() {
+
+	params := newLintCommandParams()
+
+	var lintCommand = &cobra.Command{
+		Use:   "lint <path> [path [...]]",
+		Short: "Lint Rego source files",
+		Long: `Lint Rego source files for style and correctness issues.
+
+The 'lint' command reports violations of the built-in lint rules and of custom
+rules written in Rego. The built-in rules are:
+
+` + lintRulesHelp() + `
+Custom rules are loaded with --rules. Each package that defines a 'violation'
+rule is a lint rule. The rule is evaluated for every module with the JSON AST of
+the module as input and must produce a set of messages or objects:
+
+	package lint.no_http
+
+	violation[{"message": msg, "location": x.location, "severity": "error"}] {
+		walk(input, [_, x])
+		x.type == "string"
+		startswith(x.value, "http://")
+		msg := sprintf("use https instead of %v", [x.value])
+	}
+
+Violations are suppressed with '# lint:ignore' comments. The comment applies to
+its own line and to the line after it. The names of the rules to ignore may
+follow the directive, e.g., '# lint:ignore unused-rule'.
+
+The command exits with a non-zero exit code if any violation has a severity at
+or above --fail-level.`,
+
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("specify at least one file")
+			}
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			exit, err := opaLint(os.Stdout, args, params)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			os.Exit(exit)
+		},
+	}
+
+	lintCommand.Flags().VarP(params.format, "format", "f", "set output format")
+	lintCommand.Flags().VarP(&params.rulePaths, "rules", "r", "set custom lint rule file(s) or directory path(s)")
+	lintCommand.Flags().Var(&params.disabled, "disable", "set names of lint rules to disable")
+	lintCommand.Flags().VarP(&params.entrypoints, "entrypoint", "e", "set slash separated entrypoint path")
+	lintCommand.Flags().IntVar(&params.maxBodyLength, "max-body-length", params.maxBodyLength, "set the maximum number of expressions in a rule body")
+	lintCommand.Flags().Var(params.failLevel, "fail-level", "set the lowest severity that causes a non-zero exit code")
+	addIgnoreFlag(lintCommand.Flags(), &params.ignore)
+
+	RootCommand.AddCommand(lintCommand)
+}
+
+func lintRulesHelp() string {
+	var sb strings.Builder
+	for _, rule := range lint.Rules() {
+		fmt.Fprintf(&sb, "\t%-24v %v (%v)\n", rule.Name, rule.Description, rule.Severity)
+	}
+	return sb.String()
+}
+
+func opaLint(w io.Writer, args []string, params lintCommandParams) (int, error) {
+
+	modules, err := lintLoadModules(args, params.ignore)
+	if err != nil {
+		return 1, err
+	}
+
+	var custom map[string]*ast.Module
+
+	if len(params.rulePaths.v) > 0 {
+		custom, err = lintLoadModules(params.rulePaths.v, params.ignore)
+		if err != nil {
+			return 1, err
+		}
+	}
+
+	var entrypoints []ast.Ref
+
+	for _, e := range params.entrypoints.v {
+		r, err := ref.ParseDataPath(e)
+		if err != nil {
+			return 1, fmt.Errorf("entrypoint %v not valid: use <package>/<rule>", e)
+		}
+		entrypoints = append(entrypoints, r)
+	}
+
+	violations, err := lint.New().
+		WithModules(modules).
+		WithCustomRules(custom).
+		WithDisabledRules(params.disabled.v).
+		WithEntrypoints(entrypoints).
+		WithMaxBodyLength(params.maxBodyLength).
+		Lint(context.Background())
+	if err != nil {
+		return 1, err
+	}
+
+	var reporter lint.Reporter
+
+	switch params.format.String() {
+	case lintFormatJSON:
+		reporter = lint.JSONReporter{Output: w}
+	case lintFormatSARIF:
+		reporter = lint.SARIFReporter{Output: w}
+	default:
+		reporter = lint.PrettyReporter{Output: w}
+	}
+
+	if err := reporter.Report(violations); err != nil {
+		return 1, err
+	}
+
+	level := lint.Severity(params.failLevel.String()).Level()
+
+	for _, v := range violations {
+		if v.Severity.Level() >= level {
+			return 1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+func lintLoadModules(paths []string, ignore []string) (map[string]*ast.Module, error) {
+
+	f := loaderFilter{
+		Ignore: ignore,
+	}
+
+	result, err := loader.NewFileLoader().Filtered(paths, f.Apply)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := make(map[string]*ast.Module, len(result.Modules))
+
+	for _, m := range result.Modules {
+		modules[m.Name] = m.Parsed
+	}
+
+	return modules, nil
+}
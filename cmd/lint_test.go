@@ -0,0 +1,90 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestLint(t *testing.T) {
+
+	files := map[string]string{
+		"policy/x.rego": `package x
+
+default allow = false
+
+allow { f(input.x) }
+
+f(x) { x == 1 }
+
+g(x) { x == 2 }
+
+# lint:ignore
+h(x) { x == 3 }
+`,
+		"rules/no_g.rego": `package lint.no_g
+
+violation[{"message": "do not name rules g", "location": rule.location, "severity": "info"}] {
+	rule := input.rules[_]
+	rule.head.name == "g"
+}
+`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+
+		params := newLintCommandParams()
+		params.rulePaths.v = []string{filepath.Join(root, "rules")}
+
+		var buf bytes.Buffer
+		exit, err := opaLint(&buf, []string{filepath.Join(root, "policy")}, params)
+		if err != nil {
+			t.Fatal(err)
+		} else if exit != 1 {
+			t.Fatalf("Expected exit code 1 but got %v", exit)
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 || !strings.HasSuffix(lines[0], "9:1: info: do not name rules g (no_g)") || !strings.HasSuffix(lines[1], "9:1: warning: function data.x.g is never called (unused-rule)") {
+			t.Fatalf("Unexpected output:\n%v", buf.String())
+		}
+
+		params.disabled.v = []string{"unused-rule"}
+		params.format.Set(lintFormatJSON)
+		buf.Reset()
+
+		exit, err = opaLint(&buf, []string{filepath.Join(root, "policy")}, params)
+		if err != nil {
+			t.Fatal(err)
+		} else if exit != 0 {
+			t.Fatalf("Expected exit code 0 but got %v", exit)
+		}
+
+		var result struct {
+			Violations []struct {
+				Rule string `json:"rule"`
+			} `json:"violations"`
+		}
+
+		if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		} else if len(result.Violations) != 1 || result.Violations[0].Rule != "no_g" {
+			t.Fatalf("Unexpected output:\n%v", buf.String())
+		}
+
+		params.failLevel.Set("info")
+		buf.Reset()
+
+		if exit, _ := opaLint(&buf, []string{filepath.Join(root, "policy")}, params); exit != 1 {
+			t.Fatalf("Expected exit code 1 but got %v", exit)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/open-policy-agent/opa/internal/lsp"
+)
+
+func init() {
+
+	var lspCommand = &cobra.Command{
+		Use:   "lsp",
+		Short: "Start a Language Server Protocol server",
+		Long: `Start a Language Server Protocol server.
+
+The 'lsp' command starts a server that speaks the Language Server Protocol over
+stdin and stdout. Editors start the server and communicate with it to provide
+the following features for Rego:
+
+	- diagnostics for parse, compile and type errors
+	- go to definition and find references
+	- hover with the types of rules and built-in functions
+	- completion of references, built-in functions and imports
+	- document symbols
+	- formatting
+
+The server loads the policy files in the workspace opened by the editor. Open
+documents are recompiled as they change. Logs are written to stderr.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := lsp.New(os.Stdin, os.Stdout).Serve(context.Background()); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	RootCommand.AddCommand(lspCommand)
+}
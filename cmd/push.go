@@ -0,0 +1,124 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/open-policy-agent/opa/internal/oci"
+	"github.com/open-policy-agent/opa/plugins/rest"
+)
+
+type pushParams struct {
+	plainHTTP bool
+	username  string
+	password  string
+}
+
+func init() {
+
+	var params pushParams
+
+	var pushCommand = &cobra.Command{
+		Use:   "push <bundle> <registry/repository[:tag]>",
+		Short: "Push a bundle to an OCI registry",
+		Long: `Push a bundle to an OCI registry.
+
+The 'push' command uploads a bundle file (e.g., produced by 'opa build') to an OCI
+registry. The bundle is stored as the layer of an OCI artifact and the manifest of the
+artifact is tagged with the tag of the reference ("latest" by default).
+
+	$ opa build -o bundle.tar.gz policy/
+	$ opa push bundle.tar.gz registry.example.com/policies/authz:v1
+
+OPA can download the bundle from the registry by configuring a service of type "oci".
+See https://www.openpolicyagent.org/docs/latest/management/#oci-registries.
+
+If the registry requires authentication, the --username and --password flags set
+the credentials that are presented to the token service of the registry. The
+--plain-http flag connects to the registry over HTTP instead of HTTPS.
+`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("specify exactly one bundle file and one reference")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := doPush(params, args[0], args[1]); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	pushCommand.Flags().BoolVarP(&params.plainHTTP, "plain-http", "", false, "connect to the registry over HTTP")
+	pushCommand.Flags().StringVarP(&params.username, "username", "u", "", "set the username to authenticate with")
+	pushCommand.Flags().StringVarP(&params.password, "password", "p", "", "set the password to authenticate with")
+
+	RootCommand.AddCommand(pushCommand)
+}
+
+func doPush(params pushParams, path string, reference string) error {
+
+	ref, err := oci.ParseReference(reference, true)
+	if err != nil {
+		return err
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	artifact, err := oci.NewArtifact(bs)
+	if err != nil {
+		return err
+	}
+
+	scheme := "https"
+	if params.plainHTTP {
+		scheme = "http"
+	}
+
+	config := map[string]interface{}{
+		"url":  scheme + "://" + ref.Registry,
+		"type": "oci",
+	}
+
+	if params.username != "" || params.password != "" {
+		config["credentials"] = map[string]interface{}{
+			"bearer": map[string]interface{}{
+				"scheme": "Basic",
+				"token":  base64.StdEncoding.EncodeToString([]byte(params.username + ":" + params.password)),
+			},
+		}
+	}
+
+	bs, err = json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	client, err := rest.New(bs)
+	if err != nil {
+		return err
+	}
+
+	if err := oci.NewClient(client).Push(context.Background(), ref, artifact); err != nil {
+		return err
+	}
+
+	fmt.Printf("%v@%v\n", ref, artifact.Manifest.Digest)
+
+	return nil
+}
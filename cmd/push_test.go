@@ -0,0 +1,58 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/internal/oci"
+	"github.com/open-policy-agent/opa/internal/oci/ocitest"
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestPush(t *testing.T) {
+
+	registry := ocitest.New()
+	registry.Credentials = "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	defer registry.Server.Close()
+
+	test.WithTempFS(map[string]string{"bundle.tar.gz": "bundle"}, func(root string) {
+
+		path := filepath.Join(root, "bundle.tar.gz")
+		params := pushParams{plainHTTP: true, username: "alice", password: "secret"}
+
+		if err := doPush(params, path, registry.Host()+"/policies/authz:v1"); err != nil {
+			t.Fatal(err)
+		}
+
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, err := oci.NewArtifact(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(registry.Manifest("policies/authz", "v1")) != string(a.ManifestBytes) {
+			t.Fatalf("Expected manifest %s but got %s", a.ManifestBytes, registry.Manifest("policies/authz", "v1"))
+		}
+
+		params.password = "wrong"
+
+		if err := doPush(params, path, registry.Host()+"/policies/authz:v2"); err == nil || !strings.Contains(err.Error(), "not authorized") {
+			t.Fatal("Expected authorization error but got:", err)
+		}
+
+		if err := doPush(params, path, "authz:v2"); err == nil || !strings.Contains(err.Error(), "missing registry") {
+			t.Fatal("Expected reference error but got:", err)
+		}
+	})
+}
@@ -12,11 +12,13 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/open-policy-agent/opa/runtime"
 	"github.com/open-policy-agent/opa/server"
+	"github.com/open-policy-agent/opa/server/identifier"
 	"github.com/open-policy-agent/opa/util"
 )
 
@@ -30,6 +32,8 @@ type runCmdParams struct {
 	tlsCertFile        string
 	tlsPrivateKeyFile  string
 	tlsCACertFile      string
+	tlsCertRefresh     time.Duration
+	jwtConfig          identifier.JWTConfig
 	ignore             []string
 	serverMode         bool
 	skipVersionCheck   bool
@@ -48,7 +52,7 @@ type runCmdParams struct {
 func newRunParams() runCmdParams {
 	return runCmdParams{
 		rt:             runtime.NewParams(),
-		authentication: util.NewEnumFlag("off", []string{"token", "tls", "off"}),
+		authentication: util.NewEnumFlag("off", []string{"token", "tls", "jwt", "off"}),
 		authorization:  util.NewEnumFlag("off", []string{"basic", "off"}),
 		logLevel:       util.NewEnumFlag("info", []string{"debug", "info", "error"}),
 		logFormat:      util.NewEnumFlag("json", []string{"text", "json", "json-pretty"}),
@@ -150,6 +154,31 @@ The 'run' command can ONLY be used with the --bundle flag to verify signatures
 for existing bundle files or directories following the bundle structure.
 
 To skip bundle verification, use the --skip-verify flag.
+
+The server can listen on TCP addresses, Unix domain sockets and sockets passed
+by a service manager such as systemd (socket activation). Sockets passed via
+LISTEN_FDS are addressed by name (e.g., the FileDescriptorName= setting of the
+.socket unit) or by file descriptor number:
+
+	$ opa run -s --addr unix:///var/run/opa.sock --unix-socket-perm 660
+	$ opa run -s --addr fd://opa --diagnostic-addr fd://opa-diag
+
+The --tls-cert-refresh-period flag enables periodic reloading of the files given
+by --tls-cert-file, --tls-private-key-file and --tls-ca-cert-file. When the
+files change, new connections use the new certificate and CA certificates
+without restarting the server. If the new files cannot be loaded, the error is
+logged and the previous certificate remains in use.
+
+	$ opa run -s --tls-cert-file cert.pem --tls-private-key-file key.pem --tls-cert-refresh-period 1m
+
+With --authentication=jwt, bearer tokens are verified before any policy is
+evaluated. Tokens must be signed by the key given with --jwt-verification-key or
+one of the keys in --jwt-jwks-file. The "exp" and "nbf" claims are always
+checked; the "aud" and "iss" claims are checked if --jwt-audience and
+--jwt-issuer are set. Requests with invalid tokens are rejected with 401. The
+verified claims are available to the system.authz policy as input.identity.
+
+	$ opa run -s --authentication=jwt --authorization=basic --jwt-jwks-file jwks.json --jwt-issuer https://issuer.example.com
 `,
 		Run: func(cmd *cobra.Command, args []string) {
 			ctx := context.Background()
@@ -165,19 +194,30 @@ To skip bundle verification, use the --skip-verify flag.
 	addConfigFileFlag(runCommand.Flags(), &cmdParams.rt.ConfigFile)
 	runCommand.Flags().BoolVarP(&cmdParams.serverMode, "server", "s", false, "start the runtime in server mode")
 	runCommand.Flags().StringVarP(&cmdParams.rt.HistoryPath, "history", "H", historyPath(), "set path of history file")
-	cmdParams.rt.Addrs = runCommand.Flags().StringSliceP("addr", "a", []string{defaultAddr}, "set listening address of the server (e.g., [ip]:<port> for TCP, unix://<path> for UNIX domain socket)")
-	cmdParams.rt.DiagnosticAddrs = runCommand.Flags().StringSlice("diagnostic-addr", []string{}, "set read-only diagnostic listening address of the server for /health and /metric APIs (e.g., [ip]:<port> for TCP, unix://<path> for UNIX domain socket)")
+	cmdParams.rt.Addrs = runCommand.Flags().StringSliceP("addr", "a", []string{defaultAddr}, "set listening address of the server (e.g., [ip]:<port> for TCP, unix://<path> for UNIX domain socket, fd://<name> for socket activation)")
+	cmdParams.rt.DiagnosticAddrs = runCommand.Flags().StringSlice("diagnostic-addr", []string{}, "set read-only diagnostic listening address of the server for /health and /metric APIs (e.g., [ip]:<port> for TCP, unix://<path> for UNIX domain socket, fd://<name> for socket activation)")
+	cmdParams.rt.UnixSocketPerm = runCommand.Flags().String("unix-socket-perm", "755", "specify the permissions for the Unix domain socket if used to listen for incoming connections")
 	runCommand.Flags().StringVarP(&cmdParams.rt.InsecureAddr, "insecure-addr", "", "", "set insecure listening address of the server")
 	runCommand.Flags().MarkDeprecated("insecure-addr", "use --addr instead")
 	runCommand.Flags().StringVarP(&cmdParams.rt.OutputFormat, "format", "f", "pretty", "set shell output format, i.e, pretty, json")
 	runCommand.Flags().BoolVarP(&cmdParams.rt.Watch, "watch", "w", false, "watch command line files for changes")
 	addMaxErrorsFlag(runCommand.Flags(), &cmdParams.rt.ErrorLimit)
 	runCommand.Flags().BoolVarP(&cmdParams.rt.PprofEnabled, "pprof", "", false, "enables pprof endpoints")
+	runCommand.Flags().Float64VarP(&cmdParams.rt.ProfileSampleRate, "profile-sample-rate", "", 0, "set fraction of decisions to profile and serve on /debug/pprof/rego")
+	runCommand.Flags().IntVarP(&cmdParams.rt.ChangeFeedSize, "change-feed-size", "", 0, "set number of store changes to serve on /v1/changes for replication (0 disables the Changes API)")
 	runCommand.Flags().StringVarP(&cmdParams.tlsCertFile, "tls-cert-file", "", "", "set path of TLS certificate file")
 	runCommand.Flags().StringVarP(&cmdParams.tlsPrivateKeyFile, "tls-private-key-file", "", "", "set path of TLS private key file")
 	runCommand.Flags().StringVarP(&cmdParams.tlsCACertFile, "tls-ca-cert-file", "", "", "set path of TLS CA cert file")
+	runCommand.Flags().DurationVar(&cmdParams.tlsCertRefresh, "tls-cert-refresh-period", 0, "set certificate refresh period")
 	runCommand.Flags().VarP(cmdParams.authentication, "authentication", "", "set authentication scheme")
 	runCommand.Flags().VarP(cmdParams.authorization, "authorization", "", "set authorization scheme")
+	runCommand.Flags().BoolVar(&cmdParams.rt.AuthorizationBody, "authorization-body", false, "include the request body in the authorization policy input")
+	runCommand.Flags().BoolVar(&cmdParams.rt.AuthorizationCertificates, "authorization-client-certs", false, "include the verified client certificate chain in the authorization policy input")
+	runCommand.Flags().StringVarP(&cmdParams.jwtConfig.Key, "jwt-verification-key", "", "", "set the secret (HMAC) or path of the PEM file containing the public key (RSA and ECDSA) used to verify bearer tokens")
+	runCommand.Flags().StringVarP(&cmdParams.jwtConfig.Algorithm, "jwt-signing-alg", "", defaultTokenSigningAlg, "set the algorithm of the key used to verify bearer tokens")
+	runCommand.Flags().StringVarP(&cmdParams.jwtConfig.JWKSFile, "jwt-jwks-file", "", "", "set path of the JWKS file containing the keys used to verify bearer tokens")
+	runCommand.Flags().StringVarP(&cmdParams.jwtConfig.Audience, "jwt-audience", "", "", "set the audience that bearer tokens must be issued for")
+	runCommand.Flags().StringVarP(&cmdParams.jwtConfig.Issuer, "jwt-issuer", "", "", "set the issuer that bearer tokens must be issued by")
 	runCommand.Flags().VarP(cmdParams.logLevel, "log-level", "l", "set log level")
 	runCommand.Flags().VarP(cmdParams.logFormat, "log-format", "", "set log format")
 	runCommand.Flags().IntVar(&cmdParams.rt.GracefulShutdownPeriod, "shutdown-grace-period", 10, "set the time (in seconds) that the server will wait to gracefully shut down")
@@ -211,6 +251,7 @@ func initRuntime(ctx context.Context, params runCmdParams, args []string) (*runt
 	authenticationSchemes := map[string]server.AuthenticationScheme{
 		"token": server.AuthenticationToken,
 		"tls":   server.AuthenticationTLS,
+		"jwt":   server.AuthenticationJWT,
 		"off":   server.AuthenticationOff,
 	}
 
@@ -230,11 +271,16 @@ func initRuntime(ctx context.Context, params runCmdParams, args []string) (*runt
 			return nil, err
 		}
 		params.rt.CertPool = pool
+		params.rt.CertPoolFile = params.tlsCACertFile
 	}
 
 	params.rt.Authentication = authenticationSchemes[params.authentication.String()]
 	params.rt.Authorization = authorizationScheme[params.authorization.String()]
+	params.rt.JWTConfig = params.jwtConfig
 	params.rt.Certificate = cert
+	params.rt.CertificateFile = params.tlsCertFile
+	params.rt.CertificateKeyFile = params.tlsPrivateKeyFile
+	params.rt.CertificateRefresh = params.tlsCertRefresh
 	params.rt.Logging = runtime.LoggingConfig{
 		Level:  params.logLevel.String(),
 		Format: params.logFormat.String(),
@@ -248,7 +294,12 @@ func initRuntime(ctx context.Context, params runCmdParams, args []string) (*runt
 
 	params.rt.SkipBundleVerification = params.skipBundleVerify
 
-	params.rt.BundleVerificationConfig = buildVerificationConfig(params.pubKey, params.pubKeyID, params.algorithm, params.scope, params.excludeVerifyFiles)
+	bvc, err := buildVerificationConfig(params.pubKey, params.pubKeyID, params.algorithm, params.scope, params.excludeVerifyFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	params.rt.BundleVerificationConfig = bvc
 
 	if params.rt.BundleVerificationConfig != nil && !params.rt.BundleMode {
 		return nil, fmt.Errorf("enable bundle mode (ie. --bundle) to verify bundle files or directories")
@@ -32,6 +32,8 @@ type signCmdParams struct {
 	claimsFile     string
 	outputFilePath string
 	bundleMode     bool
+	keyID          string
+	append         bool
 }
 
 const (
@@ -129,6 +131,21 @@ exists in the actual bundle. The file content is hashed using SHA256.
 To include additional claims in the payload use the --claims-file flag to provide
 a JSON file containing optional claims.
 
+The --signing-key-id flag sets the "keyid" claim in the payload. It names the key
+that verifiers use to verify the signature and cannot be combined with --claims-file
+(include the "keyid" claim in the claims file instead).
+
+Bundles can be signed by multiple keys, for example, by a security team and by the
+owner of a service. The --append flag adds the new signature to an existing
+".signatures.json" file in the output location instead of replacing it. Each
+signature must carry a key ID. For example:
+
+	$ opa sign --signing-key security.pem --signing-key-id security --bundle foo
+	$ opa sign --signing-key owner.pem --signing-key-id owner --append --bundle foo
+
+OPA can be configured to require signatures of specific keys or a minimum number of
+signatures with the "signers" and "threshold" fields of the bundle signing config.
+
 For more information on the format of the ".signatures.json" file see
 https://www.openpolicyagent.org/docs/latest/management/#bundle-signature.
 `,
@@ -152,6 +169,8 @@ https://www.openpolicyagent.org/docs/latest/management/#bundle-signature.
 	addSigningAlgFlag(signCommand.Flags(), &cmdParams.algorithm, defaultTokenSigningAlg)
 
 	signCommand.Flags().StringVarP(&cmdParams.outputFilePath, "output-file-path", "o", ".", "set the location for the .signatures.json file")
+	signCommand.Flags().StringVarP(&cmdParams.keyID, "signing-key-id", "", "", "set the key ID to include in the signature")
+	signCommand.Flags().BoolVarP(&cmdParams.append, "append", "", false, "append the signature to an existing .signatures.json file")
 
 	RootCommand.AddCommand(signCommand)
 }
@@ -173,11 +192,15 @@ func doSign(args []string, params signCmdParams) error {
 	}
 
 	// generate the signed token
-	token, err := bundle.GenerateSignedToken(files, buildSigningConfig(params.key, params.algorithm, params.claimsFile), "")
+	token, err := bundle.GenerateSignedToken(files, buildSigningConfig(params.key, params.algorithm, params.claimsFile), params.keyID)
 	if err != nil {
 		return err
 	}
 
+	if params.append {
+		return appendTokenToFile(token, params.outputFilePath)
+	}
+
 	return writeTokenToFile(token, params.outputFilePath)
 }
 
@@ -249,19 +272,44 @@ func hashFileContent(h bundle.SignatureHasher, data []byte, path string) (bundle
 }
 
 func writeTokenToFile(token, fileLoc string) error {
+	return writeTokensToFile([]string{token}, fileLoc)
+}
+
+// appendTokenToFile adds the token to the signatures in the ".signatures.json" file
+// at the location. If the file does not exist, it is created.
+func appendTokenToFile(token, fileLoc string) error {
+	bs, err := ioutil.ReadFile(signaturesFilePath(fileLoc))
+	if os.IsNotExist(err) {
+		return writeTokenToFile(token, fileLoc)
+	} else if err != nil {
+		return err
+	}
+
+	var sc bundle.SignaturesConfig
+	if err := util.UnmarshalJSON(bs, &sc); err != nil {
+		return errors.Wrap(err, "failed to read existing signatures")
+	}
+
+	return writeTokensToFile(append(sc.Signatures, token), fileLoc)
+}
+
+func writeTokensToFile(tokens []string, fileLoc string) error {
 	content := make(map[string]interface{})
-	content["signatures"] = []string{token}
+	content["signatures"] = tokens
 
 	bs, err := json.MarshalIndent(content, "", " ")
 	if err != nil {
 		return err
 	}
 
-	path := signaturesFile
+	return ioutil.WriteFile(signaturesFilePath(fileLoc), bs, 0644)
+}
+
+func signaturesFilePath(fileLoc string) string {
 	if fileLoc != "" {
-		path = filepath.Join(fileLoc, path)
+		return filepath.Join(fileLoc, signaturesFile)
 	}
-	return ioutil.WriteFile(path, bs, 0644)
+	return signaturesFile
 }
 
 func validateSignParams(args []string, params signCmdParams) error {
@@ -276,5 +324,13 @@ func validateSignParams(args []string, params signCmdParams) error {
 	if !params.bundleMode {
 		return fmt.Errorf("enable bundle mode (ie. --bundle) to sign bundle files or directories")
 	}
+
+	if params.keyID != "" && params.claimsFile != "" {
+		return fmt.Errorf("specify the key ID in the claims file (ie. --claims-file) or with --signing-key-id, not both")
+	}
+
+	if params.append && params.keyID == "" && params.claimsFile == "" {
+		return fmt.Errorf("specify the key ID (ie. --signing-key-id) to append a signature")
+	}
 	return nil
 }
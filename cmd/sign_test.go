@@ -132,6 +132,78 @@ func TestBundleSignVerification(t *testing.T) {
 	})
 }
 
+func TestBundleMultiSignVerification(t *testing.T) {
+
+	files := map[string]string{
+		"/.manifest":            `{"revision": "quickbrownfaux"}`,
+		"/a/b/c/data.json":      "[1,2,3]",
+		"/example/example.rego": `package example`,
+	}
+
+	test.WithTempFS(files, func(rootDir string) {
+
+		for _, signer := range []struct {
+			keyID, secret string
+		}{{"security", "secret1"}, {"owner", "secret2"}} {
+			params := signCmdParams{
+				algorithm:      "HS256",
+				key:            signer.secret,
+				keyID:          signer.keyID,
+				outputFilePath: rootDir,
+				bundleMode:     true,
+				append:         true,
+			}
+
+			if err := doSign([]string{rootDir}, params); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+		}
+
+		var sc bundle.SignaturesConfig
+		bs, err := ioutil.ReadFile(filepath.Join(rootDir, ".signatures.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := json.Unmarshal(bs, &sc); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(sc.Signatures) != 2 {
+			t.Fatalf("Expected 2 signatures but got %d", len(sc.Signatures))
+		}
+
+		var filesInBundle [][2]string
+		err = filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+			if !info.IsDir() {
+				bs, err := ioutil.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				filesInBundle = append(filesInBundle, [2]string{path, string(bs)})
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys := map[string]*bundle.KeyConfig{
+			"security": {Key: "secret1", Algorithm: "HS256"},
+			"owner":    {Key: "secret2", Algorithm: "HS256"},
+		}
+
+		bvc := bundle.NewVerificationConfig(keys, "", "", nil)
+		bvc.Signers = []string{"security", "owner"}
+
+		reader := bundle.NewReader(archive.MustWriteTarGz(filesInBundle)).WithBundleVerificationConfig(bvc).WithBaseDir(rootDir)
+
+		if _, err := reader.Read(); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	})
+}
+
 func TestValidateSignParams(t *testing.T) {
 
 	tests := map[string]struct {
@@ -155,11 +227,26 @@ func TestValidateSignParams(t *testing.T) {
 			signCmdParams{key: "foo"},
 			true, fmt.Errorf("enable bundle mode (ie. --bundle) to sign bundle files or directories"),
 		},
+		"key_id_and_claims_file": {
+			[]string{"foo"},
+			signCmdParams{key: "foo", bundleMode: true, keyID: "foo", claimsFile: "claims.json"},
+			true, fmt.Errorf("specify the key ID in the claims file (ie. --claims-file) or with --signing-key-id, not both"),
+		},
+		"append_without_key_id": {
+			[]string{"foo"},
+			signCmdParams{key: "foo", bundleMode: true, append: true},
+			true, fmt.Errorf("specify the key ID (ie. --signing-key-id) to append a signature"),
+		},
 		"no_error": {
 			[]string{"foo"},
 			signCmdParams{key: "foo", bundleMode: true},
 			false, nil,
 		},
+		"no_error_append": {
+			[]string{"foo"},
+			signCmdParams{key: "foo", bundleMode: true, keyID: "foo", append: true},
+			false, nil,
+		},
 	}
 
 	for name, tc := range tests {
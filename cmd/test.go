@@ -6,8 +6,11 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/open-policy-agent/opa/storage/inmem"
@@ -30,6 +33,11 @@ import (
 const (
 	testPrettyOutput = "pretty"
 	testJSONOutput   = "json"
+	testJUnitOutput  = "junit"
+	testTAPOutput    = "tap"
+
+	testLCOVOutput      = "lcov"
+	testCoberturaOutput = "cobertura"
 )
 
 type testCommandParams struct {
@@ -47,11 +55,19 @@ type testCommandParams struct {
 	benchMem     bool
 	runRegex     string
 	count        int
+	parallel     int
+	watch        bool
+	mutate       bool
+	pubKey       string
+	pubKeyID     string
+	algorithm    string
+	scope        string
+	excludeFiles []string
 }
 
 func newTestCommandParams() *testCommandParams {
 	return &testCommandParams{
-		outputFormat: util.NewEnumFlag(testPrettyOutput, []string{testPrettyOutput, testJSONOutput, benchmarkGoBenchOutput}),
+		outputFormat: util.NewEnumFlag(testPrettyOutput, []string{testPrettyOutput, testJSONOutput, testJUnitOutput, testTAPOutput, testLCOVOutput, testCoberturaOutput, benchmarkGoBenchOutput}),
 		explain:      newExplainFlag([]string{explainModeFails, explainModeFull, explainModeNotes}),
 	}
 }
@@ -71,6 +87,14 @@ and loaded following standard bundle conventions. The path can be a compressed a
 file or a directory which will be treated as a bundle. Without the '--bundle' flag OPA
 will recursively load ALL *.rego, *.json, and *.yaml files for evaluating the test cases.
 
+If the '--verification-key' option is specified the signatures of the bundles are
+verified with the key before the tests are run. The '--verification-key-id',
+'--signing-alg', '--scope' and '--exclude-files-verify' options set the name and
+algorithm of the key, the scope and the files to exclude from verification
+respectively. Without the '--verification-key' option signatures are not verified.
+
+	$ opa test --bundle --verification-key /path/to/public_key.pem bundle.tar.gz
+
 Example policy (example/authz.rego):
 
 	package authz
@@ -117,6 +141,37 @@ Example benchmark run:
 	$ opa test --bench ./example/
 
 The optional "gobench" output format conforms to the Go Benchmark Data Format.
+
+The "junit" and "tap" output formats report test results as JUnit XML and Test
+Anything Protocol (version 13) respectively for consumption by CI systems.
+Failures include the failed expression and, with --verbose or --explain, the
+trace of the failed test.
+
+Test cases with the prefix "todo_test_" are skipped and reported as such.
+
+If used with the '--coverage' option then the coverage report is printed
+instead of the test results. The "lcov" and "cobertura" output formats report
+coverage as an LCOV tracefile and Cobertura XML respectively, including the
+hit count of each line and rule. Selecting either format enables coverage.
+
+Example coverage run:
+
+	$ opa test --coverage --format=lcov ./example/ > coverage.lcov
+
+If used with the '--threshold' option then the command exits with a non-zero
+status if the overall coverage is lower than the threshold and reports the
+files whose coverage is lower than the threshold.
+
+If used with the '--mutate' option then the tests are run against mutants of
+the modules that do not contain tests. Mutants flip comparison operators,
+negate expressions, remove expressions and replace constants. Mutants that
+no test detects are reported with their locations along with the mutation
+score (the percentage of detected mutants). With '--threshold' the command
+exits with a non-zero status if the mutation score is lower than the threshold.
+
+Example mutation testing run:
+
+	$ opa test --mutate ./example/
 `,
 	PreRunE: func(Cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
@@ -128,6 +183,10 @@ The optional "gobench" output format conforms to the Go Benchmark Data Format.
 			testParams.verbose = true
 		}
 
+		if testParams.pubKey != "" && !testParams.bundleMode {
+			return fmt.Errorf("enable bundle mode (ie. --bundle) to verify bundle files or directories")
+		}
+
 		return nil
 	},
 
@@ -145,6 +204,46 @@ func opaTest(args []string) int {
 		return 0
 	}
 
+	if testParams.watch {
+		return watchTests(ctx, args)
+	}
+
+	exitCode, _ := runTestFiles(ctx, args, nil, nil)
+	return exitCode
+}
+
+// watchTests runs the tests and reruns them whenever the loaded files change.
+// Only the tests affected by changes to Rego files are rerun. Errors are
+// reported and the watch continues.
+func watchTests(ctx context.Context, args []string) int {
+
+	clearScreen(os.Stdout)
+	_, modules := runTestFiles(ctx, args, nil, nil)
+	fmt.Fprintln(os.Stderr, "\nWatching for changes...")
+
+	err := watchFiles(ctx, args, func(changed []string) {
+		clearScreen(os.Stdout)
+		_, current := runTestFiles(ctx, args, changed, modules)
+		if current != nil {
+			modules = current
+		}
+		fmt.Fprintln(os.Stderr, "\nWatching for changes...")
+	})
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// runTestFiles loads the files in args and runs the tests. If changed is not
+// nil, only tests affected by the changed files are run. The modules declared
+// by prev (e.g., loaded on a previous run) are used to determine the packages
+// of removed files. The loaded modules are returned alongside the exit code.
+func runTestFiles(ctx context.Context, args []string, changed []string, prev map[string]*ast.Module) (int, map[string]*ast.Module) {
+
 	filter := loaderFilter{
 		Ignore: testParams.ignore,
 	}
@@ -155,7 +254,11 @@ func opaTest(args []string) int {
 	var err error
 
 	if testParams.bundleMode {
-		bundles, err = tester.LoadBundles(args, filter.Apply)
+		var bvc *bundle.VerificationConfig
+		bvc, err = buildVerificationConfig(testParams.pubKey, testParams.pubKeyID, testParams.algorithm, testParams.scope, testParams.excludeFiles)
+		if err == nil {
+			bundles, err = tester.LoadBundlesWithVerification(args, filter.Apply, bvc)
+		}
 		store = inmem.New()
 	} else {
 		modules, store, err = tester.Load(args, filter.Apply)
@@ -163,13 +266,13 @@ func opaTest(args []string) int {
 
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return 1, modules
 	}
 
 	txn, err := store.NewTransaction(ctx, storage.WriteParams)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return 1, modules
 	}
 
 	defer store.Abort(ctx, txn)
@@ -181,36 +284,54 @@ func opaTest(args []string) int {
 	info, err := runtime.Term(runtime.Params{})
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return 1, modules
+	}
+
+	if testParams.mutate {
+		return runMutations(ctx, txn, store, modules, bundles, info), modules
 	}
 
 	if testParams.threshold > 0 && !testParams.coverage {
 		testParams.coverage = true
 	}
 
+	switch testParams.outputFormat.String() {
+	case testLCOVOutput, testCoberturaOutput:
+		testParams.coverage = true
+	}
+
 	var cov *cover.Cover
 	var coverTracer topdown.QueryTracer
 
 	if testParams.coverage {
 		if testParams.benchmark {
 			fmt.Fprintln(os.Stderr, "coverage reporting is not supported when benchmarking tests")
-			return 1
+			return 1, modules
 		}
 		cov = cover.New()
 		coverTracer = cov
 	}
 
+	// The JUnit and TAP reporters include the failed expression in failures.
+	failureLine := testParams.failureLine
+	switch testParams.outputFormat.String() {
+	case testJUnitOutput, testTAPOutput:
+		failureLine = true
+	}
+
 	runner := tester.NewRunner().
 		SetCompiler(compiler).
 		SetStore(store).
 		EnableTracing(testParams.verbose).
 		SetCoverageQueryTracer(coverTracer).
-		EnableFailureLine(testParams.failureLine).
+		EnableFailureLine(failureLine).
 		SetRuntime(info).
 		SetModules(modules).
 		SetBundles(bundles).
 		SetTimeout(testParams.timeout).
-		Filter(testParams.runRegex)
+		Filter(testParams.runRegex).
+		SetAffectedPackages(affectedPackages(changed, prev, modules)).
+		Parallel(testParams.parallel)
 
 	var reporter tester.Reporter
 
@@ -222,6 +343,14 @@ func opaTest(args []string) int {
 			reporter = tester.JSONReporter{
 				Output: os.Stdout,
 			}
+		case testJUnitOutput:
+			reporter = tester.JUnitReporter{
+				Output: os.Stdout,
+			}
+		case testTAPOutput:
+			reporter = tester.TAPReporter{
+				Output: os.Stdout,
+			}
 		case benchmarkGoBenchOutput:
 			goBench = true
 			fallthrough
@@ -236,24 +365,105 @@ func opaTest(args []string) int {
 			}
 		}
 	} else {
-		reporter = tester.JSONCoverageReporter{
-			Cover:     cov,
-			Modules:   modules,
-			Output:    os.Stdout,
-			Threshold: testParams.threshold,
+		switch testParams.outputFormat.String() {
+		case testLCOVOutput:
+			reporter = tester.LCOVCoverageReporter{
+				Cover:     cov,
+				Modules:   modules,
+				Output:    os.Stdout,
+				Threshold: testParams.threshold,
+			}
+		case testCoberturaOutput:
+			reporter = tester.CoberturaCoverageReporter{
+				Cover:     cov,
+				Modules:   modules,
+				Output:    os.Stdout,
+				Threshold: testParams.threshold,
+			}
+		default:
+			reporter = tester.JSONCoverageReporter{
+				Cover:     cov,
+				Modules:   modules,
+				Output:    os.Stdout,
+				Threshold: testParams.threshold,
+			}
 		}
 	}
 
 	for i := 0; i < testParams.count; i++ {
 		exitCode := runTests(ctx, txn, runner, reporter)
 		if exitCode != 0 {
-			return exitCode
+			return exitCode, modules
+		}
+	}
+
+	return 0, modules
+}
+
+func runMutations(ctx context.Context, txn storage.Transaction, store storage.Store, modules map[string]*ast.Module, bundles map[string]*bundle.Bundle, info *ast.Term) int {
+
+	if testParams.coverage || testParams.benchmark {
+		fmt.Fprintln(os.Stderr, "mutation testing is not supported with coverage reporting or benchmarks")
+		return 1
+	}
+
+	report, err := tester.NewRunner().
+		SetStore(store).
+		SetRuntime(info).
+		SetModules(modules).
+		SetBundles(bundles).
+		SetTimeout(testParams.timeout).
+		Filter(testParams.runRegex).
+		Parallel(testParams.parallel).
+		RunMutations(ctx, txn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch testParams.outputFormat.String() {
+	case testJSONOutput:
+		bs, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
 		}
+		fmt.Fprintln(os.Stdout, string(bs))
+	default:
+		printMutationReport(os.Stdout, report)
+	}
+
+	if report.Score < testParams.threshold {
+		fmt.Fprintln(os.Stderr, &tester.MutationScoreError{Score: report.Score, Threshold: testParams.threshold})
+		return 2
 	}
 
 	return 0
 }
 
+func printMutationReport(w io.Writer, report *tester.MutationReport) {
+	dashes := strings.Repeat("-", 80)
+
+	if surviving := report.Surviving(); len(surviving) > 0 {
+		fmt.Fprintln(w, "SURVIVING MUTANTS")
+		fmt.Fprintln(w, dashes)
+		for _, m := range surviving {
+			fmt.Fprintln(w, m)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "SUMMARY")
+	fmt.Fprintln(w, dashes)
+	total := report.Killed + report.Survived
+	fmt.Fprintf(w, "KILLED: %d/%d\n", report.Killed, total)
+	fmt.Fprintf(w, "SURVIVED: %d/%d\n", report.Survived, total)
+	if report.Invalid > 0 {
+		fmt.Fprintf(w, "INVALID: %d\n", report.Invalid)
+	}
+	fmt.Fprintf(w, "MUTATION SCORE: %.2f%%\n", report.Score)
+}
+
 func runTests(ctx context.Context, txn storage.Transaction, runner *tester.Runner, reporter tester.Reporter) int {
 	var err error
 	var ch chan *tester.Result
@@ -277,7 +487,7 @@ func runTests(ctx context.Context, txn storage.Transaction, runner *tester.Runne
 	go func() {
 		defer close(dup)
 		for tr := range ch {
-			if !tr.Pass() {
+			if !tr.Pass() && !tr.Skip {
 				exitCode = 2
 			}
 			tr.Trace = filterTrace(testParams, tr.Trace)
@@ -336,11 +546,22 @@ func init() {
 	testCommand.Flags().Float64VarP(&testParams.threshold, "threshold", "", 0, "set coverage threshold and exit with non-zero status if coverage is less than threshold %")
 	testCommand.Flags().BoolVar(&testParams.benchmark, "bench", false, "benchmark the unit tests")
 	testCommand.Flags().StringVarP(&testParams.runRegex, "run", "r", "", "run only test cases matching the regular expression.")
+	testCommand.Flags().IntVar(&testParams.parallel, "parallel", 1, "set the number of test cases to evaluate concurrently (ignored when benchmarking)")
 	addBundleModeFlag(testCommand.Flags(), &testParams.bundleMode, false)
 	addBenchmemFlag(testCommand.Flags(), &testParams.benchMem, true)
 	addCountFlag(testCommand.Flags(), &testParams.count, "test")
+	addWatchFlag(testCommand.Flags(), &testParams.watch)
+	testCommand.Flags().BoolVar(&testParams.mutate, "mutate", false, "run the tests against mutants of the policies under test and report surviving mutants")
 	addMaxErrorsFlag(testCommand.Flags(), &testParams.errLimit)
 	addIgnoreFlag(testCommand.Flags(), &testParams.ignore)
 	setExplainFlag(testCommand.Flags(), testParams.explain)
+
+	// bundle verification config
+	addVerificationKeyFlag(testCommand.Flags(), &testParams.pubKey)
+	addVerificationKeyIDFlag(testCommand.Flags(), &testParams.pubKeyID, defaultPublicKeyID)
+	addSigningAlgFlag(testCommand.Flags(), &testParams.algorithm, defaultTokenSigningAlg)
+	addBundleVerificationScopeFlag(testCommand.Flags(), &testParams.scope)
+	addBundleVerificationExcludeFilesFlag(testCommand.Flags(), &testParams.excludeFiles)
+
 	RootCommand.AddCommand(testCommand)
 }
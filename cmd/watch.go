@@ -0,0 +1,157 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+
+	"github.com/open-policy-agent/opa/ast"
+	initload "github.com/open-policy-agent/opa/internal/runtime/init"
+)
+
+// watchDebounce is the period during which file events are collected before
+// the watch callback is invoked. Editors often emit several events when a file
+// is saved.
+const watchDebounce = 100 * time.Millisecond
+
+// watchFiles invokes onChange with the Rego, JSON and YAML files under paths
+// that were created, modified or removed. The function blocks until ctx is
+// done or the watcher fails.
+func watchFiles(ctx context.Context, paths []string, onChange func(changed []string)) error {
+
+	watchPaths, err := initload.WatchPaths(paths)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	defer watcher.Close()
+
+	for _, path := range watchPaths {
+		if err := watcher.Add(path); err != nil {
+			return err
+		}
+	}
+
+	changed := map[string]struct{}{}
+	var timer <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			return err
+		case evt := <-watcher.Events:
+			if evt.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			if evt.Op&fsnotify.Create != 0 {
+				// Watch directories created after the watch started.
+				if info, err := os.Stat(evt.Name); err == nil && info.IsDir() {
+					if err := watcher.Add(evt.Name); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if !isWatchedFile(evt.Name) {
+				continue
+			}
+			changed[filepath.Clean(evt.Name)] = struct{}{}
+			if timer == nil {
+				timer = time.After(watchDebounce)
+			}
+		case <-timer:
+			files := make([]string, 0, len(changed))
+			for file := range changed {
+				files = append(files, file)
+			}
+			sort.Strings(files)
+			changed = map[string]struct{}{}
+			timer = nil
+			onChange(files)
+		}
+	}
+}
+
+func isWatchedFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".rego", ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// affectedPackages returns the packages declared by the changed Rego files.
+// The modules of the previous and current run are consulted so that removed
+// files are accounted for. If any of the changed files is not a known Rego
+// file (e.g., a data file), nil is returned to indicate that everything is
+// affected.
+func affectedPackages(changed []string, modules ...map[string]*ast.Module) []ast.Ref {
+
+	if changed == nil {
+		return nil
+	}
+
+	pkgs := []ast.Ref{}
+
+	for _, file := range changed {
+		if filepath.Ext(file) != ".rego" {
+			return nil
+		}
+		found := false
+		for _, m := range modules {
+			for name, module := range m {
+				if sameFile(name, file) {
+					pkgs = appendRef(pkgs, module.Package.Path)
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+
+	return pkgs
+}
+
+func appendRef(refs []ast.Ref, ref ast.Ref) []ast.Ref {
+	for _, r := range refs {
+		if r.Equal(ref) {
+			return refs
+		}
+	}
+	return append(refs, ref)
+}
+
+func sameFile(a, b string) bool {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false
+	}
+	return absA == absB
+}
+
+func clearScreen(w io.Writer) {
+	fmt.Fprint(w, "\033[H\033[2J")
+}
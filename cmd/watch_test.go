@@ -0,0 +1,117 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestAffectedPackages(t *testing.T) {
+
+	prev := map[string]*ast.Module{
+		"x/a.rego":       ast.MustParseModule("package a"),
+		"x/removed.rego": ast.MustParseModule("package removed"),
+	}
+
+	current := map[string]*ast.Module{
+		"x/a.rego": ast.MustParseModule("package a"),
+		"x/b.rego": ast.MustParseModule("package b.c"),
+	}
+
+	tests := []struct {
+		note    string
+		changed []string
+		exp     []ast.Ref
+	}{
+		{
+			note: "initial run",
+		},
+		{
+			note:    "modified",
+			changed: []string{"x/a.rego"},
+			exp:     []ast.Ref{ast.MustParseRef("data.a")},
+		},
+		{
+			note:    "created and removed",
+			changed: []string{"x/b.rego", "x/removed.rego"},
+			exp:     []ast.Ref{ast.MustParseRef("data.b.c"), ast.MustParseRef("data.removed")},
+		},
+		{
+			note:    "data file",
+			changed: []string{"x/a.rego", "x/data.json"},
+		},
+		{
+			note:    "unknown file",
+			changed: []string{"x/unknown.rego"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			result := affectedPackages(tc.changed, prev, current)
+			if fmt.Sprint(result) != fmt.Sprint(tc.exp) || (result == nil) != (tc.exp == nil) {
+				t.Fatalf("Expected %v but got %v", tc.exp, result)
+			}
+		})
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+
+	files := map[string]string{
+		"/policy/a.rego": "package a",
+		"/data.json":     "{}",
+	}
+
+	test.WithTempFS(files, func(rootDir string) {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ch := make(chan []string)
+		done := make(chan error)
+
+		go func() {
+			done <- watchFiles(ctx, []string{rootDir}, func(changed []string) {
+				ch <- changed
+			})
+		}()
+
+		// Wait for the watch to be established.
+		time.Sleep(100 * time.Millisecond)
+
+		for _, name := range []string{"/policy/a.rego", "/data.json", "/notes.txt"} {
+			if err := ioutil.WriteFile(filepath.Join(rootDir, name), []byte("{}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		exp := []string{filepath.Join(rootDir, "data.json"), filepath.Join(rootDir, "policy", "a.rego")}
+
+		select {
+		case changed := <-ch:
+			if !reflect.DeepEqual(changed, exp) {
+				t.Fatalf("Expected %v but got %v", exp, changed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for changes")
+		}
+
+		cancel()
+
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -6,6 +6,7 @@
 package compile
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -17,8 +18,13 @@ import (
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/internal/compiler/golang"
+	"github.com/open-policy-agent/opa/internal/compiler/wasm"
+	"github.com/open-policy-agent/opa/internal/ir"
+	"github.com/open-policy-agent/opa/internal/planner"
 	"github.com/open-policy-agent/opa/internal/ref"
 	initload "github.com/open-policy-agent/opa/internal/runtime/init"
+	"github.com/open-policy-agent/opa/internal/wasm/encoding"
 	"github.com/open-policy-agent/opa/loader"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/storage/inmem"
@@ -33,6 +39,16 @@ const (
 	// TargetWasm is an alternative target that compiles the policy into a wasm
 	// module instead of Rego. The target supports base documents.
 	TargetWasm = "wasm"
+
+	// TargetGo is an alternative target that compiles the policy into the
+	// source of a Go package instead of a bundle. Base documents have to be
+	// supplied to the generated code at evaluation time.
+	TargetGo = "go"
+
+	// TargetPlan is an alternative target that emits the plan of the policy as
+	// JSON (see the ir package) instead of Rego or wasm. The target supports
+	// base documents.
+	TargetPlan = "plan"
 )
 
 const wasmResultVar = ast.Var("result")
@@ -40,6 +56,8 @@ const wasmResultVar = ast.Var("result")
 var validTargets = map[string]struct{}{
 	TargetRego: struct{}{},
 	TargetWasm: struct{}{},
+	TargetGo:   struct{}{},
+	TargetPlan: struct{}{},
 }
 
 // Compiler implements bundle compilation and linking.
@@ -53,6 +71,7 @@ type Compiler struct {
 	entrypoints       orderedStringSet           // policy entrypoints required for optimization and certain targets
 	optimizationLevel int                        // how aggressive should optimization be
 	target            string                     // target type (wasm, rego, etc.)
+	goPackage         string                     // package name of the generated code for the go target
 	output            io.Writer                  // output stream to write bundle to
 	entrypointrefs    []*ast.Term                // validated entrypoints computed from default decision or manually supplied entrypoints
 	compiler          *ast.Compiler              // rego ast compiler used for semantic checks and rewriting
@@ -79,6 +98,9 @@ type Debug struct {
 }
 
 func (d Debug) String() string {
+	if d.Location == nil {
+		return d.Message
+	}
 	return fmt.Sprintf("%v: %v", d.Location, d.Message)
 }
 
@@ -88,6 +110,7 @@ func New() *Compiler {
 		asBundle:          false,
 		optimizationLevel: 0,
 		target:            TargetRego,
+		goPackage:         golang.DefaultPackage,
 		output:            ioutil.Discard,
 		debug:             &debugEvents{},
 	}
@@ -132,6 +155,12 @@ func (c *Compiler) WithTarget(t string) *Compiler {
 	return c
 }
 
+// WithGoPackage sets the package name of the code generated for the go target.
+func (c *Compiler) WithGoPackage(name string) *Compiler {
+	c.goPackage = name
+	return c
+}
+
 // WithOutput sets the output stream to write the bundle to.
 func (c *Compiler) WithOutput(w io.Writer) *Compiler {
 	c.output = w
@@ -191,11 +220,22 @@ func (c *Compiler) Build(ctx context.Context) error {
 	}
 
 	if c.target == TargetWasm {
-		if err := c.compileWasm(ctx); err != nil {
+		if err := c.compileWasm(); err != nil {
+			return err
+		}
+	}
+
+	if c.target == TargetPlan {
+		if err := c.compilePlan(); err != nil {
 			return err
 		}
 	}
 
+	// The go target emits source code instead of a bundle.
+	if c.target == TargetGo {
+		return c.compileGo()
+	}
+
 	if c.revision != nil {
 		c.bundle.Manifest.Revision = *c.revision
 	}
@@ -245,6 +285,14 @@ func (c *Compiler) init() error {
 		return errors.New("wasm compilation requires exactly one entrypoint")
 	}
 
+	if c.target == TargetGo && len(c.entrypointrefs) != 1 {
+		return errors.New("go compilation requires exactly one entrypoint")
+	}
+
+	if c.target == TargetPlan && len(c.entrypointrefs) != 1 {
+		return errors.New("plan compilation requires exactly one entrypoint")
+	}
+
 	return nil
 }
 
@@ -334,7 +382,66 @@ func (c *Compiler) optimize(ctx context.Context) error {
 	return nil
 }
 
-func (c *Compiler) compileWasm(ctx context.Context) error {
+func (c *Compiler) compileWasm() error {
+
+	policy, err := c.plan()
+	if err != nil {
+		return err
+	}
+
+	m, err := wasm.New().WithPolicy(policy).Compile()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	if err := encoding.WriteModule(&buf, m); err != nil {
+		return err
+	}
+
+	c.bundle.Wasm = buf.Bytes()
+
+	return nil
+}
+
+func (c *Compiler) compilePlan() error {
+
+	policy, err := c.plan()
+	if err != nil {
+		return err
+	}
+
+	bs, err := ir.Marshal(policy)
+	if err != nil {
+		return err
+	}
+
+	c.bundle.Plan = bs
+
+	return nil
+}
+
+func (c *Compiler) compileGo() error {
+
+	policy, err := c.plan()
+	if err != nil {
+		return err
+	}
+
+	src, err := golang.New().WithPolicy(policy).WithPackage(c.goPackage).Compile()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.output.Write(src)
+	return err
+}
+
+// plan returns the plan for the entrypoint. The IR optimization passes enabled
+// at the optimization level are run on the plan and the size of the plan after
+// each pass is added to the debug events.
+func (c *Compiler) plan() (*ir.Policy, error) {
 
 	// Lazily compile the modules if needed. If optimizations were run, the
 	// AST compiler will not be set because the default target does not require it.
@@ -342,26 +449,59 @@ func (c *Compiler) compileWasm(ctx context.Context) error {
 		var err error
 		c.compiler, err = compile(c.capabilities, c.bundle)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	store := inmem.NewFromObject(c.bundle.Data)
-	resultSym := ast.NewTerm(wasmResultVar)
+	qc := c.compiler.QueryCompiler()
+
+	query, err := qc.Compile(ast.NewBody(ast.Equality.Expr(ast.NewTerm(wasmResultVar), c.entrypointrefs[0])))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
 
-	cr, err := rego.New(
-		rego.ParsedQuery(ast.NewBody(ast.Equality.Expr(resultSym, c.entrypointrefs[0]))),
-		rego.Compiler(c.compiler),
-		rego.Store(store),
-	).Compile(ctx)
+	for name := range c.compiler.Modules {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	modules := make([]*ast.Module, len(names))
+
+	for i := range names {
+		modules[i] = c.compiler.Modules[names[i]]
+	}
 
+	decls := make(map[string]*ast.Builtin, len(c.capabilities.Builtins))
+
+	for _, bi := range c.capabilities.Builtins {
+		decls[bi.Name] = bi
+	}
+
+	policy, err := planner.New().
+		WithQueries([]ast.Body{query}).
+		WithModules(modules).
+		WithRewrittenVars(qc.RewrittenVars()).
+		WithBuiltinDecls(decls).
+		Plan()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	c.bundle.Wasm = cr.Bytes
+	passes := ir.Passes(c.optimizationLevel)
 
-	return nil
+	if len(passes) > 0 {
+		c.debug.Add(Debug{Message: fmt.Sprintf("plan size before optimization: %v", ir.SizeOf(policy))})
+	}
+
+	for _, pass := range passes {
+		pass.Run(policy)
+		c.debug.Add(Debug{Message: fmt.Sprintf("plan size after %v: %v", pass.Name, ir.SizeOf(policy))})
+	}
+
+	return policy, nil
 }
 
 type undefinedEntrypointErr struct {
@@ -15,6 +15,8 @@ import (
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/bundle"
 	"github.com/open-policy-agent/opa/format"
+	"github.com/open-policy-agent/opa/internal/ir"
+	"github.com/open-policy-agent/opa/internal/ir/eval"
 	"github.com/open-policy-agent/opa/loader"
 	"github.com/open-policy-agent/opa/util"
 	"github.com/open-policy-agent/opa/util/test"
@@ -62,6 +64,11 @@ func TestCompilerInitErrors(t *testing.T) {
 			c:    New().WithTarget("wasm"),
 			want: errors.New("wasm compilation requires exactly one entrypoint"),
 		},
+		{
+			note: "go compilation requires exactly one entrypoint",
+			c:    New().WithTarget("go"),
+			want: errors.New("go compilation requires exactly one entrypoint"),
+		},
 	}
 
 	for _, tc := range tests {
@@ -430,6 +437,109 @@ func TestCompilerWasmTargetLazyCompile(t *testing.T) {
 	})
 }
 
+func TestCompilerWasmTargetPlanOptimization(t *testing.T) {
+	files := map[string]string{
+		"test.rego": `package test
+
+		p { input.xs[_] = input.y }`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+
+		compiler := New().WithPaths(root).WithTarget("wasm").WithEntrypoints("test/p").WithOptimizationLevel(2)
+		err := compiler.Build(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var msgs []string
+
+		for _, d := range compiler.Debug() {
+			if strings.HasPrefix(d.Message, "plan size") {
+				msgs = append(msgs, d.String())
+			}
+		}
+
+		exp := len(ir.Passes(2)) + 1
+
+		if len(msgs) != exp || !strings.HasPrefix(msgs[0], "plan size before optimization: ") {
+			t.Fatalf("expected %d plan size messages but got: %v", exp, msgs)
+		}
+	})
+}
+
+func TestCompilerGoTarget(t *testing.T) {
+	files := map[string]string{
+		"test.rego": `package test
+
+		p { input.x = q }
+		q = "foo"`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+
+		buf := bytes.NewBuffer(nil)
+		compiler := New().WithPaths(root).WithTarget("go").WithGoPackage("authz").WithEntrypoints("test/p").WithOutput(buf)
+		err := compiler.Build(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		src := buf.String()
+
+		for _, exp := range []string{"package authz", "func Eval(input, data interface{})", "// g0.data.test.p\n", "// g0.data.test.q\n"} {
+			if !strings.Contains(src, exp) {
+				t.Fatalf("expected generated code to contain %q but got:\n\n%v", exp, src)
+			}
+		}
+	})
+}
+
+func TestCompilerPlanTarget(t *testing.T) {
+	files := map[string]string{
+		"test.rego": `package test
+
+		p { input.x = q }
+		q = "foo"`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+
+		buf := bytes.NewBuffer(nil)
+		compiler := New().WithPaths(root).WithTarget("plan").WithEntrypoints("test/p").WithOutput(buf)
+		err := compiler.Build(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := bundle.NewReader(buf).Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		policy, err := ir.Unmarshal(b.Plan)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e, err := eval.New(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rs, err := e.Eval(map[string]interface{}{"x": "foo"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := []map[string]interface{}{{"result": true}}
+
+		if !reflect.DeepEqual(rs, exp) {
+			t.Fatalf("Expected %v but got %v", exp, rs)
+		}
+	})
+}
+
 func TestCompilerSetRevision(t *testing.T) {
 	files := map[string]string{
 		"test.rego": `package test
@@ -0,0 +1,370 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package runtime contains the values and functions that policies compiled to
+// Go source (see the "go" target of the compile package) depend on. Values are
+// represented as ast.Value and undefined values are represented as nil.
+// Built-in functions are evaluated by the topdown implementations.
+//
+// The functions in this package are called by generated code and panic on
+// errors. The panics are recovered by Eval and EvalValue.
+package runtime
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+// Plan is the signature of the function generated for the plan of a compiled
+// policy. The plan adds the results of the query to the state.
+type Plan func(s *State, input, data ast.Value)
+
+// Error is returned if the evaluation of a compiled policy fails because of
+// conflicting values, e.g., if a complete rule produces multiple values.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Error messages match the messages of the wasm target.
+const (
+	errVarAssignConflict    = "var assignment conflict"
+	errObjectInsertConflict = "object insert conflict"
+	errObjectMergeConflict  = "object merge conflict"
+	errWithConflict         = "with target conflict"
+)
+
+// halt wraps errors that abort the evaluation.
+type halt struct {
+	err error
+}
+
+// State holds the state of a single evaluation of a compiled policy.
+type State struct {
+	bctx    topdown.BuiltinContext
+	results ast.Set
+}
+
+// Eval evaluates the plan with the input and data documents and returns the
+// query results. Each result binds the variables of the query. Input and data
+// are converted with ast.InterfaceToValue. If input is nil, it is undefined.
+func Eval(plan Plan, input, data interface{}) ([]map[string]interface{}, error) {
+
+	var x, y ast.Value
+	var err error
+
+	if input != nil {
+		if x, err = ast.InterfaceToValue(input); err != nil {
+			return nil, err
+		}
+	}
+
+	if data != nil {
+		if y, err = ast.InterfaceToValue(data); err != nil {
+			return nil, err
+		}
+	}
+
+	rs, err := EvalValue(plan, x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]map[string]interface{}, 0, rs.Len())
+
+	err = rs.Iter(func(t *ast.Term) error {
+		bindings, err := ast.JSON(t.Value)
+		if err != nil {
+			return err
+		}
+		result = append(result, bindings.(map[string]interface{}))
+		return nil
+	})
+
+	return result, err
+}
+
+// EvalValue evaluates the plan with the input and data documents and returns
+// the set of query results. If input is nil, it is undefined. If data is nil,
+// it is an empty object.
+func EvalValue(plan Plan, input, data ast.Value) (rs ast.Set, err error) {
+
+	if data == nil {
+		data = ast.NewObject()
+	}
+
+	s := &State{
+		bctx: topdown.BuiltinContext{
+			Context: context.Background(),
+			Seed:    rand.Reader,
+			Time:    ast.NumberTerm(json.Number(strconv.FormatInt(time.Now().UnixNano(), 10))),
+			Cache:   builtins.Cache{},
+		},
+		results: ast.NewSet(),
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			h, ok := r.(halt)
+			if !ok {
+				panic(r)
+			}
+			rs, err = nil, h.err
+		}
+	}()
+
+	plan(s, input, data)
+
+	return s.results, nil
+}
+
+// Add adds a query result to the result set.
+func (s *State) Add(v ast.Value) {
+	s.results.Add(ast.NewTerm(v))
+}
+
+// Call calls the built-in function and returns the result or nil if the
+// result is undefined. Errors abort the evaluation.
+func (s *State) Call(name string, args ...ast.Value) ast.Value {
+
+	fn := topdown.GetBuiltin(name)
+	if fn == nil {
+		panic(halt{fmt.Errorf("undefined built-in function %q", name)})
+	}
+
+	terms := make([]*ast.Term, len(args))
+	for i := range args {
+		terms[i] = ast.NewTerm(args[i])
+	}
+
+	var result ast.Value
+
+	err := fn(s.bctx, terms, func(t *ast.Term) error {
+		result = t.Value
+		return nil
+	})
+
+	if err != nil {
+		panic(halt{err})
+	}
+
+	return result
+}
+
+// Get returns the value of the key in the source or nil if the key does not
+// exist. Arrays are indexed by integers and sets contain their elements.
+func Get(source, key ast.Value) ast.Value {
+	switch source := source.(type) {
+	case ast.Object:
+		if t := source.Get(ast.NewTerm(key)); t != nil {
+			return t.Value
+		}
+	case ast.Array:
+		if t := source.Get(ast.NewTerm(key)); t != nil {
+			return t.Value
+		}
+	case ast.Set:
+		if t := ast.NewTerm(key); source.Contains(t) {
+			return key
+		}
+	}
+	return nil
+}
+
+// Len returns the number of elements in the source or the number of bytes if
+// the source is a string.
+func Len(source ast.Value) ast.Value {
+	var n int
+	switch source := source.(type) {
+	case ast.Object:
+		n = source.Len()
+	case ast.Array:
+		n = len(source)
+	case ast.Set:
+		n = source.Len()
+	case ast.String:
+		n = len(source)
+	}
+	return ast.IntNumberTerm(n).Value
+}
+
+// Iterator iterates over the keys and values of a collection. The keys of
+// arrays are the indices and the keys of sets are the elements.
+type Iterator struct {
+	source ast.Value
+	keys   []*ast.Term
+	i      int
+}
+
+// Iter returns an iterator for the source. The iterator is empty if the
+// source is not a collection.
+func Iter(source ast.Value) Iterator {
+	it := Iterator{source: source, i: -1}
+	switch source := source.(type) {
+	case ast.Object:
+		it.keys = source.Keys()
+	case ast.Set:
+		it.keys = source.Slice()
+	}
+	return it
+}
+
+// Next advances the iterator and returns false if the end has been reached.
+func (it *Iterator) Next() bool {
+	it.i++
+	if arr, ok := it.source.(ast.Array); ok {
+		return it.i < len(arr)
+	}
+	return it.i < len(it.keys)
+}
+
+// Key returns the current key.
+func (it *Iterator) Key() ast.Value {
+	if _, ok := it.source.(ast.Array); ok {
+		return ast.IntNumberTerm(it.i).Value
+	}
+	return it.keys[it.i].Value
+}
+
+// Value returns the current value.
+func (it *Iterator) Value() ast.Value {
+	switch source := it.source.(type) {
+	case ast.Array:
+		return source[it.i].Value
+	case ast.Object:
+		return source.Get(it.keys[it.i]).Value
+	}
+	return it.keys[it.i].Value
+}
+
+// ArrayAppend returns the array with the value appended.
+func ArrayAppend(arr, v ast.Value) ast.Value {
+	return append(arr.(ast.Array), ast.NewTerm(v))
+}
+
+// ObjectInsert inserts the key and value into the object.
+func ObjectInsert(obj, k, v ast.Value) {
+	obj.(ast.Object).Insert(ast.NewTerm(k), ast.NewTerm(v))
+}
+
+// ObjectInsertOnce inserts the key and value into the object. The evaluation
+// is aborted if the object contains the key with a different value.
+func ObjectInsertOnce(obj, k, v ast.Value) {
+	o := obj.(ast.Object)
+	key := ast.NewTerm(k)
+	if t := o.Get(key); t != nil {
+		if ast.Compare(t.Value, v) != 0 {
+			panic(halt{&Error{Message: errObjectInsertConflict}})
+		}
+		return
+	}
+	o.Insert(key, ast.NewTerm(v))
+}
+
+// ObjectMerge returns the recursive merge of the objects. The evaluation is
+// aborted if the objects contain the same key with values that are not both
+// objects.
+func ObjectMerge(a, b ast.Value) ast.Value {
+	merged := merge(a, b)
+	if merged == nil {
+		panic(halt{&Error{Message: errObjectMergeConflict}})
+	}
+	return merged
+}
+
+func merge(a, b ast.Value) ast.Value {
+
+	objA, ok1 := a.(ast.Object)
+	objB, ok2 := b.(ast.Object)
+
+	if !ok1 || !ok2 {
+		return nil
+	}
+
+	result := ast.NewObject()
+
+	stop := objA.Until(func(k, v *ast.Term) bool {
+		if other := objB.Get(k); other != nil {
+			merged := merge(v.Value, other.Value)
+			if merged == nil {
+				return true
+			}
+			result.Insert(k, ast.NewTerm(merged))
+		} else {
+			result.Insert(k, v)
+		}
+		return false
+	})
+
+	if stop {
+		return nil
+	}
+
+	objB.Foreach(func(k, v *ast.Term) {
+		if objA.Get(k) == nil {
+			result.Insert(k, v)
+		}
+	})
+
+	return result
+}
+
+// SetAdd adds the value to the set.
+func SetAdd(set, v ast.Value) {
+	set.(ast.Set).Add(ast.NewTerm(v))
+}
+
+// AssignOnce returns the source if the target is undefined or equal to the
+// source. The evaluation is aborted otherwise.
+func AssignOnce(target, source ast.Value) ast.Value {
+	if target != nil && ast.Compare(target, source) != 0 {
+		panic(halt{&Error{Message: errVarAssignConflict}})
+	}
+	return source
+}
+
+// Upsert returns a copy of the document with the value inserted at the path.
+// Objects along the path are copied and missing objects are created. The
+// evaluation is aborted if the path refers into a value that is not an
+// object.
+func Upsert(doc ast.Value, path []ast.Value, value ast.Value) ast.Value {
+
+	if len(path) == 0 {
+		return value
+	}
+
+	var obj ast.Object
+
+	switch doc := doc.(type) {
+	case nil:
+		obj = ast.NewObject()
+	case ast.Object:
+		obj = ast.NewObject()
+		doc.Foreach(obj.Insert)
+	default:
+		panic(halt{&Error{Message: errWithConflict}})
+	}
+
+	key := ast.NewTerm(path[0])
+	var child ast.Value
+
+	if t := obj.Get(key); t != nil {
+		child = t.Value
+	}
+
+	obj.Insert(key, ast.NewTerm(Upsert(child, path[1:], value)))
+
+	return obj
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestEval(t *testing.T) {
+
+	plan := func(s *State, input, data ast.Value) {
+		x := s.Call("plus", Get(input, ast.String("x")), ast.Number("1"))
+		result := ast.NewObject()
+		ObjectInsert(result, ast.String("y"), x)
+		s.Add(result)
+	}
+
+	rs, err := Eval(plan, map[string]interface{}{"x": 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []map[string]interface{}{{"y": json.Number("2")}}
+
+	if !reflect.DeepEqual(rs, exp) {
+		t.Fatalf("Expected %v but got %v", exp, rs)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+
+	tests := []struct {
+		note string
+		plan Plan
+		exp  string
+	}{
+		{
+			note: "assignment conflict",
+			plan: func(s *State, input, data ast.Value) {
+				AssignOnce(ast.Number("1"), ast.Number("2"))
+			},
+			exp: "var assignment conflict",
+		},
+		{
+			note: "insert conflict",
+			plan: func(s *State, input, data ast.Value) {
+				obj := ast.NewObject()
+				ObjectInsertOnce(obj, ast.String("a"), ast.Number("1"))
+				ObjectInsertOnce(obj, ast.String("a"), ast.Number("1"))
+				ObjectInsertOnce(obj, ast.String("a"), ast.Number("2"))
+			},
+			exp: "object insert conflict",
+		},
+		{
+			note: "merge conflict",
+			plan: func(s *State, input, data ast.Value) {
+				ObjectMerge(ast.MustParseTerm(`{"a": {"b": 1}}`).Value, ast.MustParseTerm(`{"a": {"b": 2}}`).Value)
+			},
+			exp: "object merge conflict",
+		},
+		{
+			note: "with conflict",
+			plan: func(s *State, input, data ast.Value) {
+				Upsert(ast.MustParseTerm(`{"a": 1}`).Value, []ast.Value{ast.String("a"), ast.String("b")}, ast.Number("1"))
+			},
+			exp: "with target conflict",
+		},
+		{
+			note: "built-in error",
+			plan: func(s *State, input, data ast.Value) {
+				s.Call("plus", ast.String("a"), ast.Number("1"))
+			},
+			exp: "eval_type_error: plus: operand 1 must be number but got string",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			_, err := EvalValue(tc.plan, nil, nil)
+			if err == nil || err.Error() != tc.exp {
+				t.Fatalf("Expected error %q but got %v", tc.exp, err)
+			}
+		})
+	}
+}
+
+func TestObjectMerge(t *testing.T) {
+	a := ast.MustParseTerm(`{"a": {"b": 1}, "c": 2}`).Value
+	b := ast.MustParseTerm(`{"a": {"d": 3}, "e": 4}`).Value
+	exp := ast.MustParseTerm(`{"a": {"b": 1, "d": 3}, "c": 2, "e": 4}`).Value
+	if result := ObjectMerge(a, b); result.Compare(exp) != 0 {
+		t.Fatalf("Expected %v but got %v", exp, result)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+
+	doc := ast.MustParseTerm(`{"a": {"b": 1}, "c": 2}`).Value
+	path := []ast.Value{ast.String("a"), ast.String("d"), ast.String("e")}
+
+	result := Upsert(doc, path, ast.Number("3"))
+	exp := ast.MustParseTerm(`{"a": {"b": 1, "d": {"e": 3}}, "c": 2}`).Value
+
+	if result.Compare(exp) != 0 {
+		t.Fatalf("Expected %v but got %v", exp, result)
+	}
+
+	if orig := ast.MustParseTerm(`{"a": {"b": 1}, "c": 2}`).Value; doc.Compare(orig) != 0 {
+		t.Fatalf("Expected document to be unchanged but got %v", doc)
+	}
+
+	if result := Upsert(nil, path[:1], ast.Number("1")); result.Compare(ast.MustParseTerm(`{"a": 1}`).Value) != 0 {
+		t.Fatalf("Expected undefined document to be created but got %v", result)
+	}
+}
+
+func TestIter(t *testing.T) {
+
+	tests := []struct {
+		source string
+		keys   []ast.Value
+		values []ast.Value
+	}{
+		{`[1, 2]`, []ast.Value{ast.Number("0"), ast.Number("1")}, []ast.Value{ast.Number("1"), ast.Number("2")}},
+		{`{"a": 1}`, []ast.Value{ast.String("a")}, []ast.Value{ast.Number("1")}},
+		{`{"a"}`, []ast.Value{ast.String("a")}, []ast.Value{ast.String("a")}},
+		{`"a"`, nil, nil},
+	}
+
+	for _, tc := range tests {
+		var keys, values []ast.Value
+		for it := Iter(ast.MustParseTerm(tc.source).Value); it.Next(); {
+			keys = append(keys, it.Key())
+			values = append(values, it.Value())
+		}
+		if !reflect.DeepEqual(keys, tc.keys) || !reflect.DeepEqual(values, tc.values) {
+			t.Errorf("%v: expected %v/%v but got %v/%v", tc.source, tc.keys, tc.values, keys, values)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+
+	tests := []struct {
+		source string
+		key    ast.Value
+		exp    ast.Value
+	}{
+		{`[1, 2]`, ast.Number("1"), ast.Number("2")},
+		{`[1, 2]`, ast.Number("2"), nil},
+		{`{"a": 1}`, ast.String("a"), ast.Number("1")},
+		{`{"a"}`, ast.String("a"), ast.String("a")},
+		{`{"a"}`, ast.String("b"), nil},
+		{`"a"`, ast.Number("0"), nil},
+	}
+
+	for _, tc := range tests {
+		if result := Get(ast.MustParseTerm(tc.source).Value, tc.key); !reflect.DeepEqual(result, tc.exp) {
+			t.Errorf("%v[%v]: expected %v but got %v", tc.source, tc.key, tc.exp, result)
+		}
+	}
+}
@@ -23,6 +23,8 @@ type Config struct {
 	Bundles                      json.RawMessage            `json:"bundles"`
 	DecisionLogs                 json.RawMessage            `json:"decision_logs"`
 	Status                       json.RawMessage            `json:"status"`
+	Replication                  json.RawMessage            `json:"replication"`
+	DataSources                  json.RawMessage            `json:"data_sources"`
 	Plugins                      map[string]json.RawMessage `json:"plugins"`
 	Keys                         json.RawMessage            `json:"keys"`
 	DefaultDecision              *string                    `json:"default_decision"`
@@ -41,7 +43,7 @@ func ParseConfig(raw []byte, id string) (*Config, error) {
 
 // PluginsEnabled returns true if one or more plugin features are enabled.
 func (c Config) PluginsEnabled() bool {
-	return c.Bundle != nil || c.Bundles != nil || c.DecisionLogs != nil || c.Status != nil || len(c.Plugins) > 0
+	return c.Bundle != nil || c.Bundles != nil || c.DecisionLogs != nil || c.Status != nil || c.Replication != nil || c.DataSources != nil || len(c.Plugins) > 0
 }
 
 // DefaultDecisionRef returns the default decision as a reference.
@@ -0,0 +1,159 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cover
+
+import (
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+)
+
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      int                `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity int              `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string            `xml:"name,attr"`
+	Filename   string            `xml:"filename,attr"`
+	LineRate   string            `xml:"line-rate,attr"`
+	BranchRate string            `xml:"branch-rate,attr"`
+	Complexity int               `xml:"complexity,attr"`
+	Methods    []coberturaMethod `xml:"methods>method"`
+	Lines      []coberturaLine   `xml:"lines>line"`
+}
+
+type coberturaMethod struct {
+	Name       string          `xml:"name,attr"`
+	Signature  string          `xml:"signature,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity int             `xml:"complexity,attr"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+// WriteCobertura writes the report to w in the Cobertura XML format. Files are
+// grouped into packages by the package they declare and each rule is reported
+// as a method of the class representing the file.
+func (r Report) WriteCobertura(w io.Writer) error {
+
+	result := coberturaCoverage{
+		BranchRate: "0",
+		Version:    "1",
+		Sources:    []string{"."},
+	}
+
+	packages := map[string]*coberturaPackage{}
+	packageHits := map[string][2]int{}
+
+	for _, file := range r.sortedFiles() {
+		fr := r.Files[file]
+
+		class := coberturaClass{
+			Name:       file,
+			Filename:   file,
+			BranchRate: "0",
+		}
+
+		var hit int
+		lines := fr.lines()
+		for _, l := range lines {
+			class.Lines = append(class.Lines, coberturaLine{Number: l.row, Hits: l.hits})
+			if l.hits > 0 {
+				hit++
+			}
+		}
+		class.LineRate = lineRate(hit, len(lines))
+
+		for _, f := range fr.functions() {
+			var rate string
+			if f.hits > 0 {
+				rate = "1"
+			} else {
+				rate = "0"
+			}
+			method := coberturaMethod{
+				Name:       f.name,
+				LineRate:   rate,
+				BranchRate: "0",
+				Lines:      []coberturaLine{{Number: f.row, Hits: f.hits}},
+			}
+			class.Methods = append(class.Methods, method)
+		}
+
+		pkg, ok := packages[fr.pkg]
+		if !ok {
+			pkg = &coberturaPackage{Name: fr.pkg, BranchRate: "0"}
+			packages[fr.pkg] = pkg
+		}
+		pkg.Classes = append(pkg.Classes, class)
+
+		counts := packageHits[fr.pkg]
+		counts[0] += hit
+		counts[1] += len(lines)
+		packageHits[fr.pkg] = counts
+
+		result.LinesCovered += hit
+		result.LinesValid += len(lines)
+	}
+
+	names := make([]string, 0, len(packages))
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		pkg := packages[name]
+		counts := packageHits[name]
+		pkg.LineRate = lineRate(counts[0], counts[1])
+		result.Packages = append(result.Packages, *pkg)
+	}
+
+	result.LineRate = lineRate(result.LinesCovered, result.LinesValid)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func lineRate(hit, total int) string {
+	if total == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(hit)/float64(total), 'f', -1, 64)
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"strings"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/topdown"
@@ -16,13 +17,15 @@ import (
 
 // Cover computes and reports on coverage.
 type Cover struct {
-	hits map[string]map[Position]struct{}
+	hits  map[string]map[Position]int
+	exits map[string]map[Position]int
 }
 
 // New returns a new Cover object.
 func New() *Cover {
 	return &Cover{
-		hits: map[string]map[Position]struct{}{},
+		hits:  map[string]map[Position]int{},
+		exits: map[string]map[Position]int{},
 	}
 }
 
@@ -53,14 +56,24 @@ func (c *Cover) Report(modules map[string]*ast.Module) (report Report) {
 			report.Files[file] = fr
 		}
 		fr.Covered = sortedPositionSliceToRangeSlice(covered)
+		fr.lineHits = make(map[int]int, len(hits))
+		for pos, n := range hits {
+			fr.lineHits[pos.Row] = n
+		}
 	}
 	for file, module := range modules {
 		notCovered := PositionSlice{}
+		var rules []ruleCoverage
 		ast.WalkRules(module, func(x *ast.Rule) bool {
 			if hasFileLocation(x.Head.Location) {
 				if !report.IsCovered(x.Location.File, x.Location.Row) {
 					notCovered = append(notCovered, Position{x.Head.Location.Row})
 				}
+				rules = append(rules, ruleCoverage{
+					name: x.Path().String(),
+					row:  x.Head.Location.Row,
+					hits: c.exits[x.Head.Location.File][Position{x.Head.Location.Row}],
+				})
 			}
 			return false
 		})
@@ -79,6 +92,8 @@ func (c *Cover) Report(modules map[string]*ast.Module) (report Report) {
 			report.Files[file] = fr
 		}
 		fr.NotCovered = sortedPositionSliceToRangeSlice(notCovered)
+		fr.pkg = module.Package.Path.String()
+		fr.rules = rules
 	}
 
 	var coveredLoc, notCoveredLoc int
@@ -111,6 +126,7 @@ func (c *Cover) TraceEvent(event topdown.Event) {
 	case topdown.ExitOp:
 		if rule, ok := event.Node.(*ast.Rule); ok {
 			c.setHit(rule.Head.Location)
+			c.setExit(rule.Head.Location)
 		}
 	case topdown.EvalOp:
 		if expr := event.Node.(*ast.Expr); expr != nil {
@@ -120,13 +136,21 @@ func (c *Cover) TraceEvent(event topdown.Event) {
 }
 
 func (c *Cover) setHit(loc *ast.Location) {
+	increment(c.hits, loc)
+}
+
+func (c *Cover) setExit(loc *ast.Location) {
+	increment(c.exits, loc)
+}
+
+func increment(counts map[string]map[Position]int, loc *ast.Location) {
 	if hasFileLocation(loc) {
-		hits, ok := c.hits[loc.File]
+		hits, ok := counts[loc.File]
 		if !ok {
-			hits = map[Position]struct{}{}
-			c.hits[loc.File] = hits
+			hits = map[Position]int{}
+			counts[loc.File] = hits
 		}
-		hits[Position{loc.Row}] = struct{}{}
+		hits[Position{loc.Row}]++
 	}
 }
 
@@ -161,6 +185,19 @@ type FileReport struct {
 	Covered    []Range `json:"covered,omitempty"`
 	NotCovered []Range `json:"not_covered,omitempty"`
 	Coverage   float64 `json:"coverage,omitempty"`
+
+	// The fields below are only available on reports produced by Cover.Report
+	// and are used by the LCOV and Cobertura writers.
+	pkg      string
+	lineHits map[int]int
+	rules    []ruleCoverage
+}
+
+// ruleCoverage records the number of times a rule was exited successfully.
+type ruleCoverage struct {
+	name string
+	row  int
+	hits int
 }
 
 // IsCovered returns true if the row is marked as covered in the report.
@@ -231,18 +268,55 @@ func (r Report) IsCovered(file string, row int) bool {
 	return r.Files[file].IsCovered(row)
 }
 
+// CheckThreshold returns a CoverageThresholdError if the global code coverage
+// percentage is lower than threshold. The error lists the files whose coverage
+// is lower than threshold.
+func (r Report) CheckThreshold(threshold float64) error {
+	if r.Coverage >= threshold {
+		return nil
+	}
+	err := &CoverageThresholdError{
+		Coverage:  r.Coverage,
+		Threshold: threshold,
+	}
+	for file, fr := range r.Files {
+		if fr.Coverage < threshold {
+			err.Files = append(err.Files, FileCoverage{File: file, Coverage: fr.Coverage})
+		}
+	}
+	sort.Slice(err.Files, func(i, j int) bool {
+		return err.Files[i].File < err.Files[j].File
+	})
+	return err
+}
+
 // CoverageThresholdError represents an error raised when the global
 // code coverage percenta is lower than the specified threshold.
 type CoverageThresholdError struct {
 	Coverage  float64
 	Threshold float64
+	Files     []FileCoverage
+}
+
+// FileCoverage represents the code coverage percentage of a single file.
+type FileCoverage struct {
+	File     string
+	Coverage float64
 }
 
 func (e *CoverageThresholdError) Error() string {
-	return fmt.Sprintf(
+	var b strings.Builder
+	fmt.Fprintf(&b,
 		"Code coverage threshold not met: got %.2f instead of %.2f",
 		e.Coverage,
 		e.Threshold)
+	if len(e.Files) > 0 {
+		b.WriteString("\nFiles below threshold:")
+		for _, f := range e.Files {
+			fmt.Fprintf(&b, "\n  %v: %.2f", f.File, f.Coverage)
+		}
+	}
+	return b.String()
 }
 
 func sortedPositionSliceToRangeSlice(sorted []Position) (result []Range) {
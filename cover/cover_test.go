@@ -5,6 +5,7 @@
 package cover
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -139,6 +140,190 @@ p {
 	}
 }
 
+func TestCoverWriters(t *testing.T) {
+
+	cover := New()
+
+	module := `package test
+
+p { input.x == 1 }
+p { input.y == 1 }
+
+q {
+	input.z
+	false
+}
+`
+
+	parsedModule, err := ast.ParseModule("test.rego", module)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range []map[string]interface{}{{"x": 1}, {"x": 1, "y": 1}} {
+		eval := rego.New(
+			rego.Module("test.rego", module),
+			rego.Query("data.test.p"),
+			rego.Input(input),
+			rego.QueryTracer(cover),
+		)
+		if _, err := eval.Eval(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := cover.Report(map[string]*ast.Module{
+		"test.rego": parsedModule,
+	})
+
+	t.Run("lcov", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := report.WriteLCOV(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		exp := `TN:
+SF:test.rego
+FN:3,data.test.p
+FN:4,data.test.p#2
+FN:6,data.test.q
+FNDA:2,data.test.p
+FNDA:1,data.test.p#2
+FNDA:0,data.test.q
+FNF:3
+FNH:2
+DA:3,4
+DA:4,2
+DA:6,0
+DA:7,0
+DA:8,0
+LF:5
+LH:2
+end_of_record
+`
+
+		if buf.String() != exp {
+			t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp, buf.String())
+		}
+	})
+
+	t.Run("cobertura", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := report.WriteCobertura(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		exp := `<?xml version="1.0" encoding="UTF-8"?>
+<coverage line-rate="0.4" branch-rate="0" lines-covered="2" lines-valid="5" branches-covered="0" branches-valid="0" complexity="0" version="1">
+  <sources>
+    <source>.</source>
+  </sources>
+  <packages>
+    <package name="data.test" line-rate="0.4" branch-rate="0" complexity="0">
+      <classes>
+        <class name="test.rego" filename="test.rego" line-rate="0.4" branch-rate="0" complexity="0">
+          <methods>
+            <method name="data.test.p" signature="" line-rate="1" branch-rate="0" complexity="0">
+              <lines>
+                <line number="3" hits="2"></line>
+              </lines>
+            </method>
+            <method name="data.test.p#2" signature="" line-rate="1" branch-rate="0" complexity="0">
+              <lines>
+                <line number="4" hits="1"></line>
+              </lines>
+            </method>
+            <method name="data.test.q" signature="" line-rate="0" branch-rate="0" complexity="0">
+              <lines>
+                <line number="6" hits="0"></line>
+              </lines>
+            </method>
+          </methods>
+          <lines>
+            <line number="3" hits="4"></line>
+            <line number="4" hits="2"></line>
+            <line number="6" hits="0"></line>
+            <line number="7" hits="0"></line>
+            <line number="8" hits="0"></line>
+          </lines>
+        </class>
+      </classes>
+    </package>
+  </packages>
+</coverage>
+`
+
+		if buf.String() != exp {
+			t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp, buf.String())
+		}
+	})
+
+	t.Run("lcov from json", func(t *testing.T) {
+		bs, err := json.Marshal(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded Report
+		if err := json.Unmarshal(bs, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := decoded.WriteLCOV(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		exp := `TN:
+SF:test.rego
+FNF:0
+FNH:0
+DA:3,1
+DA:4,1
+DA:6,0
+DA:7,0
+DA:8,0
+LF:5
+LH:2
+end_of_record
+`
+
+		if buf.String() != exp {
+			t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp, buf.String())
+		}
+	})
+}
+
+func TestCheckThreshold(t *testing.T) {
+
+	report := Report{
+		Files: map[string]*FileReport{
+			"a.rego": {Coverage: 100},
+			"b.rego": {Coverage: 50},
+			"c.rego": {Coverage: 25},
+		},
+		Coverage: 60,
+	}
+
+	if err := report.CheckThreshold(60); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err := report.CheckThreshold(75)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	exp := `Code coverage threshold not met: got 60.00 instead of 75.00
+Files below threshold:
+  b.rego: 50.00
+  c.rego: 25.00`
+
+	if err.Error() != exp {
+		t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp, err)
+	}
+}
+
 func TestCoverTraceConfig(t *testing.T) {
 	ct := topdown.QueryTracer(New())
 	conf := ct.Config()
@@ -0,0 +1,108 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cover
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// WriteLCOV writes the report to w in the LCOV tracefile format. Each rule is
+// reported as a function and each covered or not covered line is reported
+// with its hit count.
+func (r Report) WriteLCOV(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+
+	for _, file := range r.sortedFiles() {
+		fr := r.Files[file]
+		fmt.Fprintln(buf, "TN:")
+		fmt.Fprintf(buf, "SF:%v\n", file)
+
+		functions := fr.functions()
+		var hitFunctions int
+		for _, f := range functions {
+			fmt.Fprintf(buf, "FN:%d,%v\n", f.row, f.name)
+		}
+		for _, f := range functions {
+			fmt.Fprintf(buf, "FNDA:%d,%v\n", f.hits, f.name)
+			if f.hits > 0 {
+				hitFunctions++
+			}
+		}
+		fmt.Fprintf(buf, "FNF:%d\n", len(functions))
+		fmt.Fprintf(buf, "FNH:%d\n", hitFunctions)
+
+		lines := fr.lines()
+		var hitLines int
+		for _, l := range lines {
+			fmt.Fprintf(buf, "DA:%d,%d\n", l.row, l.hits)
+			if l.hits > 0 {
+				hitLines++
+			}
+		}
+		fmt.Fprintf(buf, "LF:%d\n", len(lines))
+		fmt.Fprintf(buf, "LH:%d\n", hitLines)
+		fmt.Fprintln(buf, "end_of_record")
+	}
+
+	return buf.Flush()
+}
+
+func (r Report) sortedFiles() []string {
+	files := make([]string, 0, len(r.Files))
+	for file := range r.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+type lineHits struct {
+	row  int
+	hits int
+}
+
+// lines returns the covered and not covered lines of the file sorted by line
+// number. If the hit count of a covered line is unknown (e.g., the report was
+// decoded from JSON), the line is reported as hit once.
+func (fr *FileReport) lines() []lineHits {
+	var result []lineHits
+	for _, rng := range fr.Covered {
+		for row := rng.Start.Row; row <= rng.End.Row; row++ {
+			n := fr.lineHits[row]
+			if n == 0 {
+				n = 1
+			}
+			result = append(result, lineHits{row: row, hits: n})
+		}
+	}
+	for _, rng := range fr.NotCovered {
+		for row := rng.Start.Row; row <= rng.End.Row; row++ {
+			result = append(result, lineHits{row: row})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].row < result[j].row
+	})
+	return result
+}
+
+// functions returns the rules of the file. Rules defined incrementally are
+// reported once per definition; subsequent definitions have their index
+// appended to the name so that function names are unique within the file.
+func (fr *FileReport) functions() []ruleCoverage {
+	result := make([]ruleCoverage, len(fr.rules))
+	seen := map[string]int{}
+	for i, rule := range fr.rules {
+		seen[rule.name]++
+		if n := seen[rule.name]; n > 1 {
+			rule.name = fmt.Sprintf("%v#%d", rule.name, n)
+		}
+		result[i] = rule
+	}
+	return result
+}
@@ -151,6 +151,10 @@ func (d *Downloader) download(ctx context.Context, m metrics.Metrics) (*bundle.B
 
 	d.logDebug("Download starting.")
 
+	if d.client.Config().Type == ociServiceType {
+		return d.downloadOCI(ctx, m)
+	}
+
 	resp, err := d.client.WithHeader("If-None-Match", d.etag).Do(ctx, "GET", d.path)
 	if err != nil {
 		return nil, "", errors.Wrap(err, "request failed")
@@ -0,0 +1,71 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package download
+
+import (
+	"bytes"
+	"context"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/internal/oci"
+	"github.com/open-policy-agent/opa/metrics"
+)
+
+// ociServiceType is the type of services that are OCI registries. The path of
+// downloaders for these services is a reference of the form
+// repository[:tag|@digest].
+const ociServiceType = "oci"
+
+// downloadOCI resolves the reference to a manifest and downloads the bundle
+// layer of the manifest. The digest of the manifest is used as the ETag, so
+// the layer is only downloaded if the reference points to a new manifest.
+func (d *Downloader) downloadOCI(ctx context.Context, m metrics.Metrics) (*bundle.Bundle, string, error) {
+
+	ref, err := oci.ParseReference(d.path, false)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client := oci.NewClient(d.client)
+
+	manifest, digest, err := client.FetchManifest(ctx, ref, d.etag)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "request failed")
+	}
+
+	if manifest == nil {
+		d.logDebug("Manifest %v not modified.", digest)
+		return nil, digest, nil
+	}
+
+	layer, err := manifest.BundleLayer()
+	if err != nil {
+		return nil, "", err
+	}
+
+	d.logDebug("Download in progress.")
+
+	m.Timer(metrics.RegoLoadBundles).Start()
+	defer m.Timer(metrics.RegoLoadBundles).Stop()
+
+	bs, err := client.FetchBlob(ctx, ref, layer, bundle.BundleLimitBytes)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "request failed")
+	}
+
+	baseURL := path.Join(d.client.Config().URL, ref.Repository)
+	loader := bundle.NewTarballLoaderWithBaseURL(bytes.NewReader(bs), baseURL)
+	reader := bundle.NewCustomReader(loader).WithMetrics(m).WithBundleVerificationConfig(d.bvc)
+
+	b, err := reader.Read()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &b, digest, nil
+}
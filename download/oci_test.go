@@ -0,0 +1,153 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package download
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/internal/oci"
+	"github.com/open-policy-agent/opa/internal/oci/ocitest"
+	"github.com/open-policy-agent/opa/plugins/rest"
+)
+
+func newOCIArtifact(t *testing.T, revision string) *oci.Artifact {
+	t.Helper()
+
+	b := bundle.Bundle{
+		Manifest: bundle.Manifest{Revision: revision},
+		Data:     map[string]interface{}{"revision": revision},
+	}
+
+	var buf bytes.Buffer
+	if err := bundle.NewWriter(&buf).Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := oci.NewArtifact(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func TestOCIDownload(t *testing.T) {
+
+	for _, credentials := range []string{"", "Basic dXNlcjpwYXNz"} {
+		t.Run(fmt.Sprintf("credentials=%q", credentials), func(t *testing.T) {
+
+			ctx := context.Background()
+			registry := ocitest.New()
+			registry.Credentials = credentials
+			defer registry.Server.Close()
+
+			config := fmt.Sprintf(`{"name": "registry", "type": "oci", "url": %q}`, registry.Server.URL)
+			if credentials != "" {
+				config = fmt.Sprintf(`{"name": "registry", "type": "oci", "url": %q, "credentials": {"bearer": {"scheme": "Basic", "token": "dXNlcjpwYXNz"}}}`, registry.Server.URL)
+			}
+
+			client, err := rest.New([]byte(config))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a1 := newOCIArtifact(t, "v1")
+			registry.Push("policies/authz", "latest", a1)
+
+			var updates []Update
+
+			d := New(Config{}, client, "policies/authz").WithCallback(func(_ context.Context, u Update) {
+				updates = append(updates, u)
+			})
+
+			if err := d.oneShot(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(updates) != 1 || updates[0].Bundle == nil || updates[0].Bundle.Manifest.Revision != "v1" {
+				t.Fatalf("Unexpected updates: %+v", updates)
+			}
+
+			if updates[0].ETag != a1.Manifest.Digest {
+				t.Fatalf("Expected ETag %v but got %v", a1.Manifest.Digest, updates[0].ETag)
+			}
+
+			// The manifest is not modified so the layer is not downloaded.
+			if err := d.oneShot(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(updates) != 2 || updates[1].Bundle != nil || updates[1].ETag != a1.Manifest.Digest {
+				t.Fatalf("Unexpected updates: %+v", updates)
+			}
+
+			a2 := newOCIArtifact(t, "v2")
+			registry.Push("policies/authz", "latest", a2)
+
+			if err := d.oneShot(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(updates) != 3 || updates[2].Bundle == nil || updates[2].Bundle.Manifest.Revision != "v2" || updates[2].ETag != a2.Manifest.Digest {
+				t.Fatalf("Unexpected updates: %+v", updates)
+			}
+
+			blobRequests := 0
+			for _, r := range registry.Requests() {
+				if strings.HasPrefix(r, "GET /v2/policies/authz/blobs/") {
+					blobRequests++
+				}
+			}
+
+			if blobRequests != 2 {
+				t.Fatalf("Expected 2 blob requests but got %d: %v", blobRequests, registry.Requests())
+			}
+		})
+	}
+}
+
+func TestOCIDownloadErrors(t *testing.T) {
+
+	ctx := context.Background()
+	registry := ocitest.New()
+	defer registry.Server.Close()
+
+	client, err := rest.New([]byte(fmt.Sprintf(`{"name": "registry", "type": "oci", "url": %q}`, registry.Server.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newOCIArtifact(t, "v1")
+	registry.Push("policies/authz", "v1", a)
+
+	tests := []struct {
+		note string
+		path string
+		err  string
+	}{
+		{note: "bad reference", path: "Policies/authz", err: "invalid repository"},
+		{note: "not found", path: "policies/authz:v2", err: "server replied with not found"},
+		{note: "unknown digest", path: "policies/authz@" + a.Bundle.Digest, err: "server replied with not found"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			d := New(Config{}, client, tc.path)
+			if err := d.oneShot(ctx); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("Expected error containing %q but got %v", tc.err, err)
+			}
+		})
+	}
+
+	d := New(Config{}, client, "policies/authz@"+a.Manifest.Digest)
+	if err := d.oneShot(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Elasticsearch translates the condition tree into an Elasticsearch query
+// DSL document. Fields are used as is. String fields compared with eq, neq
+// or the string operators must be keyword fields.
+func Elasticsearch(n *Node) (map[string]interface{}, error) {
+	switch n.Type {
+	case True:
+		return map[string]interface{}{"match_all": map[string]interface{}{}}, nil
+	case False:
+		return map[string]interface{}{"match_none": map[string]interface{}{}}, nil
+	case And, Or, Not:
+		children := make([]interface{}, len(n.Children))
+		for i := range n.Children {
+			child, err := Elasticsearch(n.Children[i])
+			if err != nil {
+				return nil, err
+			}
+			children[i] = child
+		}
+		switch n.Type {
+		case And:
+			return esBool("filter", children...), nil
+		case Or:
+			q := esBool("should", children...)
+			q["bool"].(map[string]interface{})["minimum_should_match"] = 1
+			return q, nil
+		default:
+			return esBool("must_not", children...), nil
+		}
+	case Compare:
+		return esCompare(n)
+	}
+	return nil, fmt.Errorf("%v cannot be translated into an Elasticsearch query", n)
+}
+
+func esCompare(n *Node) (map[string]interface{}, error) {
+
+	if n.Value == nil {
+		exists := map[string]interface{}{"exists": map[string]interface{}{"field": n.Field}}
+		switch n.Op {
+		case Equal:
+			return esBool("must_not", exists), nil
+		case NotEqual:
+			return exists, nil
+		}
+		return nil, fmt.Errorf("%v cannot be translated into an Elasticsearch query", n)
+	}
+
+	field := func(v interface{}) map[string]interface{} {
+		return map[string]interface{}{n.Field: v}
+	}
+
+	switch n.Op {
+	case Equal:
+		return map[string]interface{}{"term": field(n.Value)}, nil
+	case NotEqual:
+		return esBool("must_not", map[string]interface{}{"term": field(n.Value)}), nil
+	case LessThan, LessThanEq, GreaterThan, GreaterThanEq:
+		return map[string]interface{}{"range": field(map[string]interface{}{string(n.Op): n.Value})}, nil
+	case StartsWith:
+		return map[string]interface{}{"prefix": field(n.Value)}, nil
+	case EndsWith:
+		return map[string]interface{}{"wildcard": field("*" + esEscapeWildcard(n.Value.(string)))}, nil
+	case Contains:
+		return map[string]interface{}{"wildcard": field("*" + esEscapeWildcard(n.Value.(string)) + "*")}, nil
+	}
+
+	return nil, fmt.Errorf("%v cannot be translated into an Elasticsearch query", n)
+}
+
+func esBool(occur string, queries ...interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"bool": map[string]interface{}{occur: queries},
+	}
+}
+
+var esWildcardReplacer = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`)
+
+func esEscapeWildcard(s string) string {
+	return esWildcardReplacer.Replace(s)
+}
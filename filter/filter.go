@@ -0,0 +1,388 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package filter converts the results of partial evaluation into
+// language-neutral condition trees that are easy to translate into the query
+// languages of data stores.
+//
+// A condition tree consists of and, or and not nodes over comparisons of
+// fields with constant values. Fields are paths relative to the unknowns of
+// partial evaluation. For example, with input.rows unknown, the residual
+// queries
+//
+//	"alice" = input.rows.owner
+//
+//	input.rows.public
+//	not input.rows.deleted
+//
+// are converted into the following tree:
+//
+//	{"type": "or", "children": [
+//	  {"type": "compare", "unknown": "input.rows", "field": "owner", "op": "eq", "value": "alice"},
+//	  {"type": "and", "children": [
+//	    {"type": "compare", "unknown": "input.rows", "field": "public", "op": "eq", "value": true},
+//	    {"type": "not", "children": [
+//	      {"type": "compare", "unknown": "input.rows", "field": "deleted", "op": "eq", "value": true}
+//	    ]}
+//	  ]}
+//	]}
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// NodeType defines the type of a node in a condition tree.
+type NodeType string
+
+const (
+	// And nodes are true if all children are true.
+	And NodeType = "and"
+
+	// Or nodes are true if any child is true.
+	Or NodeType = "or"
+
+	// Not nodes have one child and are true if the child is false.
+	Not NodeType = "not"
+
+	// True nodes are always true.
+	True NodeType = "true"
+
+	// False nodes are always false.
+	False NodeType = "false"
+
+	// Compare nodes compare a field with a value.
+	Compare NodeType = "compare"
+
+	// Unsupported nodes represent expressions that cannot be converted. They
+	// are only created if Options.TagUnsupported is set.
+	Unsupported NodeType = "unsupported"
+)
+
+// Operator defines the comparison operator of a compare node.
+type Operator string
+
+// Comparison operators. The string operators compare a string field with a
+// string value.
+const (
+	Equal         Operator = "eq"
+	NotEqual      Operator = "neq"
+	LessThan      Operator = "lt"
+	LessThanEq    Operator = "lte"
+	GreaterThan   Operator = "gt"
+	GreaterThanEq Operator = "gte"
+	StartsWith    Operator = "startswith"
+	EndsWith      Operator = "endswith"
+	Contains      Operator = "contains"
+)
+
+// Node is a node in a condition tree.
+type Node struct {
+	Type     NodeType `json:"type"`
+	Children []*Node  `json:"children,omitempty"`
+
+	// Unknown is the unknown that the field of a compare node belongs to.
+	Unknown string `json:"unknown,omitempty"`
+
+	// Field is the dot separated path of a compare node relative to the
+	// unknown.
+	Field string `json:"field,omitempty"`
+
+	// Op and Value are the operator and the value that the field of a compare
+	// node is compared with. The value is a string, number, boolean or null.
+	Op    Operator    `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+
+	// Expr and Location are set on unsupported nodes and refer to the
+	// expression that could not be converted.
+	Expr     string        `json:"expr,omitempty"`
+	Location *ast.Location `json:"location,omitempty"`
+}
+
+// MarshalJSON returns the JSON encoding of the node. The value of compare
+// nodes is always included, even if it is null.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	type node Node
+	if n.Type != Compare {
+		return json.Marshal((*node)(n))
+	}
+	return json.Marshal(struct {
+		*node
+		Value interface{} `json:"value"`
+	}{(*node)(n), n.Value})
+}
+
+// String returns a Rego-like representation of the node.
+func (n *Node) String() string {
+	switch n.Type {
+	case And, Or:
+		parts := make([]string, len(n.Children))
+		for i := range n.Children {
+			parts[i] = n.Children[i].String()
+		}
+		return "(" + strings.Join(parts, " "+string(n.Type)+" ") + ")"
+	case Not:
+		return "not " + n.Children[0].String()
+	case Compare:
+		return fmt.Sprintf("%v.%v %v %v", n.Unknown, n.Field, n.Op, string(mustMarshal(n.Value)))
+	case Unsupported:
+		return "unsupported(" + n.Expr + ")"
+	}
+	return string(n.Type)
+}
+
+func mustMarshal(v interface{}) []byte {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return bs
+}
+
+// Error is returned if an expression cannot be converted.
+type Error struct {
+	Expr    *ast.Expr
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Expr == nil {
+		return e.Message
+	}
+	msg := fmt.Sprintf("%v: %v", e.Message, e.Expr)
+	if loc := e.Expr.Location; loc != nil {
+		if len(loc.File) > 0 {
+			return fmt.Sprintf("%v:%v: %v", loc.File, loc.Row, msg)
+		}
+		return fmt.Sprintf("%v:%v: %v", loc.Row, loc.Col, msg)
+	}
+	return msg
+}
+
+// Options controls how residual queries are converted.
+type Options struct {
+
+	// Unknowns are the unknowns of partial evaluation. Fields are paths
+	// relative to the unknowns. If the reference following an unknown starts
+	// with a variable, e.g., data.posts[x].owner for the unknown data.posts,
+	// the variable is treated as iteration over the rows and skipped. If no
+	// unknowns are set, input is the only unknown.
+	Unknowns []ast.Ref
+
+	// TagUnsupported replaces expressions that cannot be converted with
+	// unsupported nodes instead of returning an error.
+	TagUnsupported bool
+}
+
+// FromQueries converts the residual queries of partial evaluation into a
+// condition tree. The queries are combined with or and the expressions of
+// each query are combined with and. The tree is false if there are no queries
+// and true if any query is empty.
+func FromQueries(queries []ast.Body, opts Options) (*Node, error) {
+
+	if len(opts.Unknowns) == 0 {
+		opts.Unknowns = []ast.Ref{ast.InputRootRef}
+	}
+
+	c := converter{opts: opts}
+
+	or := &Node{Type: Or}
+
+	for _, body := range queries {
+		if len(body) == 0 {
+			return &Node{Type: True}, nil
+		}
+		and := &Node{Type: And}
+		for _, expr := range body {
+			node, err := c.expr(expr)
+			if err != nil {
+				return nil, err
+			}
+			and.Children = append(and.Children, node)
+		}
+		or.Children = append(or.Children, simplify(and))
+	}
+
+	if len(or.Children) == 0 {
+		return &Node{Type: False}, nil
+	}
+
+	return simplify(or), nil
+}
+
+// simplify replaces and and or nodes with a single child with the child.
+func simplify(n *Node) *Node {
+	if len(n.Children) == 1 && (n.Type == And || n.Type == Or) {
+		return n.Children[0]
+	}
+	return n
+}
+
+type converter struct {
+	opts Options
+}
+
+var comparisons = map[string]Operator{
+	ast.Equality.Name:      Equal,
+	ast.Equal.Name:         Equal,
+	ast.NotEqual.Name:      NotEqual,
+	ast.LessThan.Name:      LessThan,
+	ast.LessThanEq.Name:    LessThanEq,
+	ast.GreaterThan.Name:   GreaterThan,
+	ast.GreaterThanEq.Name: GreaterThanEq,
+	ast.StartsWith.Name:    StartsWith,
+	ast.EndsWith.Name:      EndsWith,
+	ast.Contains.Name:      Contains,
+}
+
+// flipped contains the operators to use when the operands are swapped.
+var flipped = map[Operator]Operator{
+	Equal:         Equal,
+	NotEqual:      NotEqual,
+	LessThan:      GreaterThan,
+	LessThanEq:    GreaterThanEq,
+	GreaterThan:   LessThan,
+	GreaterThanEq: LessThanEq,
+}
+
+func (c *converter) expr(expr *ast.Expr) (*Node, error) {
+
+	node, err := c.compare(expr)
+	if err != nil {
+		if !c.opts.TagUnsupported {
+			return nil, err
+		}
+		return &Node{Type: Unsupported, Expr: expr.String(), Location: expr.Location}, nil
+	}
+
+	if expr.Negated {
+		return &Node{Type: Not, Children: []*Node{node}}, nil
+	}
+
+	return node, nil
+}
+
+func (c *converter) compare(expr *ast.Expr) (*Node, error) {
+
+	if len(expr.With) > 0 {
+		return nil, &Error{Expr: expr, Message: "with modifiers cannot be converted"}
+	}
+
+	switch terms := expr.Terms.(type) {
+	case *ast.Term:
+		ref, ok := terms.Value.(ast.Ref)
+		if !ok {
+			return nil, &Error{Expr: expr, Message: "expression cannot be converted"}
+		}
+		node, err := c.field(expr, ref)
+		if err != nil {
+			return nil, err
+		}
+		node.Op = Equal
+		node.Value = true
+		return node, nil
+	case []*ast.Term:
+		name := expr.Operator().String()
+		op, ok := comparisons[name]
+		if !ok {
+			return nil, &Error{Expr: expr, Message: fmt.Sprintf("function %v cannot be converted", name)}
+		}
+		operands := expr.Operands()
+		if len(operands) != 2 {
+			return nil, &Error{Expr: expr, Message: "expression cannot be converted"}
+		}
+		a, b := operands[0], operands[1]
+		if _, ok := a.Value.(ast.Ref); !ok {
+			if _, ok := flipped[op]; ok {
+				a, b = b, a
+				op = flipped[op]
+			}
+		}
+		ref, ok := a.Value.(ast.Ref)
+		if !ok {
+			return nil, &Error{Expr: expr, Message: "expression does not refer to a field"}
+		}
+		node, err := c.field(expr, ref)
+		if err != nil {
+			return nil, err
+		}
+		value, err := c.value(expr, b)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := value.(string); !ok && (op == StartsWith || op == EndsWith || op == Contains) {
+			return nil, &Error{Expr: expr, Message: fmt.Sprintf("%v requires a string", name)}
+		}
+		node.Op = op
+		node.Value = value
+		return node, nil
+	}
+
+	return nil, &Error{Expr: expr, Message: "expression cannot be converted"}
+}
+
+// field returns a compare node for the field that the reference refers to.
+func (c *converter) field(expr *ast.Expr, ref ast.Ref) (*Node, error) {
+
+	var unknown ast.Ref
+
+	for _, u := range c.opts.Unknowns {
+		if ref.HasPrefix(u) && len(u) > len(unknown) {
+			unknown = u
+		}
+	}
+
+	if unknown == nil {
+		return nil, &Error{Expr: expr, Message: fmt.Sprintf("reference %v does not refer to an unknown", ref)}
+	}
+
+	rest := ref[len(unknown):]
+
+	if len(rest) > 0 {
+		if _, ok := rest[0].Value.(ast.Var); ok {
+			rest = rest[1:]
+		}
+	}
+
+	if len(rest) == 0 {
+		return nil, &Error{Expr: expr, Message: fmt.Sprintf("reference %v does not refer to a field", ref)}
+	}
+
+	path := make([]string, len(rest))
+
+	for i := range rest {
+		s, ok := rest[i].Value.(ast.String)
+		if !ok {
+			return nil, &Error{Expr: expr, Message: fmt.Sprintf("reference %v cannot be converted", ref)}
+		}
+		path[i] = string(s)
+	}
+
+	return &Node{Type: Compare, Unknown: unknown.String(), Field: strings.Join(path, ".")}, nil
+}
+
+func (c *converter) value(expr *ast.Expr, term *ast.Term) (interface{}, error) {
+	switch v := term.Value.(type) {
+	case ast.Null:
+		return nil, nil
+	case ast.Boolean:
+		return bool(v), nil
+	case ast.String:
+		return string(v), nil
+	case ast.Number:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		if f, ok := v.Float64(); ok {
+			return f, nil
+		}
+	case ast.Ref:
+		return nil, &Error{Expr: expr, Message: "fields can only be compared with values"}
+	}
+	return nil, &Error{Expr: expr, Message: fmt.Sprintf("%v %v cannot be converted", ast.TypeName(term.Value), term)}
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package filter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/util"
+)
+
+func parseQueries(queries []string) []ast.Body {
+	result := make([]ast.Body, len(queries))
+	for i, q := range queries {
+		if q == "" {
+			result[i] = ast.Body{}
+		} else {
+			result[i] = ast.MustParseBody(q)
+		}
+	}
+	return result
+}
+
+func TestFromQueries(t *testing.T) {
+
+	tests := []struct {
+		note     string
+		queries  []string
+		unknowns []string
+		tag      bool
+		exp      string
+		expError string
+	}{
+		{
+			note:    "no queries",
+			queries: []string{},
+			exp:     `{"type": "false"}`,
+		},
+		{
+			note:    "empty query",
+			queries: []string{`input.x = 1`, ``},
+			exp:     `{"type": "true"}`,
+		},
+		{
+			note:    "single expression",
+			queries: []string{`"alice" = input.owner`},
+			exp:     `{"type": "compare", "unknown": "input", "field": "owner", "op": "eq", "value": "alice"}`,
+		},
+		{
+			note:     "disjunction of conjunctions",
+			queries:  []string{`input.rows.owner == "alice"`, `input.rows.public; not input.rows.deleted; input.rows.meta.size > 1.5`},
+			unknowns: []string{"input.rows"},
+			exp: `{"type": "or", "children": [
+				{"type": "compare", "unknown": "input.rows", "field": "owner", "op": "eq", "value": "alice"},
+				{"type": "and", "children": [
+					{"type": "compare", "unknown": "input.rows", "field": "public", "op": "eq", "value": true},
+					{"type": "not", "children": [
+						{"type": "compare", "unknown": "input.rows", "field": "deleted", "op": "eq", "value": true}
+					]},
+					{"type": "compare", "unknown": "input.rows", "field": "meta.size", "op": "gt", "value": 1.5}
+				]}
+			]}`,
+		},
+		{
+			note:    "flipped operands",
+			queries: []string{`10 <= input.size; null != input.owner`},
+			exp: `{"type": "and", "children": [
+				{"type": "compare", "unknown": "input", "field": "size", "op": "gte", "value": 10},
+				{"type": "compare", "unknown": "input", "field": "owner", "op": "neq", "value": null}
+			]}`,
+		},
+		{
+			note:    "string operators",
+			queries: []string{`startswith(input.name, "a"); endswith(input.name, "b"); contains(input.name, "c")`},
+			exp: `{"type": "and", "children": [
+				{"type": "compare", "unknown": "input", "field": "name", "op": "startswith", "value": "a"},
+				{"type": "compare", "unknown": "input", "field": "name", "op": "endswith", "value": "b"},
+				{"type": "compare", "unknown": "input", "field": "name", "op": "contains", "value": "c"}
+			]}`,
+		},
+		{
+			note:     "iteration over rows",
+			queries:  []string{`data.posts[x].owner = "alice"`},
+			unknowns: []string{"data.posts", "data.posts.meta"},
+			exp:      `{"type": "compare", "unknown": "data.posts", "field": "owner", "op": "eq", "value": "alice"}`,
+		},
+		{
+			note:     "longest unknown",
+			queries:  []string{`data.posts.meta.owner = "alice"`},
+			unknowns: []string{"data.posts", "data.posts.meta"},
+			exp:      `{"type": "compare", "unknown": "data.posts.meta", "field": "owner", "op": "eq", "value": "alice"}`,
+		},
+		{
+			note:    "tagged",
+			queries: []string{`input.owner = "alice"; count(input.tags) > 1; not re_match("a", input.name)`},
+			tag:     true,
+			exp: `{"type": "and", "children": [
+				{"type": "compare", "unknown": "input", "field": "owner", "op": "eq", "value": "alice"},
+				{"type": "unsupported", "expr": "gt(count(input.tags), 1)", "location": {"file": "", "row": 1, "col": 24}},
+				{"type": "unsupported", "expr": "not re_match(\"a\", input.name)", "location": {"file": "", "row": 1, "col": 47}}
+			]}`,
+		},
+		{
+			note:     "unsupported function",
+			queries:  []string{`re_match("a", input.name)`},
+			expError: `1:1: function re_match cannot be converted: re_match("a", input.name)`,
+		},
+		{
+			note:     "iteration in field",
+			queries:  []string{`input.tags[_] = "x"`},
+			expError: `reference input.tags[_] cannot be converted`,
+		},
+		{
+			note:     "not an unknown",
+			queries:  []string{`data.x.y = 1`},
+			expError: `reference data.x.y does not refer to an unknown`,
+		},
+		{
+			note:     "unknown itself",
+			queries:  []string{`input = 1`},
+			expError: `reference input does not refer to a field`,
+		},
+		{
+			note:     "field comparison",
+			queries:  []string{`input.a = input.b`},
+			expError: `fields can only be compared with values`,
+		},
+		{
+			note:     "composite value",
+			queries:  []string{`input.a = [1]`},
+			expError: `array [1] cannot be converted`,
+		},
+		{
+			note:     "string operator with number",
+			queries:  []string{`startswith(input.a, 1)`},
+			expError: `startswith requires a string`,
+		},
+		{
+			note:     "no field",
+			queries:  []string{`x = 1`},
+			expError: `expression does not refer to a field`,
+		},
+		{
+			note:     "with",
+			queries:  []string{`input.a with input.a as true`},
+			expError: `with modifiers cannot be converted`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+
+			opts := Options{TagUnsupported: tc.tag}
+			for _, u := range tc.unknowns {
+				opts.Unknowns = append(opts.Unknowns, ast.MustParseRef(u))
+			}
+
+			node, err := FromQueries(parseQueries(tc.queries), opts)
+
+			if tc.expError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expError) {
+					t.Fatalf("Expected error containing %q but got %v", tc.expError, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			bs, err := json.Marshal(node)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var result, exp interface{}
+			if err := util.UnmarshalJSON(bs, &result); err != nil {
+				t.Fatal(err)
+			}
+			if err := util.UnmarshalJSON([]byte(tc.exp), &exp); err != nil {
+				t.Fatal(err)
+			}
+
+			if util.Compare(result, exp) != 0 {
+				t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", tc.exp, string(bs))
+			}
+		})
+	}
+}
+
+func TestNodeString(t *testing.T) {
+	node, err := FromQueries(parseQueries([]string{`input.a = 1; not input.b`, `input.c = null`}), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `((input.a eq 1 and not input.b eq true) or input.c eq null)`
+	if node.String() != exp {
+		t.Fatalf("Expected %v but got %v", exp, node.String())
+	}
+}
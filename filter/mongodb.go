@@ -0,0 +1,75 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package filter
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// MongoDB translates the condition tree into a MongoDB query filter document.
+// Fields are used as is, i.e., nested fields are addressed with dot notation.
+func MongoDB(n *Node) (map[string]interface{}, error) {
+	switch n.Type {
+	case True:
+		return map[string]interface{}{}, nil
+	case False:
+		return map[string]interface{}{"$expr": false}, nil
+	case And, Or, Not:
+		children := make([]interface{}, len(n.Children))
+		for i := range n.Children {
+			child, err := MongoDB(n.Children[i])
+			if err != nil {
+				return nil, err
+			}
+			children[i] = child
+		}
+		switch n.Type {
+		case And:
+			return map[string]interface{}{"$and": children}, nil
+		case Or:
+			return map[string]interface{}{"$or": children}, nil
+		default:
+			return map[string]interface{}{"$nor": children}, nil
+		}
+	case Compare:
+		return mongoCompare(n)
+	}
+	return nil, fmt.Errorf("%v cannot be translated into a MongoDB filter", n)
+}
+
+var mongoOperators = map[Operator]string{
+	Equal:         "$eq",
+	NotEqual:      "$ne",
+	LessThan:      "$lt",
+	LessThanEq:    "$lte",
+	GreaterThan:   "$gt",
+	GreaterThanEq: "$gte",
+}
+
+func mongoCompare(n *Node) (map[string]interface{}, error) {
+
+	if op, ok := mongoOperators[n.Op]; ok {
+		if n.Value == nil && op != "$eq" && op != "$ne" {
+			return nil, fmt.Errorf("%v cannot be translated into a MongoDB filter", n)
+		}
+		return map[string]interface{}{n.Field: map[string]interface{}{op: n.Value}}, nil
+	}
+
+	var pattern string
+
+	switch n.Op {
+	case StartsWith:
+		pattern = "^" + regexp.QuoteMeta(n.Value.(string))
+	case EndsWith:
+		pattern = regexp.QuoteMeta(n.Value.(string)) + "$"
+	case Contains:
+		pattern = regexp.QuoteMeta(n.Value.(string))
+	default:
+		return nil, fmt.Errorf("%v cannot be translated into a MongoDB filter", n)
+	}
+
+	return map[string]interface{}{n.Field: map[string]interface{}{"$regex": pattern}}, nil
+}
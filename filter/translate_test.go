@@ -0,0 +1,143 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package filter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/util"
+)
+
+func TestTranslate(t *testing.T) {
+
+	tests := []struct {
+		note     string
+		queries  []string
+		es       string
+		mongo    string
+		expError string
+	}{
+		{
+			note:    "false",
+			queries: []string{},
+			es:      `{"match_none": {}}`,
+			mongo:   `{"$expr": false}`,
+		},
+		{
+			note:    "true",
+			queries: []string{``},
+			es:      `{"match_all": {}}`,
+			mongo:   `{}`,
+		},
+		{
+			note:    "boolean operators",
+			queries: []string{`input.owner = "alice"`, `input.public; not input.deleted`},
+			es: `{"bool": {"minimum_should_match": 1, "should": [
+				{"term": {"owner": "alice"}},
+				{"bool": {"filter": [
+					{"term": {"public": true}},
+					{"bool": {"must_not": [{"term": {"deleted": true}}]}}
+				]}}
+			]}}`,
+			mongo: `{"$or": [
+				{"owner": {"$eq": "alice"}},
+				{"$and": [
+					{"public": {"$eq": true}},
+					{"$nor": [{"deleted": {"$eq": true}}]}
+				]}
+			]}`,
+		},
+		{
+			note:    "comparisons",
+			queries: []string{`input.a != 1; input.b < 2; input.c >= 3`},
+			es: `{"bool": {"filter": [
+				{"bool": {"must_not": [{"term": {"a": 1}}]}},
+				{"range": {"b": {"lt": 2}}},
+				{"range": {"c": {"gte": 3}}}
+			]}}`,
+			mongo: `{"$and": [{"a": {"$ne": 1}}, {"b": {"$lt": 2}}, {"c": {"$gte": 3}}]}`,
+		},
+		{
+			note:    "null",
+			queries: []string{`input.a = null; input.b != null`},
+			es: `{"bool": {"filter": [
+				{"bool": {"must_not": [{"exists": {"field": "a"}}]}},
+				{"exists": {"field": "b"}}
+			]}}`,
+			mongo: `{"$and": [{"a": {"$eq": null}}, {"b": {"$ne": null}}]}`,
+		},
+		{
+			note:    "string operators",
+			queries: []string{`startswith(input.a, "x*"); endswith(input.b.c, "y?"); contains(input.d, "z.")`},
+			es: `{"bool": {"filter": [
+				{"prefix": {"a": "x*"}},
+				{"wildcard": {"b.c": "*y\\?"}},
+				{"wildcard": {"d": "*z.*"}}
+			]}}`,
+			mongo: `{"$and": [
+				{"a": {"$regex": "^x\\*"}},
+				{"b.c": {"$regex": "y\\?$"}},
+				{"d": {"$regex": "z\\."}}
+			]}`,
+		},
+		{
+			note:     "unsupported",
+			queries:  []string{`count(input.a) > 1`},
+			expError: "unsupported(gt(count(input.a), 1)) cannot be translated",
+		},
+	}
+
+	translators := map[string]func(*Node) (map[string]interface{}, error){
+		"elasticsearch": Elasticsearch,
+		"mongodb":       MongoDB,
+	}
+
+	for _, tc := range tests {
+		for name, translate := range translators {
+			t.Run(tc.note+"/"+name, func(t *testing.T) {
+
+				node, err := FromQueries(parseQueries(tc.queries), Options{TagUnsupported: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				result, err := translate(node)
+
+				if tc.expError != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expError) {
+						t.Fatalf("Expected error containing %q but got %v", tc.expError, err)
+					}
+					return
+				} else if err != nil {
+					t.Fatal(err)
+				}
+
+				expected := tc.es
+				if name == "mongodb" {
+					expected = tc.mongo
+				}
+
+				bs, err := json.Marshal(result)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var actual, exp interface{}
+				if err := util.UnmarshalJSON(bs, &actual); err != nil {
+					t.Fatal(err)
+				}
+				if err := util.UnmarshalJSON([]byte(expected), &exp); err != nil {
+					t.Fatal(err)
+				}
+
+				if util.Compare(actual, exp) != 0 {
+					t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", expected, string(bs))
+				}
+			})
+		}
+	}
+}
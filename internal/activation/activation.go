@@ -0,0 +1,127 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package activation implements support for socket activation following the
+// systemd protocol. When a service manager starts OPA with pre-opened sockets
+// it passes them as file descriptors starting at 3 and describes them with the
+// LISTEN_PID, LISTEN_FDS and (optionally) LISTEN_FDNAMES environment variables.
+package activation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables defined by the socket activation protocol.
+const (
+	EnvListenPID     = "LISTEN_PID"
+	EnvListenFDs     = "LISTEN_FDS"
+	EnvListenFDNames = "LISTEN_FDNAMES"
+)
+
+// listenFDsStart is the first file descriptor passed by the service manager.
+const listenFDsStart = 3
+
+// Listener is a socket inherited from the service manager.
+type Listener struct {
+	net.Listener
+
+	// Name is the name assigned to the socket by the service manager (e.g.,
+	// the FileDescriptorName= setting in a systemd .socket unit.) If no names
+	// were passed, the name is the file descriptor number.
+	Name string
+
+	// FD is the file descriptor number of the socket in the process.
+	FD int
+}
+
+// Listeners returns the sockets passed to the process by the service manager.
+// If the process was not socket activated, Listeners returns an empty slice.
+// The activation environment variables are unset so that child processes do
+// not inherit the sockets.
+func Listeners() ([]Listener, error) {
+	files, names, err := files(os.Getenv, os.Getpid())
+	unsetEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Listener, 0, len(files))
+
+	for i, f := range files {
+		l, err := net.FileListener(f)
+		// FileListener dups the descriptor so the original can be closed
+		// regardless of the outcome.
+		f.Close()
+		if err != nil {
+			closeAll(result)
+			return nil, fmt.Errorf("socket activation: file descriptor %d: %v", listenFDsStart+i, err)
+		}
+		result = append(result, Listener{Listener: l, Name: names[i], FD: listenFDsStart + i})
+	}
+
+	return result, nil
+}
+
+func files(getenv func(string) string, pid int) ([]*os.File, []string, error) {
+
+	pidStr := getenv(EnvListenPID)
+	if pidStr == "" {
+		return nil, nil, nil
+	}
+
+	listenPID, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("socket activation: invalid %v: %q", EnvListenPID, pidStr)
+	}
+
+	// The sockets were intended for another process (e.g., a parent that
+	// did not unset the environment before exec'ing OPA.)
+	if listenPID != pid {
+		return nil, nil, nil
+	}
+
+	nfdsStr := getenv(EnvListenFDs)
+	nfds, err := strconv.Atoi(nfdsStr)
+	if err != nil || nfds < 0 {
+		return nil, nil, fmt.Errorf("socket activation: invalid %v: %q", EnvListenFDs, nfdsStr)
+	}
+
+	var names []string
+	if s := getenv(EnvListenFDNames); s != "" {
+		names = strings.Split(s, ":")
+		if len(names) != nfds {
+			return nil, nil, fmt.Errorf("socket activation: %v has %d names but %v is %d", EnvListenFDNames, len(names), EnvListenFDs, nfds)
+		}
+	}
+
+	files := make([]*os.File, nfds)
+	fdNames := make([]string, nfds)
+
+	for i := 0; i < nfds; i++ {
+		fd := listenFDsStart + i
+		fdNames[i] = strconv.Itoa(fd)
+		if names != nil {
+			fdNames[i] = names[i]
+		}
+		files[i] = os.NewFile(uintptr(fd), fdNames[i])
+	}
+
+	return files, fdNames, nil
+}
+
+func unsetEnv() {
+	os.Unsetenv(EnvListenPID)
+	os.Unsetenv(EnvListenFDs)
+	os.Unsetenv(EnvListenFDNames)
+}
+
+func closeAll(ls []Listener) {
+	for _, l := range ls {
+		l.Close()
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package activation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFiles(t *testing.T) {
+
+	tests := []struct {
+		note    string
+		env     map[string]string
+		pid     int
+		names   []string
+		wantErr bool
+	}{
+		{
+			note: "not activated",
+			env:  map[string]string{},
+			pid:  100,
+		},
+		{
+			note: "different pid",
+			env:  map[string]string{EnvListenPID: "101", EnvListenFDs: "1"},
+			pid:  100,
+		},
+		{
+			note:  "unnamed",
+			env:   map[string]string{EnvListenPID: "100", EnvListenFDs: "2"},
+			pid:   100,
+			names: []string{"3", "4"},
+		},
+		{
+			note:  "named",
+			env:   map[string]string{EnvListenPID: "100", EnvListenFDs: "2", EnvListenFDNames: "http:diag"},
+			pid:   100,
+			names: []string{"http", "diag"},
+		},
+		{
+			note:    "bad pid",
+			env:     map[string]string{EnvListenPID: "x", EnvListenFDs: "1"},
+			pid:     100,
+			wantErr: true,
+		},
+		{
+			note:    "bad fds",
+			env:     map[string]string{EnvListenPID: "100", EnvListenFDs: "-1"},
+			pid:     100,
+			wantErr: true,
+		},
+		{
+			note:    "names mismatch",
+			env:     map[string]string{EnvListenPID: "100", EnvListenFDs: "2", EnvListenFDNames: "http"},
+			pid:     100,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			getenv := func(k string) string { return tc.env[k] }
+			files, names, err := files(getenv, tc.pid)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(tc.names) {
+				t.Fatalf("expected %d files but got %d", len(tc.names), len(files))
+			}
+			if len(tc.names) > 0 && !reflect.DeepEqual(names, tc.names) {
+				t.Fatalf("expected names %v but got %v", tc.names, names)
+			}
+			for i := range files {
+				if fd := int(files[i].Fd()); fd != listenFDsStart+i {
+					t.Fatalf("expected file descriptor %d but got %d", listenFDsStart+i, fd)
+				}
+			}
+		})
+	}
+}
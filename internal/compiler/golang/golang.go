@@ -0,0 +1,595 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package golang contains an IR->Go compiler backend. The backend generates a
+// self-contained Go package that evaluates the plan. The generated code depends
+// on the github.com/open-policy-agent/opa/compile/runtime package.
+package golang
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/open-policy-agent/opa/internal/ir"
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+// DefaultPackage is the name of the generated package if no name is set.
+const DefaultPackage = "policy"
+
+const runtimeImport = "github.com/open-policy-agent/opa/compile/runtime"
+
+// Compiler implements an IR->Go compiler backend.
+type Compiler struct {
+	policy *ir.Policy // input policy to compile
+	pkg    string     // name of the generated package
+
+	funcs  map[string]string // maps plan function names to Go function names
+	labels []*label          // enclosing blocks of the current statement
+	used   map[string]bool   // labels that are referred to by breaks
+	next   int               // counter for generated labels and variables
+}
+
+// label refers to an enclosing block. Breaking out of the body of a scan
+// continues with the next element.
+type label struct {
+	name string
+	cont bool
+}
+
+// New returns a new compiler object.
+func New() *Compiler {
+	return &Compiler{
+		pkg: DefaultPackage,
+	}
+}
+
+// WithPolicy sets the policy to compile.
+func (c *Compiler) WithPolicy(p *ir.Policy) *Compiler {
+	c.policy = p
+	return c
+}
+
+// WithPackage sets the name of the generated package.
+func (c *Compiler) WithPackage(name string) *Compiler {
+	c.pkg = name
+	return c
+}
+
+// Compile returns the formatted source of the generated package.
+func (c *Compiler) Compile() ([]byte, error) {
+
+	if !token.IsIdentifier(c.pkg) || token.IsKeyword(c.pkg) {
+		return nil, fmt.Errorf("illegal package name: %q", c.pkg)
+	}
+
+	for _, decl := range c.policy.Static.BuiltinFuncs {
+		if topdown.GetBuiltin(decl.Name) == nil {
+			return nil, fmt.Errorf("undefined function: %q", decl.Name)
+		}
+	}
+
+	c.funcs = make(map[string]string, len(c.policy.Funcs.Funcs))
+
+	for i, fn := range c.policy.Funcs.Funcs {
+		c.funcs[fn.Name] = fmt.Sprintf("f%d", i)
+	}
+
+	var buf bytes.Buffer
+
+	c.compileHeader(&buf)
+
+	if err := c.compilePlan(&buf); err != nil {
+		return nil, errors.Wrap(err, "plan")
+	}
+
+	for _, fn := range c.policy.Funcs.Funcs {
+		if err := c.compileFunc(&buf, fn); err != nil {
+			return nil, errors.Wrapf(err, "func %v", fn.Name)
+		}
+	}
+
+	bs, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "format")
+	}
+
+	return bs, nil
+}
+
+// compileHeader generates the package clause, the imports, the entrypoints and
+// the string constants.
+func (c *Compiler) compileHeader(buf *bytes.Buffer) {
+
+	fmt.Fprintf(buf, "// Code generated by opa build. DO NOT EDIT.\n\n")
+	fmt.Fprintf(buf, "package %s\n\n", c.pkg)
+	fmt.Fprintf(buf, "import (\n\"github.com/open-policy-agent/opa/ast\"\nrt %q\n)\n\n", runtimeImport)
+
+	fmt.Fprintf(buf, `// Eval evaluates the policy with the input and data documents and returns
+// the query results. Input and data are converted with ast.InterfaceToValue.
+// If input is nil, it is undefined.
+func Eval(input, data interface{}) ([]map[string]interface{}, error) {
+	return rt.Eval(plan, input, data)
+}
+
+// EvalValue evaluates the policy with the input and data documents and
+// returns the set of query results. If input is nil, it is undefined.
+func EvalValue(input, data ast.Value) (ast.Set, error) {
+	return rt.EvalValue(plan, input, data)
+}
+
+`)
+
+	fmt.Fprintf(buf, "var strs = [...]ast.Value{\n")
+	for _, s := range c.policy.Static.Strings {
+		fmt.Fprintf(buf, "ast.String(%s),\n", strconv.Quote(s.Value))
+	}
+	fmt.Fprintf(buf, "}\n\n")
+}
+
+func (c *Compiler) compilePlan(buf *bytes.Buffer) error {
+
+	n := maxLocal(c.policy.Plan)
+	if n < ir.Data {
+		n = ir.Data
+	}
+
+	fmt.Fprintf(buf, "func plan(s *rt.State, input, data ast.Value) {\n")
+	fmt.Fprintf(buf, "var l [%d]ast.Value\n", n+1)
+	fmt.Fprintf(buf, "l[%d], l[%d] = input, data\n", ir.Input, ir.Data)
+
+	for i := range c.policy.Plan.Blocks {
+		if err := c.compileBlock(buf, c.policy.Plan.Blocks[i], ""); err != nil {
+			return errors.Wrapf(err, "block %d", i)
+		}
+	}
+
+	fmt.Fprintf(buf, "}\n\n")
+
+	return nil
+}
+
+func (c *Compiler) compileFunc(buf *bytes.Buffer, fn *ir.Func) error {
+
+	if len(fn.Params) == 0 {
+		return fmt.Errorf("illegal function: zero args")
+	}
+
+	params := make([]string, len(fn.Params))
+	for i := range params {
+		params[i] = fmt.Sprintf("a%d", i)
+	}
+
+	fmt.Fprintf(buf, "// %s\n", fn.Name)
+	fmt.Fprintf(buf, "func %s(s *rt.State, %s ast.Value) ast.Value {\n", c.funcs[fn.Name], join(params))
+	fmt.Fprintf(buf, "var l [%d]ast.Value\n", maxLocal(fn)+1)
+
+	for i, p := range fn.Params {
+		fmt.Fprintf(buf, "l[%d] = %s\n", p, params[i])
+	}
+
+	// Like the wasm backend, the last block is not nested so that it can
+	// return the result.
+	for i := range fn.Blocks {
+		var err error
+		if i < len(fn.Blocks)-1 {
+			err = c.compileBlock(buf, fn.Blocks[i], "")
+		} else {
+			err = c.compileStmts(buf, fn.Blocks[i].Stmts)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "block %d", i)
+		}
+	}
+
+	if !returns(fn) {
+		fmt.Fprintf(buf, "return nil\n")
+	}
+
+	fmt.Fprintf(buf, "}\n\n")
+
+	return nil
+}
+
+// returns returns true if the last statement of the function returns.
+func returns(fn *ir.Func) bool {
+	if len(fn.Blocks) == 0 {
+		return false
+	}
+	stmts := fn.Blocks[len(fn.Blocks)-1].Stmts
+	if len(stmts) == 0 {
+		return false
+	}
+	_, ok := stmts[len(stmts)-1].(*ir.ReturnLocalStmt)
+	return ok
+}
+
+// compileBlock generates a labeled switch statement for the block. Statements
+// that are undefined break out of the switch. If the end of the block is
+// reached, the suffix is executed. The label is omitted if no statement breaks
+// out of the block.
+func (c *Compiler) compileBlock(buf *bytes.Buffer, block *ir.Block, suffix string) error {
+
+	name := c.genName("L")
+	c.labels = append(c.labels, &label{name: name})
+
+	var body bytes.Buffer
+	err := c.compileStmts(&body, block.Stmts)
+	c.labels = c.labels[:len(c.labels)-1]
+
+	if err != nil {
+		return err
+	}
+
+	body.WriteString(suffix)
+
+	if !c.used[name] {
+		buf.Write(body.Bytes())
+		return nil
+	}
+
+	fmt.Fprintf(buf, "%s:\nswitch {\ndefault:\n", name)
+	buf.Write(body.Bytes())
+	fmt.Fprintf(buf, "}\n")
+
+	return nil
+}
+
+func (c *Compiler) compileStmts(buf *bytes.Buffer, stmts []ir.Stmt) error {
+
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ir.ResultSetAdd:
+			fmt.Fprintf(buf, "s.Add(l[%d])\n", stmt.Value)
+		case *ir.ReturnLocalStmt:
+			fmt.Fprintf(buf, "return l[%d]\n", stmt.Source)
+		case *ir.BlockStmt:
+			for i := range stmt.Blocks {
+				if err := c.compileBlock(buf, stmt.Blocks[i], ""); err != nil {
+					return err
+				}
+			}
+		case *ir.BreakStmt:
+			brk, err := c.breakStmt(stmt.Index)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(buf, "%s\n", brk)
+		case *ir.CallStmt:
+			if err := c.compileCallStmt(buf, stmt); err != nil {
+				return err
+			}
+		case *ir.WithStmt:
+			if err := c.compileWithStmt(buf, stmt); err != nil {
+				return err
+			}
+		case *ir.AssignVarStmt:
+			fmt.Fprintf(buf, "l[%d] = l[%d]\n", stmt.Target, stmt.Source)
+		case *ir.AssignVarOnceStmt:
+			fmt.Fprintf(buf, "l[%d] = rt.AssignOnce(l[%d], l[%d])\n", stmt.Target, stmt.Target, stmt.Source)
+		case *ir.AssignBooleanStmt:
+			fmt.Fprintf(buf, "l[%d] = ast.Boolean(%v)\n", stmt.Target, stmt.Value)
+		case *ir.AssignIntStmt:
+			fmt.Fprintf(buf, "l[%d] = ast.Number(%q)\n", stmt.Target, strconv.FormatInt(stmt.Value, 10))
+		case *ir.ScanStmt:
+			if err := c.compileScan(buf, stmt); err != nil {
+				return err
+			}
+		case *ir.NotStmt:
+			if err := c.compileNot(buf, stmt); err != nil {
+				return err
+			}
+		case *ir.DotStmt:
+			fmt.Fprintf(buf, "l[%d] = rt.Get(l[%d], l[%d])\n", stmt.Target, stmt.Source, stmt.Key)
+			c.undefinedIf(buf, fmt.Sprintf("l[%d] == nil", stmt.Target))
+		case *ir.LenStmt:
+			fmt.Fprintf(buf, "l[%d] = rt.Len(l[%d])\n", stmt.Target, stmt.Source)
+		case *ir.EqualStmt:
+			c.undefinedIf(buf, fmt.Sprintf("ast.Compare(l[%d], l[%d]) != 0", stmt.A, stmt.B))
+		case *ir.NotEqualStmt:
+			c.undefinedIf(buf, fmt.Sprintf("ast.Compare(l[%d], l[%d]) == 0", stmt.A, stmt.B))
+		case *ir.LessThanStmt:
+			c.undefinedIf(buf, fmt.Sprintf("ast.Compare(l[%d], l[%d]) >= 0", stmt.A, stmt.B))
+		case *ir.LessThanEqualStmt:
+			c.undefinedIf(buf, fmt.Sprintf("ast.Compare(l[%d], l[%d]) > 0", stmt.A, stmt.B))
+		case *ir.GreaterThanStmt:
+			c.undefinedIf(buf, fmt.Sprintf("ast.Compare(l[%d], l[%d]) <= 0", stmt.A, stmt.B))
+		case *ir.GreaterThanEqualStmt:
+			c.undefinedIf(buf, fmt.Sprintf("ast.Compare(l[%d], l[%d]) < 0", stmt.A, stmt.B))
+		case *ir.MakeNullStmt:
+			fmt.Fprintf(buf, "l[%d] = ast.Null{}\n", stmt.Target)
+		case *ir.MakeBooleanStmt:
+			fmt.Fprintf(buf, "l[%d] = ast.Boolean(%v)\n", stmt.Target, stmt.Value)
+		case *ir.MakeNumberFloatStmt:
+			fmt.Fprintf(buf, "l[%d] = ast.Number(%q)\n", stmt.Target, strconv.FormatFloat(stmt.Value, 'g', -1, 64))
+		case *ir.MakeNumberIntStmt:
+			fmt.Fprintf(buf, "l[%d] = ast.Number(%q)\n", stmt.Target, strconv.FormatInt(stmt.Value, 10))
+		case *ir.MakeNumberRefStmt:
+			fmt.Fprintf(buf, "l[%d] = ast.Number(%q)\n", stmt.Target, c.policy.Static.Strings[stmt.Index].Value)
+		case *ir.MakeStringStmt:
+			fmt.Fprintf(buf, "l[%d] = strs[%d]\n", stmt.Target, stmt.Index)
+		case *ir.MakeArrayStmt:
+			fmt.Fprintf(buf, "l[%d] = make(ast.Array, 0, %d)\n", stmt.Target, stmt.Capacity)
+		case *ir.MakeObjectStmt:
+			fmt.Fprintf(buf, "l[%d] = ast.NewObject()\n", stmt.Target)
+		case *ir.MakeSetStmt:
+			fmt.Fprintf(buf, "l[%d] = ast.NewSet()\n", stmt.Target)
+		case *ir.IsArrayStmt:
+			c.undefinedIf(buf, fmt.Sprintf("_, ok := l[%d].(ast.Array); !ok", stmt.Source))
+		case *ir.IsObjectStmt:
+			c.undefinedIf(buf, fmt.Sprintf("_, ok := l[%d].(ast.Object); !ok", stmt.Source))
+		case *ir.IsUndefinedStmt:
+			c.undefinedIf(buf, fmt.Sprintf("l[%d] != nil", stmt.Source))
+		case *ir.IsDefinedStmt:
+			c.undefinedIf(buf, fmt.Sprintf("l[%d] == nil", stmt.Source))
+		case *ir.ArrayAppendStmt:
+			fmt.Fprintf(buf, "l[%d] = rt.ArrayAppend(l[%d], l[%d])\n", stmt.Array, stmt.Array, stmt.Value)
+		case *ir.ObjectInsertStmt:
+			fmt.Fprintf(buf, "rt.ObjectInsert(l[%d], l[%d], l[%d])\n", stmt.Object, stmt.Key, stmt.Value)
+		case *ir.ObjectInsertOnceStmt:
+			fmt.Fprintf(buf, "rt.ObjectInsertOnce(l[%d], l[%d], l[%d])\n", stmt.Object, stmt.Key, stmt.Value)
+		case *ir.ObjectMergeStmt:
+			fmt.Fprintf(buf, "l[%d] = rt.ObjectMerge(l[%d], l[%d])\n", stmt.Target, stmt.A, stmt.B)
+		case *ir.SetAddStmt:
+			fmt.Fprintf(buf, "rt.SetAdd(l[%d], l[%d])\n", stmt.Set, stmt.Value)
+		default:
+			var buf bytes.Buffer
+			ir.Pretty(&buf, stmt)
+			return fmt.Errorf("illegal statement: %v", buf.String())
+		}
+	}
+
+	return nil
+}
+
+// compileScan generates a loop over the source. Statements in the body of the
+// scan that are undefined continue with the next element.
+func (c *Compiler) compileScan(buf *bytes.Buffer, scan *ir.ScanStmt) error {
+
+	name := c.genName("L")
+	c.labels = append(c.labels, &label{name: name}, &label{name: name, cont: true})
+
+	var body bytes.Buffer
+	err := c.compileStmts(&body, scan.Block.Stmts)
+	c.labels = c.labels[:len(c.labels)-2]
+
+	if err != nil {
+		return err
+	}
+
+	if c.used[name] {
+		fmt.Fprintf(buf, "%s:\n", name)
+	}
+
+	it := c.genName("it")
+	fmt.Fprintf(buf, "for %s := rt.Iter(l[%d]); %s.Next(); {\n", it, scan.Source, it)
+	fmt.Fprintf(buf, "l[%d], l[%d] = %s.Key(), %s.Value()\n", scan.Key, scan.Value, it, it)
+	buf.Write(body.Bytes())
+	fmt.Fprintf(buf, "}\n")
+
+	return nil
+}
+
+// compileNot generates a block that is undefined if the end of the nested
+// block is reached.
+func (c *Compiler) compileNot(buf *bytes.Buffer, not *ir.NotStmt) error {
+
+	defined := c.genName("defined")
+	fmt.Fprintf(buf, "%s := false\n", defined)
+
+	if err := c.compileBlock(buf, not.Block, fmt.Sprintf("%s = true\n", defined)); err != nil {
+		return err
+	}
+
+	c.undefinedIf(buf, defined)
+
+	return nil
+}
+
+// compileWithStmt generates statements that replace the local (or the value at
+// the path inside the local) during the evaluation of the nested block. The
+// local is restored afterwards.
+func (c *Compiler) compileWithStmt(buf *bytes.Buffer, with *ir.WithStmt) error {
+
+	save := c.genName("save")
+	undefined := c.genName("undefined")
+
+	fmt.Fprintf(buf, "%s := l[%d]\n", save, with.Local)
+
+	if len(with.Path) == 0 {
+		fmt.Fprintf(buf, "l[%d] = l[%d]\n", with.Local, with.Value)
+	} else {
+		path := make([]string, len(with.Path))
+		for i := range with.Path {
+			path[i] = fmt.Sprintf("strs[%d]", with.Path[i])
+		}
+		fmt.Fprintf(buf, "l[%d] = rt.Upsert(l[%d], []ast.Value{%s}, l[%d])\n", with.Local, with.Local, join(path), with.Value)
+	}
+
+	fmt.Fprintf(buf, "%s := true\n", undefined)
+
+	if err := c.compileBlock(buf, with.Block, fmt.Sprintf("%s = false\n", undefined)); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(buf, "l[%d] = %s\n", with.Local, save)
+	c.undefinedIf(buf, undefined)
+
+	return nil
+}
+
+func (c *Compiler) compileCallStmt(buf *bytes.Buffer, stmt *ir.CallStmt) error {
+
+	args := make([]string, len(stmt.Args))
+	for i := range stmt.Args {
+		args[i] = fmt.Sprintf("l[%d]", stmt.Args[i])
+	}
+
+	if fn, ok := c.funcs[stmt.Func]; ok {
+		fmt.Fprintf(buf, "l[%d] = %s(s, %s)\n", stmt.Result, fn, join(args))
+	} else {
+		args = append([]string{strconv.Quote(stmt.Func)}, args...)
+		fmt.Fprintf(buf, "l[%d] = s.Call(%s)\n", stmt.Result, join(args))
+	}
+
+	c.undefinedIf(buf, fmt.Sprintf("l[%d] == nil", stmt.Result))
+
+	return nil
+}
+
+// undefinedIf generates a statement that breaks out of the enclosing block if
+// the condition is true.
+func (c *Compiler) undefinedIf(buf *bytes.Buffer, cond string) {
+	brk, _ := c.breakStmt(0)
+	fmt.Fprintf(buf, "if %s {\n%s\n}\n", cond, brk)
+}
+
+// breakStmt returns the statement that breaks out of the enclosing block at
+// the index. Index 0 refers to the innermost block.
+func (c *Compiler) breakStmt(index uint32) (string, error) {
+
+	if int(index) >= len(c.labels) {
+		return "", fmt.Errorf("illegal break: index %d", index)
+	}
+
+	l := c.labels[len(c.labels)-1-int(index)]
+
+	if c.used == nil {
+		c.used = map[string]bool{}
+	}
+
+	c.used[l.name] = true
+
+	if l.cont {
+		return "continue " + l.name, nil
+	}
+
+	return "break " + l.name, nil
+}
+
+func (c *Compiler) genName(prefix string) string {
+	c.next++
+	return fmt.Sprintf("%s%d", prefix, c.next)
+}
+
+func join(xs []string) string {
+	var buf bytes.Buffer
+	for i := range xs {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(xs[i])
+	}
+	return buf.String()
+}
+
+// maxLocal returns the highest local referred to by x.
+func maxLocal(x interface{}) ir.Local {
+	vis := &localVisitor{}
+	ir.Walk(vis, x)
+	return vis.max
+}
+
+type localVisitor struct {
+	max ir.Local
+}
+
+func (*localVisitor) Before(interface{}) {}
+
+func (*localVisitor) After(interface{}) {}
+
+func (vis *localVisitor) Visit(x interface{}) (ir.Visitor, error) {
+
+	var locals []ir.Local
+
+	switch x := x.(type) {
+	case *ir.Func:
+		locals = append(locals, x.Params...)
+		locals = append(locals, x.Return)
+	case *ir.ReturnLocalStmt:
+		locals = append(locals, x.Source)
+	case *ir.CallStmt:
+		locals = append(locals, x.Args...)
+		locals = append(locals, x.Result)
+	case *ir.WithStmt:
+		locals = append(locals, x.Local, x.Value)
+	case *ir.ResultSetAdd:
+		locals = append(locals, x.Value)
+	case *ir.DotStmt:
+		locals = append(locals, x.Source, x.Key, x.Target)
+	case *ir.LenStmt:
+		locals = append(locals, x.Source, x.Target)
+	case *ir.ScanStmt:
+		locals = append(locals, x.Source, x.Key, x.Value)
+	case *ir.AssignVarStmt:
+		locals = append(locals, x.Source, x.Target)
+	case *ir.AssignVarOnceStmt:
+		locals = append(locals, x.Source, x.Target)
+	case *ir.AssignBooleanStmt:
+		locals = append(locals, x.Target)
+	case *ir.AssignIntStmt:
+		locals = append(locals, x.Target)
+	case *ir.MakeStringStmt:
+		locals = append(locals, x.Target)
+	case *ir.MakeNullStmt:
+		locals = append(locals, x.Target)
+	case *ir.MakeBooleanStmt:
+		locals = append(locals, x.Target)
+	case *ir.MakeNumberFloatStmt:
+		locals = append(locals, x.Target)
+	case *ir.MakeNumberIntStmt:
+		locals = append(locals, x.Target)
+	case *ir.MakeNumberRefStmt:
+		locals = append(locals, x.Target)
+	case *ir.MakeArrayStmt:
+		locals = append(locals, x.Target)
+	case *ir.MakeObjectStmt:
+		locals = append(locals, x.Target)
+	case *ir.MakeSetStmt:
+		locals = append(locals, x.Target)
+	case *ir.EqualStmt:
+		locals = append(locals, x.A, x.B)
+	case *ir.NotEqualStmt:
+		locals = append(locals, x.A, x.B)
+	case *ir.LessThanStmt:
+		locals = append(locals, x.A, x.B)
+	case *ir.LessThanEqualStmt:
+		locals = append(locals, x.A, x.B)
+	case *ir.GreaterThanStmt:
+		locals = append(locals, x.A, x.B)
+	case *ir.GreaterThanEqualStmt:
+		locals = append(locals, x.A, x.B)
+	case *ir.IsArrayStmt:
+		locals = append(locals, x.Source)
+	case *ir.IsObjectStmt:
+		locals = append(locals, x.Source)
+	case *ir.IsDefinedStmt:
+		locals = append(locals, x.Source)
+	case *ir.IsUndefinedStmt:
+		locals = append(locals, x.Source)
+	case *ir.ArrayAppendStmt:
+		locals = append(locals, x.Array, x.Value)
+	case *ir.ObjectInsertStmt:
+		locals = append(locals, x.Object, x.Key, x.Value)
+	case *ir.ObjectInsertOnceStmt:
+		locals = append(locals, x.Object, x.Key, x.Value)
+	case *ir.ObjectMergeStmt:
+		locals = append(locals, x.A, x.B, x.Target)
+	case *ir.SetAddStmt:
+		locals = append(locals, x.Set, x.Value)
+	}
+
+	for _, l := range locals {
+		if l > vis.max {
+			vis.max = l
+		}
+	}
+
+	return vis, nil
+}
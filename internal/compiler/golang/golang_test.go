@@ -0,0 +1,451 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package golang
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/ir"
+	"github.com/open-policy-agent/opa/internal/planner"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+type testCase struct {
+	Note        string                  `json:"note"`
+	Query       string                  `json:"query"`
+	Modules     []string                `json:"modules,omitempty"`
+	Data        *map[string]interface{} `json:"data,omitempty"`
+	Input       *interface{}            `json:"input,omitempty"`
+	WantDefined *bool                   `json:"want_defined,omitempty"`
+	WantError   *string                 `json:"want_error,omitempty"`
+}
+
+type testCaseSet struct {
+	Cases []testCase `json:"cases"`
+}
+
+// testResult is the output of the test program for a single test case.
+type testResult struct {
+	Results []map[string]interface{} `json:"results"`
+	Error   string                   `json:"error,omitempty"`
+}
+
+func plan(query string, modules []string) (*ir.Policy, error) {
+
+	parsed := map[string]*ast.Module{}
+
+	for i := range modules {
+		name := fmt.Sprintf("module%d.rego", i)
+		module, err := ast.ParseModule(name, modules[i])
+		if err != nil {
+			return nil, err
+		}
+		parsed[name] = module
+	}
+
+	compiler := ast.NewCompiler()
+	if compiler.Compile(parsed); compiler.Failed() {
+		return nil, compiler.Errors
+	}
+
+	body, err := ast.ParseBody(query)
+	if err != nil {
+		return nil, err
+	}
+
+	qc := compiler.QueryCompiler()
+	compiled, err := qc.Compile(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range compiler.Modules {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var mods []*ast.Module
+	for _, name := range names {
+		mods = append(mods, compiler.Modules[name])
+	}
+
+	return planner.New().
+		WithQueries([]ast.Body{compiled}).
+		WithModules(mods).
+		WithRewrittenVars(qc.RewrittenVars()).
+		WithBuiltinDecls(ast.BuiltinMap).
+		Plan()
+}
+
+func TestCompile(t *testing.T) {
+
+	policy, err := plan(`data.test.p = x`, []string{`package test
+
+p = y { y := count(input.xs) + 1 }`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := New().WithPolicy(policy).WithPackage("authz").Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range []string{
+		"// Code generated by opa build. DO NOT EDIT.",
+		"package authz",
+		"func Eval(input, data interface{}) ([]map[string]interface{}, error) {",
+		`s.Call("count", `,
+		"// g0.data.test.p\nfunc f0(s *rt.State, a0, a1 ast.Value) ast.Value {",
+	} {
+		if !bytes.Contains(src, []byte(exp)) {
+			t.Fatalf("Expected generated code to contain %q:\n\n%s", exp, src)
+		}
+	}
+
+	if _, err := New().WithPolicy(policy).WithPackage("func").Compile(); err == nil || err.Error() != `illegal package name: "func"` {
+		t.Fatalf("Expected package name error but got %v", err)
+	}
+}
+
+func TestCompileUndefinedFunction(t *testing.T) {
+
+	policy := &ir.Policy{
+		Static: &ir.Static{BuiltinFuncs: []*ir.BuiltinFunc{{Name: "deadbeef"}}},
+		Plan:   &ir.Plan{},
+		Funcs:  &ir.Funcs{},
+	}
+
+	_, err := New().WithPolicy(policy).Compile()
+	if err == nil || err.Error() != `undefined function: "deadbeef"` {
+		t.Fatalf("Expected undefined function error but got %v", err)
+	}
+}
+
+// TestCorpus compiles the wasm test cases to Go with and without
+// optimizations, runs the generated code and compares the results with
+// topdown. The expected results of the test cases
+// are not compared because the wasm target rounds numbers differently.
+func TestCorpus(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("skipping corpus test in short mode")
+	}
+
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	files, err := filepath.Glob(filepath.Join("..", "..", "..", "test", "wasm", "assets", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases []testCase
+
+	for _, file := range files {
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var set testCaseSet
+		if err := util.Unmarshal(bs, &set); err != nil {
+			t.Fatalf("%v: %v", file, err)
+		}
+		for _, tc := range set.Cases {
+			tc.Note = filepath.Base(file) + "/" + tc.Note
+			cases = append(cases, tc)
+		}
+	}
+
+	if err := os.MkdirAll("testdata", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove("testdata") // only removed if empty
+
+	dir, err := ioutil.TempDir("testdata", "corpus")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	importPath := "github.com/open-policy-agent/opa/internal/compiler/golang/" + filepath.ToSlash(dir)
+
+	var compiled []testCase
+	var imports, calls bytes.Buffer
+
+	for _, tc := range cases {
+
+		policy, err := plan(tc.Query, tc.Modules)
+		if err != nil {
+			t.Logf("%v: skipped: %v", tc.Note, err)
+			continue
+		}
+
+		// Each case is compiled without and with optimizations.
+		pkgs := []string{fmt.Sprintf("c%d", len(compiled)), fmt.Sprintf("o%d", len(compiled))}
+		srcs := make([][]byte, len(pkgs))
+
+		for i, pkg := range pkgs {
+			if i > 0 {
+				ir.Optimize(policy, ir.Passes(2))
+			}
+			if srcs[i], err = New().WithPolicy(policy).WithPackage(pkg).Compile(); err != nil {
+				break
+			}
+		}
+
+		if err != nil {
+			if strings.Contains(err.Error(), "undefined function") {
+				t.Logf("%v: skipped: %v", tc.Note, err)
+				continue
+			}
+			t.Fatalf("%v: %v", tc.Note, err)
+		}
+
+		for i, pkg := range pkgs {
+
+			if err := os.MkdirAll(filepath.Join(dir, pkg), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := ioutil.WriteFile(filepath.Join(dir, pkg, "policy.go"), srcs[i], 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			fmt.Fprintf(&imports, "%q\n", importPath+"/"+pkg)
+			fmt.Fprintf(&calls, "%s.Eval,\n", pkg)
+		}
+
+		compiled = append(compiled, tc, tc)
+	}
+
+	main := fmt.Sprintf(`package main
+
+import (
+	"encoding/json"
+	"os"
+
+%s)
+
+type testCase struct {
+	Input *interface{} %s
+	Data  *map[string]interface{} %s
+}
+
+type testResult struct {
+	Results []map[string]interface{} %s
+	Error   string %s
+}
+
+var evals = []func(input, data interface{}) ([]map[string]interface{}, error){
+%s}
+
+func main() {
+	var cases []testCase
+	if err := json.NewDecoder(os.Stdin).Decode(&cases); err != nil {
+		panic(err)
+	}
+	results := make([]testResult, len(cases))
+	for i, tc := range cases {
+		var input, data interface{}
+		if tc.Input != nil {
+			input = *tc.Input
+		}
+		if tc.Data != nil {
+			data = *tc.Data
+		}
+		rs, err := evals[i](input, data)
+		if err != nil {
+			results[i].Error = err.Error()
+		} else {
+			results[i].Results = rs
+		}
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(results); err != nil {
+		panic(err)
+	}
+}
+`, imports.String(), "`json:\"input\"`", "`json:\"data\"`", "`json:\"results\"`", "`json:\"error\"`", calls.String())
+
+	if err := os.MkdirAll(filepath.Join(dir, "main"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "main", "main.go"), []byte(main), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, err := json.Marshal(compiled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(gobin, "run", "./"+filepath.ToSlash(filepath.Join(dir, "main")))
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("%v: %s", err, stderr.String())
+	}
+
+	var results []testResult
+	if err := util.UnmarshalJSON(stdout.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range compiled {
+		note := tc.Note
+		if i%2 == 1 {
+			note += "/optimized"
+		}
+		t.Run(note, func(t *testing.T) {
+			assertResult(t, tc, results[i])
+		})
+	}
+}
+
+func assertResult(t *testing.T, tc testCase, result testResult) {
+
+	exp, err := evalTopdown(tc)
+
+	switch {
+	case tc.WantError != nil:
+		if !strings.Contains(result.Error, *tc.WantError) {
+			t.Fatalf("Expected error containing %q but got %v", *tc.WantError, result)
+		}
+		if err == nil {
+			t.Fatalf("Expected topdown error but got %v", exp)
+		}
+		return
+	case result.Error != "":
+		t.Fatalf("Unexpected error: %v", result.Error)
+	case err != nil:
+		t.Fatalf("Unexpected topdown error: %v", err)
+	}
+
+	got := normalize(result.Results)
+
+	if got != normalize(exp) {
+		t.Fatalf("Expected topdown results %v but got %v", normalize(exp), got)
+	}
+
+	if tc.WantDefined != nil && *tc.WantDefined != (len(result.Results) > 0) {
+		t.Fatalf("Expected defined to be %v but got %v", *tc.WantDefined, got)
+	}
+}
+
+func evalTopdown(tc testCase) ([]map[string]interface{}, error) {
+
+	args := []func(*rego.Rego){
+		rego.Query(tc.Query),
+	}
+
+	for i := range tc.Modules {
+		args = append(args, rego.Module(fmt.Sprintf("module%d.rego", i), tc.Modules[i]))
+	}
+
+	if tc.Data != nil {
+		args = append(args, rego.Store(inmem.NewFromObject(*tc.Data)))
+	}
+
+	if tc.Input != nil {
+		args = append(args, rego.Input(*tc.Input))
+	}
+
+	rs, err := rego.New(args...).Eval(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	// Expressions that evaluate to false are undefined in the plan but their
+	// values are captured by topdown.
+	var result []map[string]interface{}
+
+	for i := range rs {
+		defined := true
+		for _, expr := range rs[i].Expressions {
+			if expr.Value == false {
+				defined = false
+			}
+		}
+		if defined {
+			result = append(result, rs[i].Bindings)
+		}
+	}
+
+	return result, nil
+}
+
+// normalize returns a canonical representation of a result set. Topdown may
+// return the same bindings multiple times and sets are compared regardless
+// of their order.
+func normalize(rs []map[string]interface{}) string {
+
+	seen := map[string]struct{}{}
+	var keys []string
+
+	for i := range rs {
+		x, err := ast.InterfaceToValue(rs[i])
+		if err != nil {
+			panic(err)
+		}
+		bs, err := json.Marshal(canonical(x))
+		if err != nil {
+			panic(err)
+		}
+		if _, ok := seen[string(bs)]; !ok {
+			seen[string(bs)] = struct{}{}
+			keys = append(keys, string(bs))
+		}
+	}
+
+	sort.Strings(keys)
+
+	return "[" + strings.Join(keys, ",") + "]"
+}
+
+// canonical sorts arrays so that sets, which are returned as arrays, compare
+// equal regardless of their order.
+func canonical(x ast.Value) interface{} {
+	switch x := x.(type) {
+	case ast.Array:
+		result := make([]string, len(x))
+		for i := range x {
+			bs, _ := json.Marshal(canonical(x[i].Value))
+			result[i] = string(bs)
+		}
+		sort.Strings(result)
+		return result
+	case ast.Object:
+		result := map[string]interface{}{}
+		x.Foreach(func(k, v *ast.Term) {
+			result[k.String()] = canonical(v.Value)
+		})
+		return result
+	}
+	v, _ := ast.JSON(x)
+	return v
+}
@@ -0,0 +1,330 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package dap
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+// stepMode controls where evaluation pauses next.
+type stepMode int
+
+const (
+	// modeContinue pauses at breakpoints only.
+	modeContinue stepMode = iota
+
+	// modeEntry pauses at the first expression.
+	modeEntry
+
+	// modePause pauses at the next expression.
+	modePause
+
+	// modeStepIn pauses at the next expression, including expressions of
+	// rules and functions called by the current expression.
+	modeStepIn
+
+	// modeNext pauses at the next expression of the current query or of a
+	// query that called it.
+	modeNext
+
+	// modeStepOut pauses at the next expression of a query that called the
+	// current query.
+	modeStepOut
+
+	// modeAbort never pauses. It is set when evaluation is cancelled.
+	modeAbort
+)
+
+// Enabled always returns true.
+func (s *Server) Enabled() bool {
+	return true
+}
+
+// Config returns the tracing configuration. Variable bindings are read from
+// the evaluation stack when paused so events do not need them.
+func (s *Server) Config() topdown.TraceConfig {
+	return topdown.TraceConfig{}
+}
+
+// TraceEvent records the names of rules and functions entered by evaluation.
+func (s *Server) TraceEvent(evt topdown.Event) {
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	switch evt.Op {
+	case topdown.EnterOp:
+		if rule, ok := evt.Node.(*ast.Rule); ok {
+			s.names[evt.QueryID] = rule.Path().String()
+		}
+	case topdown.RedoOp:
+		// Backtracking evaluates expressions again. The same line may be
+		// paused at again.
+		s.last = nil
+	}
+}
+
+// TraceStep pauses evaluation at breakpoints and steps. It blocks until the
+// client resumes evaluation.
+func (s *Server) TraceStep(evt topdown.Event, state *topdown.StepState) {
+
+	if evt.Op != topdown.EvalOp || evt.Location == nil {
+		return
+	}
+
+	s.mtx.Lock()
+
+	reason := s.stopReason(evt.Location, state)
+	if reason == "" {
+		s.mtx.Unlock()
+		return
+	}
+
+	s.paused = state
+	s.depth = state.Depth()
+	s.last = evt.Location
+	s.handles = nil
+
+	s.mtx.Unlock()
+
+	s.event("stopped", StoppedEventBody{
+		Reason:            reason,
+		ThreadID:          threadID,
+		AllThreadsStopped: true,
+	})
+
+	<-s.resumed
+}
+
+// stopReason returns the reason to pause at the expression at loc. If
+// evaluation should not pause, the reason is empty. Evaluation pauses once
+// per line, so expressions that the compiler generated from the same
+// expression are stepped over together.
+func (s *Server) stopReason(loc *ast.Location, state *topdown.StepState) string {
+
+	if s.mode == modeAbort {
+		return ""
+	}
+
+	depth := state.Depth()
+
+	if s.last != nil && s.last.File == loc.File && s.last.Row == loc.Row && depth == s.depth {
+		return ""
+	}
+
+	if s.breakpoints[filepath.Clean(loc.File)][loc.Row] {
+		return ReasonBreakpoint
+	}
+
+	switch s.mode {
+	case modeEntry:
+		return ReasonEntry
+	case modePause:
+		return ReasonPause
+	case modeStepIn:
+		return ReasonStep
+	case modeNext:
+		if depth <= s.depth {
+			return ReasonStep
+		}
+	case modeStepOut:
+		if depth < s.depth {
+			return ReasonStep
+		}
+	}
+
+	return ""
+}
+
+// resume continues paused evaluation.
+func (s *Server) resume(mode stepMode) error {
+	if s.paused == nil {
+		return fmt.Errorf("evaluation is not paused")
+	}
+	s.mode = mode
+	s.paused = nil
+	s.handles = nil
+	if mode == modeContinue {
+		s.last = nil
+	}
+	s.resumed <- struct{}{}
+	return nil
+}
+
+// abort prevents evaluation from pausing again and resumes it if paused.
+func (s *Server) abort() {
+	s.mode = modeAbort
+	if s.paused != nil {
+		s.paused = nil
+		s.resumed <- struct{}{}
+	}
+}
+
+func (s *Server) stackTrace(args json.RawMessage) (interface{}, error) {
+
+	var p StackTraceArguments
+	if err := decode(args, &p); err != nil {
+		return nil, err
+	}
+
+	if s.paused == nil {
+		return nil, fmt.Errorf("evaluation is not paused")
+	}
+
+	frames := s.paused.Frames()
+	result := make([]StackFrame, len(frames))
+
+	// Queries that are not rules or functions, e.g., comprehensions and
+	// negated expressions, are named after the rule that contains them.
+	name := "query"
+
+	for i := len(frames) - 1; i >= 0; i-- {
+		if n, ok := s.names[frames[i].QueryID()]; ok {
+			name = n
+		}
+		result[i] = StackFrame{ID: i + 1, Name: name}
+		if loc := frameLocation(frames[i]); loc != nil {
+			result[i].Line = loc.Row
+			result[i].Column = loc.Col
+			if loc.File != "" {
+				result[i].Source = &Source{Name: filepath.Base(loc.File), Path: loc.File}
+			}
+		}
+	}
+
+	total := len(result)
+
+	if p.StartFrame > 0 {
+		if p.StartFrame > len(result) {
+			p.StartFrame = len(result)
+		}
+		result = result[p.StartFrame:]
+	}
+
+	if p.Levels > 0 && p.Levels < len(result) {
+		result = result[:p.Levels]
+	}
+
+	return StackTraceResponseBody{StackFrames: result, TotalFrames: total}, nil
+}
+
+// frameLocation returns the location of the expression evaluated by the frame.
+// If all expressions have been evaluated, the location of the last one is
+// returned.
+func frameLocation(f topdown.StepFrame) *ast.Location {
+	if expr := f.Expr(); expr != nil {
+		return expr.Location
+	}
+	if query := f.Query(); len(query) > 0 {
+		return query[len(query)-1].Location
+	}
+	return nil
+}
+
+// Scopes and compound values are referenced by their index in the handles
+// plus one. References are valid until evaluation resumes.
+type localsScope struct {
+	frame topdown.StepFrame
+}
+
+type cacheScope struct{}
+
+func (s *Server) reference(x interface{}) int {
+	s.handles = append(s.handles, x)
+	return len(s.handles)
+}
+
+func (s *Server) scopes(args json.RawMessage) (interface{}, error) {
+
+	var p ScopesArguments
+	if err := decode(args, &p); err != nil {
+		return nil, err
+	}
+
+	if s.paused == nil {
+		return nil, fmt.Errorf("evaluation is not paused")
+	}
+
+	frames := s.paused.Frames()
+	if p.FrameID < 1 || p.FrameID > len(frames) {
+		return nil, fmt.Errorf("invalid frame: %d", p.FrameID)
+	}
+
+	return ScopesResponseBody{Scopes: []Scope{
+		{Name: "Locals", VariablesReference: s.reference(localsScope{frames[p.FrameID-1]})},
+		{Name: "Virtual Documents", VariablesReference: s.reference(cacheScope{})},
+	}}, nil
+}
+
+func (s *Server) variables(args json.RawMessage) (interface{}, error) {
+
+	var p VariablesArguments
+	if err := decode(args, &p); err != nil {
+		return nil, err
+	}
+
+	if s.paused == nil {
+		return nil, fmt.Errorf("evaluation is not paused")
+	}
+
+	if p.VariablesReference < 1 || p.VariablesReference > len(s.handles) {
+		return nil, fmt.Errorf("invalid variables reference: %d", p.VariablesReference)
+	}
+
+	result := []Variable{}
+
+	switch x := s.handles[p.VariablesReference-1].(type) {
+	case localsScope:
+		for _, b := range x.frame.Bindings() {
+			// Variables generated by the compiler are hidden unless they
+			// were rewritten from variables in the policy.
+			if b.Name.IsGenerated() || b.Name.IsWildcard() {
+				continue
+			}
+			result = append(result, s.variable(string(b.Name), b.Value))
+		}
+	case cacheScope:
+		for _, doc := range s.paused.VirtualCache() {
+			result = append(result, s.variable(doc.Ref.String(), doc.Value))
+		}
+	case *ast.Term:
+		switch v := x.Value.(type) {
+		case ast.Object:
+			for _, k := range v.Keys() {
+				result = append(result, s.variable(k.String(), v.Get(k)))
+			}
+		case ast.Array:
+			for i, elem := range v {
+				result = append(result, s.variable(strconv.Itoa(i), elem))
+			}
+		case ast.Set:
+			for i, elem := range v.Sorted() {
+				result = append(result, s.variable(strconv.Itoa(i), elem))
+			}
+		}
+	}
+
+	return VariablesResponseBody{Variables: result}, nil
+}
+
+// variable returns a variable for value. Compound values can be expanded.
+func (s *Server) variable(name string, value *ast.Term) Variable {
+	v := Variable{
+		Name:  name,
+		Value: value.String(),
+		Type:  ast.TypeName(value.Value),
+	}
+	switch value.Value.(type) {
+	case ast.Object, ast.Array, ast.Set:
+		v.VariablesReference = s.reference(value)
+	}
+	return v
+}
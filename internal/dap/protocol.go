@@ -0,0 +1,192 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package dap
+
+import "encoding/json"
+
+// This file defines the subset of the Debug Adapter Protocol used by the
+// server. See https://microsoft.github.io/debug-adapter-protocol/specification.
+
+// Message types.
+const (
+	typeRequest  = "request"
+	typeResponse = "response"
+	typeEvent    = "event"
+)
+
+// Request is a request sent by the client.
+type Request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// Response is the response to a request.
+type Response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// Event is an event sent by the server.
+type Event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// Capabilities describes the features supported by the server.
+type Capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportTerminateDebuggee         bool `json:"supportTerminateDebuggee"`
+}
+
+// LaunchArguments describes the evaluation to debug. Input is the path of a
+// JSON or YAML file. Data contains paths of policy and data files or
+// directories. Bundles contains paths of bundle directories or files.
+type LaunchArguments struct {
+	Query       string   `json:"query"`
+	Input       string   `json:"input,omitempty"`
+	Data        []string `json:"data,omitempty"`
+	Bundles     []string `json:"bundles,omitempty"`
+	StopOnEntry bool     `json:"stopOnEntry,omitempty"`
+}
+
+// Source is a policy file.
+type Source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// SourceBreakpoint is a breakpoint requested by the client.
+type SourceBreakpoint struct {
+	Line int `json:"line"`
+}
+
+// SetBreakpointsArguments replaces the breakpoints of a source.
+type SetBreakpointsArguments struct {
+	Source      Source             `json:"source"`
+	Breakpoints []SourceBreakpoint `json:"breakpoints"`
+}
+
+// Breakpoint is a breakpoint set by the server.
+type Breakpoint struct {
+	ID       int     `json:"id"`
+	Verified bool    `json:"verified"`
+	Source   *Source `json:"source,omitempty"`
+	Line     int     `json:"line,omitempty"`
+}
+
+// SetBreakpointsResponseBody contains the breakpoints of a source.
+type SetBreakpointsResponseBody struct {
+	Breakpoints []Breakpoint `json:"breakpoints"`
+}
+
+// Thread is a thread of execution. Evaluation is debugged on a single thread.
+type Thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// ThreadsResponseBody contains the threads.
+type ThreadsResponseBody struct {
+	Threads []Thread `json:"threads"`
+}
+
+// StackTraceArguments requests the stack of a thread.
+type StackTraceArguments struct {
+	ThreadID   int `json:"threadId"`
+	StartFrame int `json:"startFrame,omitempty"`
+	Levels     int `json:"levels,omitempty"`
+}
+
+// StackFrame is a query, rule or function being evaluated.
+type StackFrame struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Source *Source `json:"source,omitempty"`
+	Line   int     `json:"line"`
+	Column int     `json:"column"`
+}
+
+// StackTraceResponseBody contains the stack of a thread.
+type StackTraceResponseBody struct {
+	StackFrames []StackFrame `json:"stackFrames"`
+	TotalFrames int          `json:"totalFrames"`
+}
+
+// ScopesArguments requests the scopes of a stack frame.
+type ScopesArguments struct {
+	FrameID int `json:"frameId"`
+}
+
+// Scope is a named set of variables.
+type Scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+// ScopesResponseBody contains the scopes of a stack frame.
+type ScopesResponseBody struct {
+	Scopes []Scope `json:"scopes"`
+}
+
+// VariablesArguments requests the variables of a scope or compound value.
+type VariablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+// Variable is a variable or an element of a compound value. Compound values
+// have a non-zero reference that can be used to request their elements.
+type Variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// VariablesResponseBody contains variables.
+type VariablesResponseBody struct {
+	Variables []Variable `json:"variables"`
+}
+
+// ContinueResponseBody is the response to the continue request.
+type ContinueResponseBody struct {
+	AllThreadsContinued bool `json:"allThreadsContinued"`
+}
+
+// StoppedEventBody is sent when evaluation is paused.
+type StoppedEventBody struct {
+	Reason            string `json:"reason"`
+	Description       string `json:"description,omitempty"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+}
+
+// OutputEventBody contains output of the evaluation.
+type OutputEventBody struct {
+	Category string `json:"category"`
+	Output   string `json:"output"`
+}
+
+// ExitedEventBody is sent when evaluation has finished.
+type ExitedEventBody struct {
+	ExitCode int `json:"exitCode"`
+}
+
+// Reasons for stopping.
+const (
+	ReasonEntry      = "entry"
+	ReasonStep       = "step"
+	ReasonBreakpoint = "breakpoint"
+	ReasonPause      = "pause"
+)
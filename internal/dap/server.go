@@ -0,0 +1,405 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package dap implements a Debug Adapter Protocol server for Rego. The server
+// evaluates a query and pauses evaluation at breakpoints and steps so that
+// editors can inspect the stack, variable bindings and cached virtual
+// documents.
+package dap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/jsonrpc"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// threadID identifies the only thread. Queries are evaluated sequentially.
+const threadID = 1
+
+type handler func(*Server, json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"initialize":        (*Server).initialize,
+	"launch":            (*Server).launch,
+	"setBreakpoints":    (*Server).setBreakpoints,
+	"configurationDone": (*Server).configurationDone,
+	"threads":           (*Server).threads,
+	"stackTrace":        (*Server).stackTrace,
+	"scopes":            (*Server).scopes,
+	"variables":         (*Server).variables,
+	"continue":          (*Server).continueRequest,
+	"next":              (*Server).next,
+	"stepIn":            (*Server).stepIn,
+	"stepOut":           (*Server).stepOut,
+	"pause":             (*Server).pause,
+}
+
+// Server implements a Debug Adapter Protocol server. Requests are handled on
+// the goroutine that calls Serve and the query is evaluated on another
+// goroutine. Evaluation blocks while paused until a request resumes it.
+type Server struct {
+	stream *jsonrpc.Stream
+	wmtx   sync.Mutex
+	seq    int
+
+	mtx         sync.Mutex
+	query       *rego.PreparedEvalQuery
+	configured  bool
+	started     bool
+	stopOnEntry bool
+	cancel      context.CancelFunc
+	done        chan struct{}
+
+	breakpoints map[string]map[int]bool
+	nextID      int
+
+	// The state of the debugger is guarded by mtx. When evaluation is
+	// paused, paused holds the state of evaluation and handles holds the
+	// scopes and values referenced by variables sent to the client.
+	mode    stepMode
+	depth   int
+	last    *ast.Location
+	paused  *topdown.StepState
+	resumed chan struct{}
+	names   map[uint64]string
+	handles []interface{}
+}
+
+// New returns a new Server that reads messages from r and writes messages to
+// w.
+func New(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		stream:      jsonrpc.NewStream(r, w),
+		breakpoints: map[string]map[int]bool{},
+		resumed:     make(chan struct{}, 1),
+		names:       map[uint64]string{},
+	}
+}
+
+// Serve handles requests until the client disconnects, the input ends or the
+// context is canceled. Evaluation is stopped before Serve returns.
+func (s *Server) Serve(ctx context.Context) error {
+
+	defer s.stop()
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		bs, err := s.stream.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var req Request
+		if err := json.Unmarshal(bs, &req); err != nil || req.Type != typeRequest {
+			logrus.Errorf("Ignoring invalid message: %s", bs)
+			continue
+		}
+
+		// The evaluation goroutine has to be stopped without holding the
+		// lock because it may be waiting for it.
+		if req.Command == "disconnect" {
+			s.stop()
+			s.respond(&req, nil, nil)
+			return nil
+		}
+
+		s.handle(&req)
+	}
+}
+
+func (s *Server) handle(req *Request) {
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	h, ok := handlers[req.Command]
+	if !ok {
+		s.respond(req, nil, fmt.Errorf("unsupported command: %v", req.Command))
+		return
+	}
+
+	body, err := h(s, req.Arguments)
+	s.respond(req, body, err)
+
+	if err != nil {
+		return
+	}
+
+	// The client sends the configuration, e.g., breakpoints, after it has
+	// received the initialized event. Evaluation starts once the query has
+	// been launched and the configuration is done.
+	if req.Command == "initialize" {
+		s.event("initialized", nil)
+	}
+
+	if s.query != nil && s.configured && !s.started {
+		s.start()
+	}
+}
+
+func (s *Server) respond(req *Request, body interface{}, err error) {
+	resp := &Response{
+		Type:       typeResponse,
+		RequestSeq: req.Seq,
+		Success:    err == nil,
+		Command:    req.Command,
+		Body:       body,
+	}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	s.send(func(seq int) interface{} {
+		resp.Seq = seq
+		return resp
+	})
+}
+
+func (s *Server) event(name string, body interface{}) {
+	evt := &Event{
+		Type:  typeEvent,
+		Event: name,
+		Body:  body,
+	}
+	s.send(func(seq int) interface{} {
+		evt.Seq = seq
+		return evt
+	})
+}
+
+// send writes the message returned by f. Messages are numbered in the order
+// they are written.
+func (s *Server) send(f func(seq int) interface{}) {
+	s.wmtx.Lock()
+	defer s.wmtx.Unlock()
+	s.seq++
+	if err := s.stream.Write(f(s.seq)); err != nil {
+		logrus.Errorf("Failed to write message: %v", err)
+	}
+}
+
+func decode(args json.RawMessage, v interface{}) error {
+	if len(args) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(args, v); err != nil {
+		return fmt.Errorf("invalid arguments: %v", err)
+	}
+	return nil
+}
+
+func (s *Server) initialize(json.RawMessage) (interface{}, error) {
+	return Capabilities{
+		SupportsConfigurationDoneRequest: true,
+	}, nil
+}
+
+func (s *Server) launch(args json.RawMessage) (interface{}, error) {
+
+	var p LaunchArguments
+	if err := decode(args, &p); err != nil {
+		return nil, err
+	}
+
+	if s.query != nil {
+		return nil, fmt.Errorf("query already launched")
+	}
+
+	if p.Query == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+
+	regoArgs := []func(*rego.Rego){rego.Query(p.Query)}
+
+	// Paths are made absolute so that the locations of expressions match the
+	// paths of breakpoints.
+	if len(p.Data) > 0 {
+		paths, err := absPaths(p.Data)
+		if err != nil {
+			return nil, err
+		}
+		regoArgs = append(regoArgs, rego.Load(paths, nil))
+	}
+
+	bundles, err := absPaths(p.Bundles)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range bundles {
+		regoArgs = append(regoArgs, rego.LoadBundle(path))
+	}
+
+	if p.Input != "" {
+		bs, err := ioutil.ReadFile(p.Input)
+		if err != nil {
+			return nil, err
+		}
+		var input interface{}
+		if err := util.Unmarshal(bs, &input); err != nil {
+			return nil, fmt.Errorf("unable to parse input: %v", err)
+		}
+		regoArgs = append(regoArgs, rego.Input(input))
+	}
+
+	pq, err := rego.New(regoArgs...).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	s.query = &pq
+	s.stopOnEntry = p.StopOnEntry
+
+	return nil, nil
+}
+
+func absPaths(paths []string) ([]string, error) {
+	result := make([]string, 0, len(paths))
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, abs)
+	}
+	return result, nil
+}
+
+func (s *Server) setBreakpoints(args json.RawMessage) (interface{}, error) {
+
+	var p SetBreakpointsArguments
+	if err := decode(args, &p); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Clean(p.Source.Path)
+	lines := make(map[int]bool, len(p.Breakpoints))
+	result := make([]Breakpoint, 0, len(p.Breakpoints))
+
+	for _, bp := range p.Breakpoints {
+		s.nextID++
+		lines[bp.Line] = true
+		result = append(result, Breakpoint{
+			ID:       s.nextID,
+			Verified: true,
+			Source:   &p.Source,
+			Line:     bp.Line,
+		})
+	}
+
+	if len(lines) > 0 {
+		s.breakpoints[path] = lines
+	} else {
+		delete(s.breakpoints, path)
+	}
+
+	return SetBreakpointsResponseBody{Breakpoints: result}, nil
+}
+
+func (s *Server) configurationDone(json.RawMessage) (interface{}, error) {
+	s.configured = true
+	return nil, nil
+}
+
+// start evaluates the launched query. Output and termination are reported to
+// the client with events.
+func (s *Server) start() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.started = true
+
+	if s.stopOnEntry {
+		s.mode = modeEntry
+	}
+
+	pq, done := s.query, s.done
+
+	go func() {
+		defer close(done)
+
+		rs, err := pq.Eval(ctx, rego.EvalQueryTracer(s))
+
+		exitCode := 0
+		if err != nil {
+			exitCode = 1
+			s.event("output", OutputEventBody{Category: "stderr", Output: err.Error() + "\n"})
+		} else {
+			bs, err := json.MarshalIndent(rs, "", "  ")
+			if err != nil {
+				bs = []byte(err.Error())
+			}
+			s.event("output", OutputEventBody{Category: "stdout", Output: string(bs) + "\n"})
+		}
+
+		s.event("exited", ExitedEventBody{ExitCode: exitCode})
+		s.event("terminated", nil)
+	}()
+}
+
+// stop cancels evaluation and waits for it to finish.
+func (s *Server) stop() {
+
+	s.mtx.Lock()
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	s.abort()
+	done := s.done
+
+	s.mtx.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+func (s *Server) threads(json.RawMessage) (interface{}, error) {
+	return ThreadsResponseBody{Threads: []Thread{{ID: threadID, Name: "main"}}}, nil
+}
+
+func (s *Server) continueRequest(json.RawMessage) (interface{}, error) {
+	if err := s.resume(modeContinue); err != nil {
+		return nil, err
+	}
+	return ContinueResponseBody{AllThreadsContinued: true}, nil
+}
+
+func (s *Server) next(json.RawMessage) (interface{}, error) {
+	return nil, s.resume(modeNext)
+}
+
+func (s *Server) stepIn(json.RawMessage) (interface{}, error) {
+	return nil, s.resume(modeStepIn)
+}
+
+func (s *Server) stepOut(json.RawMessage) (interface{}, error) {
+	return nil, s.resume(modeStepOut)
+}
+
+func (s *Server) pause(json.RawMessage) (interface{}, error) {
+	if s.paused == nil {
+		s.mode = modePause
+	}
+	return nil, nil
+}
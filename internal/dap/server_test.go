@@ -0,0 +1,409 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package dap
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/internal/jsonrpc"
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+// message is a response or event read by the test client.
+type message struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Command    string          `json:"command"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// testClient drives a server over pipes. Events are queued while waiting for
+// responses.
+type testClient struct {
+	t        *testing.T
+	stream   *jsonrpc.Stream
+	messages chan *message
+	events   []*message
+	done     chan error
+	seq      int
+}
+
+func newTestClient(t *testing.T) *testClient {
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	s := New(serverIn, serverOut)
+
+	c := &testClient{
+		t:        t,
+		stream:   jsonrpc.NewStream(clientIn, clientOut),
+		messages: make(chan *message, 100),
+		done:     make(chan error, 1),
+	}
+
+	go func() {
+		c.done <- s.Serve(context.Background())
+		serverOut.Close()
+	}()
+
+	go func() {
+		defer close(c.messages)
+		for {
+			bs, err := c.stream.Read()
+			if err != nil {
+				return
+			}
+			var msg message
+			if err := json.Unmarshal(bs, &msg); err != nil {
+				panic(err)
+			}
+			c.messages <- &msg
+		}
+	}()
+
+	return c
+}
+
+func (c *testClient) next() *message {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("Unexpected end of stream")
+		}
+		return msg
+	case <-time.After(10 * time.Second):
+		c.t.Fatal("Timed out waiting for message")
+	}
+	return nil
+}
+
+// request sends a request and waits for the response. If the request fails,
+// the error message is returned.
+func (c *testClient) request(command string, args interface{}, body interface{}) string {
+	c.t.Helper()
+
+	c.seq++
+	seq := c.seq
+
+	if err := c.stream.Write(map[string]interface{}{"seq": seq, "type": "request", "command": command, "arguments": args}); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		msg := c.next()
+		if msg.Type == typeEvent {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg.RequestSeq != seq || msg.Command != command {
+			c.t.Fatalf("Unexpected response: %+v", msg)
+		}
+		if !msg.Success {
+			return msg.Message
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return ""
+	}
+}
+
+func (c *testClient) mustRequest(command string, args interface{}, body interface{}) {
+	c.t.Helper()
+	if msg := c.request(command, args, body); msg != "" {
+		c.t.Fatalf("Request %v failed: %v", command, msg)
+	}
+}
+
+// event waits for the named event. Other events are discarded.
+func (c *testClient) event(name string, body interface{}) {
+	c.t.Helper()
+	for {
+		var msg *message
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.next()
+		}
+		if msg.Type != typeEvent {
+			c.t.Fatalf("Unexpected response: %+v", msg)
+		}
+		if msg.Event != name {
+			continue
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return
+	}
+}
+
+// stopped waits for evaluation to pause and returns the reason, the top stack
+// frame and the number of frames.
+func (c *testClient) stopped() (string, StackFrame, int) {
+	c.t.Helper()
+	var evt StoppedEventBody
+	c.event("stopped", &evt)
+	var st StackTraceResponseBody
+	c.mustRequest("stackTrace", StackTraceArguments{ThreadID: threadID}, &st)
+	return evt.Reason, st.StackFrames[0], st.TotalFrames
+}
+
+func (c *testClient) variables(ref int) map[string]Variable {
+	c.t.Helper()
+	var body VariablesResponseBody
+	c.mustRequest("variables", VariablesArguments{VariablesReference: ref}, &body)
+	result := map[string]Variable{}
+	for _, v := range body.Variables {
+		result[v.Name] = v
+	}
+	return result
+}
+
+func (c *testClient) disconnect() {
+	c.t.Helper()
+	c.mustRequest("disconnect", nil, nil)
+	select {
+	case err := <-c.done:
+		if err != nil {
+			c.t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		c.t.Fatal("Timed out waiting for disconnect")
+	}
+}
+
+const testPolicy = `package test
+
+p = y {
+	q
+	x := input.x
+	y := f(x)
+}
+
+q { true }
+
+f(a) = b {
+	b := a + 1
+}
+`
+
+func launch(c *testClient, dir string, stopOnEntry bool, lines ...int) {
+	c.t.Helper()
+
+	c.mustRequest("initialize", map[string]interface{}{"adapterID": "opa"}, nil)
+	c.event("initialized", nil)
+
+	c.mustRequest("launch", LaunchArguments{
+		Query:       "data.test.p",
+		Data:        []string{filepath.Join(dir, "x.rego")},
+		Input:       filepath.Join(dir, "input.json"),
+		StopOnEntry: stopOnEntry,
+	}, nil)
+
+	var breakpoints []SourceBreakpoint
+	for _, line := range lines {
+		breakpoints = append(breakpoints, SourceBreakpoint{Line: line})
+	}
+
+	var bps SetBreakpointsResponseBody
+	c.mustRequest("setBreakpoints", SetBreakpointsArguments{
+		Source:      Source{Path: filepath.Join(dir, "x.rego")},
+		Breakpoints: breakpoints,
+	}, &bps)
+
+	if len(bps.Breakpoints) != len(lines) {
+		c.t.Fatalf("Unexpected breakpoints: %v", bps.Breakpoints)
+	}
+
+	c.mustRequest("configurationDone", nil, nil)
+}
+
+func TestBreakpointsAndSteps(t *testing.T) {
+
+	files := map[string]string{
+		"x.rego":     testPolicy,
+		"input.json": `{"x": 1}`,
+	}
+
+	test.WithTempFS(files, func(dir string) {
+
+		c := newTestClient(t)
+		launch(c, dir, false, 6)
+
+		reason, frame, depth := c.stopped()
+		if reason != ReasonBreakpoint || frame.Line != 6 || frame.Name != "data.test.p" || depth != 2 {
+			t.Fatalf("Unexpected stop: %v %+v %d", reason, frame, depth)
+		}
+
+		if frame.Source == nil || frame.Source.Path != filepath.Join(dir, "x.rego") {
+			t.Fatalf("Unexpected source: %+v", frame.Source)
+		}
+
+		var scopes ScopesResponseBody
+		c.mustRequest("scopes", ScopesArguments{FrameID: frame.ID}, &scopes)
+
+		if len(scopes.Scopes) != 2 {
+			t.Fatalf("Unexpected scopes: %+v", scopes)
+		}
+
+		locals := c.variables(scopes.Scopes[0].VariablesReference)
+		if locals["x"].Value != "1" || locals["x"].Type != "number" {
+			t.Fatalf("Unexpected locals: %+v", locals)
+		}
+
+		for name := range locals {
+			if strings.HasPrefix(name, "__") {
+				t.Fatalf("Unexpected generated variable: %v", name)
+			}
+		}
+
+		cache := c.variables(scopes.Scopes[1].VariablesReference)
+		if cache["data.test.q"].Value != "true" {
+			t.Fatalf("Unexpected virtual documents: %+v", cache)
+		}
+
+		// Step into the function call.
+		c.mustRequest("stepIn", nil, nil)
+
+		reason, frame, depth = c.stopped()
+		if reason != ReasonStep || frame.Line != 12 || frame.Name != "data.test.f" || depth != 3 {
+			t.Fatalf("Unexpected stop: %v %+v %d", reason, frame, depth)
+		}
+
+		c.mustRequest("scopes", ScopesArguments{FrameID: frame.ID}, &scopes)
+		if locals := c.variables(scopes.Scopes[0].VariablesReference); locals["a"].Value != "1" {
+			t.Fatalf("Unexpected locals: %+v", locals)
+		}
+
+		// Step out to the caller. The rest of the calling expression is on
+		// the line of the breakpoint.
+		c.mustRequest("stepOut", nil, nil)
+
+		_, frame, depth = c.stopped()
+		if frame.Line != 6 || frame.Name != "data.test.p" || depth != 2 {
+			t.Fatalf("Unexpected stop: %+v %d", frame, depth)
+		}
+
+		var cont ContinueResponseBody
+		c.mustRequest("continue", nil, &cont)
+
+		var output OutputEventBody
+		c.event("output", &output)
+
+		if output.Category != "stdout" || !strings.Contains(output.Output, `"value": 2`) {
+			t.Fatalf("Unexpected output: %+v", output)
+		}
+
+		var exited ExitedEventBody
+		c.event("exited", &exited)
+		if exited.ExitCode != 0 {
+			t.Fatalf("Unexpected exit code: %d", exited.ExitCode)
+		}
+
+		c.event("terminated", nil)
+
+		if msg := c.request("stackTrace", StackTraceArguments{ThreadID: threadID}, nil); msg == "" {
+			t.Fatal("Expected error when not paused")
+		}
+
+		c.disconnect()
+	})
+}
+
+func TestStepOver(t *testing.T) {
+
+	files := map[string]string{
+		"x.rego":     testPolicy,
+		"input.json": `{"x": 1}`,
+	}
+
+	test.WithTempFS(files, func(dir string) {
+
+		c := newTestClient(t)
+		launch(c, dir, true)
+
+		reason, frame, _ := c.stopped()
+		if reason != ReasonEntry || frame.Name != "query" {
+			t.Fatalf("Unexpected stop: %v %+v", reason, frame)
+		}
+
+		// The query calls the rule.
+		c.mustRequest("stepIn", nil, nil)
+
+		lines := []int{}
+
+		for {
+			_, frame, _ = c.stopped()
+			if frame.Name != "data.test.p" {
+				t.Fatalf("Expected to step over rule and function calls but got %+v", frame)
+			}
+			lines = append(lines, frame.Line)
+			if frame.Line == 6 {
+				break
+			}
+			c.mustRequest("next", nil, nil)
+		}
+
+		if len(lines) != 3 || lines[0] != 4 || lines[1] != 5 {
+			t.Fatalf("Unexpected lines: %v", lines)
+		}
+
+		c.disconnect()
+	})
+}
+
+func TestDisconnectWhilePaused(t *testing.T) {
+
+	files := map[string]string{
+		"x.rego":     testPolicy,
+		"input.json": `{"x": 1}`,
+	}
+
+	test.WithTempFS(files, func(dir string) {
+		c := newTestClient(t)
+		launch(c, dir, false, 12)
+		if reason, _, _ := c.stopped(); reason != ReasonBreakpoint {
+			t.Fatalf("Unexpected reason: %v", reason)
+		}
+		c.disconnect()
+	})
+}
+
+func TestLaunchErrors(t *testing.T) {
+
+	c := newTestClient(t)
+	c.mustRequest("initialize", nil, nil)
+
+	if msg := c.request("launch", LaunchArguments{}, nil); msg != "query is required" {
+		t.Fatalf("Unexpected error: %v", msg)
+	}
+
+	if msg := c.request("launch", LaunchArguments{Query: "data.x[", Data: []string{}}, nil); msg == "" {
+		t.Fatal("Expected parse error")
+	}
+
+	if msg := c.request("evaluate", nil, nil); msg != "unsupported command: evaluate" {
+		t.Fatalf("Unexpected error: %v", msg)
+	}
+
+	c.disconnect()
+}
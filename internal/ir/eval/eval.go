@@ -0,0 +1,477 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package eval contains an evaluator for plans. The evaluator interprets the
+// statements of the plan with the same semantics as the wasm and go targets
+// and serves as a reference for runtimes that execute plans emitted by the
+// plan target (see ir.Marshal).
+package eval
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/compile/runtime"
+	"github.com/open-policy-agent/opa/internal/ir"
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+// Evaluator evaluates a plan.
+type Evaluator struct {
+	policy *ir.Policy
+	funcs  map[string]*ir.Func
+	strs   []ast.Value
+}
+
+// Results of executing statements other than the indexes of blocks to break
+// out of.
+const (
+	next = -1 // continue with the next statement
+	ret  = -2 // return from the function
+)
+
+// frame holds the locals of a function call or the plan.
+type frame struct {
+	locals []ast.Value
+	result ast.Value
+}
+
+func (f *frame) get(l ir.Local) ast.Value {
+	if int(l) >= len(f.locals) {
+		return nil
+	}
+	return f.locals[l]
+}
+
+func (f *frame) set(l ir.Local, v ast.Value) {
+	for int(l) >= len(f.locals) {
+		f.locals = append(f.locals, nil)
+	}
+	f.locals[l] = v
+}
+
+// New returns an evaluator for the policy. An error is returned if the policy
+// is malformed or calls built-in functions that are not implemented by OPA.
+func New(policy *ir.Policy) (*Evaluator, error) {
+
+	if policy == nil || policy.Static == nil || policy.Plan == nil || policy.Funcs == nil {
+		return nil, fmt.Errorf("illegal plan: static, plan and funcs must be set")
+	}
+
+	e := &Evaluator{
+		policy: policy,
+		funcs:  make(map[string]*ir.Func, len(policy.Funcs.Funcs)),
+		strs:   make([]ast.Value, len(policy.Static.Strings)),
+	}
+
+	for i, s := range policy.Static.Strings {
+		e.strs[i] = ast.String(s.Value)
+	}
+
+	for _, fn := range policy.Funcs.Funcs {
+		e.funcs[fn.Name] = fn
+	}
+
+	c := &checker{policy: policy, funcs: e.funcs, builtins: map[string]struct{}{}}
+
+	for _, decl := range policy.Static.BuiltinFuncs {
+		if topdown.GetBuiltin(decl.Name) == nil {
+			return nil, fmt.Errorf("undefined function: %q", decl.Name)
+		}
+		c.builtins[decl.Name] = struct{}{}
+	}
+
+	for i, block := range policy.Plan.Blocks {
+		if err := c.check(block, 1); err != nil {
+			return nil, errors.Wrapf(err, "plan: block %d", i)
+		}
+	}
+
+	for _, fn := range policy.Funcs.Funcs {
+		if err := c.checkLocals(fn.Params); err != nil {
+			return nil, errors.Wrapf(err, "func %v", fn.Name)
+		}
+		// The last block of a function is not nested. Breaking out of it
+		// returns an undefined value.
+		for i, block := range fn.Blocks {
+			if err := c.check(block, 1); err != nil {
+				return nil, errors.Wrapf(err, "func %v: block %d", fn.Name, i)
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// Eval evaluates the plan with the input and data documents and returns the
+// query results. Input and data are converted with ast.InterfaceToValue. If
+// input is nil, it is undefined.
+func (e *Evaluator) Eval(input, data interface{}) ([]map[string]interface{}, error) {
+	return runtime.Eval(e.plan, input, data)
+}
+
+// EvalValue evaluates the plan with the input and data documents and returns
+// the set of query results. If input is nil, it is undefined.
+func (e *Evaluator) EvalValue(input, data ast.Value) (ast.Set, error) {
+	return runtime.EvalValue(e.plan, input, data)
+}
+
+func (e *Evaluator) plan(s *runtime.State, input, data ast.Value) {
+
+	f := &frame{}
+	f.set(ir.Input, input)
+	f.set(ir.Data, data)
+
+	for _, block := range e.policy.Plan.Blocks {
+		if e.block(s, f, block) == ret {
+			return
+		}
+	}
+}
+
+func (e *Evaluator) call(s *runtime.State, fn *ir.Func, args []ast.Value) ast.Value {
+
+	f := &frame{}
+
+	for i, p := range fn.Params {
+		f.set(p, args[i])
+	}
+
+	for i, block := range fn.Blocks {
+		var r int
+		if i < len(fn.Blocks)-1 {
+			r = e.block(s, f, block)
+		} else {
+			r = e.exec(s, f, block.Stmts)
+		}
+		if r == ret {
+			return f.result
+		} else if r != next {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// block executes the statements of a nested block. Breaking out of the block
+// continues with the statement after the block.
+func (e *Evaluator) block(s *runtime.State, f *frame, block *ir.Block) int {
+	switch r := e.exec(s, f, block.Stmts); r {
+	case next, ret:
+		return r
+	case 0:
+		return next
+	default:
+		return r - 1
+	}
+}
+
+// exec executes the statements. If a statement is undefined or a break
+// statement is reached, the index of the block to break out of is returned.
+func (e *Evaluator) exec(s *runtime.State, f *frame, stmts []ir.Stmt) int {
+
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ir.ResultSetAdd:
+			s.Add(f.get(stmt.Value))
+		case *ir.ReturnLocalStmt:
+			f.result = f.get(stmt.Source)
+			return ret
+		case *ir.BlockStmt:
+			for _, block := range stmt.Blocks {
+				if r := e.block(s, f, block); r != next {
+					return r
+				}
+			}
+		case *ir.BreakStmt:
+			return int(stmt.Index)
+		case *ir.CallStmt:
+			args := make([]ast.Value, len(stmt.Args))
+			for i := range stmt.Args {
+				args[i] = f.get(stmt.Args[i])
+			}
+			var result ast.Value
+			if fn, ok := e.funcs[stmt.Func]; ok {
+				result = e.call(s, fn, args)
+			} else {
+				result = s.Call(stmt.Func, args...)
+			}
+			f.set(stmt.Result, result)
+			if result == nil {
+				return 0
+			}
+		case *ir.WithStmt:
+			if r := e.with(s, f, stmt); r != next {
+				return r
+			}
+		case *ir.AssignVarStmt:
+			f.set(stmt.Target, f.get(stmt.Source))
+		case *ir.AssignVarOnceStmt:
+			f.set(stmt.Target, runtime.AssignOnce(f.get(stmt.Target), f.get(stmt.Source)))
+		case *ir.AssignBooleanStmt:
+			f.set(stmt.Target, ast.Boolean(stmt.Value))
+		case *ir.AssignIntStmt:
+			f.set(stmt.Target, ast.Number(strconv.FormatInt(stmt.Value, 10)))
+		case *ir.ScanStmt:
+			if r := e.scan(s, f, stmt); r != next {
+				return r
+			}
+		case *ir.NotStmt:
+			switch r := e.exec(s, f, stmt.Block.Stmts); r {
+			case next:
+				return 0
+			case ret:
+				return ret
+			case 0:
+			default:
+				return r - 1
+			}
+		case *ir.DotStmt:
+			v := runtime.Get(f.get(stmt.Source), f.get(stmt.Key))
+			f.set(stmt.Target, v)
+			if v == nil {
+				return 0
+			}
+		case *ir.LenStmt:
+			f.set(stmt.Target, runtime.Len(f.get(stmt.Source)))
+		case *ir.EqualStmt:
+			if ast.Compare(f.get(stmt.A), f.get(stmt.B)) != 0 {
+				return 0
+			}
+		case *ir.NotEqualStmt:
+			if ast.Compare(f.get(stmt.A), f.get(stmt.B)) == 0 {
+				return 0
+			}
+		case *ir.LessThanStmt:
+			if ast.Compare(f.get(stmt.A), f.get(stmt.B)) >= 0 {
+				return 0
+			}
+		case *ir.LessThanEqualStmt:
+			if ast.Compare(f.get(stmt.A), f.get(stmt.B)) > 0 {
+				return 0
+			}
+		case *ir.GreaterThanStmt:
+			if ast.Compare(f.get(stmt.A), f.get(stmt.B)) <= 0 {
+				return 0
+			}
+		case *ir.GreaterThanEqualStmt:
+			if ast.Compare(f.get(stmt.A), f.get(stmt.B)) < 0 {
+				return 0
+			}
+		case *ir.MakeNullStmt:
+			f.set(stmt.Target, ast.Null{})
+		case *ir.MakeBooleanStmt:
+			f.set(stmt.Target, ast.Boolean(stmt.Value))
+		case *ir.MakeNumberFloatStmt:
+			f.set(stmt.Target, ast.Number(strconv.FormatFloat(stmt.Value, 'g', -1, 64)))
+		case *ir.MakeNumberIntStmt:
+			f.set(stmt.Target, ast.Number(strconv.FormatInt(stmt.Value, 10)))
+		case *ir.MakeNumberRefStmt:
+			f.set(stmt.Target, ast.Number(e.policy.Static.Strings[stmt.Index].Value))
+		case *ir.MakeStringStmt:
+			f.set(stmt.Target, e.strs[stmt.Index])
+		case *ir.MakeArrayStmt:
+			f.set(stmt.Target, make(ast.Array, 0, stmt.Capacity))
+		case *ir.MakeObjectStmt:
+			f.set(stmt.Target, ast.NewObject())
+		case *ir.MakeSetStmt:
+			f.set(stmt.Target, ast.NewSet())
+		case *ir.IsArrayStmt:
+			if _, ok := f.get(stmt.Source).(ast.Array); !ok {
+				return 0
+			}
+		case *ir.IsObjectStmt:
+			if _, ok := f.get(stmt.Source).(ast.Object); !ok {
+				return 0
+			}
+		case *ir.IsDefinedStmt:
+			if f.get(stmt.Source) == nil {
+				return 0
+			}
+		case *ir.IsUndefinedStmt:
+			if f.get(stmt.Source) != nil {
+				return 0
+			}
+		case *ir.ArrayAppendStmt:
+			f.set(stmt.Array, runtime.ArrayAppend(f.get(stmt.Array), f.get(stmt.Value)))
+		case *ir.ObjectInsertStmt:
+			runtime.ObjectInsert(f.get(stmt.Object), f.get(stmt.Key), f.get(stmt.Value))
+		case *ir.ObjectInsertOnceStmt:
+			runtime.ObjectInsertOnce(f.get(stmt.Object), f.get(stmt.Key), f.get(stmt.Value))
+		case *ir.ObjectMergeStmt:
+			f.set(stmt.Target, runtime.ObjectMerge(f.get(stmt.A), f.get(stmt.B)))
+		case *ir.SetAddStmt:
+			runtime.SetAdd(f.get(stmt.Set), f.get(stmt.Value))
+		}
+	}
+
+	return next
+}
+
+// scan executes the body of the scan for each element of the source. Breaking
+// out of the body (index 0) continues with the next element. Index 1 exits the
+// scan.
+func (e *Evaluator) scan(s *runtime.State, f *frame, scan *ir.ScanStmt) int {
+
+	for it := runtime.Iter(f.get(scan.Source)); it.Next(); {
+
+		f.set(scan.Key, it.Key())
+		f.set(scan.Value, it.Value())
+
+		switch r := e.exec(s, f, scan.Block.Stmts); r {
+		case next, 0:
+		case 1:
+			return next
+		case ret:
+			return ret
+		default:
+			return r - 2
+		}
+	}
+
+	return next
+}
+
+// with replaces the local (or the value at the path inside the local) during
+// the execution of the block. The local is restored afterwards. The statement
+// is undefined if the end of the block is not reached.
+func (e *Evaluator) with(s *runtime.State, f *frame, with *ir.WithStmt) int {
+
+	save := f.get(with.Local)
+
+	if len(with.Path) == 0 {
+		f.set(with.Local, f.get(with.Value))
+	} else {
+		path := make([]ast.Value, len(with.Path))
+		for i := range with.Path {
+			path[i] = e.strs[with.Path[i]]
+		}
+		f.set(with.Local, runtime.Upsert(save, path, f.get(with.Value)))
+	}
+
+	r := e.exec(s, f, with.Block.Stmts)
+
+	f.set(with.Local, save)
+
+	switch r {
+	case next, ret, 0:
+		return r
+	default:
+		return r - 1
+	}
+}
+
+// checker validates the statements of a policy so that the evaluator does not
+// have to handle malformed plans.
+type checker struct {
+	policy   *ir.Policy
+	funcs    map[string]*ir.Func
+	builtins map[string]struct{}
+}
+
+// check validates the statements in the block. Depth is the number of
+// enclosing blocks that can be broken out of.
+func (c *checker) check(block *ir.Block, depth int) error {
+
+	if block == nil {
+		return fmt.Errorf("illegal block: nil")
+	}
+
+	for _, stmt := range block.Stmts {
+
+		if err := c.checkStmt(stmt, depth); err != nil {
+			return errors.Wrapf(err, "%T %+v", stmt, stmt)
+		}
+	}
+
+	return nil
+}
+
+func (c *checker) checkStmt(stmt ir.Stmt, depth int) error {
+
+	if v := reflect.ValueOf(stmt); v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+		for i := 0; i < v.NumField(); i++ {
+			switch x := v.Field(i).Interface().(type) {
+			case ir.Local:
+				if err := c.checkLocals([]ir.Local{x}); err != nil {
+					return err
+				}
+			case []ir.Local:
+				if err := c.checkLocals(x); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	switch stmt := stmt.(type) {
+	case *ir.BlockStmt:
+		for _, block := range stmt.Blocks {
+			if err := c.check(block, depth+1); err != nil {
+				return err
+			}
+		}
+	case *ir.ScanStmt:
+		return c.check(stmt.Block, depth+2)
+	case *ir.NotStmt:
+		return c.check(stmt.Block, depth+1)
+	case *ir.WithStmt:
+		for _, index := range stmt.Path {
+			if err := c.checkString(index); err != nil {
+				return err
+			}
+		}
+		return c.check(stmt.Block, depth+1)
+	case *ir.BreakStmt:
+		if int(stmt.Index) >= depth {
+			return fmt.Errorf("illegal break: index %d", stmt.Index)
+		}
+	case *ir.CallStmt:
+		if fn, ok := c.funcs[stmt.Func]; ok {
+			if len(fn.Params) != len(stmt.Args) {
+				return fmt.Errorf("illegal call: %v expects %d arguments", stmt.Func, len(fn.Params))
+			}
+		} else if _, ok := c.builtins[stmt.Func]; !ok {
+			return fmt.Errorf("undefined function: %q", stmt.Func)
+		}
+	case *ir.MakeStringStmt:
+		return c.checkString(stmt.Index)
+	case *ir.MakeNumberRefStmt:
+		return c.checkString(stmt.Index)
+	case *ir.ResultSetAdd, *ir.ReturnLocalStmt, *ir.AssignVarStmt, *ir.AssignVarOnceStmt,
+		*ir.AssignBooleanStmt, *ir.AssignIntStmt, *ir.DotStmt, *ir.LenStmt,
+		*ir.EqualStmt, *ir.NotEqualStmt, *ir.LessThanStmt, *ir.LessThanEqualStmt,
+		*ir.GreaterThanStmt, *ir.GreaterThanEqualStmt, *ir.MakeNullStmt,
+		*ir.MakeBooleanStmt, *ir.MakeNumberFloatStmt, *ir.MakeNumberIntStmt,
+		*ir.MakeArrayStmt, *ir.MakeObjectStmt, *ir.MakeSetStmt, *ir.IsArrayStmt,
+		*ir.IsObjectStmt, *ir.IsDefinedStmt, *ir.IsUndefinedStmt, *ir.ArrayAppendStmt,
+		*ir.ObjectInsertStmt, *ir.ObjectInsertOnceStmt, *ir.ObjectMergeStmt, *ir.SetAddStmt:
+	default:
+		return fmt.Errorf("illegal statement: %T", stmt)
+	}
+
+	return nil
+}
+
+func (c *checker) checkLocals(locals []ir.Local) error {
+	for _, l := range locals {
+		if l < 0 {
+			return fmt.Errorf("illegal local: %d", l)
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkString(index int) error {
+	if index < 0 || index >= len(c.policy.Static.Strings) {
+		return fmt.Errorf("illegal string index: %d", index)
+	}
+	return nil
+}
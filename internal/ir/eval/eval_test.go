@@ -0,0 +1,372 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/ir"
+	"github.com/open-policy-agent/opa/internal/planner"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+type testCase struct {
+	Note        string                  `json:"note"`
+	Query       string                  `json:"query"`
+	Modules     []string                `json:"modules,omitempty"`
+	Data        *map[string]interface{} `json:"data,omitempty"`
+	Input       *interface{}            `json:"input,omitempty"`
+	WantDefined *bool                   `json:"want_defined,omitempty"`
+	WantError   *string                 `json:"want_error,omitempty"`
+}
+
+type testCaseSet struct {
+	Cases []testCase `json:"cases"`
+}
+
+func plan(query string, modules []string) (*ir.Policy, error) {
+
+	parsed := map[string]*ast.Module{}
+
+	for i := range modules {
+		name := fmt.Sprintf("module%d.rego", i)
+		module, err := ast.ParseModule(name, modules[i])
+		if err != nil {
+			return nil, err
+		}
+		parsed[name] = module
+	}
+
+	compiler := ast.NewCompiler()
+	if compiler.Compile(parsed); compiler.Failed() {
+		return nil, compiler.Errors
+	}
+
+	body, err := ast.ParseBody(query)
+	if err != nil {
+		return nil, err
+	}
+
+	qc := compiler.QueryCompiler()
+	compiled, err := qc.Compile(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range compiler.Modules {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var mods []*ast.Module
+	for _, name := range names {
+		mods = append(mods, compiler.Modules[name])
+	}
+
+	return planner.New().
+		WithQueries([]ast.Body{compiled}).
+		WithModules(mods).
+		WithRewrittenVars(qc.RewrittenVars()).
+		WithBuiltinDecls(ast.BuiltinMap).
+		Plan()
+}
+
+func TestEval(t *testing.T) {
+
+	policy, err := plan(`data.test.p = x`, []string{`package test
+
+p = y { y := count(input.xs) + 1 }`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := New(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := e.Eval(map[string]interface{}{"xs": []interface{}{1, 2}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []map[string]interface{}{{"x": 3}}
+
+	if normalize(rs) != normalize(exp) {
+		t.Fatalf("Expected %v but got %v", exp, rs)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+
+	tests := []struct {
+		note   string
+		static *ir.Static
+		stmts  []ir.Stmt
+		exp    string
+	}{
+		{
+			note:   "undefined builtin",
+			static: &ir.Static{BuiltinFuncs: []*ir.BuiltinFunc{{Name: "deadbeef"}}},
+			exp:    `undefined function: "deadbeef"`,
+		},
+		{
+			note:  "undeclared call",
+			stmts: []ir.Stmt{&ir.CallStmt{Func: "plus", Args: []ir.Local{2, 3}, Result: 4}},
+			exp:   `plan: block 0: *ir.CallStmt &{Func:plus Args:[2 3] Result:4}: undefined function: "plus"`,
+		},
+		{
+			note:  "break",
+			stmts: []ir.Stmt{&ir.BlockStmt{Blocks: []*ir.Block{{Stmts: []ir.Stmt{&ir.BreakStmt{Index: 2}}}}}},
+			exp:   "illegal break: index 2",
+		},
+		{
+			note:  "string index",
+			stmts: []ir.Stmt{&ir.MakeStringStmt{Index: 1, Target: 2}},
+			exp:   "illegal string index: 1",
+		},
+		{
+			note:  "local",
+			stmts: []ir.Stmt{&ir.AssignVarStmt{Source: -1, Target: 2}},
+			exp:   "illegal local: -1",
+		},
+		{
+			note:  "missing block",
+			stmts: []ir.Stmt{&ir.NotStmt{}},
+			exp:   "illegal block: nil",
+		},
+		{
+			note:  "statement",
+			stmts: []ir.Stmt{"deadbeef"},
+			exp:   "illegal statement: string",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			static := tc.static
+			if static == nil {
+				static = &ir.Static{}
+			}
+			policy := &ir.Policy{
+				Static: static,
+				Plan:   &ir.Plan{Blocks: []*ir.Block{{Stmts: tc.stmts}}},
+				Funcs:  &ir.Funcs{},
+			}
+			_, err := New(policy)
+			if err == nil || !strings.Contains(err.Error(), tc.exp) {
+				t.Fatalf("Expected error containing %q but got %v", tc.exp, err)
+			}
+		})
+	}
+}
+
+// TestCorpus plans the wasm test cases with and without optimizations,
+// round-trips the plans through their JSON encoding, evaluates them and
+// compares the results with topdown. The expected results of the test cases
+// are not compared because the wasm target rounds numbers differently.
+func TestCorpus(t *testing.T) {
+
+	files, err := filepath.Glob(filepath.Join("..", "..", "..", "test", "wasm", "assets", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var set testCaseSet
+		if err := util.Unmarshal(bs, &set); err != nil {
+			t.Fatalf("%v: %v", file, err)
+		}
+
+		for _, tc := range set.Cases {
+			for _, level := range []int{0, 2} {
+
+				note := filepath.Base(file) + "/" + tc.Note
+				if level > 0 {
+					note += "/optimized"
+				}
+
+				t.Run(note, func(t *testing.T) {
+
+					policy, err := plan(tc.Query, tc.Modules)
+					if err != nil {
+						t.Skipf("plan: %v", err)
+					}
+
+					ir.Optimize(policy, ir.Passes(level))
+
+					bs, err := ir.Marshal(policy)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if policy, err = ir.Unmarshal(bs); err != nil {
+						t.Fatal(err)
+					}
+
+					e, err := New(policy)
+					if err != nil {
+						if strings.Contains(err.Error(), "undefined function") {
+							t.Skip(err)
+						}
+						t.Fatal(err)
+					}
+
+					var input, data interface{}
+					if tc.Input != nil {
+						input = *tc.Input
+					}
+					if tc.Data != nil {
+						data = *tc.Data
+					}
+
+					rs, err := e.Eval(input, data)
+					assertResult(t, tc, rs, err)
+				})
+			}
+		}
+	}
+}
+
+func assertResult(t *testing.T, tc testCase, rs []map[string]interface{}, err error) {
+
+	exp, topdownErr := evalTopdown(tc)
+
+	switch {
+	case tc.WantError != nil:
+		if err == nil || !strings.Contains(err.Error(), *tc.WantError) {
+			t.Fatalf("Expected error containing %q but got %v (results: %v)", *tc.WantError, err, rs)
+		}
+		if topdownErr == nil {
+			t.Fatalf("Expected topdown error but got %v", exp)
+		}
+		return
+	case err != nil:
+		t.Fatalf("Unexpected error: %v", err)
+	case topdownErr != nil:
+		t.Fatalf("Unexpected topdown error: %v", topdownErr)
+	}
+
+	got := normalize(rs)
+
+	if got != normalize(exp) {
+		t.Fatalf("Expected topdown results %v but got %v", normalize(exp), got)
+	}
+
+	if tc.WantDefined != nil && *tc.WantDefined != (len(rs) > 0) {
+		t.Fatalf("Expected defined to be %v but got %v", *tc.WantDefined, got)
+	}
+}
+
+func evalTopdown(tc testCase) ([]map[string]interface{}, error) {
+
+	args := []func(*rego.Rego){
+		rego.Query(tc.Query),
+	}
+
+	for i := range tc.Modules {
+		args = append(args, rego.Module(fmt.Sprintf("module%d.rego", i), tc.Modules[i]))
+	}
+
+	if tc.Data != nil {
+		args = append(args, rego.Store(inmem.NewFromObject(*tc.Data)))
+	}
+
+	if tc.Input != nil {
+		args = append(args, rego.Input(*tc.Input))
+	}
+
+	rs, err := rego.New(args...).Eval(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	// Expressions that evaluate to false are undefined in the plan but their
+	// values are captured by topdown.
+	var result []map[string]interface{}
+
+	for i := range rs {
+		defined := true
+		for _, expr := range rs[i].Expressions {
+			if expr.Value == false {
+				defined = false
+			}
+		}
+		if defined {
+			result = append(result, rs[i].Bindings)
+		}
+	}
+
+	return result, nil
+}
+
+// normalize returns a canonical representation of a result set. Topdown may
+// return the same bindings multiple times and sets are compared regardless
+// of their order.
+func normalize(rs []map[string]interface{}) string {
+
+	seen := map[string]struct{}{}
+	var keys []string
+
+	for i := range rs {
+		x, err := ast.InterfaceToValue(rs[i])
+		if err != nil {
+			panic(err)
+		}
+		bs, err := json.Marshal(canonical(x))
+		if err != nil {
+			panic(err)
+		}
+		if _, ok := seen[string(bs)]; !ok {
+			seen[string(bs)] = struct{}{}
+			keys = append(keys, string(bs))
+		}
+	}
+
+	sort.Strings(keys)
+
+	return "[" + strings.Join(keys, ",") + "]"
+}
+
+// canonical sorts arrays so that sets, which are returned as arrays, compare
+// equal regardless of their order.
+func canonical(x ast.Value) interface{} {
+	switch x := x.(type) {
+	case ast.Array:
+		result := make([]string, len(x))
+		for i := range x {
+			bs, _ := json.Marshal(canonical(x[i].Value))
+			result[i] = string(bs)
+		}
+		sort.Strings(result)
+		return result
+	case ast.Object:
+		result := map[string]interface{}{}
+		x.Foreach(func(k, v *ast.Term) {
+			result[k.String()] = canonical(v.Value)
+		})
+		return result
+	}
+	v, _ := ast.JSON(x)
+	return v
+}
@@ -10,32 +10,36 @@ package ir
 
 import (
 	"fmt"
+
+	"github.com/open-policy-agent/opa/types"
 )
 
 type (
 	// Policy represents a planned policy query.
 	Policy struct {
-		Static *Static
-		Plan   *Plan
-		Funcs  *Funcs
+		Static *Static `json:"static"`
+		Plan   *Plan   `json:"plan"`
+		Funcs  *Funcs  `json:"funcs"`
 	}
 
 	// Static represents a static data segment that is indexed into by the policy.
 	Static struct {
-		Strings      []*StringConst
-		BuiltinFuncs []*BuiltinFunc
+		Strings      []*StringConst `json:"strings"`
+		BuiltinFuncs []*BuiltinFunc `json:"builtin_funcs"`
 	}
 
 	// BuiltinFunc represents a built-in function that may be required by the
-	// policy.
+	// policy. The declaration is set if the planner knows the type of the
+	// function.
 	BuiltinFunc struct {
-		Name string
+		Name string          `json:"name"`
+		Decl *types.Function `json:"decl,omitempty"`
 	}
 
 	// Funcs represents a collection of planned functions to include in the
 	// policy.
 	Funcs struct {
-		Funcs []*Func
+		Funcs []*Func `json:"funcs"`
 	}
 
 	// Func represents a named plan (function) that can be invoked. Functions
@@ -43,16 +47,16 @@ type (
 	// input document and data documents are always passed as the first and
 	// second arguments (respectively).
 	Func struct {
-		Name   string
-		Params []Local
-		Return Local
-		Blocks []*Block // TODO(tsandall): should this be a plan?
+		Name   string   `json:"name"`
+		Params []Local  `json:"params"`
+		Return Local    `json:"return"`
+		Blocks []*Block `json:"blocks"` // TODO(tsandall): should this be a plan?
 	}
 
 	// Plan represents an ordered series of blocks to execute. Plan execution
 	// stops when a return statement is reached. Blocks are executed in-order.
 	Plan struct {
-		Blocks []*Block
+		Blocks []*Block `json:"blocks"`
 	}
 
 	// Block represents an ordered sequence of statements to execute. Blocks are
@@ -60,7 +64,7 @@ type (
 	// or there are no more statements. If all statements are defined but no return
 	// statement is encountered, the block is undefined.
 	Block struct {
-		Stmts []Stmt
+		Stmts []Stmt `json:"stmts"`
 	}
 
 	// Stmt represents an operation (e.g., comparison, loop, dot, etc.) to execute.
@@ -82,22 +86,22 @@ type (
 
 	// BooleanConst represents a boolean value.
 	BooleanConst struct {
-		Value bool
+		Value bool `json:"value"`
 	}
 
 	// StringConst represents a string value.
 	StringConst struct {
-		Value string
+		Value string `json:"value"`
 	}
 
 	// IntConst represents an integer constant.
 	IntConst struct {
-		Value int64
+		Value int64 `json:"value"`
 	}
 
 	// FloatConst represents a floating-point constant.
 	FloatConst struct {
-		Value float64
+		Value float64 `json:"value"`
 	}
 )
 
@@ -144,21 +148,21 @@ func (a *StringConst) typeMarker()  {}
 
 // ReturnLocalStmt represents a return statement that yields a local value.
 type ReturnLocalStmt struct {
-	Source Local
+	Source Local `json:"source"`
 }
 
 // CallStmt represents a named function call. The result should be stored in the
 // result local.
 type CallStmt struct {
-	Func   string
-	Args   []Local
-	Result Local
+	Func   string  `json:"func"`
+	Args   []Local `json:"args"`
+	Result Local   `json:"result"`
 }
 
 // BlockStmt represents a nested block. Nested blocks and break statements can
 // be used to short-circuit execution.
 type BlockStmt struct {
-	Blocks []*Block
+	Blocks []*Block `json:"blocks"`
 }
 
 func (a *BlockStmt) String() string {
@@ -169,56 +173,56 @@ func (a *BlockStmt) String() string {
 // many blocks to jump starting from zero (the current block). Execution will
 // continue from the end of the block that is jumped to.
 type BreakStmt struct {
-	Index uint32
+	Index uint32 `json:"index"`
 }
 
 // DotStmt represents a lookup operation on a value (e.g., array, object, etc.)
 // The source of a DotStmt may be a scalar value in which case the statement
 // will be undefined.
 type DotStmt struct {
-	Source Local
-	Key    Local
-	Target Local
+	Source Local `json:"source"`
+	Key    Local `json:"key"`
+	Target Local `json:"target"`
 }
 
 // LenStmt represents a length() operation on a local variable. The
 // result is stored in the target local variable.
 type LenStmt struct {
-	Source Local
-	Target Local
+	Source Local `json:"source"`
+	Target Local `json:"target"`
 }
 
 // ScanStmt represents a linear scan over a composite value. The
 // source may be a scalar in which case the block will never execute.
 type ScanStmt struct {
-	Source Local
-	Key    Local
-	Value  Local
-	Block  *Block
+	Source Local  `json:"source"`
+	Key    Local  `json:"key"`
+	Value  Local  `json:"value"`
+	Block  *Block `json:"block"`
 }
 
 // NotStmt represents a negated statement.
 type NotStmt struct {
-	Block *Block
+	Block *Block `json:"block"`
 }
 
 // AssignBooleanStmt represents an assignment of a boolean value to a local variable.
 type AssignBooleanStmt struct {
-	Value  bool
-	Target Local
+	Value  bool  `json:"value"`
+	Target Local `json:"target"`
 }
 
 // AssignIntStmt represents an assignment of an integer value to a
 // local variable.
 type AssignIntStmt struct {
-	Value  int64
-	Target Local
+	Value  int64 `json:"value"`
+	Target Local `json:"target"`
 }
 
 // AssignVarStmt represents an assignment of one local variable to another.
 type AssignVarStmt struct {
-	Source Local
-	Target Local
+	Source Local `json:"source"`
+	Target Local `json:"target"`
 }
 
 // AssignVarOnceStmt represents an assignment of one local variable to another.
@@ -226,155 +230,155 @@ type AssignVarStmt struct {
 //
 // TODO(tsandall): is there a better name for this?
 type AssignVarOnceStmt struct {
-	Target Local
-	Source Local
+	Target Local `json:"target"`
+	Source Local `json:"source"`
 }
 
 // MakeStringStmt constructs a local variable that refers to a string constant.
 type MakeStringStmt struct {
-	Index  int
-	Target Local
+	Index  int   `json:"index"`
+	Target Local `json:"target"`
 }
 
 // MakeNullStmt constructs a local variable that refers to a null value.
 type MakeNullStmt struct {
-	Target Local
+	Target Local `json:"target"`
 }
 
 // MakeBooleanStmt constructs a local variable that refers to a boolean value.
 type MakeBooleanStmt struct {
-	Value  bool
-	Target Local
+	Value  bool  `json:"value"`
+	Target Local `json:"target"`
 }
 
 // MakeNumberFloatStmt constructs a local variable that refers to a
 // floating-point number value.
 type MakeNumberFloatStmt struct {
-	Value  float64
-	Target Local
+	Value  float64 `json:"value"`
+	Target Local   `json:"target"`
 }
 
 // MakeNumberIntStmt constructs a local variable that refers to an integer value.
 type MakeNumberIntStmt struct {
-	Value  int64
-	Target Local
+	Value  int64 `json:"value"`
+	Target Local `json:"target"`
 }
 
 // MakeNumberRefStmt constructs a local variable that refers to a number stored as a string.
 type MakeNumberRefStmt struct {
-	Index  int
-	Target Local
+	Index  int   `json:"index"`
+	Target Local `json:"target"`
 }
 
 // MakeArrayStmt constructs a local variable that refers to an array value.
 type MakeArrayStmt struct {
-	Capacity int32
-	Target   Local
+	Capacity int32 `json:"capacity"`
+	Target   Local `json:"target"`
 }
 
 // MakeObjectStmt constructs a local variable that refers to an object value.
 type MakeObjectStmt struct {
-	Target Local
+	Target Local `json:"target"`
 }
 
 // MakeSetStmt constructs a local variable that refers to a set value.
 type MakeSetStmt struct {
-	Target Local
+	Target Local `json:"target"`
 }
 
 // EqualStmt represents an value-equality check of two local variables.
 type EqualStmt struct {
-	A Local
-	B Local
+	A Local `json:"a"`
+	B Local `json:"b"`
 }
 
 // LessThanStmt represents a < check of two local variables.
 type LessThanStmt struct {
-	A Local
-	B Local
+	A Local `json:"a"`
+	B Local `json:"b"`
 }
 
 // LessThanEqualStmt represents a <= check of two local variables.
 type LessThanEqualStmt struct {
-	A Local
-	B Local
+	A Local `json:"a"`
+	B Local `json:"b"`
 }
 
 // GreaterThanStmt represents a > check of two local variables.
 type GreaterThanStmt struct {
-	A Local
-	B Local
+	A Local `json:"a"`
+	B Local `json:"b"`
 }
 
 // GreaterThanEqualStmt represents a >= check of two local variables.
 type GreaterThanEqualStmt struct {
-	A Local
-	B Local
+	A Local `json:"a"`
+	B Local `json:"b"`
 }
 
 // NotEqualStmt represents a != check of two local variables.
 type NotEqualStmt struct {
-	A Local
-	B Local
+	A Local `json:"a"`
+	B Local `json:"b"`
 }
 
 // IsArrayStmt represents a dynamic type check on a local variable.
 type IsArrayStmt struct {
-	Source Local
+	Source Local `json:"source"`
 }
 
 // IsObjectStmt represents a dynamic type check on a local variable.
 type IsObjectStmt struct {
-	Source Local
+	Source Local `json:"source"`
 }
 
 // IsDefinedStmt represents a check of whether a local variable is defined.
 type IsDefinedStmt struct {
-	Source Local
+	Source Local `json:"source"`
 }
 
 // IsUndefinedStmt represents a check of whether local variable is undefined.
 type IsUndefinedStmt struct {
-	Source Local
+	Source Local `json:"source"`
 }
 
 // ArrayAppendStmt represents a dynamic append operation of a value
 // onto an array.
 type ArrayAppendStmt struct {
-	Value Local
-	Array Local
+	Value Local `json:"value"`
+	Array Local `json:"array"`
 }
 
 // ObjectInsertStmt represents a dynamic insert operation of a
 // key/value pair into an object.
 type ObjectInsertStmt struct {
-	Key    Local
-	Value  Local
-	Object Local
+	Key    Local `json:"key"`
+	Value  Local `json:"value"`
+	Object Local `json:"object"`
 }
 
 // ObjectInsertOnceStmt represents a dynamic insert operation of a key/value
 // pair into an object. If the key already exists and the value differs,
 // execution aborts with a conflict error.
 type ObjectInsertOnceStmt struct {
-	Key    Local
-	Value  Local
-	Object Local
+	Key    Local `json:"key"`
+	Value  Local `json:"value"`
+	Object Local `json:"object"`
 }
 
 // ObjectMergeStmt performs a recursive merge of two object values. If either of
 // the locals refer to non-object values this operation will abort with a
 // conflict error. Overlapping object keys are merged recursively.
 type ObjectMergeStmt struct {
-	A      Local
-	B      Local
-	Target Local
+	A      Local `json:"a"`
+	B      Local `json:"b"`
+	Target Local `json:"target"`
 }
 
 // SetAddStmt represents a dynamic add operation of an element into a set.
 type SetAddStmt struct {
-	Value Local
-	Set   Local
+	Value Local `json:"value"`
+	Set   Local `json:"set"`
 }
 
 // WithStmt replaces the Local or a portion of the document referred to by the
@@ -383,13 +387,13 @@ type SetAddStmt struct {
 // the Local referred to by the Path do not exist, they will be created. When
 // the WithStmt finishes the Local is reset to it's original value.
 type WithStmt struct {
-	Local Local
-	Path  []int
-	Value Local
-	Block *Block
+	Local Local  `json:"local"`
+	Path  []int  `json:"path"`
+	Value Local  `json:"value"`
+	Block *Block `json:"block"`
 }
 
 // ResultSetAdd adds a value into the result set returned by the query plan.
 type ResultSetAdd struct {
-	Value Local
+	Value Local `json:"value"`
 }
@@ -0,0 +1,159 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Version is the version of the JSON encoding of policies. The version is
+// incremented when the encoding or the semantics of statements change in a way
+// that is not backwards compatible.
+const Version = 1
+
+// document is the top-level JSON object of an encoded policy.
+type document struct {
+	Version int `json:"version"`
+	*Policy
+}
+
+// Marshal returns the JSON encoding of the policy. Statements are encoded as
+// objects that contain the name of the statement type and the statement, e.g.,
+// {"type": "DotStmt", "stmt": {"source": 1, "key": 2, "target": 3}}.
+func Marshal(policy *Policy) ([]byte, error) {
+	return json.Marshal(document{Version: Version, Policy: policy})
+}
+
+// Unmarshal decodes a policy encoded by Marshal. An error is returned if the
+// policy was encoded with a different version.
+func Unmarshal(bs []byte) (*Policy, error) {
+
+	doc := document{Policy: &Policy{}}
+
+	if err := json.Unmarshal(bs, &doc); err != nil {
+		return nil, err
+	}
+
+	if doc.Version != Version {
+		return nil, fmt.Errorf("unsupported plan version: %d", doc.Version)
+	}
+
+	if doc.Static == nil || doc.Plan == nil || doc.Funcs == nil {
+		return nil, fmt.Errorf("illegal plan: static, plan and funcs must be set")
+	}
+
+	return doc.Policy, nil
+}
+
+// stmtTypes maps the names of statement types to the types.
+var stmtTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, stmt := range []Stmt{
+		&ReturnLocalStmt{},
+		&CallStmt{},
+		&BlockStmt{},
+		&BreakStmt{},
+		&DotStmt{},
+		&LenStmt{},
+		&ScanStmt{},
+		&NotStmt{},
+		&AssignBooleanStmt{},
+		&AssignIntStmt{},
+		&AssignVarStmt{},
+		&AssignVarOnceStmt{},
+		&MakeStringStmt{},
+		&MakeNullStmt{},
+		&MakeBooleanStmt{},
+		&MakeNumberFloatStmt{},
+		&MakeNumberIntStmt{},
+		&MakeNumberRefStmt{},
+		&MakeArrayStmt{},
+		&MakeObjectStmt{},
+		&MakeSetStmt{},
+		&EqualStmt{},
+		&LessThanStmt{},
+		&LessThanEqualStmt{},
+		&GreaterThanStmt{},
+		&GreaterThanEqualStmt{},
+		&NotEqualStmt{},
+		&IsArrayStmt{},
+		&IsObjectStmt{},
+		&IsDefinedStmt{},
+		&IsUndefinedStmt{},
+		&ArrayAppendStmt{},
+		&ObjectInsertStmt{},
+		&ObjectInsertOnceStmt{},
+		&ObjectMergeStmt{},
+		&SetAddStmt{},
+		&WithStmt{},
+		&ResultSetAdd{},
+	} {
+		t := reflect.TypeOf(stmt).Elem()
+		stmtTypes[t.Name()] = t
+	}
+}
+
+type rawStmt struct {
+	Type string          `json:"type"`
+	Stmt json.RawMessage `json:"stmt"`
+}
+
+type typedStmt struct {
+	Type string `json:"type"`
+	Stmt Stmt   `json:"stmt"`
+}
+
+type rawBlock struct {
+	Stmts []rawStmt `json:"stmts"`
+}
+
+type typedBlock struct {
+	Stmts []typedStmt `json:"stmts"`
+}
+
+// MarshalJSON encodes the block with the types of the statements.
+func (a *Block) MarshalJSON() ([]byte, error) {
+
+	block := typedBlock{Stmts: make([]typedStmt, len(a.Stmts))}
+
+	for i, stmt := range a.Stmts {
+		t := reflect.TypeOf(stmt)
+		if t == nil || t.Kind() != reflect.Ptr || stmtTypes[t.Elem().Name()] != t.Elem() {
+			return nil, fmt.Errorf("illegal statement: %T", stmt)
+		}
+		block.Stmts[i] = typedStmt{Type: t.Elem().Name(), Stmt: stmt}
+	}
+
+	return json.Marshal(block)
+}
+
+// UnmarshalJSON decodes a block encoded by MarshalJSON.
+func (a *Block) UnmarshalJSON(bs []byte) error {
+
+	var block rawBlock
+
+	if err := json.Unmarshal(bs, &block); err != nil {
+		return err
+	}
+
+	a.Stmts = make([]Stmt, len(block.Stmts))
+
+	for i, raw := range block.Stmts {
+		t, ok := stmtTypes[raw.Type]
+		if !ok {
+			return fmt.Errorf("illegal statement type: %q", raw.Type)
+		}
+		stmt := reflect.New(t).Interface()
+		if err := json.Unmarshal(raw.Stmt, stmt); err != nil {
+			return fmt.Errorf("%v: %v", raw.Type, err)
+		}
+		a.Stmts[i] = stmt
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/types"
+)
+
+func TestMarshal(t *testing.T) {
+
+	policy := &Policy{
+		Static: &Static{
+			Strings: []*StringConst{{Value: "a"}},
+			BuiltinFuncs: []*BuiltinFunc{
+				{Name: "plus", Decl: types.NewFunction(types.Args(types.N, types.N), types.N)},
+			},
+		},
+		Plan: &Plan{Blocks: []*Block{
+			{Stmts: []Stmt{
+				&CallStmt{Func: "g0.data.p", Args: []Local{Input, Data}, Result: 2},
+				&MakeObjectStmt{Target: 3},
+				&MakeStringStmt{Index: 0, Target: 4},
+				&ObjectInsertStmt{Key: 4, Value: 2, Object: 3},
+				&ResultSetAdd{Value: 3},
+			}},
+		}},
+		Funcs: &Funcs{Funcs: []*Func{
+			{Name: "g0.data.p", Params: []Local{Input, Data}, Return: 2, Blocks: []*Block{
+				{Stmts: []Stmt{
+					&ScanStmt{Source: Input, Key: 3, Value: 4, Block: &Block{Stmts: []Stmt{
+						&NotStmt{Block: &Block{Stmts: []Stmt{
+							&IsUndefinedStmt{Source: 4},
+						}}},
+						&WithStmt{Local: Data, Path: []int{0}, Value: 4, Block: &Block{Stmts: []Stmt{
+							&CallStmt{Func: "plus", Args: []Local{4, 4}, Result: 5},
+							&AssignVarOnceStmt{Source: 5, Target: 2},
+						}}},
+					}}},
+				}},
+				{Stmts: []Stmt{
+					&ReturnLocalStmt{Source: 2},
+				}},
+			}},
+		}},
+	}
+
+	bs, err := Marshal(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range []string{
+		`"version":1`,
+		`{"type":"ScanStmt","stmt":{"source":0,"key":3,"value":4,"block":{"stmts":[{"type":"NotStmt"`,
+		`"builtin_funcs":[{"name":"plus","decl":{"args":[{"type":"number"},{"type":"number"}]`,
+	} {
+		if !bytes.Contains(bs, []byte(exp)) {
+			t.Fatalf("Expected encoding to contain %v but got %s", exp, bs)
+		}
+	}
+
+	result, err := Unmarshal(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(result, policy) {
+		var exp, got bytes.Buffer
+		Pretty(&exp, policy)
+		Pretty(&got, result)
+		t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp.String(), got.String())
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+
+	tests := []struct {
+		note string
+		doc  string
+		exp  string
+	}{
+		{
+			note: "version",
+			doc:  `{"version": 2, "static": {}, "plan": {}, "funcs": {}}`,
+			exp:  "unsupported plan version: 2",
+		},
+		{
+			note: "missing plan",
+			doc:  `{"version": 1, "static": {}, "funcs": {}}`,
+			exp:  "illegal plan: static, plan and funcs must be set",
+		},
+		{
+			note: "statement type",
+			doc:  `{"version": 1, "static": {}, "plan": {"blocks": [{"stmts": [{"type": "GotoStmt", "stmt": {}}]}]}, "funcs": {}}`,
+			exp:  `illegal statement type: "GotoStmt"`,
+		},
+		{
+			note: "statement",
+			doc:  `{"version": 1, "static": {}, "plan": {"blocks": [{"stmts": [{"type": "BreakStmt", "stmt": {"index": -1}}]}]}, "funcs": {}}`,
+			exp:  "BreakStmt: json: cannot unmarshal number -1",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			_, err := Unmarshal([]byte(tc.doc))
+			if err == nil || !strings.Contains(err.Error(), tc.exp) {
+				t.Fatalf("Expected error containing %q but got %v", tc.exp, err)
+			}
+		})
+	}
+}
+
+func TestMarshalIllegalStatement(t *testing.T) {
+
+	_, err := json.Marshal(&Block{Stmts: []Stmt{"deadbeef"}})
+	if err == nil || !strings.Contains(err.Error(), "illegal statement: string") {
+		t.Fatalf("Expected illegal statement error but got %v", err)
+	}
+}
@@ -0,0 +1,1040 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// Pass represents an optimization pass. Passes rewrite the policy in place and
+// do not change the results of the plan.
+type Pass struct {
+	Name string
+	Run  func(*Policy)
+}
+
+// Passes returns the optimization passes enabled at the optimization level. No
+// passes are enabled at level zero. Level one propagates copies, folds
+// constants and removes dead locals, blocks and functions. Level two also
+// hoists loop-invariant lookups out of scans.
+func Passes(level int) []Pass {
+
+	if level <= 0 {
+		return nil
+	}
+
+	passes := []Pass{
+		{Name: "copy propagation", Run: propagateCopies},
+		{Name: "constant folding", Run: foldConstants},
+	}
+
+	if level >= 2 {
+		passes = append(passes, Pass{Name: "loop-invariant lookup hoisting", Run: hoistLookups})
+	}
+
+	return append(passes,
+		Pass{Name: "dead local elimination", Run: eliminateDeadLocals},
+		Pass{Name: "dead block elimination", Run: eliminateDeadBlocks},
+		Pass{Name: "dead function elimination", Run: eliminateDeadFuncs},
+	)
+}
+
+// Optimize runs the passes on the policy in order.
+func Optimize(policy *Policy, passes []Pass) {
+	for _, pass := range passes {
+		pass.Run(policy)
+	}
+}
+
+// Size represents the size of a policy.
+type Size struct {
+	Funcs  int
+	Blocks int
+	Stmts  int
+	Locals int
+}
+
+func (s Size) String() string {
+	return fmt.Sprintf("%d funcs, %d blocks, %d statements, %d locals", s.Funcs, s.Blocks, s.Stmts, s.Locals)
+}
+
+// SizeOf returns the size of the policy. Locals are counted per function.
+func SizeOf(policy *Policy) Size {
+
+	var size Size
+
+	count := func(blocks []*Block) {
+		locals := map[Local]struct{}{}
+		walkStmts(blocks, func(stmt Stmt) {
+			size.Stmts++
+			switch stmt := stmt.(type) {
+			case *BlockStmt:
+				size.Blocks += len(stmt.Blocks)
+			case *ScanStmt, *NotStmt, *WithStmt:
+				size.Blocks++
+			}
+			visitLocals(stmt, func(l *Local, _ bool) {
+				locals[*l] = struct{}{}
+			})
+		})
+		size.Blocks += len(blocks)
+		size.Locals += len(locals)
+	}
+
+	if policy.Plan != nil {
+		count(policy.Plan.Blocks)
+	}
+
+	if policy.Funcs != nil {
+		for _, fn := range policy.Funcs.Funcs {
+			size.Funcs++
+			count(fn.Blocks)
+		}
+	}
+
+	return size
+}
+
+// walkStmts calls fn for each statement in the blocks and their nested blocks.
+func walkStmts(blocks []*Block, fn func(Stmt)) {
+	for _, block := range blocks {
+		for _, stmt := range block.Stmts {
+			fn(stmt)
+			switch stmt := stmt.(type) {
+			case *BlockStmt:
+				walkStmts(stmt.Blocks, fn)
+			case *ScanStmt:
+				walkStmts([]*Block{stmt.Block}, fn)
+			case *NotStmt:
+				walkStmts([]*Block{stmt.Block}, fn)
+			case *WithStmt:
+				walkStmts([]*Block{stmt.Block}, fn)
+			}
+		}
+	}
+}
+
+// walkFuncs calls fn with the parameters and blocks of the plan and each
+// function. The parameters of the plan are the input and data documents.
+func walkFuncs(policy *Policy, fn func(params []Local, blocks []*Block)) {
+
+	if policy.Plan != nil {
+		fn([]Local{Input, Data}, policy.Plan.Blocks)
+	}
+
+	if policy.Funcs != nil {
+		for _, f := range policy.Funcs.Funcs {
+			fn(append([]Local{f.Return}, f.Params...), f.Blocks)
+		}
+	}
+}
+
+// visitLocals calls fn with a pointer to each local that the statement refers
+// to. Locals written by the statement are reported with def set. Locals that
+// are read and written, e.g., objects that are inserted into, are reported
+// twice. Nested blocks are not visited.
+func visitLocals(stmt Stmt, fn func(l *Local, def bool)) {
+	switch stmt := stmt.(type) {
+	case *ReturnLocalStmt:
+		fn(&stmt.Source, false)
+	case *CallStmt:
+		for i := range stmt.Args {
+			fn(&stmt.Args[i], false)
+		}
+		fn(&stmt.Result, true)
+	case *DotStmt:
+		fn(&stmt.Source, false)
+		fn(&stmt.Key, false)
+		fn(&stmt.Target, true)
+	case *LenStmt:
+		fn(&stmt.Source, false)
+		fn(&stmt.Target, true)
+	case *ScanStmt:
+		fn(&stmt.Source, false)
+		fn(&stmt.Key, true)
+		fn(&stmt.Value, true)
+	case *AssignBooleanStmt:
+		fn(&stmt.Target, false)
+		fn(&stmt.Target, true)
+	case *AssignIntStmt:
+		fn(&stmt.Target, false)
+		fn(&stmt.Target, true)
+	case *AssignVarStmt:
+		fn(&stmt.Source, false)
+		fn(&stmt.Target, true)
+	case *AssignVarOnceStmt:
+		fn(&stmt.Source, false)
+		fn(&stmt.Target, false)
+		fn(&stmt.Target, true)
+	case *MakeStringStmt:
+		fn(&stmt.Target, true)
+	case *MakeNullStmt:
+		fn(&stmt.Target, true)
+	case *MakeBooleanStmt:
+		fn(&stmt.Target, true)
+	case *MakeNumberFloatStmt:
+		fn(&stmt.Target, true)
+	case *MakeNumberIntStmt:
+		fn(&stmt.Target, true)
+	case *MakeNumberRefStmt:
+		fn(&stmt.Target, true)
+	case *MakeArrayStmt:
+		fn(&stmt.Target, true)
+	case *MakeObjectStmt:
+		fn(&stmt.Target, true)
+	case *MakeSetStmt:
+		fn(&stmt.Target, true)
+	case *EqualStmt:
+		fn(&stmt.A, false)
+		fn(&stmt.B, false)
+	case *NotEqualStmt:
+		fn(&stmt.A, false)
+		fn(&stmt.B, false)
+	case *LessThanStmt:
+		fn(&stmt.A, false)
+		fn(&stmt.B, false)
+	case *LessThanEqualStmt:
+		fn(&stmt.A, false)
+		fn(&stmt.B, false)
+	case *GreaterThanStmt:
+		fn(&stmt.A, false)
+		fn(&stmt.B, false)
+	case *GreaterThanEqualStmt:
+		fn(&stmt.A, false)
+		fn(&stmt.B, false)
+	case *IsArrayStmt:
+		fn(&stmt.Source, false)
+	case *IsObjectStmt:
+		fn(&stmt.Source, false)
+	case *IsDefinedStmt:
+		fn(&stmt.Source, false)
+	case *IsUndefinedStmt:
+		fn(&stmt.Source, false)
+	case *ArrayAppendStmt:
+		fn(&stmt.Value, false)
+		fn(&stmt.Array, false)
+		fn(&stmt.Array, true)
+	case *ObjectInsertStmt:
+		fn(&stmt.Key, false)
+		fn(&stmt.Value, false)
+		fn(&stmt.Object, false)
+		fn(&stmt.Object, true)
+	case *ObjectInsertOnceStmt:
+		fn(&stmt.Key, false)
+		fn(&stmt.Value, false)
+		fn(&stmt.Object, false)
+		fn(&stmt.Object, true)
+	case *ObjectMergeStmt:
+		fn(&stmt.A, false)
+		fn(&stmt.B, false)
+		fn(&stmt.Target, true)
+	case *SetAddStmt:
+		fn(&stmt.Value, false)
+		fn(&stmt.Set, false)
+		fn(&stmt.Set, true)
+	case *WithStmt:
+		fn(&stmt.Value, false)
+		fn(&stmt.Local, false)
+		fn(&stmt.Local, true)
+	case *ResultSetAdd:
+		fn(&stmt.Value, false)
+	}
+}
+
+// scope represents the locals that are defined when a statement executes.
+// Statements in a block are executed in order, so a definition in a block
+// dominates the following statements of the block and their nested blocks.
+type scope struct {
+	parent *scope
+	locals map[Local]struct{}
+}
+
+func newScope(parent *scope, locals ...Local) *scope {
+	s := &scope{parent: parent, locals: map[Local]struct{}{}}
+	for _, l := range locals {
+		s.locals[l] = struct{}{}
+	}
+	return s
+}
+
+func (s *scope) contains(l Local) bool {
+	for ; s != nil; s = s.parent {
+		if _, ok := s.locals[l]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// walkScoped calls fn for each statement in the block and its nested blocks
+// with the scope in which the statement executes. The key and value of scans
+// are only in scope in the scan block.
+func walkScoped(block *Block, parent *scope, fn func(stmt Stmt, s *scope)) {
+	s := newScope(parent)
+	for _, stmt := range block.Stmts {
+		fn(stmt, s)
+		switch stmt := stmt.(type) {
+		case *BlockStmt:
+			for _, b := range stmt.Blocks {
+				walkScoped(b, s, fn)
+			}
+		case *ScanStmt:
+			walkScoped(stmt.Block, newScope(s, stmt.Key, stmt.Value), fn)
+			continue
+		case *NotStmt:
+			walkScoped(stmt.Block, s, fn)
+		case *WithStmt:
+			walkScoped(stmt.Block, s, fn)
+		}
+		visitLocals(stmt, func(l *Local, def bool) {
+			if def {
+				s.locals[*l] = struct{}{}
+			}
+		})
+	}
+}
+
+// funcInfo contains the definitions and uses of the locals of a plan or
+// function. The blocks of a plan or function are executed in order until one
+// returns, so locals can only be replaced by other locals within a block if
+// their values do not flow into the following blocks.
+type funcInfo struct {
+	params  map[Local]struct{}
+	defs    map[Local]int      // number of definitions
+	uses    map[Local]int      // number of uses
+	local   []map[Local]int    // number of definitions per block
+	used    []map[Local]int    // number of uses per block
+	stmts   []map[Local]Stmt   // definitions per block
+	mutated map[Local]struct{} // locals that are read and written by the same statement
+	escaped map[Local]struct{} // locals used without a dominating definition in the same block
+	next    Local              // first local that is not used
+}
+
+func analyze(params []Local, blocks []*Block) *funcInfo {
+
+	info := &funcInfo{
+		params:  map[Local]struct{}{},
+		defs:    map[Local]int{},
+		uses:    map[Local]int{},
+		local:   make([]map[Local]int, len(blocks)),
+		used:    make([]map[Local]int, len(blocks)),
+		stmts:   make([]map[Local]Stmt, len(blocks)),
+		mutated: map[Local]struct{}{},
+		escaped: map[Local]struct{}{},
+	}
+
+	for _, p := range params {
+		info.params[p] = struct{}{}
+		if p >= info.next {
+			info.next = p + 1
+		}
+	}
+
+	for i, block := range blocks {
+		info.local[i] = map[Local]int{}
+		info.stmts[i] = map[Local]Stmt{}
+		info.used[i] = map[Local]int{}
+		walkScoped(block, newScope(nil, params...), func(stmt Stmt, s *scope) {
+			read := map[Local]struct{}{}
+			visitLocals(stmt, func(l *Local, def bool) {
+				if *l >= info.next {
+					info.next = *l + 1
+				}
+				if !def {
+					info.uses[*l]++
+					info.used[i][*l]++
+					read[*l] = struct{}{}
+					if !s.contains(*l) {
+						info.escaped[*l] = struct{}{}
+					}
+					return
+				}
+				if _, ok := read[*l]; ok {
+					info.mutated[*l] = struct{}{}
+				}
+				info.defs[*l]++
+				info.local[i][*l]++
+				info.stmts[i][*l] = stmt
+			})
+		})
+	}
+
+	return info
+}
+
+// replaceable returns true if the local is defined once in the block, all of
+// its uses are dominated by the definition and its value is never modified.
+// Uses of such locals can be replaced by other locals with the same value.
+func (info *funcInfo) replaceable(block int, l Local) bool {
+	if _, ok := info.params[l]; ok {
+		return false
+	}
+	if _, ok := info.mutated[l]; ok {
+		return false
+	}
+	if _, ok := info.escaped[l]; ok {
+		return false
+	}
+	return info.local[block][l] == 1
+}
+
+// stable returns true if the value of the local does not change in the rest of
+// the block once the scope is entered.
+func (info *funcInfo) stable(block int, l Local, s *scope) bool {
+	if _, ok := info.params[l]; ok {
+		return info.defs[l] == 0
+	}
+	return info.replaceable(block, l) && s.contains(l)
+}
+
+// rename replaces the locals in the block according to the mapping.
+func rename(block *Block, mapping map[Local]Local) {
+	if len(mapping) == 0 {
+		return
+	}
+	walkStmts([]*Block{block}, func(stmt Stmt) {
+		visitLocals(stmt, func(l *Local, _ bool) {
+			for {
+				r, ok := mapping[*l]
+				if !ok {
+					break
+				}
+				*l = r
+			}
+		})
+	})
+}
+
+// filter removes the statements in the block and its nested blocks for which
+// fn returns nil and replaces statements by the result of fn otherwise.
+func filter(block *Block, fn func(Stmt) Stmt) {
+	stmts := block.Stmts[:0]
+	for _, stmt := range block.Stmts {
+		switch x := stmt.(type) {
+		case *BlockStmt:
+			for _, b := range x.Blocks {
+				filter(b, fn)
+			}
+		case *ScanStmt:
+			filter(x.Block, fn)
+		case *NotStmt:
+			filter(x.Block, fn)
+		case *WithStmt:
+			filter(x.Block, fn)
+		}
+		if stmt = fn(stmt); stmt != nil {
+			stmts = append(stmts, stmt)
+		}
+	}
+	block.Stmts = stmts
+}
+
+// propagateCopies replaces locals that are assigned from other locals by the
+// source of the assignment and removes the assignment.
+func propagateCopies(policy *Policy) {
+	walkFuncs(policy, func(params []Local, blocks []*Block) {
+
+		info := analyze(params, blocks)
+
+		for i, block := range blocks {
+
+			mapping := map[Local]Local{}
+			removed := map[Stmt]struct{}{}
+
+			walkScoped(block, newScope(nil, params...), func(stmt Stmt, s *scope) {
+				if assign, ok := stmt.(*AssignVarStmt); ok {
+					if assign.Source != assign.Target && info.replaceable(i, assign.Target) && info.stable(i, assign.Source, s) {
+						mapping[assign.Target] = assign.Source
+						removed[stmt] = struct{}{}
+					}
+				}
+			})
+
+			filter(block, func(stmt Stmt) Stmt {
+				if _, ok := removed[stmt]; ok {
+					return nil
+				}
+				return stmt
+			})
+
+			rename(block, mapping)
+		}
+	})
+}
+
+// foldConstants replaces locals that are constructed from the same literal by
+// the first local constructed from it and evaluates comparisons and type
+// checks of literals. Statements that always succeed are removed. Statements
+// that always fail are replaced by breaks out of the current block.
+func foldConstants(policy *Policy) {
+	walkFuncs(policy, func(params []Local, blocks []*Block) {
+		info := analyze(params, blocks)
+		for i, block := range blocks {
+			f := &folder{
+				policy:  policy,
+				info:    info,
+				block:   i,
+				mapping: map[Local]Local{},
+			}
+			f.fold(block, nil)
+		}
+	})
+}
+
+type folder struct {
+	policy  *Policy
+	info    *funcInfo
+	block   int
+	mapping map[Local]Local
+}
+
+// constants holds the literals that are in scope.
+type constants struct {
+	parent *constants
+	values map[Local]ast.Value
+	locals map[string]Local
+}
+
+func (c *constants) value(l Local) (ast.Value, bool) {
+	for ; c != nil; c = c.parent {
+		if v, ok := c.values[l]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (c *constants) local(key string) (Local, bool) {
+	for ; c != nil; c = c.parent {
+		if l, ok := c.locals[key]; ok {
+			return l, true
+		}
+	}
+	return 0, false
+}
+
+func (f *folder) fold(block *Block, parent *constants) {
+
+	c := &constants{parent: parent, values: map[Local]ast.Value{}, locals: map[string]Local{}}
+	stmts := block.Stmts[:0]
+
+	for _, stmt := range block.Stmts {
+
+		visitLocals(stmt, func(l *Local, def bool) {
+			if r, ok := f.mapping[*l]; ok {
+				*l = r
+			}
+		})
+
+		switch x := stmt.(type) {
+		case *BlockStmt:
+			for _, b := range x.Blocks {
+				f.fold(b, c)
+			}
+		case *ScanStmt:
+			f.fold(x.Block, c)
+		case *NotStmt:
+			f.fold(x.Block, c)
+		case *WithStmt:
+			f.fold(x.Block, c)
+		case *EqualStmt, *NotEqualStmt, *LessThanStmt, *LessThanEqualStmt, *GreaterThanStmt, *GreaterThanEqualStmt:
+			if ok, folded := f.compare(c, x); folded {
+				if ok {
+					continue
+				}
+				stmt = &BreakStmt{Index: 0}
+			}
+		case *IsDefinedStmt:
+			if _, ok := c.value(x.Source); ok {
+				continue
+			}
+		case *IsUndefinedStmt:
+			if _, ok := c.value(x.Source); ok {
+				stmt = &BreakStmt{Index: 0}
+			}
+		case *IsArrayStmt:
+			if _, ok := c.value(x.Source); ok {
+				stmt = &BreakStmt{Index: 0}
+			}
+		case *IsObjectStmt:
+			if _, ok := c.value(x.Source); ok {
+				stmt = &BreakStmt{Index: 0}
+			}
+		default:
+			if target, value, key, ok := f.literal(stmt); ok && f.info.replaceable(f.block, target) {
+				if other, ok := c.local(key); ok {
+					f.mapping[target] = other
+					continue
+				}
+				c.values[target] = value
+				c.locals[key] = target
+			}
+		}
+
+		stmts = append(stmts, stmt)
+	}
+
+	block.Stmts = stmts
+}
+
+// isLiteral returns true if the statement constructs a literal.
+func isLiteral(stmt Stmt) bool {
+	switch stmt.(type) {
+	case *MakeNullStmt, *MakeBooleanStmt, *MakeNumberIntStmt, *MakeNumberFloatStmt, *MakeNumberRefStmt, *MakeStringStmt:
+		return true
+	}
+	return false
+}
+
+// literal returns the target, value and a key that identifies the
+// construction of the value if the statement constructs a literal.
+func (f *folder) literal(stmt Stmt) (Local, ast.Value, string, bool) {
+
+	var target Local
+	var value ast.Value
+
+	switch stmt := stmt.(type) {
+	case *MakeNullStmt:
+		target, value = stmt.Target, ast.Null{}
+	case *MakeBooleanStmt:
+		target, value = stmt.Target, ast.Boolean(stmt.Value)
+	case *MakeNumberIntStmt:
+		target, value = stmt.Target, ast.IntNumberTerm(int(stmt.Value)).Value
+	case *MakeNumberFloatStmt:
+		target, value = stmt.Target, ast.FloatNumberTerm(stmt.Value).Value
+	case *MakeNumberRefStmt:
+		target, value = stmt.Target, ast.Number(f.policy.Static.Strings[stmt.Index].Value)
+	case *MakeStringStmt:
+		target, value = stmt.Target, ast.String(f.policy.Static.Strings[stmt.Index].Value)
+	default:
+		return 0, nil, "", false
+	}
+
+	return target, value, fmt.Sprintf("%T %v", stmt, value), true
+}
+
+// compare returns the result of the comparison if both operands are literals.
+func (f *folder) compare(c *constants, stmt Stmt) (bool, bool) {
+
+	var a, b Local
+
+	switch stmt := stmt.(type) {
+	case *EqualStmt:
+		a, b = stmt.A, stmt.B
+	case *NotEqualStmt:
+		a, b = stmt.A, stmt.B
+	case *LessThanStmt:
+		a, b = stmt.A, stmt.B
+	case *LessThanEqualStmt:
+		a, b = stmt.A, stmt.B
+	case *GreaterThanStmt:
+		a, b = stmt.A, stmt.B
+	case *GreaterThanEqualStmt:
+		a, b = stmt.A, stmt.B
+	}
+
+	x, ok1 := c.value(a)
+	y, ok2 := c.value(b)
+
+	if !ok1 || !ok2 {
+		return false, false
+	}
+
+	cmp := ast.Compare(x, y)
+
+	switch stmt.(type) {
+	case *EqualStmt:
+		return cmp == 0, true
+	case *NotEqualStmt:
+		return cmp != 0, true
+	case *LessThanStmt:
+		return cmp < 0, true
+	case *LessThanEqualStmt:
+		return cmp <= 0, true
+	case *GreaterThanStmt:
+		return cmp > 0, true
+	default:
+		return cmp >= 0, true
+	}
+}
+
+// hoistLookups moves lookups inside scans whose source and key do not change
+// in the block into a nested block before the outermost scan. The nested block
+// leaves the target undefined if the lookup fails, so the lookup in the scan
+// is replaced by a check that the target is defined. Targets are replaced by
+// new locals because previous blocks may have defined them.
+func hoistLookups(policy *Policy) {
+	walkFuncs(policy, func(params []Local, blocks []*Block) {
+		info := analyze(params, blocks)
+		next := info.next
+		for i, block := range blocks {
+			h := &hoister{info: info, block: i, next: next, mapping: map[Local]Local{}}
+			h.hoistBlock(block, newScope(nil, params...))
+			rename(block, h.mapping)
+			next = h.next
+		}
+	})
+}
+
+type hoister struct {
+	info    *funcInfo
+	block   int
+	next    Local
+	mapping map[Local]Local
+}
+
+func (h *hoister) hoistBlock(block *Block, parent *scope) {
+
+	s := newScope(parent)
+	stmts := make([]Stmt, 0, len(block.Stmts))
+
+	for _, stmt := range block.Stmts {
+		switch x := stmt.(type) {
+		case *BlockStmt:
+			for _, b := range x.Blocks {
+				h.hoistBlock(b, s)
+			}
+		case *NotStmt:
+			h.hoistBlock(x.Block, s)
+		case *WithStmt:
+			h.hoistBlock(x.Block, s)
+		case *ScanStmt:
+			if hoisted := h.hoistScan(x, s); len(hoisted) > 0 {
+				stmts = append(stmts, &BlockStmt{Blocks: []*Block{{Stmts: hoisted}}})
+			}
+		}
+		stmts = append(stmts, stmt)
+		if _, ok := stmt.(*ScanStmt); !ok {
+			visitLocals(stmt, func(l *Local, def bool) {
+				if def {
+					s.locals[*l] = struct{}{}
+				}
+			})
+		}
+	}
+
+	block.Stmts = stmts
+}
+
+// hoistScan returns the statements hoisted out of the scan and replaces the
+// hoisted lookups in the scan block.
+func (h *hoister) hoistScan(scan *ScanStmt, s *scope) []Stmt {
+
+	info := h.info
+	uses := map[Local]int{}
+	inner := map[Stmt]struct{}{}
+
+	walkStmts([]*Block{scan.Block}, func(stmt Stmt) {
+		inner[stmt] = struct{}{}
+		visitLocals(stmt, func(l *Local, def bool) {
+			if !def {
+				uses[*l]++
+			}
+		})
+	})
+
+	var hoisted []Stmt
+	outer := newScope(s)
+	moved := map[Stmt]struct{}{}
+	replaced := map[Stmt]Stmt{}
+
+	walkStmts([]*Block{scan.Block}, func(stmt Stmt) {
+
+		dot, ok := stmt.(*DotStmt)
+		if !ok || !info.stable(h.block, dot.Source, outer) {
+			return
+		}
+
+		var key Stmt
+
+		// Literal keys constructed in the scan are moved with the lookup.
+		if !info.stable(h.block, dot.Key, outer) {
+			if !info.replaceable(h.block, dot.Key) {
+				return
+			}
+			key = info.stmts[h.block][dot.Key]
+			if _, ok := inner[key]; !ok || !isLiteral(key) {
+				return
+			}
+		}
+
+		target := dot.Target
+		if !info.replaceable(h.block, target) || uses[target] != info.used[h.block][target] {
+			return
+		}
+
+		if key != nil {
+			hoisted = append(hoisted, key)
+			moved[key] = struct{}{}
+			outer.locals[dot.Key] = struct{}{}
+		}
+
+		hoisted = append(hoisted, dot)
+		replaced[stmt] = &IsDefinedStmt{Source: target}
+		outer.locals[target] = struct{}{}
+		h.mapping[target] = h.next
+		h.next++
+	})
+
+	if len(hoisted) == 0 {
+		return nil
+	}
+
+	filter(scan.Block, func(stmt Stmt) Stmt {
+		if _, ok := moved[stmt]; ok {
+			return nil
+		}
+		if r, ok := replaced[stmt]; ok {
+			return r
+		}
+		return stmt
+	})
+
+	return hoisted
+}
+
+// eliminateDeadLocals removes statements that cannot fail and have no effect
+// other than defining locals that are never used.
+func eliminateDeadLocals(policy *Policy) {
+	walkFuncs(policy, func(params []Local, blocks []*Block) {
+		for {
+			info := analyze(params, blocks)
+			removed := false
+			for _, block := range blocks {
+				filter(block, func(stmt Stmt) Stmt {
+					var target Local
+					switch stmt := stmt.(type) {
+					case *AssignVarStmt:
+						target = stmt.Target
+					case *LenStmt:
+						target = stmt.Target
+					case *MakeStringStmt, *MakeNullStmt, *MakeBooleanStmt, *MakeNumberFloatStmt, *MakeNumberIntStmt, *MakeNumberRefStmt,
+						*MakeArrayStmt, *MakeObjectStmt, *MakeSetStmt:
+						visitLocals(stmt, func(l *Local, _ bool) {
+							target = *l
+						})
+					default:
+						return stmt
+					}
+					if _, ok := info.params[target]; ok || info.uses[target] > 0 {
+						return stmt
+					}
+					removed = true
+					return nil
+				})
+			}
+			if !removed {
+				return
+			}
+		}
+	})
+}
+
+// eliminateDeadBlocks removes statements that follow unconditional breaks and
+// returns and replaces blocks that only contain breaks by their effect.
+func eliminateDeadBlocks(policy *Policy) {
+
+	if policy.Plan != nil {
+		blocks := policy.Plan.Blocks[:0]
+		for _, block := range policy.Plan.Blocks {
+			if simplify(block, true); len(block.Stmts) > 0 {
+				blocks = append(blocks, block)
+			}
+		}
+		policy.Plan.Blocks = blocks
+	}
+
+	if policy.Funcs != nil {
+		for _, fn := range policy.Funcs.Funcs {
+			blocks := fn.Blocks[:0]
+			for i, block := range fn.Blocks {
+				// The last block of a function is not nested, so a break out
+				// of it is not the same as reaching its end.
+				last := i == len(fn.Blocks)-1
+				if simplify(block, !last); last || len(block.Stmts) > 0 {
+					blocks = append(blocks, block)
+				}
+			}
+			fn.Blocks = blocks
+		}
+	}
+}
+
+// simplify removes dead statements from the block. If nested is true, a break
+// out of the block at its end is the same as reaching its end.
+func simplify(block *Block, nested bool) {
+
+	stmts := block.Stmts[:0]
+
+	for _, stmt := range block.Stmts {
+
+		switch x := stmt.(type) {
+		case *BlockStmt:
+			for _, b := range x.Blocks {
+				simplify(b, true)
+			}
+			if index, ok := control(&Block{Stmts: []Stmt{x}}); ok {
+				if index < 0 {
+					continue
+				}
+				stmt = &BreakStmt{Index: uint32(index)}
+			} else {
+				blocks := x.Blocks[:0]
+				for _, b := range x.Blocks {
+					if index, ok := control(b); !ok || index > 0 {
+						blocks = append(blocks, b)
+					}
+				}
+				x.Blocks = blocks
+			}
+		case *ScanStmt:
+			// A break out of the scan block continues with the next element.
+			simplify(x.Block, true)
+		case *NotStmt:
+			simplify(x.Block, false)
+			if index, ok := control(x.Block); ok {
+				switch {
+				case index < 0:
+					stmt = &BreakStmt{Index: 0}
+				case index == 0:
+					continue
+				default:
+					stmt = &BreakStmt{Index: uint32(index - 1)}
+				}
+			}
+		case *WithStmt:
+			simplify(x.Block, false)
+		}
+
+		stmts = append(stmts, stmt)
+
+		if _, ok := stmt.(*BreakStmt); ok {
+			break
+		} else if _, ok := stmt.(*ReturnLocalStmt); ok {
+			break
+		}
+	}
+
+	if n := len(stmts); nested && n > 0 {
+		if br, ok := stmts[n-1].(*BreakStmt); ok && br.Index == 0 {
+			stmts = stmts[:n-1]
+		}
+	}
+
+	block.Stmts = stmts
+}
+
+// control returns the index of the block that is broken out of when the block
+// is executed, relative to the block, or -1 if the end of the block is reached.
+// If the block contains statements other than blocks and breaks, the result is
+// false.
+func control(block *Block) (int, bool) {
+	for _, stmt := range block.Stmts {
+		switch stmt := stmt.(type) {
+		case *BreakStmt:
+			return int(stmt.Index), true
+		case *BlockStmt:
+			for _, b := range stmt.Blocks {
+				index, ok := control(b)
+				if !ok {
+					return 0, false
+				} else if index > 0 {
+					return index - 1, true
+				}
+			}
+		default:
+			return 0, false
+		}
+	}
+	return -1, true
+}
+
+// eliminateDeadFuncs removes functions and built-in functions that are not
+// called by the plan and strings that are not referred to.
+func eliminateDeadFuncs(policy *Policy) {
+
+	if policy.Plan == nil || policy.Funcs == nil || policy.Static == nil {
+		return
+	}
+
+	funcs := map[string]*Func{}
+	for _, fn := range policy.Funcs.Funcs {
+		funcs[fn.Name] = fn
+	}
+
+	called := map[string]struct{}{}
+
+	var visit func(blocks []*Block)
+	visit = func(blocks []*Block) {
+		walkStmts(blocks, func(stmt Stmt) {
+			if call, ok := stmt.(*CallStmt); ok {
+				if _, ok := called[call.Func]; !ok {
+					called[call.Func] = struct{}{}
+					if fn, ok := funcs[call.Func]; ok {
+						visit(fn.Blocks)
+					}
+				}
+			}
+		})
+	}
+
+	visit(policy.Plan.Blocks)
+
+	live := policy.Funcs.Funcs[:0]
+	for _, fn := range policy.Funcs.Funcs {
+		if _, ok := called[fn.Name]; ok {
+			live = append(live, fn)
+		}
+	}
+	policy.Funcs.Funcs = live
+
+	builtins := policy.Static.BuiltinFuncs[:0]
+	for _, bi := range policy.Static.BuiltinFuncs {
+		if _, ok := called[bi.Name]; ok {
+			builtins = append(builtins, bi)
+		}
+	}
+	policy.Static.BuiltinFuncs = builtins
+
+	var refs []*int
+
+	collect := func(blocks []*Block) {
+		walkStmts(blocks, func(stmt Stmt) {
+			switch stmt := stmt.(type) {
+			case *MakeStringStmt:
+				refs = append(refs, &stmt.Index)
+			case *MakeNumberRefStmt:
+				refs = append(refs, &stmt.Index)
+			case *WithStmt:
+				for i := range stmt.Path {
+					refs = append(refs, &stmt.Path[i])
+				}
+			}
+		})
+	}
+
+	collect(policy.Plan.Blocks)
+
+	for _, fn := range policy.Funcs.Funcs {
+		collect(fn.Blocks)
+	}
+
+	index := map[int]int{}
+	var strings []*StringConst
+
+	for _, ref := range refs {
+		i, ok := index[*ref]
+		if !ok {
+			i = len(strings)
+			index[*ref] = i
+			strings = append(strings, policy.Static.Strings[*ref])
+		}
+		*ref = i
+	}
+
+	policy.Static.Strings = strings
+}
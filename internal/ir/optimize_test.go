@@ -0,0 +1,275 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPasses(t *testing.T) {
+
+	if passes := Passes(0); len(passes) != 0 {
+		t.Fatalf("Expected no passes at level 0 but got %d", len(passes))
+	}
+
+	names := func(passes []Pass) []string {
+		var result []string
+		for _, pass := range passes {
+			result = append(result, pass.Name)
+		}
+		return result
+	}
+
+	exp := []string{"copy propagation", "constant folding", "dead local elimination", "dead block elimination", "dead function elimination"}
+
+	if result := names(Passes(1)); !reflect.DeepEqual(result, exp) {
+		t.Fatalf("Expected %v but got %v", exp, result)
+	}
+
+	exp = []string{"copy propagation", "constant folding", "loop-invariant lookup hoisting", "dead local elimination", "dead block elimination", "dead function elimination"}
+
+	if result := names(Passes(2)); !reflect.DeepEqual(result, exp) {
+		t.Fatalf("Expected %v but got %v", exp, result)
+	}
+}
+
+func TestSizeOf(t *testing.T) {
+
+	policy := &Policy{
+		Static: &Static{},
+		Plan: &Plan{Blocks: []*Block{
+			{Stmts: []Stmt{
+				&MakeNumberIntStmt{Value: 1, Target: 2},
+				&ScanStmt{Source: Input, Key: 3, Value: 4, Block: &Block{Stmts: []Stmt{
+					&EqualStmt{A: 2, B: 4},
+				}}},
+				&ReturnLocalStmt{Source: 2},
+			}},
+		}},
+		Funcs: &Funcs{Funcs: []*Func{
+			{Name: "f", Params: []Local{Input, Data}, Return: 2, Blocks: []*Block{
+				{Stmts: []Stmt{&AssignVarStmt{Source: Data, Target: 2}}},
+			}},
+		}},
+	}
+
+	exp := Size{Funcs: 1, Blocks: 3, Stmts: 5, Locals: 6}
+
+	if result := SizeOf(policy); result != exp {
+		t.Fatalf("Expected %v but got %v", exp, result)
+	}
+
+	if result := SizeOf(&Policy{}); result != (Size{}) {
+		t.Fatalf("Expected empty size but got %v", result)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+
+	tests := []struct {
+		note   string
+		passes []Pass
+		policy *Policy
+		exp    *Policy
+	}{
+		{
+			note:   "copy propagation",
+			passes: []Pass{{Run: propagateCopies}},
+			policy: plan(
+				&AssignVarStmt{Source: Input, Target: 2},
+				&AssignVarStmt{Source: 2, Target: 3},
+				&IsDefinedStmt{Source: 3},
+				&ReturnLocalStmt{Source: 3},
+			),
+			exp: plan(
+				&IsDefinedStmt{Source: Input},
+				&ReturnLocalStmt{Source: Input},
+			),
+		},
+		{
+			note:   "copy propagation: mutated target",
+			passes: []Pass{{Run: propagateCopies}},
+			policy: plan(
+				&AssignVarStmt{Source: Input, Target: 2},
+				&AssignIntStmt{Value: 1, Target: 2},
+				&ReturnLocalStmt{Source: 2},
+			),
+			exp: plan(
+				&AssignVarStmt{Source: Input, Target: 2},
+				&AssignIntStmt{Value: 1, Target: 2},
+				&ReturnLocalStmt{Source: 2},
+			),
+		},
+		{
+			note:   "constant folding",
+			passes: []Pass{{Run: foldConstants}},
+			policy: plan(
+				&MakeNumberIntStmt{Value: 1, Target: 2},
+				&MakeNumberIntStmt{Value: 1, Target: 3},
+				&EqualStmt{A: 2, B: 3},
+				&IsDefinedStmt{Source: 3},
+				&ReturnLocalStmt{Source: 3},
+			),
+			exp: plan(
+				&MakeNumberIntStmt{Value: 1, Target: 2},
+				&ReturnLocalStmt{Source: 2},
+			),
+		},
+		{
+			note:   "constant folding: false comparison",
+			passes: []Pass{{Run: foldConstants}},
+			policy: plan(
+				&MakeNumberIntStmt{Value: 1, Target: 2},
+				&MakeNumberIntStmt{Value: 2, Target: 3},
+				&LessThanStmt{A: 3, B: 2},
+				&ReturnLocalStmt{Source: 3},
+			),
+			exp: plan(
+				&MakeNumberIntStmt{Value: 1, Target: 2},
+				&MakeNumberIntStmt{Value: 2, Target: 3},
+				&BreakStmt{Index: 0},
+				&ReturnLocalStmt{Source: 3},
+			),
+		},
+		{
+			note:   "loop-invariant lookup hoisting",
+			passes: []Pass{{Run: hoistLookups}},
+			policy: plan(
+				&ScanStmt{Source: Input, Key: 2, Value: 3, Block: &Block{Stmts: []Stmt{
+					&MakeStringStmt{Index: 0, Target: 4},
+					&DotStmt{Source: Data, Key: 4, Target: 5},
+					&EqualStmt{A: 3, B: 5},
+				}}},
+				&ReturnLocalStmt{Source: Input},
+			),
+			exp: plan(
+				&BlockStmt{Blocks: []*Block{{Stmts: []Stmt{
+					&MakeStringStmt{Index: 0, Target: 4},
+					&DotStmt{Source: Data, Key: 4, Target: 6},
+				}}}},
+				&ScanStmt{Source: Input, Key: 2, Value: 3, Block: &Block{Stmts: []Stmt{
+					&IsDefinedStmt{Source: 6},
+					&EqualStmt{A: 3, B: 6},
+				}}},
+				&ReturnLocalStmt{Source: Input},
+			),
+		},
+		{
+			note:   "loop-invariant lookup hoisting: variant key",
+			passes: []Pass{{Run: hoistLookups}},
+			policy: plan(
+				&ScanStmt{Source: Input, Key: 2, Value: 3, Block: &Block{Stmts: []Stmt{
+					&DotStmt{Source: Data, Key: 3, Target: 4},
+					&IsDefinedStmt{Source: 4},
+				}}},
+				&ReturnLocalStmt{Source: Input},
+			),
+			exp: plan(
+				&ScanStmt{Source: Input, Key: 2, Value: 3, Block: &Block{Stmts: []Stmt{
+					&DotStmt{Source: Data, Key: 3, Target: 4},
+					&IsDefinedStmt{Source: 4},
+				}}},
+				&ReturnLocalStmt{Source: Input},
+			),
+		},
+		{
+			note:   "dead local elimination",
+			passes: []Pass{{Run: eliminateDeadLocals}},
+			policy: plan(
+				&MakeNumberIntStmt{Value: 1, Target: 2},
+				&AssignVarStmt{Source: 2, Target: 3},
+				&LenStmt{Source: Input, Target: 4},
+				&ReturnLocalStmt{Source: 4},
+			),
+			exp: plan(
+				&LenStmt{Source: Input, Target: 4},
+				&ReturnLocalStmt{Source: 4},
+			),
+		},
+		{
+			note:   "dead block elimination",
+			passes: []Pass{{Run: eliminateDeadBlocks}},
+			policy: &Policy{
+				Static: &Static{},
+				Plan: &Plan{Blocks: []*Block{
+					{Stmts: []Stmt{
+						&BlockStmt{Blocks: []*Block{{Stmts: []Stmt{&BreakStmt{Index: 0}}}}},
+						&IsDefinedStmt{Source: Input},
+						&ReturnLocalStmt{Source: Input},
+						&IsDefinedStmt{Source: Data},
+					}},
+					{},
+				}},
+				Funcs: &Funcs{},
+			},
+			exp: plan(
+				&IsDefinedStmt{Source: Input},
+				&ReturnLocalStmt{Source: Input},
+			),
+		},
+		{
+			note:   "dead function elimination",
+			passes: []Pass{{Run: eliminateDeadFuncs}},
+			policy: &Policy{
+				Static: &Static{
+					Strings:      []*StringConst{{Value: "a"}, {Value: "b"}},
+					BuiltinFuncs: []*BuiltinFunc{{Name: "plus"}, {Name: "minus"}},
+				},
+				Plan: &Plan{Blocks: []*Block{{Stmts: []Stmt{
+					&CallStmt{Func: "g0.data.p", Args: []Local{Input, Data}, Result: 2},
+					&ReturnLocalStmt{Source: 2},
+				}}}},
+				Funcs: &Funcs{Funcs: []*Func{
+					{Name: "g0.data.p", Params: []Local{Input, Data}, Return: 2, Blocks: []*Block{{Stmts: []Stmt{
+						&MakeStringStmt{Index: 1, Target: 3},
+						&CallStmt{Func: "plus", Args: []Local{3, 3}, Result: 2},
+					}}}},
+					{Name: "g0.data.q", Params: []Local{Input, Data}, Return: 2, Blocks: []*Block{{Stmts: []Stmt{
+						&MakeStringStmt{Index: 0, Target: 3},
+						&CallStmt{Func: "minus", Args: []Local{3, 3}, Result: 2},
+					}}}},
+				}},
+			},
+			exp: &Policy{
+				Static: &Static{
+					Strings:      []*StringConst{{Value: "b"}},
+					BuiltinFuncs: []*BuiltinFunc{{Name: "plus"}},
+				},
+				Plan: &Plan{Blocks: []*Block{{Stmts: []Stmt{
+					&CallStmt{Func: "g0.data.p", Args: []Local{Input, Data}, Result: 2},
+					&ReturnLocalStmt{Source: 2},
+				}}}},
+				Funcs: &Funcs{Funcs: []*Func{
+					{Name: "g0.data.p", Params: []Local{Input, Data}, Return: 2, Blocks: []*Block{{Stmts: []Stmt{
+						&MakeStringStmt{Index: 0, Target: 3},
+						&CallStmt{Func: "plus", Args: []Local{3, 3}, Result: 2},
+					}}}},
+				}},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			Optimize(tc.policy, tc.passes)
+			if !reflect.DeepEqual(tc.policy, tc.exp) {
+				var exp, result bytes.Buffer
+				Pretty(&exp, tc.exp)
+				Pretty(&result, tc.policy)
+				t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp.String(), result.String())
+			}
+		})
+	}
+}
+
+func plan(stmts ...Stmt) *Policy {
+	return &Policy{
+		Static: &Static{},
+		Plan:   &Plan{Blocks: []*Block{{Stmts: stmts}}},
+		Funcs:  &Funcs{},
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package jsonrpc implements the base protocol shared by the Language Server
+// Protocol and the Debug Adapter Protocol. Messages are JSON documents
+// preceded by a header that contains the length of the content. The package
+// also defines the JSON-RPC 2.0 messages used by the Language Server Protocol.
+package jsonrpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Version is the JSON-RPC version set on all messages.
+const Version = "2.0"
+
+// Error codes defined by JSON-RPC 2.0.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Stream reads and writes messages framed by Content-Length headers. Writes are
+// safe for concurrent use.
+type Stream struct {
+	r   *bufio.Reader
+	w   io.Writer
+	mtx sync.Mutex
+}
+
+// NewStream returns a new Stream that reads from r and writes to w.
+func NewStream(r io.Reader, w io.Writer) *Stream {
+	return &Stream{
+		r: bufio.NewReader(r),
+		w: w,
+	}
+}
+
+// Read returns the content of the next message. If the stream has ended, Read
+// returns io.EOF.
+func (s *Stream) Read() ([]byte, error) {
+
+	header, err := textproto.NewReader(s.r).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length header: %q", header.Get("Content-Length"))
+	}
+
+	bs := make([]byte, length)
+	if _, err := io.ReadFull(s.r, bs); err != nil {
+		return nil, err
+	}
+
+	return bs, nil
+}
+
+// Write writes the JSON encoding of v as a message.
+func (s *Stream) Write(v interface{}) error {
+
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, err := fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n", len(bs)); err != nil {
+		return err
+	}
+
+	_, err = s.w.Write(bs)
+	return err
+}
+
+// Message represents a request, response or notification read from a stream.
+// Requests have an ID and a method, notifications have a method only and
+// responses have an ID only.
+type Message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+// IsRequest returns true if m is a request.
+func (m *Message) IsRequest() bool {
+	return m.ID != nil && m.Method != ""
+}
+
+// Response represents the response to a request.
+type Response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+// NewResponse returns a response to the request identified by id. If err is
+// not nil, the response contains the error instead of the result. Errors that
+// are not of type *Error are reported as internal errors.
+func NewResponse(id *json.RawMessage, result interface{}, err error) (*Response, error) {
+
+	resp := &Response{
+		JSONRPC: Version,
+		ID:      id,
+	}
+
+	if err != nil {
+		e, ok := err.(*Error)
+		if !ok {
+			e = NewError(CodeInternalError, err.Error())
+		}
+		resp.Error = e
+		return resp, nil
+	}
+
+	bs, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Result = bs
+	return resp, nil
+}
+
+// Notification represents a notification sent to the client.
+type Notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// NewNotification returns a notification for method with params.
+func NewNotification(method string, params interface{}) *Notification {
+	return &Notification{
+		JSONRPC: Version,
+		Method:  method,
+		Params:  params,
+	}
+}
+
+// Error represents a JSON-RPC error.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewError returns a new Error.
+func NewError(code int, f string, a ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(f, a...),
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v (code: %d)", e.Message, e.Code)
+}
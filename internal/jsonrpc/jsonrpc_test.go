@@ -0,0 +1,103 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+
+	var buf bytes.Buffer
+	s := NewStream(&buf, &buf)
+
+	if err := s.Write(NewNotification("a", map[string]int{"x": 1})); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Write(NewNotification("b", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), "Content-Length: 47\r\n\r\n") {
+		t.Fatalf("Unexpected framing: %q", buf.String())
+	}
+
+	for _, exp := range []string{"a", "b"} {
+		bs, err := s.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg Message
+		if err := json.Unmarshal(bs, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Method != exp || msg.IsRequest() {
+			t.Fatalf("Expected notification %v but got %+v", exp, msg)
+		}
+	}
+
+	if _, err := s.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF but got %v", err)
+	}
+}
+
+func TestStreamReadErrors(t *testing.T) {
+
+	tests := []struct {
+		note  string
+		input string
+	}{
+		{"missing length", "Content-Type: application/json\r\n\r\n{}"},
+		{"bad length", "Content-Length: x\r\n\r\n{}"},
+		{"short content", "Content-Length: 10\r\n\r\n{}"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			if _, err := NewStream(strings.NewReader(tc.input), nil).Read(); err == nil || err == io.EOF {
+				t.Fatalf("Expected error but got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewResponse(t *testing.T) {
+
+	id := json.RawMessage(`1`)
+
+	tests := []struct {
+		note   string
+		result interface{}
+		err    error
+		exp    string
+	}{
+		{"null result", nil, nil, `{"jsonrpc":"2.0","id":1,"result":null}`},
+		{"result", []int{1}, nil, `{"jsonrpc":"2.0","id":1,"result":[1]}`},
+		{"error", nil, NewError(CodeMethodNotFound, "method not found: %v", "x"), `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"method not found: x"}}`},
+		{"internal error", nil, errors.New("boom"), `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"boom"}}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			resp, err := NewResponse(&id, tc.result, tc.err)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bs, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(bs) != tc.exp {
+				t.Fatalf("Expected %v but got %v", tc.exp, string(bs))
+			}
+		})
+	}
+}
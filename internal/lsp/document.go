@@ -0,0 +1,159 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lsp
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// document is a policy file in the workspace. The text of open documents is
+// managed by the client; other documents are read from disk. The last module
+// parsed successfully is kept for completions, which are usually requested
+// while the text does not parse.
+type document struct {
+	uri        string
+	path       string
+	text       string
+	open       bool
+	module     *ast.Module
+	lastModule *ast.Module
+	errs       ast.Errors
+}
+
+// parse parses the text of the document. If the text cannot be parsed, the
+// module is nil and the errors are recorded on the document.
+func (d *document) parse() {
+
+	d.module, d.errs = nil, nil
+
+	module, err := ast.ParseModule(d.path, d.text)
+	if err != nil {
+		if errs, ok := err.(ast.Errors); ok {
+			d.errs = errs
+		} else {
+			d.errs = ast.Errors{ast.NewError(ast.ParseErr, nil, err.Error())}
+		}
+		return
+	}
+
+	d.module = module
+	d.lastModule = module
+}
+
+// rangeOf returns the range of the text at loc.
+func (d *document) rangeOf(loc *ast.Location) Range {
+	if loc == nil {
+		return Range{}
+	}
+	start := locationOffset(d.text, loc)
+	end := start + len(loc.Text)
+	if end > len(d.text) {
+		end = len(d.text)
+	}
+	return Range{Start: position(d.text, start), End: position(d.text, end)}
+}
+
+// uriToPath returns the file path for a file URI.
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// pathToURI returns the file URI for a file path.
+func pathToURI(path string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
+
+// offset returns the byte offset of pos in text. Positions past the end of a
+// line refer to the end of the line.
+func offset(text string, pos Position) int {
+
+	i := 0
+
+	for line := 0; line < pos.Line; line++ {
+		j := strings.IndexByte(text[i:], '\n')
+		if j < 0 {
+			return len(text)
+		}
+		i += j + 1
+	}
+
+	units := 0
+
+	for j, r := range text[i:] {
+		if units >= pos.Character || r == '\n' {
+			return i + j
+		}
+		units += utf16Len(r)
+	}
+
+	return len(text)
+}
+
+// position returns the position of the byte offset off in text.
+func position(text string, off int) Position {
+
+	if off > len(text) {
+		off = len(text)
+	}
+
+	start := strings.LastIndexByte(text[:off], '\n') + 1
+	units := 0
+
+	for _, r := range text[start:off] {
+		units += utf16Len(r)
+	}
+
+	return Position{Line: strings.Count(text[:off], "\n"), Character: units}
+}
+
+// Positions count UTF-16 code units. Characters outside of the basic
+// multilingual plane are encoded as surrogate pairs.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// applyChange returns text after applying the change c.
+func applyChange(text string, c TextDocumentContentChangeEvent) string {
+	if c.Range == nil {
+		return c.Text
+	}
+	start, end := offset(text, c.Range.Start), offset(text, c.Range.End)
+	if end < start {
+		end = start
+	}
+	return text[:start] + c.Text + text[end:]
+}
+
+// locationOffset returns the byte offset of loc in text. Locations created
+// without an offset are converted from their row and column.
+func locationOffset(text string, loc *ast.Location) int {
+
+	if loc.Offset > 0 && loc.Offset <= len(text) && strings.Count(text[:loc.Offset], "\n") == loc.Row-1 {
+		return loc.Offset
+	}
+
+	off := offset(text, Position{Line: loc.Row - 1})
+	if loc.Col > 1 {
+		off += loc.Col - 1
+	}
+
+	if off > len(text) {
+		return len(text)
+	}
+
+	return off
+}
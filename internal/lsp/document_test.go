@@ -0,0 +1,110 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lsp
+
+import (
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestOffsetPosition(t *testing.T) {
+
+	// "ü" is two bytes in UTF-8 and one UTF-16 code unit. "😀" is four bytes
+	// in UTF-8 and two UTF-16 code units.
+	text := "ab\nüc😀d\n"
+
+	tests := []struct {
+		pos    Position
+		offset int
+	}{
+		{Position{0, 0}, 0},
+		{Position{0, 2}, 2},
+		{Position{1, 0}, 3},
+		{Position{1, 1}, 5},
+		{Position{1, 2}, 6},
+		{Position{1, 4}, 10},
+		{Position{2, 0}, 12},
+	}
+
+	for _, tc := range tests {
+		if got := offset(text, tc.pos); got != tc.offset {
+			t.Errorf("Expected offset of %v to be %d but got %d", tc.pos, tc.offset, got)
+		}
+		if got := position(text, tc.offset); got != tc.pos {
+			t.Errorf("Expected position of %d to be %v but got %v", tc.offset, tc.pos, got)
+		}
+	}
+
+	// Positions past the end of a line or document are clamped.
+	if got := offset(text, Position{0, 10}); got != 2 {
+		t.Errorf("Expected end of line but got %d", got)
+	}
+
+	if got := offset(text, Position{5, 0}); got != len(text) {
+		t.Errorf("Expected end of document but got %d", got)
+	}
+}
+
+func TestApplyChange(t *testing.T) {
+
+	text := "package x\n\np { true }\n"
+
+	text = applyChange(text, TextDocumentContentChangeEvent{
+		Range: &Range{Start: Position{2, 4}, End: Position{2, 8}},
+		Text:  "input.x",
+	})
+
+	text = applyChange(text, TextDocumentContentChangeEvent{
+		Range: &Range{Start: Position{3, 0}, End: Position{3, 0}},
+		Text:  "q = 1\n",
+	})
+
+	if exp := "package x\n\np { input.x }\nq = 1\n"; text != exp {
+		t.Fatalf("Expected %q but got %q", exp, text)
+	}
+
+	if text = applyChange(text, TextDocumentContentChangeEvent{Text: "package y"}); text != "package y" {
+		t.Fatalf("Expected full replacement but got %q", text)
+	}
+}
+
+func TestDocumentRangeOf(t *testing.T) {
+
+	d := &document{path: "x.rego", text: "package x\n\np { q }\nq = true\n"}
+	d.parse()
+
+	if d.module == nil {
+		t.Fatal(d.errs)
+	}
+
+	r := d.rangeOf(d.module.Rules[1].Location)
+	if exp := (Range{Position{3, 0}, Position{3, 8}}); r != exp {
+		t.Fatalf("Expected %v but got %v", exp, r)
+	}
+
+	// Locations without an offset are converted from the row and column.
+	r = d.rangeOf(ast.NewLocation([]byte("q"), "x.rego", 3, 5))
+	if exp := (Range{Position{2, 4}, Position{2, 5}}); r != exp {
+		t.Fatalf("Expected %v but got %v", exp, r)
+	}
+
+	d.text = "package"
+	d.parse()
+
+	if d.module != nil || len(d.errs) == 0 {
+		t.Fatal("Expected parse errors")
+	}
+}
+
+func TestURIPath(t *testing.T) {
+	uri := pathToURI("/a b/x.rego")
+	if uri != "file:///a%20b/x.rego" {
+		t.Fatalf("Unexpected URI: %v", uri)
+	}
+	if path := uriToPath(uri); path != "/a b/x.rego" {
+		t.Fatalf("Unexpected path: %v", path)
+	}
+}
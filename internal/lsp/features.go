@@ -0,0 +1,420 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/format"
+	"github.com/open-policy-agent/opa/internal/oracle"
+	"github.com/open-policy-agent/opa/types"
+)
+
+// lookup returns the parsed document and byte offset referred to by p. If the
+// document does not exist or cannot be parsed, nil is returned.
+func (s *Server) lookup(p TextDocumentPositionParams) (*document, int) {
+	d, ok := s.docs[uriToPath(p.TextDocument.URI)]
+	if !ok || d.module == nil {
+		return nil, 0
+	}
+	return d, offset(d.text, p.Position)
+}
+
+// location converts loc into a location in a document of the workspace.
+func (s *Server) location(loc *ast.Location) (Location, bool) {
+	if loc == nil {
+		return Location{}, false
+	}
+	d, ok := s.docs[loc.File]
+	if !ok {
+		return Location{}, false
+	}
+	return Location{URI: d.uri, Range: d.rangeOf(loc)}, true
+}
+
+func (s *Server) definition(params json.RawMessage) (interface{}, error) {
+
+	var p TextDocumentPositionParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	d, pos := s.lookup(p)
+	if d == nil {
+		return nil, nil
+	}
+
+	result, err := oracle.New().FindDefinition(oracle.DefinitionQuery{
+		Filename: d.path,
+		Pos:      pos,
+		Modules:  s.modules(),
+	})
+	if err != nil {
+		// The oracle also fails if the workspace does not compile. This is
+		// reported through diagnostics.
+		return nil, nil
+	}
+
+	if loc, ok := s.location(result.Result); ok {
+		return loc, nil
+	}
+
+	return nil, nil
+}
+
+func (s *Server) references(params json.RawMessage) (interface{}, error) {
+
+	var p ReferenceParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	d, pos := s.lookup(p.TextDocumentPositionParams)
+	if d == nil {
+		return nil, nil
+	}
+
+	result, err := oracle.New().FindReferences(oracle.ReferencesQuery{
+		Filename:          d.path,
+		Pos:               pos,
+		Modules:           s.modules(),
+		IncludeDefinition: p.Context.IncludeDeclaration,
+	})
+	if err != nil {
+		return nil, nil
+	}
+
+	locs := []Location{}
+	for _, loc := range result.Result {
+		if l, ok := s.location(loc); ok {
+			locs = append(locs, l)
+		}
+	}
+
+	return locs, nil
+}
+
+func (s *Server) hover(params json.RawMessage) (interface{}, error) {
+
+	var p TextDocumentPositionParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	d, pos := s.lookup(p)
+	if d == nil {
+		return nil, nil
+	}
+
+	// Built-in functions are described by their declarations.
+	if term := findRefTerm(d.module, pos); term != nil {
+		if bi, ok := ast.BuiltinMap[term.Value.(ast.Ref).String()]; ok {
+			r := d.rangeOf(term.Location)
+			return newHover(fmt.Sprintf("%v: %v", bi.Name, bi.Decl), &r), nil
+		}
+	}
+
+	compiler := s.getCompiler()
+
+	var rule *ast.Rule
+	var loc *ast.Location
+
+	if r := findRuleName(d.module, pos); r != nil {
+		rule, loc = r, r.Head.Location
+	} else {
+		result, err := oracle.New().FindDefinition(oracle.DefinitionQuery{
+			Filename: d.path,
+			Pos:      pos,
+			Modules:  s.modules(),
+		})
+		if err != nil {
+			return nil, nil
+		}
+		rule = s.findRule(result.Result)
+		loc = findRefTerm(d.module, pos).Loc()
+	}
+
+	if rule == nil {
+		return nil, nil
+	}
+
+	path := rule.Path()
+
+	var r *Range
+	if loc != nil {
+		rng := d.rangeOf(loc)
+		r = &rng
+	}
+
+	return newHover(fmt.Sprintf("%v: %v", path, typeString(compiler.TypeEnv.Get(path))), r), nil
+}
+
+func newHover(text string, r *Range) *Hover {
+	return &Hover{
+		Contents: MarkupContent{
+			Kind:  "markdown",
+			Value: "```rego\n" + text + "\n```",
+		},
+		Range: r,
+	}
+}
+
+func typeString(tpe types.Type) string {
+	if tpe == nil {
+		return "any"
+	}
+	return types.Sprint(tpe)
+}
+
+// findRule returns the rule defined at loc.
+func (s *Server) findRule(loc *ast.Location) *ast.Rule {
+	if loc == nil {
+		return nil
+	}
+	d, ok := s.docs[loc.File]
+	if !ok || d.module == nil {
+		return nil
+	}
+	for _, rule := range d.module.Rules {
+		if rule.Location != nil && rule.Location.Offset == loc.Offset {
+			return rule
+		}
+	}
+	return nil
+}
+
+// findRuleName returns the rule whose name is at pos.
+func findRuleName(module *ast.Module, pos int) *ast.Rule {
+	for _, rule := range module.Rules {
+		if loc := rule.Head.Location; loc != nil && pos >= loc.Offset && pos < loc.Offset+len(rule.Head.Name) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// findRefTerm returns the innermost reference in module that contains pos.
+func findRefTerm(module *ast.Module, pos int) (result *ast.Term) {
+	ast.WalkTerms(module, func(x *ast.Term) bool {
+		if x.Location == nil || pos < x.Location.Offset || pos >= x.Location.Offset+len(x.Location.Text) {
+			return false
+		}
+		if _, ok := x.Value.(ast.Ref); ok {
+			result = x
+		}
+		return false
+	})
+	return result
+}
+
+var keywords = []string{"as", "default", "else", "false", "import", "not", "null", "package", "some", "true", "with"}
+
+func (s *Server) completion(params json.RawMessage) (interface{}, error) {
+
+	var p TextDocumentPositionParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	d, ok := s.docs[uriToPath(p.TextDocument.URI)]
+	if !ok {
+		return nil, nil
+	}
+
+	pos := offset(d.text, p.Position)
+	lineStart := strings.LastIndexByte(d.text[:pos], '\n') + 1
+	line := d.text[lineStart:pos]
+
+	start := pos
+	for start > lineStart && isRefChar(d.text[start-1]) {
+		start--
+	}
+
+	word := d.text[start:pos]
+	c := newCompletions(word)
+
+	// Completions for imports are limited to the roots of documents and
+	// package paths.
+	if strings.HasPrefix(strings.TrimSpace(line), "import ") {
+		c.add("input", CompletionItemKindModule, "")
+		c.add("data", CompletionItemKindModule, "")
+		for _, path := range s.packagePaths() {
+			c.add(path, CompletionItemKindModule, "package")
+		}
+		return c.list(), nil
+	}
+
+	for _, kw := range keywords {
+		c.add(kw, CompletionItemKindKeyword, "")
+	}
+
+	c.add("input", CompletionItemKindVariable, "")
+	c.add("data", CompletionItemKindVariable, "")
+
+	for _, bi := range ast.Builtins {
+		if bi.Infix == "" {
+			c.add(bi.Name, CompletionItemKindFunction, fmt.Sprint(bi.Decl))
+		}
+	}
+
+	module := d.lastModule
+	if module == nil {
+		return c.list(), nil
+	}
+
+	for _, imp := range module.Imports {
+		name := imp.Name()
+		if name != "" {
+			c.add(string(name), CompletionItemKindModule, imp.Path.String())
+		}
+	}
+
+	for _, m := range s.lastModules() {
+		local := m.Package.Path.Equal(module.Package.Path)
+		for _, rule := range m.Rules {
+			kind := CompletionItemKindVariable
+			if len(rule.Head.Args) > 0 {
+				kind = CompletionItemKindFunction
+			}
+			path := rule.Path().String()
+			if local {
+				c.add(string(rule.Head.Name), kind, path)
+			}
+			c.add(path, kind, "")
+		}
+	}
+
+	return c.list(), nil
+}
+
+func (s *Server) packagePaths() []string {
+	var result []string
+	for _, m := range s.lastModules() {
+		result = append(result, m.Package.Path.String())
+	}
+	return result
+}
+
+// lastModules returns the last modules parsed successfully for each document.
+func (s *Server) lastModules() []*ast.Module {
+	var result []*ast.Module
+	for _, d := range s.docs {
+		if d.lastModule != nil {
+			result = append(result, d.lastModule)
+		}
+	}
+	return result
+}
+
+func isRefChar(b byte) bool {
+	return b == '.' || b == '_' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
+
+// completions collects completion items that start with a prefix. Duplicate
+// labels are ignored.
+type completions struct {
+	prefix string
+	items  map[string]CompletionItem
+}
+
+func newCompletions(prefix string) *completions {
+	return &completions{prefix: prefix, items: map[string]CompletionItem{}}
+}
+
+func (c *completions) add(label string, kind int, detail string) {
+	if !strings.HasPrefix(label, c.prefix) {
+		return
+	}
+	if _, ok := c.items[label]; ok {
+		return
+	}
+	c.items[label] = CompletionItem{Label: label, Kind: kind, Detail: detail}
+}
+
+func (c *completions) list() CompletionList {
+	items := make([]CompletionItem, 0, len(c.items))
+	for _, item := range c.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return CompletionList{Items: items}
+}
+
+func (s *Server) documentSymbol(params json.RawMessage) (interface{}, error) {
+
+	var p DocumentSymbolParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	d, ok := s.docs[uriToPath(p.TextDocument.URI)]
+	if !ok || d.module == nil {
+		return []DocumentSymbol{}, nil
+	}
+
+	env := s.getCompiler().TypeEnv
+
+	pkg := DocumentSymbol{
+		Name:           strings.TrimPrefix(d.module.Package.Path.String(), "data."),
+		Kind:           SymbolKindPackage,
+		Range:          Range{End: position(d.text, len(d.text))},
+		SelectionRange: d.rangeOf(d.module.Package.Location),
+	}
+
+	for _, rule := range d.module.Rules {
+		kind := SymbolKindVariable
+		if len(rule.Head.Args) > 0 {
+			kind = SymbolKindFunction
+		}
+		r := d.rangeOf(rule.Location)
+		selection := r
+		if loc := rule.Head.Location; loc != nil {
+			start := locationOffset(d.text, loc)
+			selection = Range{Start: position(d.text, start), End: position(d.text, start+len(rule.Head.Name))}
+		}
+		pkg.Children = append(pkg.Children, DocumentSymbol{
+			Name:           string(rule.Head.Name),
+			Detail:         typeString(env.Get(rule.Path())),
+			Kind:           kind,
+			Range:          r,
+			SelectionRange: selection,
+		})
+	}
+
+	return []DocumentSymbol{pkg}, nil
+}
+
+func (s *Server) formatting(params json.RawMessage) (interface{}, error) {
+
+	var p DocumentFormattingParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	d, ok := s.docs[uriToPath(p.TextDocument.URI)]
+	if !ok {
+		return nil, nil
+	}
+
+	bs, err := format.Source(d.path, []byte(d.text))
+	if err != nil {
+		return nil, err
+	}
+
+	if string(bs) == d.text {
+		return []TextEdit{}, nil
+	}
+
+	return []TextEdit{{
+		Range:   Range{End: position(d.text, len(d.text))},
+		NewText: string(bs),
+	}}, nil
+}
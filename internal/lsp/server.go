@@ -0,0 +1,482 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package lsp implements a Language Server Protocol server for Rego.
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/jsonrpc"
+	"github.com/open-policy-agent/opa/version"
+)
+
+// codeServerNotInitialized is returned for requests received before the
+// initialize request.
+const codeServerNotInitialized = -32002
+
+// defaultCompileDelay is the time the server waits for further changes before
+// recompiling the workspace.
+const defaultCompileDelay = 200 * time.Millisecond
+
+// ErrExitWithoutShutdown is returned by Serve if the client requested the
+// server to exit without shutting it down first.
+var ErrExitWithoutShutdown = errors.New("exit notification received before shutdown request")
+
+type handler func(*Server, json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"initialize":                  (*Server).initialize,
+	"initialized":                 (*Server).initialized,
+	"shutdown":                    (*Server).shutdown,
+	"textDocument/didOpen":        (*Server).didOpen,
+	"textDocument/didChange":      (*Server).didChange,
+	"textDocument/didClose":       (*Server).didClose,
+	"textDocument/didSave":        (*Server).didSave,
+	"textDocument/definition":     (*Server).definition,
+	"textDocument/references":     (*Server).references,
+	"textDocument/hover":          (*Server).hover,
+	"textDocument/completion":     (*Server).completion,
+	"textDocument/documentSymbol": (*Server).documentSymbol,
+	"textDocument/formatting":     (*Server).formatting,
+}
+
+// Server implements a Language Server Protocol server for Rego. The server
+// keeps the parsed modules of all policy files in the workspace. When a
+// document changes, only that document is parsed again and the workspace is
+// recompiled once the client stops sending changes.
+type Server struct {
+	stream      *jsonrpc.Stream
+	mtx         sync.Mutex
+	ready       bool
+	shutdownReq bool
+	root        string
+	docs        map[string]*document
+	compiler    *ast.Compiler
+	dirty       bool
+	published   map[string]bool
+	delay       time.Duration
+	timer       *time.Timer
+}
+
+// New returns a new Server that reads messages from r and writes messages to
+// w.
+func New(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		stream:    jsonrpc.NewStream(r, w),
+		docs:      map[string]*document{},
+		published: map[string]bool{},
+		delay:     defaultCompileDelay,
+	}
+}
+
+// Serve handles messages until the client sends the exit notification, the
+// input ends or the context is canceled.
+func (s *Server) Serve(ctx context.Context) error {
+
+	defer s.stopTimer()
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		bs, err := s.stream.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var msg jsonrpc.Message
+		if err := json.Unmarshal(bs, &msg); err != nil {
+			s.respond(nil, nil, jsonrpc.NewError(jsonrpc.CodeParseError, "invalid message: %v", err))
+			continue
+		}
+
+		if msg.Method == "exit" {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+			if !s.shutdownReq {
+				return ErrExitWithoutShutdown
+			}
+			return nil
+		}
+
+		s.handle(&msg)
+	}
+}
+
+func (s *Server) handle(msg *jsonrpc.Message) {
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	h, ok := handlers[msg.Method]
+
+	if !msg.IsRequest() {
+		if ok && (s.ready || msg.Method == "initialized") {
+			if _, err := h(s, msg.Params); err != nil {
+				logrus.WithField("method", msg.Method).Errorf("Failed to handle notification: %v", err)
+			}
+		}
+		return
+	}
+
+	if !ok {
+		s.respond(msg.ID, nil, jsonrpc.NewError(jsonrpc.CodeMethodNotFound, "method not found: %v", msg.Method))
+		return
+	}
+
+	if !s.ready && msg.Method != "initialize" {
+		s.respond(msg.ID, nil, jsonrpc.NewError(codeServerNotInitialized, "server not initialized"))
+		return
+	}
+
+	result, err := h(s, msg.Params)
+	s.respond(msg.ID, result, err)
+}
+
+func (s *Server) respond(id *json.RawMessage, result interface{}, err error) {
+	if id == nil {
+		null := json.RawMessage("null")
+		id = &null
+	}
+	resp, err := jsonrpc.NewResponse(id, result, err)
+	if err == nil {
+		err = s.stream.Write(resp)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to write response: %v", err)
+	}
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	if err := s.stream.Write(jsonrpc.NewNotification(method, params)); err != nil {
+		logrus.Errorf("Failed to write notification: %v", err)
+	}
+}
+
+func decode(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return jsonrpc.NewError(jsonrpc.CodeInvalidParams, "invalid params: %v", err)
+	}
+	return nil
+}
+
+func (s *Server) initialize(params json.RawMessage) (interface{}, error) {
+
+	var p InitializeParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	if p.RootURI != "" {
+		s.root = uriToPath(p.RootURI)
+	} else {
+		s.root = p.RootPath
+	}
+
+	if s.root != "" {
+		if err := s.loadWorkspace(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.ready = true
+
+	return InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync: TextDocumentSyncOptions{
+				OpenClose: true,
+				Change:    TextDocumentSyncKindIncremental,
+				Save:      true,
+			},
+			DefinitionProvider: true,
+			ReferencesProvider: true,
+			HoverProvider:      true,
+			CompletionProvider: CompletionOptions{
+				TriggerCharacters: []string{"."},
+			},
+			DocumentSymbolProvider:     true,
+			DocumentFormattingProvider: true,
+		},
+		ServerInfo: ServerInfo{
+			Name:    "opa",
+			Version: version.Version,
+		},
+	}, nil
+}
+
+// loadWorkspace parses the policy files under the workspace root. Hidden
+// directories are skipped.
+func (s *Server) loadWorkspace() error {
+	return filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != s.root && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".rego" {
+			return nil
+		}
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		d := &document{uri: pathToURI(path), path: path, text: string(bs)}
+		d.parse()
+		s.docs[path] = d
+		return nil
+	})
+}
+
+func (s *Server) initialized(json.RawMessage) (interface{}, error) {
+	s.compile()
+	return nil, nil
+}
+
+func (s *Server) shutdown(json.RawMessage) (interface{}, error) {
+	s.shutdownReq = true
+	return nil, nil
+}
+
+func (s *Server) didOpen(params json.RawMessage) (interface{}, error) {
+
+	var p DidOpenTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	path := uriToPath(p.TextDocument.URI)
+	d, ok := s.docs[path]
+	if !ok {
+		d = &document{path: path}
+		s.docs[path] = d
+	}
+
+	d.uri = p.TextDocument.URI
+	d.text = p.TextDocument.Text
+	d.open = true
+	d.parse()
+	s.changed()
+
+	return nil, nil
+}
+
+func (s *Server) didChange(params json.RawMessage) (interface{}, error) {
+
+	var p DidChangeTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	d, ok := s.docs[uriToPath(p.TextDocument.URI)]
+	if !ok || !d.open {
+		return nil, errors.New("document not open: " + p.TextDocument.URI)
+	}
+
+	for _, c := range p.ContentChanges {
+		d.text = applyChange(d.text, c)
+	}
+
+	d.parse()
+	s.changed()
+
+	return nil, nil
+}
+
+func (s *Server) didClose(params json.RawMessage) (interface{}, error) {
+
+	var p DidCloseTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	path := uriToPath(p.TextDocument.URI)
+	d, ok := s.docs[path]
+	if !ok {
+		return nil, nil
+	}
+
+	d.open = false
+
+	// The document reverts to the file on disk. Documents that were never
+	// saved are removed from the workspace.
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		delete(s.docs, path)
+		if s.published[path] {
+			s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: d.uri, Diagnostics: []Diagnostic{}})
+			delete(s.published, path)
+		}
+	} else {
+		d.text = string(bs)
+		d.parse()
+	}
+
+	s.changed()
+
+	return nil, nil
+}
+
+func (s *Server) didSave(params json.RawMessage) (interface{}, error) {
+
+	var p DidSaveTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	path := uriToPath(p.TextDocument.URI)
+	if d, ok := s.docs[path]; ok && d.open {
+		return nil, nil
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d, ok := s.docs[path]
+	if !ok {
+		d = &document{uri: p.TextDocument.URI, path: path}
+		s.docs[path] = d
+	}
+
+	d.text = string(bs)
+	d.parse()
+	s.changed()
+
+	return nil, nil
+}
+
+// changed schedules compilation of the workspace. Changes received within the
+// compile delay are compiled together.
+func (s *Server) changed() {
+
+	s.dirty = true
+
+	if s.delay <= 0 {
+		s.compile()
+		return
+	}
+
+	s.stopTimer()
+
+	s.timer = time.AfterFunc(s.delay, func() {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		if s.dirty {
+			s.compile()
+		}
+	})
+}
+
+func (s *Server) stopTimer() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// getCompiler returns the compiler for the current state of the workspace.
+func (s *Server) getCompiler() *ast.Compiler {
+	if s.dirty || s.compiler == nil {
+		s.compile()
+	}
+	return s.compiler
+}
+
+// modules returns the parsed modules of the workspace. Documents that cannot
+// be parsed are excluded.
+func (s *Server) modules() map[string]*ast.Module {
+	modules := make(map[string]*ast.Module, len(s.docs))
+	for path, d := range s.docs {
+		if d.module != nil {
+			modules[path] = d.module
+		}
+	}
+	return modules
+}
+
+// compile compiles the workspace and publishes the parse, compile and type
+// errors as diagnostics.
+func (s *Server) compile() {
+
+	s.stopTimer()
+
+	compiler := ast.NewCompiler()
+	compiler.Compile(s.modules())
+
+	s.compiler = compiler
+	s.dirty = false
+
+	diagnostics := map[string][]Diagnostic{}
+
+	for path, d := range s.docs {
+		for _, err := range d.errs {
+			diagnostics[path] = append(diagnostics[path], d.diagnostic(err))
+		}
+	}
+
+	for _, err := range compiler.Errors {
+		if err.Location == nil {
+			logrus.Errorf("Compile error without location: %v", err)
+			continue
+		}
+		d, ok := s.docs[err.Location.File]
+		if !ok {
+			continue
+		}
+		diagnostics[d.path] = append(diagnostics[d.path], d.diagnostic(err))
+	}
+
+	paths := make([]string, 0, len(s.docs))
+	for path := range s.docs {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		diags := diagnostics[path]
+		if len(diags) == 0 && !s.published[path] {
+			continue
+		}
+		if diags == nil {
+			diags = []Diagnostic{}
+		}
+		s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI:         s.docs[path].uri,
+			Diagnostics: diags,
+		})
+		s.published[path] = len(diags) > 0
+	}
+}
+
+func (d *document) diagnostic(err *ast.Error) Diagnostic {
+	return Diagnostic{
+		Range:    d.rangeOf(err.Location),
+		Severity: DiagnosticSeverityError,
+		Code:     err.Code,
+		Source:   "opa",
+		Message:  err.Message,
+	}
+}
@@ -0,0 +1,486 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/internal/jsonrpc"
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+// testClient drives a server over pipes. Notifications are collected while
+// waiting for responses.
+type testClient struct {
+	t           *testing.T
+	in          io.WriteCloser
+	stream      *jsonrpc.Stream
+	messages    chan *jsonrpc.Message
+	done        chan error
+	nextID      int
+	diagnostics map[string][]Diagnostic
+}
+
+func newTestClient(t *testing.T) *testClient {
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	s := New(serverIn, serverOut)
+	s.delay = 0
+
+	c := &testClient{
+		t:           t,
+		in:          clientOut,
+		stream:      jsonrpc.NewStream(clientIn, clientOut),
+		messages:    make(chan *jsonrpc.Message, 100),
+		done:        make(chan error, 1),
+		diagnostics: map[string][]Diagnostic{},
+	}
+
+	go func() {
+		c.done <- s.Serve(context.Background())
+		serverOut.Close()
+	}()
+
+	go func() {
+		defer close(c.messages)
+		for {
+			bs, err := c.stream.Read()
+			if err != nil {
+				return
+			}
+			var msg jsonrpc.Message
+			if err := json.Unmarshal(bs, &msg); err != nil {
+				panic(err)
+			}
+			c.messages <- &msg
+		}
+	}()
+
+	return c
+}
+
+func (c *testClient) request(method string, params interface{}, result interface{}) *jsonrpc.Error {
+	c.t.Helper()
+
+	c.nextID++
+	id := json.RawMessage(strings.TrimSpace(string(mustMarshal(c.nextID))))
+
+	if err := c.stream.Write(map[string]interface{}{"jsonrpc": "2.0", "id": &id, "method": method, "params": params}); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		msg := c.next()
+		if msg.ID == nil || string(*msg.ID) != string(id) {
+			continue
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return nil
+	}
+}
+
+func (c *testClient) notify(method string, params interface{}) {
+	c.t.Helper()
+	if err := c.stream.Write(jsonrpc.NewNotification(method, params)); err != nil {
+		c.t.Fatal(err)
+	}
+	// Notifications do not have responses. A request is used to wait for
+	// the server to process the notification.
+	c.request("test/barrier", nil, nil)
+}
+
+func (c *testClient) next() *jsonrpc.Message {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("Unexpected end of stream")
+		}
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var p PublishDiagnosticsParams
+			if err := json.Unmarshal(msg.Params, &p); err != nil {
+				c.t.Fatal(err)
+			}
+			c.diagnostics[uriToPath(p.URI)] = p.Diagnostics
+		}
+		return msg
+	case <-time.After(10 * time.Second):
+		c.t.Fatal("Timed out waiting for message")
+	}
+	return nil
+}
+
+func (c *testClient) initialize(root string) {
+	c.t.Helper()
+	var result InitializeResult
+	if err := c.request("initialize", InitializeParams{RootURI: pathToURI(root)}, &result); err != nil {
+		c.t.Fatal(err)
+	}
+	c.notify("initialized", map[string]interface{}{})
+}
+
+func (c *testClient) exit() error {
+	c.t.Helper()
+	if err := c.stream.Write(jsonrpc.NewNotification("exit", nil)); err != nil {
+		c.t.Fatal(err)
+	}
+	select {
+	case err := <-c.done:
+		return err
+	case <-time.After(10 * time.Second):
+		c.t.Fatal("Timed out waiting for exit")
+	}
+	return nil
+}
+
+func mustMarshal(x interface{}) []byte {
+	bs, err := json.Marshal(x)
+	if err != nil {
+		panic(err)
+	}
+	return bs
+}
+
+func positionParams(uri string, pos Position) TextDocumentPositionParams {
+	return TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: pos}
+}
+
+func TestLifecycle(t *testing.T) {
+
+	c := newTestClient(t)
+
+	if err := c.request("textDocument/hover", positionParams("file:///x.rego", Position{}), nil); err == nil || err.Code != codeServerNotInitialized {
+		t.Fatalf("Expected not initialized error but got %v", err)
+	}
+
+	var result InitializeResult
+	if err := c.request("initialize", InitializeParams{}, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Capabilities.HoverProvider || result.Capabilities.TextDocumentSync.Change != TextDocumentSyncKindIncremental {
+		t.Fatalf("Unexpected capabilities: %+v", result.Capabilities)
+	}
+
+	if err := c.request("textDocument/unknown", nil, nil); err == nil || err.Code != jsonrpc.CodeMethodNotFound {
+		t.Fatalf("Expected method not found error but got %v", err)
+	}
+
+	if err := c.request("shutdown", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.exit(); err != nil {
+		t.Fatal(err)
+	}
+
+	c = newTestClient(t)
+
+	if err := c.exit(); err != ErrExitWithoutShutdown {
+		t.Fatalf("Expected exit without shutdown error but got %v", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+
+	files := map[string]string{
+		"a.rego": "package a\n\np { q }\nq = true\n",
+		"b.rego": "package b\n\nr { data.a.q + 1 }\n",
+	}
+
+	test.WithTempFS(files, func(root string) {
+
+		c := newTestClient(t)
+		c.initialize(root)
+
+		a, b := filepath.Join(root, "a.rego"), filepath.Join(root, "b.rego")
+
+		// The workspace is compiled after initialization.
+		if diags := c.diagnostics[b]; len(diags) != 1 || diags[0].Code != "rego_type_error" || diags[0].Range.Start != (Position{2, 4}) {
+			t.Fatalf("Expected type error in b.rego but got %+v", c.diagnostics)
+		}
+
+		if _, ok := c.diagnostics[a]; ok {
+			t.Fatalf("Expected no diagnostics for a.rego but got %+v", c.diagnostics[a])
+		}
+
+		c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{
+			URI:  pathToURI(a),
+			Text: files["a.rego"],
+		}})
+
+		// Changing q to a number fixes b.rego. Changing p breaks a.rego.
+		c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+			TextDocument: VersionedTextDocumentIdentifier{URI: pathToURI(a), Version: 2},
+			ContentChanges: []TextDocumentContentChangeEvent{
+				{Range: &Range{Start: Position{3, 4}, End: Position{3, 8}}, Text: "1"},
+				{Range: &Range{Start: Position{2, 0}, End: Position{2, 0}}, Text: "p {"},
+			},
+		})
+
+		if diags := c.diagnostics[a]; len(diags) == 0 || diags[0].Code != "rego_parse_error" {
+			t.Fatalf("Expected parse error in a.rego but got %+v", c.diagnostics)
+		}
+
+		c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+			TextDocument: VersionedTextDocumentIdentifier{URI: pathToURI(a), Version: 3},
+			ContentChanges: []TextDocumentContentChangeEvent{
+				{Range: &Range{Start: Position{2, 0}, End: Position{2, 3}}, Text: ""},
+			},
+		})
+
+		if diags := c.diagnostics[a]; len(diags) != 0 {
+			t.Fatalf("Expected parse error to be cleared but got %+v", diags)
+		}
+
+		if diags := c.diagnostics[b]; len(diags) != 0 {
+			t.Fatalf("Expected type error to be cleared but got %+v", diags)
+		}
+
+		// Closing the document reverts it to the file on disk.
+		c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: pathToURI(a)}})
+
+		if diags := c.diagnostics[b]; len(diags) != 1 {
+			t.Fatalf("Expected type error in b.rego but got %+v", c.diagnostics)
+		}
+	})
+}
+
+const (
+	testModuleA = `package a
+
+import data.b.allowed
+
+p {
+	q[x]
+	allowed[x]
+	is_string(x)
+}
+
+q = {"x", "y"}
+
+f(x) = y {
+	y := x + 1
+}
+`
+	testModuleB = `package b
+
+allowed = {"x"}
+
+r { data.a.q["x"] }
+`
+)
+
+func withTestWorkspace(t *testing.T, f func(c *testClient, a, b string)) {
+	files := map[string]string{
+		"a.rego": testModuleA,
+		"b.rego": testModuleB,
+	}
+	test.WithTempFS(files, func(root string) {
+		c := newTestClient(t)
+		c.initialize(root)
+		f(c, pathToURI(filepath.Join(root, "a.rego")), pathToURI(filepath.Join(root, "b.rego")))
+	})
+}
+
+func TestDefinitionAndReferences(t *testing.T) {
+	withTestWorkspace(t, func(c *testClient, a, b string) {
+
+		var loc *Location
+		if err := c.request("textDocument/definition", positionParams(a, Position{5, 1}), &loc); err != nil {
+			t.Fatal(err)
+		}
+
+		if loc == nil || loc.URI != a || loc.Range.Start != (Position{10, 0}) {
+			t.Fatalf("Expected definition of q but got %+v", loc)
+		}
+
+		if err := c.request("textDocument/definition", positionParams(a, Position{6, 2}), &loc); err != nil {
+			t.Fatal(err)
+		}
+
+		if loc == nil || loc.URI != b || loc.Range.Start != (Position{2, 0}) {
+			t.Fatalf("Expected definition of allowed but got %+v", loc)
+		}
+
+		var locs []Location
+		params := ReferenceParams{TextDocumentPositionParams: positionParams(a, Position{10, 0})}
+		params.Context.IncludeDeclaration = true
+
+		if err := c.request("textDocument/references", params, &locs); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, l := range locs {
+			got = append(got, filepath.Base(uriToPath(l.URI))+":"+string(mustMarshal(l.Range.Start)))
+		}
+
+		exp := []string{
+			`a.rego:{"line":10,"character":0}`,
+			`a.rego:{"line":5,"character":1}`,
+			`b.rego:{"line":4,"character":4}`,
+		}
+
+		if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("Expected %v but got %v", exp, got)
+		}
+
+		// No definition is found for positions without symbols.
+		if err := c.request("textDocument/definition", positionParams(a, Position{1, 0}), &loc); err != nil || loc != nil {
+			t.Fatalf("Expected null result but got %v (err: %v)", loc, err)
+		}
+	})
+}
+
+func TestHover(t *testing.T) {
+	withTestWorkspace(t, func(c *testClient, a, b string) {
+
+		tests := []struct {
+			note string
+			pos  Position
+			exp  string
+		}{
+			{"rule name", Position{10, 0}, "data.a.q: set[string]"},
+			{"rule reference", Position{5, 1}, "data.a.q: set[string]"},
+			{"imported rule", Position{6, 1}, "data.b.allowed: set[string]"},
+			{"function", Position{12, 0}, "data.a.f: number => number"},
+			{"builtin", Position{7, 2}, "is_string: any => boolean"},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.note, func(t *testing.T) {
+				var hover *Hover
+				if err := c.request("textDocument/hover", positionParams(a, tc.pos), &hover); err != nil {
+					t.Fatal(err)
+				}
+				if hover == nil || !strings.Contains(hover.Contents.Value, tc.exp) {
+					t.Fatalf("Expected hover with %q but got %+v", tc.exp, hover)
+				}
+			})
+		}
+	})
+}
+
+func TestCompletion(t *testing.T) {
+	withTestWorkspace(t, func(c *testClient, a, b string) {
+
+		c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{
+			URI:  b,
+			Text: testModuleB + "\nimport data.\ns { con }\nt { allo }\nu { data.a. }\n",
+		}})
+
+		tests := []struct {
+			note    string
+			pos     Position
+			include []string
+			exclude []string
+		}{
+			{"import", Position{6, 12}, []string{"data.a", "data.b"}, []string{"data.a.q", "count"}},
+			{"builtin", Position{7, 7}, []string{"concat", "contains"}, []string{"count", "allowed"}},
+			{"local rule", Position{8, 8}, []string{"allowed"}, []string{"data.b.allowed"}},
+			{"ref", Position{9, 11}, []string{"data.a.p", "data.a.q", "data.a.f"}, []string{"data.b.r"}},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.note, func(t *testing.T) {
+				var list CompletionList
+				if err := c.request("textDocument/completion", positionParams(b, tc.pos), &list); err != nil {
+					t.Fatal(err)
+				}
+				labels := map[string]bool{}
+				for _, item := range list.Items {
+					labels[item.Label] = true
+				}
+				for _, l := range tc.include {
+					if !labels[l] {
+						t.Errorf("Expected completion %q in %v", l, list.Items)
+					}
+				}
+				for _, l := range tc.exclude {
+					if labels[l] {
+						t.Errorf("Unexpected completion %q", l)
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestDocumentSymbol(t *testing.T) {
+	withTestWorkspace(t, func(c *testClient, a, b string) {
+
+		var symbols []DocumentSymbol
+		if err := c.request("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: a}}, &symbols); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(symbols) != 1 || symbols[0].Name != "a" || symbols[0].Kind != SymbolKindPackage {
+			t.Fatalf("Expected package symbol but got %+v", symbols)
+		}
+
+		var got []string
+		for _, s := range symbols[0].Children {
+			got = append(got, s.Name+" "+s.Detail)
+		}
+
+		sort.Strings(got)
+
+		exp := []string{"f number => number", "p boolean", "q set[string]"}
+
+		if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("Expected %v but got %v", exp, got)
+		}
+
+		f := symbols[0].Children[2]
+		if f.Kind != SymbolKindFunction || f.SelectionRange != (Range{Position{12, 0}, Position{12, 1}}) {
+			t.Fatalf("Unexpected function symbol: %+v", f)
+		}
+	})
+}
+
+func TestFormatting(t *testing.T) {
+	withTestWorkspace(t, func(c *testClient, a, b string) {
+
+		c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{
+			URI:  b,
+			Text: "package b\nallowed={\"x\"}",
+		}})
+
+		var edits []TextEdit
+		if err := c.request("textDocument/formatting", DocumentFormattingParams{TextDocument: TextDocumentIdentifier{URI: b}}, &edits); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(edits) != 1 || edits[0].NewText != "package b\n\nallowed = {\"x\"}\n" || edits[0].Range.End != (Position{1, 13}) {
+			t.Fatalf("Unexpected edits: %+v", edits)
+		}
+
+		if err := c.request("textDocument/formatting", DocumentFormattingParams{TextDocument: TextDocumentIdentifier{URI: a}}, &edits); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(edits) != 0 {
+			t.Fatalf("Expected no edits for formatted document but got %+v", edits)
+		}
+	})
+}
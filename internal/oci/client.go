@@ -0,0 +1,381 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/open-policy-agent/opa/plugins/rest"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// Client implements the parts of the OCI distribution API that are needed to
+// push and pull bundles. Requests are sent with the REST client, so the
+// credentials and TLS settings of the service apply. If the registry replies
+// with a bearer token challenge, the client requests a token from the token
+// service named in the challenge with the credentials of the service and
+// retries the request with the token. Requests that carry the token are sent
+// without the bearer and signing credentials of the service.
+type Client struct {
+	client      rest.Client
+	tokenClient *rest.Client
+	token       string
+}
+
+// NewClient returns a new Client that sends requests to the registry the REST
+// client is configured for.
+func NewClient(client rest.Client) *Client {
+	return &Client{client: client}
+}
+
+// FetchManifest fetches the manifest of the reference and returns it with
+// its digest. If the digest equals etag, the manifest is not modified and
+// nil is returned.
+func (c *Client) FetchManifest(ctx context.Context, ref Reference, etag string) (*Manifest, string, error) {
+
+	headers := map[string]string{"Accept": ManifestMediaType}
+
+	resp, err := c.do(ctx, "GET", fmt.Sprintf("/v2/%v/manifests/%v", ref.Repository, ref.Name()), headers, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer util.Close(resp)
+
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return nil, "", errors.Wrap(err, "manifest request failed")
+	}
+
+	if mt := resp.Header.Get("Content-Type"); mt != "" && mt != ManifestMediaType {
+		return nil, "", fmt.Errorf("unsupported manifest media type %v", mt)
+	}
+
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	digest := Digest(bs)
+
+	if ref.Digest != "" && ref.Digest != digest {
+		return nil, "", fmt.Errorf("manifest digest %v does not match reference digest %v", digest, ref.Digest)
+	}
+
+	if digest == etag {
+		return nil, digest, nil
+	}
+
+	var manifest Manifest
+
+	if err := util.UnmarshalJSON(bs, &manifest); err != nil {
+		return nil, "", errors.Wrap(err, "manifest decode failed")
+	}
+
+	return &manifest, digest, nil
+}
+
+// FetchBlob fetches the blob described by desc. At most limit bytes are read
+// and the digest of the content is verified.
+func (c *Client) FetchBlob(ctx context.Context, ref Reference, desc Descriptor, limit int64) ([]byte, error) {
+
+	if desc.Size >= limit {
+		return nil, fmt.Errorf("blob %v exceeds the size limit (%v bytes)", desc.Digest, limit-1)
+	}
+
+	resp, err := c.do(ctx, "GET", fmt.Sprintf("/v2/%v/blobs/%v", ref.Repository, desc.Digest), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer util.Close(resp)
+
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return nil, errors.Wrap(err, "blob request failed")
+	}
+
+	bs, err := ioutil.ReadAll(io.LimitReader(resp.Body, desc.Size+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(bs)) != desc.Size || Digest(bs) != desc.Digest {
+		return nil, fmt.Errorf("blob %v does not match its descriptor", desc.Digest)
+	}
+
+	return bs, nil
+}
+
+// Push uploads the blobs of the artifact and tags the manifest with the tag
+// of the reference. Blobs that exist in the repository are not uploaded.
+func (c *Client) Push(ctx context.Context, ref Reference, a *Artifact) error {
+
+	if ref.Tag == "" {
+		return fmt.Errorf("invalid reference %v: pushing requires a tag", ref)
+	}
+
+	for _, blob := range []struct {
+		desc    Descriptor
+		content []byte
+	}{
+		{a.Config, a.ConfigBytes},
+		{a.Bundle, a.BundleBytes},
+	} {
+		if err := c.pushBlob(ctx, ref, blob.desc, blob.content); err != nil {
+			return err
+		}
+	}
+
+	headers := map[string]string{"Content-Type": ManifestMediaType}
+
+	resp, err := c.do(ctx, "PUT", fmt.Sprintf("/v2/%v/manifests/%v", ref.Repository, ref.Tag), headers, a.ManifestBytes)
+	if err != nil {
+		return err
+	}
+
+	defer util.Close(resp)
+
+	return errors.Wrap(checkStatus(resp, http.StatusCreated), "manifest upload failed")
+}
+
+func (c *Client) pushBlob(ctx context.Context, ref Reference, desc Descriptor, content []byte) error {
+
+	resp, err := c.do(ctx, "HEAD", fmt.Sprintf("/v2/%v/blobs/%v", ref.Repository, desc.Digest), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	util.Close(resp)
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = c.do(ctx, "POST", fmt.Sprintf("/v2/%v/blobs/uploads/", ref.Repository), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	util.Close(resp)
+
+	if err := checkStatus(resp, http.StatusAccepted); err != nil {
+		return errors.Wrap(err, "blob upload failed")
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return errors.Wrap(err, "blob upload failed")
+	}
+
+	query := location.Query()
+	query.Set("digest", desc.Digest)
+	location.RawQuery = query.Encode()
+
+	headers := map[string]string{"Content-Type": "application/octet-stream"}
+
+	resp, err = c.doURL(ctx, "PUT", location, headers, content)
+	if err != nil {
+		return err
+	}
+
+	defer util.Close(resp)
+
+	return errors.Wrap(checkStatus(resp, http.StatusCreated), "blob upload failed")
+}
+
+func (c *Client) do(ctx context.Context, method, path string, headers map[string]string, body []byte) (*http.Response, error) {
+	u, err := url.Parse(c.client.Config().URL + path)
+	if err != nil {
+		return nil, err
+	}
+	return c.doURL(ctx, method, u, headers, body)
+}
+
+// doURL sends the request to the absolute URL. If the registry replies with a
+// bearer token challenge, a token is requested and the request is retried.
+func (c *Client) doURL(ctx context.Context, method string, u *url.URL, headers map[string]string, body []byte) (*http.Response, error) {
+
+	resp, err := c.send(ctx, method, u, headers, body)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return resp, nil
+	}
+
+	util.Close(resp)
+
+	if err := c.fetchToken(ctx, parseChallenge(challenge[len("bearer "):])); err != nil {
+		return nil, err
+	}
+
+	return c.send(ctx, method, u, headers, body)
+}
+
+func (c *Client) send(ctx context.Context, method string, u *url.URL, headers map[string]string, body []byte) (*http.Response, error) {
+
+	path := u.EscapedPath()
+
+	// The REST client trims trailing slashes from the path but the upload
+	// endpoint requires one. An empty query keeps it.
+	if u.RawQuery != "" || strings.HasSuffix(path, "/") {
+		path += "?" + u.RawQuery
+	}
+
+	client := c.client
+
+	if c.token != "" {
+		client = c.tokenClient.WithHeader("Authorization", "Bearer "+c.token)
+	}
+
+	client = client.WithURL(u.Scheme + "://" + u.Host)
+
+	for k, v := range headers {
+		client = client.WithHeader(k, v)
+	}
+
+	if body != nil {
+		client = client.WithBytes(body)
+	}
+
+	return client.Do(ctx, method, path)
+}
+
+// fetchToken requests a token from the token service described by the
+// challenge parameters with the credentials of the service.
+func (c *Client) fetchToken(ctx context.Context, params map[string]string) error {
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme == "" || realm.Host == "" {
+		return fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	// The token request must not carry an expired token.
+	c.token = ""
+
+	resp, err := c.send(ctx, "GET", realm, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "token request failed")
+	}
+
+	defer util.Close(resp)
+
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return errors.Wrap(err, "token request failed")
+	}
+
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := util.NewJSONDecoder(resp.Body).Decode(&result); err != nil {
+		return errors.Wrap(err, "token decode failed")
+	}
+
+	token := result.Token
+	if token == "" {
+		token = result.AccessToken
+	}
+
+	if token == "" {
+		return fmt.Errorf("token service replied without a token")
+	}
+
+	if c.tokenClient == nil {
+		tokenClient, err := newTokenClient(c.client)
+		if err != nil {
+			return err
+		}
+		c.tokenClient = &tokenClient
+	}
+
+	c.token = token
+
+	return nil
+}
+
+// newTokenClient returns a REST client for requests that carry a registry
+// token. The bearer and signing credentials of the service would conflict
+// with the token, so they are removed. Client TLS credentials are kept.
+func newTokenClient(client rest.Client) (rest.Client, error) {
+
+	config := *client.Config()
+	config.Credentials.Bearer = nil
+	config.Credentials.S3Signing = nil
+
+	bs, err := json.Marshal(config)
+	if err != nil {
+		return rest.Client{}, err
+	}
+
+	return rest.New(bs)
+}
+
+// parseChallenge parses the comma separated key="value" parameters of a
+// WWW-Authenticate challenge.
+func parseChallenge(s string) map[string]string {
+
+	params := map[string]string{}
+
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		i := strings.Index(s, "=")
+		if i < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = s[i+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			j := strings.Index(s[1:], `"`)
+			if j < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:j+1], s[j+2:]
+			}
+		} else if j := strings.Index(s, ","); j >= 0 {
+			value, s = s[:j], s[j:]
+		} else {
+			value, s = s, ""
+		}
+
+		params[key] = value
+	}
+
+	return params
+}
+
+func checkStatus(resp *http.Response, expected int) error {
+	switch resp.StatusCode {
+	case expected:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("server replied with not found")
+	case http.StatusUnauthorized:
+		return fmt.Errorf("server replied with not authorized")
+	default:
+		return fmt.Errorf("server replied with HTTP %v", resp.StatusCode)
+	}
+}
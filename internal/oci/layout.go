@@ -0,0 +1,78 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package oci
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/open-policy-agent/opa/util"
+)
+
+const layoutVersion = `{"imageLayoutVersion":"1.0.0"}`
+
+// WriteLayout writes the artifact to the OCI image layout in dir and tags
+// the manifest with tag. The directory is created if it does not exist. If
+// the layout already contains a manifest with the same tag, it is replaced in
+// the index. Other manifests and blobs are kept.
+func WriteLayout(dir string, tag string, a *Artifact) error {
+
+	blobs := filepath.Join(dir, "blobs", "sha256")
+
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "oci-layout"), []byte(layoutVersion), 0644); err != nil {
+		return err
+	}
+
+	for _, blob := range []struct {
+		desc    Descriptor
+		content []byte
+	}{
+		{a.Config, a.ConfigBytes},
+		{a.Bundle, a.BundleBytes},
+		{a.Manifest, a.ManifestBytes},
+	} {
+		path := filepath.Join(blobs, strings.TrimPrefix(blob.desc.Digest, "sha256:"))
+		if err := ioutil.WriteFile(path, blob.content, 0644); err != nil {
+			return err
+		}
+	}
+
+	index := Index{SchemaVersion: 2, MediaType: IndexMediaType}
+	indexPath := filepath.Join(dir, "index.json")
+
+	if bs, err := ioutil.ReadFile(indexPath); err == nil {
+		if err := util.UnmarshalJSON(bs, &index); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	manifests := index.Manifests[:0]
+
+	for _, m := range index.Manifests {
+		if m.Annotations[AnnotationRefName] != tag {
+			manifests = append(manifests, m)
+		}
+	}
+
+	desc := a.Manifest
+	desc.Annotations = map[string]string{AnnotationRefName: tag}
+	index.Manifests = append(manifests, desc)
+
+	bs, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(indexPath, bs, 0644)
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package oci implements the parts of the OCI image and distribution
+// specifications that are needed to store bundles as OCI artifacts.
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Media types of the OCI artifacts that contain bundles.
+const (
+	ManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	IndexMediaType       = "application/vnd.oci.image.index.v1+json"
+	ConfigMediaType      = "application/vnd.oci.image.config.v1+json"
+	BundleLayerMediaType = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// Annotations set on the OCI artifacts that contain bundles.
+const (
+	AnnotationTitle   = "org.opencontainers.image.title"
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+)
+
+// DefaultTag is the tag used if a reference contains neither a tag nor a
+// digest.
+const DefaultTag = "latest"
+
+// Descriptor describes the content of a blob.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest represents an OCI image manifest.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Index represents an OCI image index.
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// BundleLayer returns the descriptor of the layer that contains the bundle.
+func (m Manifest) BundleLayer() (Descriptor, error) {
+	for _, layer := range m.Layers {
+		if layer.MediaType == BundleLayerMediaType {
+			return layer, nil
+		}
+	}
+	return Descriptor{}, fmt.Errorf("manifest does not contain a layer of type %v", BundleLayerMediaType)
+}
+
+// Artifact contains the blobs of an OCI artifact that stores a bundle.
+type Artifact struct {
+	Manifest      Descriptor
+	ManifestBytes []byte
+	Config        Descriptor
+	ConfigBytes   []byte
+	Bundle        Descriptor
+	BundleBytes   []byte
+}
+
+// NewArtifact returns the OCI artifact for the gzipped bundle tarball.
+func NewArtifact(bundle []byte) (*Artifact, error) {
+
+	a := &Artifact{
+		ConfigBytes: []byte("{}"),
+		BundleBytes: bundle,
+	}
+
+	a.Config = NewDescriptor(ConfigMediaType, a.ConfigBytes)
+	a.Bundle = NewDescriptor(BundleLayerMediaType, a.BundleBytes)
+	a.Bundle.Annotations = map[string]string{AnnotationTitle: "bundle.tar.gz"}
+
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		Config:        a.Config,
+		Layers:        []Descriptor{a.Bundle},
+	}
+
+	bs, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	a.ManifestBytes = bs
+	a.Manifest = NewDescriptor(ManifestMediaType, bs)
+
+	return a, nil
+}
+
+// NewDescriptor returns a descriptor for the content.
+func NewDescriptor(mediaType string, content []byte) Descriptor {
+	return Descriptor{
+		MediaType: mediaType,
+		Digest:    Digest(content),
+		Size:      int64(len(content)),
+	}
+}
+
+// Digest returns the sha256 digest of the content.
+func Digest(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+// Reference identifies an artifact in a repository by tag or digest.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+var (
+	repositoryRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagRegexp        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	digestRegexp     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// ParseReference parses a reference of the form
+// [registry/]repository[:tag|@digest]. The registry is only parsed if
+// withRegistry is true, in which case it is required. If the reference
+// contains neither a tag nor a digest, the tag defaults to DefaultTag.
+func ParseReference(s string, withRegistry bool) (Reference, error) {
+
+	var ref Reference
+	rest := strings.Trim(s, "/")
+
+	if withRegistry {
+		i := strings.Index(rest, "/")
+		if i < 0 {
+			return ref, fmt.Errorf("invalid reference %q: missing registry", s)
+		}
+		ref.Registry, rest = rest[:i], rest[i+1:]
+	}
+
+	if i := strings.Index(rest, "@"); i >= 0 {
+		ref.Digest, rest = rest[i+1:], rest[:i]
+		if !digestRegexp.MatchString(ref.Digest) {
+			return ref, fmt.Errorf("invalid reference %q: invalid digest", s)
+		}
+	} else if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.Contains(rest[i:], "/") {
+		ref.Tag, rest = rest[i+1:], rest[:i]
+		if !tagRegexp.MatchString(ref.Tag) {
+			return ref, fmt.Errorf("invalid reference %q: invalid tag", s)
+		}
+	} else {
+		ref.Tag = DefaultTag
+	}
+
+	if !repositoryRegexp.MatchString(rest) {
+		return ref, fmt.Errorf("invalid reference %q: invalid repository", s)
+	}
+
+	ref.Repository = rest
+
+	return ref, nil
+}
+
+// Name returns the tag or digest of the reference used to fetch the
+// manifest.
+func (r Reference) Name() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r Reference) String() string {
+	var sb strings.Builder
+	if r.Registry != "" {
+		sb.WriteString(r.Registry)
+		sb.WriteString("/")
+	}
+	sb.WriteString(r.Repository)
+	if r.Digest != "" {
+		sb.WriteString("@")
+		sb.WriteString(r.Digest)
+	} else {
+		sb.WriteString(":")
+		sb.WriteString(r.Tag)
+	}
+	return sb.String()
+}
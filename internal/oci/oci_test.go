@@ -0,0 +1,189 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package oci_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/internal/oci"
+	"github.com/open-policy-agent/opa/internal/oci/ocitest"
+	"github.com/open-policy-agent/opa/plugins/rest"
+	"github.com/open-policy-agent/opa/util"
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestParseReference(t *testing.T) {
+
+	digest := "sha256:" + strings.Repeat("ab", 32)
+
+	tests := []struct {
+		input        string
+		withRegistry bool
+		exp          oci.Reference
+		err          string
+	}{
+		{input: "policies/authz", exp: oci.Reference{Repository: "policies/authz", Tag: "latest"}},
+		{input: "/policies/authz:v1", exp: oci.Reference{Repository: "policies/authz", Tag: "v1"}},
+		{input: "authz@" + digest, exp: oci.Reference{Repository: "authz", Digest: digest}},
+		{input: "localhost:5000/policies/authz:v1.2", withRegistry: true, exp: oci.Reference{Registry: "localhost:5000", Repository: "policies/authz", Tag: "v1.2"}},
+		{input: "ghcr.io/authz", withRegistry: true, exp: oci.Reference{Registry: "ghcr.io", Repository: "authz", Tag: "latest"}},
+		{input: "authz", withRegistry: true, err: "missing registry"},
+		{input: "Authz", err: "invalid repository"},
+		{input: "authz:-x", err: "invalid tag"},
+		{input: "authz@sha256:x", err: "invalid digest"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			ref, err := oci.ParseReference(tc.input, tc.withRegistry)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected error containing %q but got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ref != tc.exp {
+				t.Fatalf("Expected %+v but got %+v", tc.exp, ref)
+			}
+		})
+	}
+}
+
+func TestWriteLayout(t *testing.T) {
+
+	test.WithTempFS(nil, func(root string) {
+
+		a1, err := oci.NewArtifact([]byte("bundle1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a2, err := oci.NewArtifact([]byte("bundle2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, w := range []struct {
+			tag string
+			a   *oci.Artifact
+		}{{"v1", a1}, {"latest", a1}, {"latest", a2}} {
+			if err := oci.WriteLayout(root, w.tag, w.a); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		bs, err := ioutil.ReadFile(filepath.Join(root, "index.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var index oci.Index
+		if err := util.UnmarshalJSON(bs, &index); err != nil {
+			t.Fatal(err)
+		}
+
+		refs := map[string]string{}
+		for _, m := range index.Manifests {
+			refs[m.Annotations[oci.AnnotationRefName]] = m.Digest
+		}
+
+		if exp := map[string]string{"v1": a1.Manifest.Digest, "latest": a2.Manifest.Digest}; !reflect.DeepEqual(refs, exp) {
+			t.Fatalf("Expected refs %v but got %v", exp, refs)
+		}
+
+		bs, err = ioutil.ReadFile(filepath.Join(root, "blobs", "sha256", strings.TrimPrefix(a2.Bundle.Digest, "sha256:")))
+		if err != nil || string(bs) != "bundle2" {
+			t.Fatalf("Unexpected bundle blob %q: %v", bs, err)
+		}
+	})
+}
+
+func TestClientPushFetch(t *testing.T) {
+
+	ctx := context.Background()
+	registry := ocitest.New()
+	registry.Credentials = "Bearer secret"
+	defer registry.Server.Close()
+
+	config := fmt.Sprintf(`{"url": %q, "credentials": {"bearer": {"token": "secret"}}}`, registry.Server.URL)
+
+	rc, err := rest.New([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := oci.NewClient(rc)
+	ref := oci.Reference{Repository: "policies/authz", Tag: "v1"}
+
+	a, err := oci.NewArtifact([]byte("bundle"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Push(ctx, ref, a); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blobs that exist are not uploaded again.
+	if err := client.Push(ctx, oci.Reference{Repository: "policies/authz", Tag: "v2"}, a); err != nil {
+		t.Fatal(err)
+	}
+
+	uploads := 0
+	for _, r := range registry.Requests() {
+		if strings.HasPrefix(r, "PUT /v2/policies/authz/blobs/uploads/") {
+			uploads++
+		}
+	}
+
+	if uploads != 2 {
+		t.Fatalf("Expected 2 blob uploads but got %d: %v", uploads, registry.Requests())
+	}
+
+	manifest, digest, err := client.FetchManifest(ctx, ref, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if digest != a.Manifest.Digest {
+		t.Fatalf("Expected digest %v but got %v", a.Manifest.Digest, digest)
+	}
+
+	layer, err := manifest.BundleLayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := client.FetchBlob(ctx, ref, layer, 1024)
+	if err != nil || string(bs) != "bundle" {
+		t.Fatalf("Unexpected blob %q: %v", bs, err)
+	}
+
+	if _, err := client.FetchBlob(ctx, ref, layer, 4); err == nil || !strings.Contains(err.Error(), "exceeds the size limit") {
+		t.Fatal("Expected size limit error but got:", err)
+	}
+
+	if manifest, _, err := client.FetchManifest(ctx, ref, digest); manifest != nil || err != nil {
+		t.Fatalf("Expected manifest not to be modified but got %v %v", manifest, err)
+	}
+
+	rc, err = rest.New([]byte(fmt.Sprintf(`{"url": %q}`, registry.Server.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := oci.NewClient(rc).FetchManifest(ctx, ref, ""); err == nil || !strings.Contains(err.Error(), "token request failed: server replied with not authorized") {
+		t.Fatal("Expected token error but got:", err)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package ocitest implements an in-memory OCI registry for tests.
+package ocitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/opa/internal/oci"
+)
+
+// Registry is an in-memory registry that implements the parts of the OCI
+// distribution API used by the oci package. If Credentials is set, the
+// registry requires a token that its token service only issues to clients
+// that present the credentials in the Authorization header.
+type Registry struct {
+	Server      *httptest.Server
+	Credentials string
+
+	mtx       sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string]map[string][]byte
+	uploads   int
+	requests  []string
+}
+
+const token = "registry-token"
+
+// New returns a new Registry. The caller must close the server.
+func New() *Registry {
+	r := &Registry{
+		blobs:     map[string][]byte{},
+		manifests: map[string]map[string][]byte{},
+	}
+	r.Server = httptest.NewServer(r)
+	return r
+}
+
+// Host returns the host and port of the registry.
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.Server.URL, "http://")
+}
+
+// Push stores the artifact in the repository and tags it.
+func (r *Registry) Push(repository, tag string, a *oci.Artifact) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.blobs[a.Config.Digest] = a.ConfigBytes
+	r.blobs[a.Bundle.Digest] = a.BundleBytes
+	r.putManifest(repository, tag, a.ManifestBytes)
+}
+
+// Manifest returns the manifest tagged in the repository.
+func (r *Registry) Manifest(repository, tag string) []byte {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.manifests[repository][tag]
+}
+
+// Requests returns the method and path of the requests served so far.
+func (r *Registry) Requests() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]string(nil), r.requests...)
+}
+
+func (r *Registry) putManifest(repository, ref string, bs []byte) {
+	if r.manifests[repository] == nil {
+		r.manifests[repository] = map[string][]byte{}
+	}
+	r.manifests[repository][ref] = bs
+	r.manifests[repository][oci.Digest(bs)] = bs
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+
+	if req.URL.Path == "/token" {
+		if len(req.Header["Authorization"]) != 1 || req.Header.Get("Authorization") != r.Credentials {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
+		return
+	}
+
+	if r.Credentials != "" && (len(req.Header["Authorization"]) != 1 || req.Header.Get("Authorization") != "Bearer "+token) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%v/token",service="test",scope="repository:x:pull"`, r.Server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+
+	switch {
+	case strings.HasSuffix(path, "/blobs/uploads/"):
+		r.uploads++
+		w.Header().Set("Location", fmt.Sprintf("/v2/%vblobs/uploads/%d?state=x", strings.TrimSuffix(path, "blobs/uploads/"), r.uploads))
+		w.WriteHeader(http.StatusAccepted)
+
+	case strings.Contains(path, "/blobs/uploads/"):
+		if req.Method != http.MethodPut || req.URL.Query().Get("state") != "x" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		bs, _ := ioutil.ReadAll(req.Body)
+		digest := req.URL.Query().Get("digest")
+		if oci.Digest(bs) != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[digest] = bs
+		w.WriteHeader(http.StatusCreated)
+
+	case strings.Contains(path, "/blobs/"):
+		digest := path[strings.LastIndex(path, "/")+1:]
+		bs, ok := r.blobs[digest]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			w.Write(bs)
+		}
+
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		repository, ref := path[:i], path[i+len("/manifests/"):]
+		switch req.Method {
+		case http.MethodPut:
+			bs, _ := ioutil.ReadAll(req.Body)
+			r.putManifest(repository, ref, bs)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			bs, ok := r.manifests[repository][ref]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", oci.ManifestMediaType)
+			w.Header().Set("Docker-Content-Digest", oci.Digest(bs))
+			w.WriteHeader(http.StatusOK)
+			w.Write(bs)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -2,6 +2,7 @@ package oracle
 
 import (
 	"errors"
+	"sort"
 
 	"github.com/open-policy-agent/opa/ast"
 )
@@ -106,6 +107,145 @@ func (o *Oracle) FindDefinition(q DefinitionQuery) (*DefinitionQueryResult, erro
 	return nil, ErrNoDefinitionFound
 }
 
+// ReferencesQuery defines a Rego references query.
+type ReferencesQuery struct {
+	Filename          string                 // name of file to search for position inside of
+	Pos               int                    // position to search for
+	Modules           map[string]*ast.Module // workspace modules; buffer may shadow a file inside the workspace
+	Buffer            []byte                 // buffer that overrides module with filename
+	IncludeDefinition bool                   // include the locations of definitions in the result
+}
+
+// ReferencesQueryResult defines output of a references query.
+type ReferencesQueryResult struct {
+	Result []*ast.Location `json:"result"`
+}
+
+// FindReferences returns the locations of the references to the symbol at the
+// position in q. References to rules are searched for in all modules.
+// References to variables are searched for in the rule that contains the
+// position.
+func (o *Oracle) FindReferences(q ReferencesQuery) (*ReferencesQueryResult, error) {
+
+	compiler, parsed, err := compileUpto("SetRuleTree", q.Modules, q.Buffer, q.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	stack := findContainingNodeStack(compiler.Modules[q.Filename], q.Pos)
+	if len(stack) == 0 {
+		return nil, ErrNoMatchFound
+	}
+
+	if path := findRulePath(compiler, stack, q.Pos); path != nil {
+		// The compiler drops imports after resolving references so they are
+		// taken from the parsed modules.
+		imports := map[string][]*ast.Import{q.Filename: parsed.Imports}
+		for filename, module := range q.Modules {
+			if filename != q.Filename {
+				imports[filename] = module.Imports
+			}
+		}
+		return &ReferencesQueryResult{findRuleReferences(compiler, imports, path, q.IncludeDefinition)}, nil
+	}
+
+	top := stack[len(stack)-1]
+	if term, ok := top.(*ast.Term); ok {
+		if name, ok := term.Value.(ast.Var); ok {
+			for i := 0; i < len(stack); i++ {
+				if rule, ok := stack[i].(*ast.Rule); ok {
+					return &ReferencesQueryResult{findVarReferences(rule, name, q.IncludeDefinition)}, nil
+				}
+			}
+		}
+	}
+
+	return nil, ErrNoDefinitionFound
+}
+
+// findRulePath returns the path of the rule referred to or defined at pos.
+func findRulePath(compiler *ast.Compiler, stack []ast.Node, pos int) ast.Ref {
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch node := stack[i].(type) {
+		case *ast.Term:
+			if ref, ok := node.Value.(ast.Ref); ok {
+				if rules := compiler.GetRulesExact(ref.ConstantPrefix()); len(rules) > 0 {
+					return rules[0].Path()
+				}
+			}
+		case *ast.Head:
+			if loc := node.Location; loc != nil && i > 0 && pos < loc.Offset+len(node.Name) {
+				if rule, ok := stack[i-1].(*ast.Rule); ok {
+					return rule.Path()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func findRuleReferences(compiler *ast.Compiler, imports map[string][]*ast.Import, path ast.Ref, includeDefinition bool) []*ast.Location {
+
+	var result []*ast.Location
+
+	if includeDefinition {
+		for _, rule := range compiler.GetRulesExact(path) {
+			result = append(result, rule.Location)
+		}
+	}
+
+	filenames := make([]string, 0, len(compiler.Modules))
+	for filename := range compiler.Modules {
+		filenames = append(filenames, filename)
+	}
+
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		for _, imp := range imports[filename] {
+			if ref, ok := imp.Path.Value.(ast.Ref); ok && imp.Path.Location != nil && ref.HasPrefix(path) {
+				result = append(result, imp.Path.Location)
+			}
+		}
+		ast.WalkTerms(compiler.Modules[filename], func(x *ast.Term) bool {
+			if ref, ok := x.Value.(ast.Ref); ok && x.Location != nil && ref.ConstantPrefix().HasPrefix(path) {
+				result = append(result, x.Location)
+			}
+			return false
+		})
+	}
+
+	return result
+}
+
+func findVarReferences(rule *ast.Rule, name ast.Var, includeDefinition bool) []*ast.Location {
+
+	definition := walkToFirstOccurrence(rule.Head.Args, name)
+	if definition == nil {
+		definition = walkToFirstOccurrence(rule.Body, name)
+	}
+
+	var result []*ast.Location
+
+	ast.WalkNodes(rule, func(x ast.Node) bool {
+		switch x := x.(type) {
+		case *ast.SomeDecl:
+			for i := range x.Symbols {
+				if x.Symbols[i].Value.Compare(name) == 0 && (includeDefinition || x.Symbols[i] != definition) {
+					result = append(result, x.Symbols[i].Location)
+				}
+			}
+		case *ast.Term:
+			if x.Value.Compare(name) == 0 && x.Location != nil && (includeDefinition || x != definition) {
+				result = append(result, x.Location)
+			}
+		}
+		return false
+	})
+
+	return result
+}
+
 func walkToFirstOccurrence(node ast.Node, needle ast.Var) (match *ast.Term) {
 	ast.WalkNodes(node, func(x ast.Node) bool {
 		if match == nil {
@@ -2,6 +2,7 @@ package oracle
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
 
@@ -387,6 +388,99 @@ bar = 7`
 	}
 }
 
+func TestOracleFindReferences(t *testing.T) {
+
+	const bufferModule = `package test
+
+import data.foo.s
+
+p {
+	q
+	x := s
+	x > 1
+	f(x)
+}
+
+q = true
+
+f(y) { y > q }
+`
+
+	const fooModule = `package foo
+
+s = 7
+t { data.test.q }`
+
+	cases := []struct {
+		note              string
+		row, col          int
+		includeDefinition bool
+		exp               []string
+	}{
+		{
+			note: "rule reference",
+			row:  6, col: 2, // q in the body of p
+			exp: []string{"buffer.rego:6:2", "buffer.rego:14:12", "foo.rego:4:5"},
+		},
+		{
+			note: "rule name",
+			row:  12, col: 1, // q in the head of q
+			includeDefinition: true,
+			exp:               []string{"buffer.rego:12:1", "buffer.rego:6:2", "buffer.rego:14:12", "foo.rego:4:5"},
+		},
+		{
+			note: "imported rule",
+			row:  7, col: 7, // s in the body of p
+			exp: []string{"buffer.rego:3:8", "buffer.rego:7:7"},
+		},
+		{
+			note: "variable",
+			row:  8, col: 2, // x in x > 1
+			exp: []string{"buffer.rego:8:2", "buffer.rego:9:4"},
+		},
+		{
+			note: "variable with definition",
+			row:  8, col: 2,
+			includeDefinition: true,
+			exp:               []string{"buffer.rego:7:2", "buffer.rego:8:2", "buffer.rego:9:4"},
+		},
+		{
+			note: "function argument",
+			row:  14, col: 8, // y in y > q
+			includeDefinition: true,
+			exp:               []string{"buffer.rego:14:3", "buffer.rego:14:8"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.note, func(t *testing.T) {
+			foo, err := ast.ParseModule("foo.rego", fooModule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.SplitAfter(bufferModule, "\n")
+			pos := len(strings.Join(lines[:tc.row-1], "")) + tc.col - 1
+			result, err := New().FindReferences(ReferencesQuery{
+				Modules:           map[string]*ast.Module{"foo.rego": foo},
+				Buffer:            []byte(bufferModule),
+				Filename:          "buffer.rego",
+				Pos:               pos,
+				IncludeDefinition: tc.includeDefinition,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, loc := range result.Result {
+				got = append(got, fmt.Sprintf("%v:%v:%v", loc.File, loc.Row, loc.Col))
+			}
+			if strings.Join(got, " ") != strings.Join(tc.exp, " ") {
+				t.Fatalf("Expected %v but got %v", tc.exp, got)
+			}
+		})
+	}
+}
+
 func TestFindContainingNodeStack(t *testing.T) {
 	const trivial = `package test
 
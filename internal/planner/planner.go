@@ -9,11 +9,16 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/internal/ir"
 )
 
+// mockRoot is the key in the data document under which non-constant values
+// of functions replaced by with statements are stored during evaluation.
+const mockRoot = "__mock__"
+
 type planiter func() error
 type binaryiter func(ir.Local, ir.Local) error
 
@@ -33,6 +38,8 @@ type Planner struct {
 	decls     map[string]*ast.Builtin // built-in functions that may be provided in execution environment
 	rules     *ruletrie               // rules that may be planned
 	funcs     *funcstack              // functions that have been planned
+	mocks     *mockstack              // functions replaced by with statements
+	nmocks    int                     // number of non-constant replacement values
 	curr      *ir.Block               // in-progress query block
 	vars      *varstack               // in-scope variables
 	ltarget   ir.Local                // target variable of last planned statement
@@ -56,6 +63,7 @@ func New() *Planner {
 		}),
 		rules: newRuletrie(),
 		funcs: newFuncstack(),
+		mocks: newMockstack(),
 	}
 }
 
@@ -482,6 +490,51 @@ func (p *Planner) planNot(e *ast.Expr, iter planiter) error {
 
 func (p *Planner) planWith(e *ast.Expr, iter planiter) error {
 
+	// Calls to functions replaced by with statements are planned as calls to
+	// the replacement (or as the replacement value.) The remaining with
+	// statements replace parts of the input and data documents.
+	mocks := map[string]*ast.Term{}
+	var with []*ast.With
+
+	for i := range e.With {
+		target := e.With[i].Target.Value.(ast.Ref)
+		if !p.isFunction(target) {
+			if !target.HasPrefix(ast.InputRootRef) && !target.HasPrefix(ast.DefaultRootRef) {
+				return fmt.Errorf("illegal with target: unknown function %v", target)
+			}
+			with = append(with, e.With[i])
+			continue
+		}
+		value := e.With[i].Value
+		if ref, ok := value.Value.(ast.Ref); !(ok && p.isFunction(ref)) && !ast.IsConstant(value.Value) {
+			// Non-constant values (e.g., locals introduced by the compiler) are
+			// stored in the data document so that the value is available to
+			// calls inside the functions planned for other rules.
+			ref := ast.DefaultRootRef.Append(ast.StringTerm(mockRoot)).Append(ast.StringTerm(strconv.Itoa(p.nmocks)))
+			p.nmocks++
+			with = append(with, &ast.With{Target: ast.NewTerm(ref), Value: value})
+			value = ast.NewTerm(ref)
+		}
+		mocks[target.String()] = value
+	}
+
+	if len(mocks) > 0 {
+
+		// Shadow the existing planned functions so that functions calling the
+		// replaced functions are re-planned.
+		p.mocks.PushFrame(mocks)
+		p.funcs.Push(map[string]string{})
+
+		next := e.NoWith()
+		next.With = with
+
+		return p.planExpr(next, func() error {
+			p.funcs.Pop()
+			p.mocks.PopFrame()
+			return iter()
+		})
+	}
+
 	// Plan the values that will be applied by the with modifiers. All values
 	// must be defined for the overall expression to evaluate.
 	values := make([]*ast.Term, len(e.With))
@@ -679,6 +732,10 @@ func (p *Planner) planExprCall(e *ast.Expr, iter planiter) error {
 		})
 	default:
 
+		if mock, ok := p.mocks.Lookup(e.Operator()); ok {
+			return p.planExprCallMock(e, mock, iter)
+		}
+
 		var name string
 		var arity int
 		var args []ir.Local
@@ -749,6 +806,70 @@ func (p *Planner) planExprCall(e *ast.Expr, iter planiter) error {
 	}
 }
 
+// planExprCallMock plans a call to a function replaced by a with statement.
+// Replacement functions are planned without the mocks in effect at the call
+// site. Replacement values are used as the result of the call.
+func (p *Planner) planExprCallMock(e *ast.Expr, mock *ast.Term, iter planiter) error {
+
+	if ref, ok := mock.Value.(ast.Ref); ok && p.isFunction(ref) {
+		terms := append([]*ast.Term{ast.NewTerm(ref)}, e.Operands()...)
+		p.mocks.PushBarrier()
+		p.funcs.Push(map[string]string{})
+		return p.planExprCall(ast.NewExpr(terms), func() error {
+			p.funcs.Pop()
+			p.mocks.PopBarrier()
+			return iter()
+		})
+	}
+
+	var arity int
+	operator := e.Operator()
+
+	if node := p.rules.Lookup(operator); node != nil {
+		arity = node.Arity()
+	} else if decl, ok := p.decls[operator.String()]; ok {
+		arity = len(decl.Decl.Args())
+	} else {
+		return fmt.Errorf("illegal call: unknown operator %q", operator)
+	}
+
+	operands := e.Operands()
+
+	if len(operands) == arity {
+		return p.planTerm(mock, func() error {
+			falsy := p.newLocal()
+			p.appendStmt(&ir.MakeBooleanStmt{
+				Value:  false,
+				Target: falsy,
+			})
+			p.appendStmt(&ir.NotEqualStmt{
+				A: p.ltarget,
+				B: falsy,
+			})
+			return iter()
+		})
+	} else if len(operands) == arity+1 {
+		return p.planTerm(mock, func() error {
+			return p.planUnifyLocal(p.ltarget, operands[len(operands)-1], iter)
+		})
+	}
+
+	return fmt.Errorf("illegal call: wrong number of operands: got %v, want %v)", len(operands), arity)
+}
+
+// isFunction returns true if ref refers to a built-in or user-defined function.
+func (p *Planner) isFunction(ref ast.Ref) bool {
+	if ref.HasPrefix(ast.DefaultRootRef) {
+		node := p.rules.Lookup(ref)
+		return node != nil && node.Arity() > 0
+	}
+	if ref.HasPrefix(ast.InputRootRef) {
+		return false
+	}
+	_, ok := p.decls[ref.String()]
+	return ok
+}
+
 func (p *Planner) planCallArgs(terms []*ast.Term, idx int, args []ir.Local, iter func([]ir.Local) error) error {
 	if idx >= len(terms) {
 		return iter(args)
@@ -1714,7 +1835,7 @@ func (p *Planner) planExterns() error {
 	p.policy.Static.BuiltinFuncs = make([]*ir.BuiltinFunc, 0, len(p.externs))
 
 	for name := range p.externs {
-		p.policy.Static.BuiltinFuncs = append(p.policy.Static.BuiltinFuncs, &ir.BuiltinFunc{Name: name})
+		p.policy.Static.BuiltinFuncs = append(p.policy.Static.BuiltinFuncs, &ir.BuiltinFunc{Name: name, Decl: p.decls[name].Decl})
 	}
 
 	sort.Slice(p.policy.Static.BuiltinFuncs, func(i, j int) bool {
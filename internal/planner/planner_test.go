@@ -195,6 +195,26 @@ func TestPlannerHelloWorld(t *testing.T) {
 				q = 2`,
 			},
 		},
+		{
+			note: "with keyword function mocks",
+			queries: []string{
+				`data.test.p = x with data.test.f as data.test.g`,
+				`data.test.p = x with data.test.f as 7`,
+				`data.test.q with time.now_ns as 1`,
+				`data.test.r = x with data.test.f as data.test.g with data.test.g as data.test.f`,
+				`data.test.s = x`,
+			},
+			modules: []string{
+				`package test
+
+				f(x) = y { y = x }
+				g(x) = y { data.test.f(x, y) }
+				p = y { data.test.f(1, y) }
+				q { time.now_ns(1) }
+				r = y { data.test.g(2, y) }
+				s = z { arr = [5]; data.test.g(1, z) with data.test.f as arr[0] }`,
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -207,7 +227,7 @@ func TestPlannerHelloWorld(t *testing.T) {
 			for i := range modules {
 				modules[i] = ast.MustParseModule(tc.modules[i])
 			}
-			planner := New().WithQueries(queries).WithModules(modules)
+			planner := New().WithQueries(queries).WithModules(modules).WithBuiltinDecls(ast.BuiltinMap)
 			policy, err := planner.Plan()
 			if err != nil {
 				t.Fatal(err)
@@ -45,6 +45,50 @@ func (p *funcstack) Pop() map[string]string {
 	return last
 }
 
+// mockstack implements a stack of function => replacement mappings used to
+// plan calls to functions replaced by 'with' statements. Barriers hide the
+// mappings below them so that replacement functions are planned without the
+// mocks in effect at the call site.
+type mockstack struct {
+	stack [][]map[string]*ast.Term
+}
+
+func newMockstack() *mockstack {
+	return &mockstack{
+		stack: [][]map[string]*ast.Term{
+			nil,
+		},
+	}
+}
+
+func (s *mockstack) PushFrame(mocks map[string]*ast.Term) {
+	s.stack[len(s.stack)-1] = append(s.stack[len(s.stack)-1], mocks)
+}
+
+func (s *mockstack) PopFrame() {
+	frames := s.stack[len(s.stack)-1]
+	s.stack[len(s.stack)-1] = frames[:len(frames)-1]
+}
+
+func (s *mockstack) PushBarrier() {
+	s.stack = append(s.stack, nil)
+}
+
+func (s *mockstack) PopBarrier() {
+	s.stack = s.stack[:len(s.stack)-1]
+}
+
+func (s *mockstack) Lookup(ref ast.Ref) (*ast.Term, bool) {
+	frames := s.stack[len(s.stack)-1]
+	key := ref.String()
+	for i := len(frames) - 1; i >= 0; i-- {
+		if value, ok := frames[i][key]; ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
 // ruletrie implements a simple trie structure for organizing rules that may be
 // planned. The trie nodes are keyed by the rule path. The ruletrie supports
 // Push and Pop operations that allow the planner to shadow subtrees when 'with'
@@ -187,3 +187,22 @@ func WalkPaths(paths []string, filter loader.Filter, asBundle bool) (*WalkPathsR
 
 	return &result, nil
 }
+
+// WatchPaths returns the directories and files under rootPaths that should be
+// watched for changes. Path prefixes (e.g., "x.y:/path/to/data") are removed.
+func WatchPaths(rootPaths []string) ([]string, error) {
+	paths := []string{}
+
+	for _, path := range rootPaths {
+
+		_, path = loader.SplitPrefix(path)
+		result, err := loader.Paths(path, true)
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, result...)
+	}
+
+	return paths, nil
+}
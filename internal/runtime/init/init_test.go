@@ -9,6 +9,7 @@ import (
 	"io"
 	"path"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -251,3 +252,28 @@ func TestWalkPaths(t *testing.T) {
 		}
 	})
 }
+
+func TestWatchPaths(t *testing.T) {
+
+	fs := map[string]string{
+		"/foo/bar/baz.json": "true",
+	}
+
+	expected := []string{
+		"/foo", "/foo/bar", "/foo/bar/baz.json",
+	}
+
+	test.WithTempFS(fs, func(rootDir string) {
+		paths, err := WatchPaths([]string{"prefix:" + rootDir + "/foo"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		result := []string{}
+		for _, path := range paths {
+			result = append(result, strings.TrimPrefix(path, rootDir))
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("Expected %v but got: %v", expected, result)
+		}
+	})
+}
@@ -0,0 +1,351 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// CustomRuleName is the name of the rule that custom lint rules define. Each
+// package that defines the rule is a lint rule. The rule is evaluated once
+// for every module with the JSON AST of the module as input. Terms,
+// expressions, rules and other nodes of the AST include their location.
+//
+// The rule is a set of violations. A violation is either a message or an
+// object with the following fields:
+//
+//	message:  the message to report (required)
+//	location: an object with the row and col of the violation
+//	severity: "error", "warning" (default) or "info"
+//	rule:     the name of the rule (defaults to the last segment of the package path)
+//
+// For example:
+//
+//	package lint.no_http
+//
+//	violation[{"message": msg, "location": x.location}] {
+//		walk(input, [_, x])
+//		x.type == "string"
+//		startswith(x.value, "http://")
+//		msg := sprintf("use https instead of %v", [x.value])
+//	}
+const CustomRuleName = "violation"
+
+func (l *Linter) lintCustom(ctx context.Context, c *lintContext) ([]Violation, error) {
+
+	if len(l.custom) == 0 {
+		return nil, nil
+	}
+
+	compiler := ast.NewCompiler()
+	compiler.Compile(l.custom)
+
+	if compiler.Failed() {
+		return nil, compiler.Errors
+	}
+
+	var paths []ast.Ref
+	seen := map[string]bool{}
+
+	for _, module := range compiler.Modules {
+		for _, rule := range module.Rules {
+			path := rule.Path()
+			if rule.Head.Name == CustomRuleName && !seen[path.String()] {
+				seen[path.String()] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return paths[i].Compare(paths[j]) < 0
+	})
+
+	var result []Violation
+
+	for _, path := range paths {
+
+		pq, err := rego.New(
+			rego.Compiler(compiler),
+			rego.Query(path.String()),
+		).PrepareForEval(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		name := string(path[len(path)-2].Value.(ast.String))
+
+		for _, file := range c.names {
+			module := c.modules[file]
+			rs, err := pq.Eval(ctx, rego.EvalInput(moduleInput(module)))
+			if err != nil {
+				return nil, err
+			}
+			if len(rs) == 0 {
+				continue
+			}
+			vs, err := customViolations(name, module, rs[0].Expressions[0].Value)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", path, err)
+			}
+			result = append(result, vs...)
+		}
+	}
+
+	return result, nil
+}
+
+// customViolations converts the values of a custom rule into violations.
+func customViolations(name string, module *ast.Module, value interface{}) ([]Violation, error) {
+
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v must be a set", CustomRuleName)
+	}
+
+	var result []Violation
+
+	for _, x := range values {
+
+		v := Violation{
+			Rule:     name,
+			Severity: SeverityWarning,
+			Location: module.Package.Location,
+		}
+
+		switch x := x.(type) {
+		case string:
+			v.Message = x
+		case map[string]interface{}:
+			bs, err := json.Marshal(x)
+			if err != nil {
+				return nil, err
+			}
+			var obj struct {
+				Message  string   `json:"message"`
+				Severity Severity `json:"severity"`
+				Rule     string   `json:"rule"`
+				Location *struct {
+					Row int `json:"row"`
+					Col int `json:"col"`
+				} `json:"location"`
+			}
+			if err := json.Unmarshal(bs, &obj); err != nil {
+				return nil, fmt.Errorf("invalid violation: %v", err)
+			}
+			if obj.Message == "" {
+				return nil, fmt.Errorf("violation must have a message: %v", string(bs))
+			}
+			v.Message = obj.Message
+			if obj.Rule != "" {
+				v.Rule = obj.Rule
+			}
+			if obj.Severity != "" {
+				if obj.Severity.Level() == 0 {
+					return nil, fmt.Errorf("invalid severity: %v", obj.Severity)
+				}
+				v.Severity = obj.Severity
+			}
+			if obj.Location != nil {
+				var file string
+				if module.Package.Location != nil {
+					file = module.Package.Location.File
+				}
+				v.Location = &ast.Location{File: file, Row: obj.Location.Row, Col: obj.Location.Col}
+			}
+		default:
+			return nil, fmt.Errorf("violation must be a string or an object: %v", x)
+		}
+
+		result = append(result, v)
+	}
+
+	return result, nil
+}
+
+// moduleInput returns the JSON AST of the module with the locations of the
+// nodes. The AST has the same structure as the JSON encoding of ast.Module.
+func moduleInput(module *ast.Module) map[string]interface{} {
+
+	result := map[string]interface{}{
+		"package": withLocation(map[string]interface{}{
+			"path": termsInput(module.Package.Path),
+		}, module.Package.Location),
+	}
+
+	imports := make([]interface{}, len(module.Imports))
+	for i, imp := range module.Imports {
+		obj := map[string]interface{}{"path": termInput(imp.Path)}
+		if imp.Alias != "" {
+			obj["alias"] = string(imp.Alias)
+		}
+		imports[i] = withLocation(obj, imp.Location)
+	}
+
+	rules := make([]interface{}, len(module.Rules))
+	for i, rule := range module.Rules {
+		rules[i] = ruleInput(rule)
+	}
+
+	comments := make([]interface{}, len(module.Comments))
+	for i, comment := range module.Comments {
+		comments[i] = withLocation(map[string]interface{}{"text": string(comment.Text)}, comment.Location)
+	}
+
+	result["imports"] = imports
+	result["rules"] = rules
+	result["comments"] = comments
+
+	return result
+}
+
+func ruleInput(rule *ast.Rule) map[string]interface{} {
+
+	head := map[string]interface{}{"name": string(rule.Head.Name)}
+
+	if len(rule.Head.Args) > 0 {
+		head["args"] = termsInput(rule.Head.Args)
+	}
+
+	if rule.Head.Key != nil {
+		head["key"] = termInput(rule.Head.Key)
+	}
+
+	if rule.Head.Value != nil {
+		head["value"] = termInput(rule.Head.Value)
+	}
+
+	if rule.Head.Assign {
+		head["assign"] = true
+	}
+
+	obj := map[string]interface{}{
+		"head": withLocation(head, rule.Head.Location),
+		"body": bodyInput(rule.Body),
+	}
+
+	if rule.Default {
+		obj["default"] = true
+	}
+
+	if rule.Else != nil {
+		obj["else"] = ruleInput(rule.Else)
+	}
+
+	return withLocation(obj, rule.Location)
+}
+
+func bodyInput(body ast.Body) []interface{} {
+	result := make([]interface{}, len(body))
+	for i, expr := range body {
+		result[i] = exprInput(expr)
+	}
+	return result
+}
+
+func exprInput(expr *ast.Expr) map[string]interface{} {
+
+	obj := map[string]interface{}{"index": expr.Index}
+
+	switch t := expr.Terms.(type) {
+	case *ast.Term:
+		obj["terms"] = termInput(t)
+	case []*ast.Term:
+		obj["terms"] = termsInput(t)
+	case *ast.SomeDecl:
+		obj["terms"] = withLocation(map[string]interface{}{
+			"symbols": termsInput(t.Symbols),
+		}, t.Location)
+	}
+
+	if expr.Negated {
+		obj["negated"] = true
+	}
+
+	if expr.Generated {
+		obj["generated"] = true
+	}
+
+	if len(expr.With) > 0 {
+		with := make([]interface{}, len(expr.With))
+		for i, w := range expr.With {
+			with[i] = withLocation(map[string]interface{}{
+				"target": termInput(w.Target),
+				"value":  termInput(w.Value),
+			}, w.Location)
+		}
+		obj["with"] = with
+	}
+
+	return withLocation(obj, expr.Location)
+}
+
+func termsInput(terms []*ast.Term) []interface{} {
+	result := make([]interface{}, len(terms))
+	for i, t := range terms {
+		result[i] = termInput(t)
+	}
+	return result
+}
+
+func termInput(term *ast.Term) map[string]interface{} {
+
+	var value interface{}
+
+	switch v := term.Value.(type) {
+	case ast.Null:
+		value = nil
+	case ast.Boolean:
+		value = bool(v)
+	case ast.Number:
+		value = json.Number(v)
+	case ast.String:
+		value = string(v)
+	case ast.Var:
+		value = string(v)
+	case ast.Ref:
+		value = termsInput(v)
+	case ast.Call:
+		value = termsInput(v)
+	case ast.Array:
+		value = termsInput(v)
+	case ast.Set:
+		value = termsInput(v.Slice())
+	case ast.Object:
+		pairs := []interface{}{}
+		v.Foreach(func(k, v *ast.Term) {
+			pairs = append(pairs, []interface{}{termInput(k), termInput(v)})
+		})
+		value = pairs
+	case *ast.ArrayComprehension:
+		value = map[string]interface{}{"term": termInput(v.Term), "body": bodyInput(v.Body)}
+	case *ast.SetComprehension:
+		value = map[string]interface{}{"term": termInput(v.Term), "body": bodyInput(v.Body)}
+	case *ast.ObjectComprehension:
+		value = map[string]interface{}{"key": termInput(v.Key), "value": termInput(v.Value), "body": bodyInput(v.Body)}
+	}
+
+	return withLocation(map[string]interface{}{
+		"type":  ast.TypeName(term.Value),
+		"value": value,
+	}, term.Location)
+}
+
+func withLocation(obj map[string]interface{}, loc *ast.Location) map[string]interface{} {
+	if loc != nil {
+		obj["location"] = map[string]interface{}{
+			"file": loc.File,
+			"row":  loc.Row,
+			"col":  loc.Col,
+		}
+	}
+	return obj
+}
@@ -0,0 +1,293 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package lint reports style and correctness issues in Rego policies. Built-in
+// rules are implemented in Go and custom rules are written in Rego.
+package lint
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// Severity defines the severity of a violation.
+type Severity string
+
+const (
+	// SeverityError is used for issues that are likely bugs.
+	SeverityError Severity = "error"
+
+	// SeverityWarning is used for issues that should be fixed.
+	SeverityWarning Severity = "warning"
+
+	// SeverityInfo is used for suggestions.
+	SeverityInfo Severity = "info"
+)
+
+// Level returns the numeric level of the severity. Higher levels are more
+// severe. Unknown severities have level zero.
+func (s Severity) Level() int {
+	switch s {
+	case SeverityError:
+		return 3
+	case SeverityWarning:
+		return 2
+	case SeverityInfo:
+		return 1
+	}
+	return 0
+}
+
+// Violation represents an issue reported by a lint rule.
+type Violation struct {
+	Rule     string        `json:"rule"`
+	Severity Severity      `json:"severity"`
+	Message  string        `json:"message"`
+	Location *ast.Location `json:"location,omitempty"`
+}
+
+func (v Violation) less(other Violation) bool {
+	a, b := v.Location, other.Location
+	switch {
+	case a == nil && b != nil:
+		return true
+	case a != nil && b == nil:
+		return false
+	case a != nil && b != nil:
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Row != b.Row {
+			return a.Row < b.Row
+		}
+		if a.Col != b.Col {
+			return a.Col < b.Col
+		}
+	}
+	return v.Rule < other.Rule
+}
+
+// Rule describes a built-in lint rule.
+type Rule struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Severity    Severity `json:"severity"`
+}
+
+// IgnoreDirective is the prefix of comments that suppress violations. The
+// directive is followed by the names of the rules to ignore, separated by
+// commas. If no names are given, all rules are ignored. The directive applies
+// to the line of the comment and to the line after it.
+const IgnoreDirective = "lint:ignore"
+
+// DefaultMaxBodyLength is the default maximum number of expressions in a rule
+// body.
+const DefaultMaxBodyLength = 25
+
+// Linter reports violations of lint rules in a set of modules.
+type Linter struct {
+	modules       map[string]*ast.Module
+	custom        map[string]*ast.Module
+	disabled      map[string]bool
+	entrypoints   []ast.Ref
+	maxBodyLength int
+}
+
+// New returns a new Linter.
+func New() *Linter {
+	return &Linter{
+		disabled:      map[string]bool{},
+		maxBodyLength: DefaultMaxBodyLength,
+	}
+}
+
+// WithModules sets the modules to lint. The modules must be parsed with
+// comments for ignore directives to apply.
+func (l *Linter) WithModules(modules map[string]*ast.Module) *Linter {
+	l.modules = modules
+	return l
+}
+
+// WithCustomRules sets the modules that define custom lint rules. See
+// CustomRuleName for how custom rules are evaluated.
+func (l *Linter) WithCustomRules(modules map[string]*ast.Module) *Linter {
+	l.custom = modules
+	return l
+}
+
+// WithDisabledRules sets the names of rules that are not checked.
+func (l *Linter) WithDisabledRules(names []string) *Linter {
+	for _, name := range names {
+		l.disabled[name] = true
+	}
+	return l
+}
+
+// WithEntrypoints sets the references of the documents queried by users of
+// the policy. If entrypoints are set, rules that the entrypoints do not
+// depend on are reported as unused.
+func (l *Linter) WithEntrypoints(refs []ast.Ref) *Linter {
+	l.entrypoints = refs
+	return l
+}
+
+// WithMaxBodyLength sets the maximum number of expressions in a rule body.
+func (l *Linter) WithMaxBodyLength(n int) *Linter {
+	l.maxBodyLength = n
+	return l
+}
+
+// Lint checks the modules and returns the violations sorted by location. An
+// error is returned if custom rules cannot be evaluated. Modules that do not
+// compile are reported as violations of the compile-error rule and rules that
+// require the compiled policy are not checked.
+func (l *Linter) Lint(ctx context.Context) ([]Violation, error) {
+
+	c := newContext(l)
+
+	var result []Violation
+
+	for _, r := range builtinRules {
+		if !l.disabled[r.Name] {
+			result = append(result, r.check(c)...)
+		}
+	}
+
+	custom, err := l.lintCustom(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range custom {
+		if !l.disabled[v.Rule] {
+			result = append(result, v)
+		}
+	}
+
+	result = c.filterIgnored(result)
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].less(result[j])
+	})
+
+	return result, nil
+}
+
+// Rules returns the built-in lint rules.
+func Rules() []Rule {
+	result := make([]Rule, len(builtinRules))
+	for i := range builtinRules {
+		result[i] = builtinRules[i].Rule
+	}
+	return result
+}
+
+// lintContext contains the state shared by lint rules.
+type lintContext struct {
+	linter   *Linter
+	names    []string
+	modules  map[string]*ast.Module
+	compiler *ast.Compiler
+	errs     ast.Errors
+}
+
+func newContext(l *Linter) *lintContext {
+
+	c := &lintContext{
+		linter:  l,
+		modules: l.modules,
+	}
+
+	for name := range l.modules {
+		c.names = append(c.names, name)
+	}
+
+	sort.Strings(c.names)
+
+	compiler := ast.NewCompiler()
+	compiler.Compile(l.modules)
+
+	if compiler.Failed() {
+		c.errs = compiler.Errors
+	} else {
+		c.compiler = compiler
+	}
+
+	return c
+}
+
+// each calls f for each module in order of name.
+func (c *lintContext) each(f func(name string, module *ast.Module)) {
+	for _, name := range c.names {
+		f(name, c.modules[name])
+	}
+}
+
+// filterIgnored removes violations suppressed by ignore directives.
+func (c *lintContext) filterIgnored(violations []Violation) []Violation {
+
+	// File -> row -> ignored rules. The empty name ignores all rules.
+	ignores := map[string]map[int]map[string]bool{}
+
+	c.each(func(name string, module *ast.Module) {
+		for _, comment := range module.Comments {
+			rules, ok := parseIgnoreDirective(string(comment.Text))
+			if !ok || comment.Location == nil {
+				continue
+			}
+			file := comment.Location.File
+			if ignores[file] == nil {
+				ignores[file] = map[int]map[string]bool{}
+			}
+			for _, row := range []int{comment.Location.Row, comment.Location.Row + 1} {
+				if ignores[file][row] == nil {
+					ignores[file][row] = map[string]bool{}
+				}
+				for _, r := range rules {
+					ignores[file][row][r] = true
+				}
+				if len(rules) == 0 {
+					ignores[file][row][""] = true
+				}
+			}
+		}
+	})
+
+	result := violations[:0]
+
+	for _, v := range violations {
+		if v.Location != nil {
+			if rules, ok := ignores[v.Location.File][v.Location.Row]; ok && (rules[""] || rules[v.Rule]) {
+				continue
+			}
+		}
+		result = append(result, v)
+	}
+
+	return result
+}
+
+// parseIgnoreDirective returns the rules ignored by the comment text.
+func parseIgnoreDirective(text string) ([]string, bool) {
+
+	text = strings.TrimSpace(text)
+
+	if !strings.HasPrefix(text, IgnoreDirective) {
+		return nil, false
+	}
+
+	rest := text[len(IgnoreDirective):]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return nil, false
+	}
+
+	rules := strings.FieldsFunc(rest, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+
+	return rules, true
+}
@@ -0,0 +1,318 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lint
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func parseModules(t *testing.T, files map[string]string) map[string]*ast.Module {
+	t.Helper()
+	modules := map[string]*ast.Module{}
+	for name, src := range files {
+		module, err := ast.ParseModule(name, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modules[name] = module
+	}
+	return modules
+}
+
+// summarize returns the violations formatted as "rule:file:row".
+func summarize(vs []Violation) []string {
+	result := []string{}
+	for _, v := range vs {
+		result = append(result, fmt.Sprintf("%v:%v:%v", v.Rule, v.Location.File, v.Location.Row))
+	}
+	return result
+}
+
+func TestLintBuiltinRules(t *testing.T) {
+
+	tests := []struct {
+		note     string
+		files    map[string]string
+		expected []string
+	}{
+		{
+			note: "unused function",
+			files: map[string]string{"x.rego": `package x
+
+allow { f(1) }
+
+f(x) { x > 0 }
+
+g(x) { x > 1 }
+
+test_g { g(2) }`},
+			expected: []string{"missing-default:x.rego:3"},
+		},
+		{
+			note: "unused function not called by tests",
+			files: map[string]string{"x.rego": `package x
+
+default allow = false
+
+g(x) { x > 1 }`},
+			expected: []string{"unused-rule:x.rego:5"},
+		},
+		{
+			note: "shadowed builtin",
+			files: map[string]string{"x.rego": `package x
+
+default count = 0
+
+count = 1 { input.x }`},
+			expected: []string{"shadowed-builtin:x.rego:3", "shadowed-builtin:x.rego:5"},
+		},
+		{
+			note: "comparison instead of assignment",
+			files: map[string]string{"x.rego": `package x
+
+default p = false
+
+p {
+	x == input.x
+	y := 1
+	y == 1
+	z := [a | a == 1]
+	q == 1
+}
+
+q = 1`},
+			expected: []string{"compile-error:x.rego:6", "use-assignment-operator:x.rego:6", "compile-error:x.rego:9", "use-assignment-operator:x.rego:9"},
+		},
+		{
+			note: "broad data references",
+			files: map[string]string{"x.rego": `package x
+
+import data
+
+default p = false
+
+p { data.y.z }
+
+q[x] { data[x].allow }`},
+			expected: []string{"broad-data-reference:x.rego:3", "broad-data-reference:x.rego:9"},
+		},
+		{
+			note: "missing default",
+			files: map[string]string{
+				"x.rego": `package x
+
+allow { input.x }
+
+allow { input.y }
+
+deny { input.z }
+
+helper { input.x }
+
+v = 1 { helper }`,
+				"y.rego": `package x
+
+default deny = false`,
+			},
+			expected: []string{"missing-default:x.rego:3"},
+		},
+		{
+			note: "long rule body",
+			files: map[string]string{"x.rego": `package x
+
+default p = false
+
+p {
+	` + strings.Repeat("input.x\n\t", DefaultMaxBodyLength+1) + `
+}`},
+			expected: []string{"long-rule-body:x.rego:5"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			vs, err := New().WithModules(parseModules(t, tc.files)).Lint(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := summarize(vs)
+			sort.Strings(result)
+			sort.Strings(tc.expected)
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Fatalf("Expected %v but got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestLintEntrypoints(t *testing.T) {
+
+	modules := parseModules(t, map[string]string{"x.rego": `package x
+
+default allow = false
+
+allow { admin }
+
+allow = true { false } else = false { user }
+
+admin { input.admin }
+
+user { input.user }
+
+helper { true }`})
+
+	vs, err := New().
+		WithModules(modules).
+		WithEntrypoints([]ast.Ref{ast.MustParseRef("data.x.allow")}).
+		Lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := summarize(vs)
+	expected := []string{"unused-rule:x.rego:13"}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v but got %v", expected, result)
+	}
+}
+
+func TestLintIgnoreAndDisable(t *testing.T) {
+
+	modules := parseModules(t, map[string]string{"x.rego": `package x
+
+# lint:ignore missing-default
+allow { input.x }
+
+deny { input.x } # lint:ignore
+
+# lint:ignore unused-rule, shadowed-builtin
+count { input.x }
+
+# lint:ignored
+other { input.x }
+`})
+
+	vs, err := New().WithModules(modules).Lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := summarize(vs)
+	expected := []string{"missing-default:x.rego:9", "missing-default:x.rego:12"}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v but got %v", expected, result)
+	}
+
+	vs, err = New().WithModules(modules).WithDisabledRules([]string{"missing-default"}).Lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(vs) != 0 {
+		t.Fatalf("Expected no violations but got %v", vs)
+	}
+}
+
+func TestLintCustomRules(t *testing.T) {
+
+	modules := parseModules(t, map[string]string{"x.rego": `package x
+
+default allow = false
+
+allow {
+	http.send({"method": "get", "url": "http://example.com"})
+}
+
+# lint:ignore
+url = "http://example.org"
+`})
+
+	custom := parseModules(t, map[string]string{"rules.rego": `package lint.no_http
+
+violation[{"message": msg, "location": x.location, "severity": "error"}] {
+	walk(input.rules, [_, x])
+	x.type == "string"
+	startswith(x.value, "http://")
+	msg := sprintf("use https instead of %v", [x.value])
+}
+
+violation["module has no imports"] {
+	count(input.imports) == 0
+}`})
+
+	vs, err := New().WithModules(modules).WithCustomRules(custom).Lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Violation{
+		{Rule: "no_http", Severity: SeverityWarning, Message: "module has no imports", Location: modules["x.rego"].Package.Location},
+		{Rule: "no_http", Severity: SeverityError, Message: "use https instead of http://example.com", Location: &ast.Location{File: "x.rego", Row: 6, Col: 37}},
+	}
+
+	if len(vs) != len(expected) {
+		t.Fatalf("Expected %v but got %v", expected, vs)
+	}
+
+	for i := range expected {
+		if vs[i].Rule != expected[i].Rule || vs[i].Severity != expected[i].Severity || vs[i].Message != expected[i].Message || vs[i].Location.Compare(expected[i].Location) != 0 {
+			t.Errorf("Expected %v but got %v", expected[i], vs[i])
+		}
+	}
+}
+
+func TestLintCustomRuleErrors(t *testing.T) {
+
+	modules := parseModules(t, map[string]string{"x.rego": `package x`})
+
+	tests := map[string]string{
+		"invalid severity": `package lint.x
+violation[{"message": "x", "severity": "fatal"}]`,
+		"missing message": `package lint.x
+violation[{"msg": "x"}]`,
+		"not a set": `package lint.x
+violation = 1`,
+		"compile error": `package lint.x
+violation[x]`,
+	}
+
+	for note, src := range tests {
+		t.Run(note, func(t *testing.T) {
+			custom := parseModules(t, map[string]string{"rules.rego": src})
+			if _, err := New().WithModules(modules).WithCustomRules(custom).Lint(context.Background()); err == nil {
+				t.Fatal("Expected error")
+			}
+		})
+	}
+}
+
+func TestParseIgnoreDirective(t *testing.T) {
+
+	tests := []struct {
+		text  string
+		rules []string
+		ok    bool
+	}{
+		{" lint:ignore", nil, true},
+		{" lint:ignore a", []string{"a"}, true},
+		{"lint:ignore a,b c", []string{"a", "b", "c"}, true},
+		{" lint:ignored", nil, false},
+		{" some comment", nil, false},
+	}
+
+	for _, tc := range tests {
+		rules, ok := parseIgnoreDirective(tc.text)
+		if ok != tc.ok || len(rules) != len(tc.rules) || (len(rules) > 0 && !reflect.DeepEqual(rules, tc.rules)) {
+			t.Errorf("Expected %v %v for %q but got %v %v", tc.rules, tc.ok, tc.text, rules, ok)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/open-policy-agent/opa/version"
+)
+
+// Reporter defines the interface for reporting lint violations.
+type Reporter interface {
+	Report(violations []Violation) error
+}
+
+// PrettyReporter reports violations in a human readable format, one line per
+// violation.
+type PrettyReporter struct {
+	Output io.Writer
+}
+
+// Report prints the violations to the reporter's output.
+func (r PrettyReporter) Report(violations []Violation) error {
+	for _, v := range violations {
+		var err error
+		if v.Location != nil {
+			_, err = fmt.Fprintf(r.Output, "%v:%d:%d: %v: %v (%v)\n", v.Location.File, v.Location.Row, v.Location.Col, v.Severity, v.Message, v.Rule)
+		} else {
+			_, err = fmt.Fprintf(r.Output, "%v: %v (%v)\n", v.Severity, v.Message, v.Rule)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JSONReporter reports violations as a JSON object.
+type JSONReporter struct {
+	Output io.Writer
+}
+
+// Report prints the violations as JSON to the reporter's output.
+func (r JSONReporter) Report(violations []Violation) error {
+	if violations == nil {
+		violations = []Violation{}
+	}
+	bs, err := json.MarshalIndent(map[string]interface{}{"violations": violations}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(r.Output, string(bs))
+	return err
+}
+
+// SARIFReporter reports violations in the Static Analysis Results Interchange
+// Format (SARIF) version 2.1.0.
+type SARIFReporter struct {
+	Output io.Writer
+}
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://schemastore.azurewebsites.net/schemas/json/sarif-2.1.0-rtm.5.json"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Version        string      `json:"version"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     *sarifMessage      `json:"shortDescription,omitempty"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// sarifLevel returns the SARIF level of the severity.
+func sarifLevel(s Severity) string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "note"
+}
+
+// Report prints the violations as a SARIF log to the reporter's output. The
+// log describes the built-in rules and the custom rules that reported
+// violations.
+func (r SARIFReporter) Report(violations []Violation) error {
+
+	driver := sarifDriver{
+		Name:           "opa",
+		InformationURI: "https://www.openpolicyagent.org",
+		Version:        version.Version,
+	}
+
+	index := map[string]int{}
+
+	for _, rule := range Rules() {
+		index[rule.Name] = len(driver.Rules)
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   rule.Name,
+			ShortDescription:     &sarifMessage{Text: rule.Description},
+			DefaultConfiguration: sarifConfiguration{Level: sarifLevel(rule.Severity)},
+		})
+	}
+
+	var custom []Violation
+	for _, v := range violations {
+		if _, ok := index[v.Rule]; !ok {
+			custom = append(custom, v)
+		}
+	}
+
+	sort.SliceStable(custom, func(i, j int) bool {
+		return custom[i].Rule < custom[j].Rule
+	})
+
+	for _, v := range custom {
+		if _, ok := index[v.Rule]; !ok {
+			index[v.Rule] = len(driver.Rules)
+			driver.Rules = append(driver.Rules, sarifRule{
+				ID:                   v.Rule,
+				DefaultConfiguration: sarifConfiguration{Level: sarifLevel(v.Severity)},
+			})
+		}
+	}
+
+	results := make([]sarifResult, 0, len(violations))
+
+	for _, v := range violations {
+		result := sarifResult{
+			RuleID:    v.Rule,
+			RuleIndex: index[v.Rule],
+			Level:     sarifLevel(v.Severity),
+			Message:   sarifMessage{Text: v.Message},
+		}
+		if v.Location != nil && v.Location.File != "" {
+			result.Locations = []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(v.Location.File)},
+					Region:           sarifRegion{StartLine: v.Location.Row, StartColumn: v.Location.Col},
+				},
+			}}
+		}
+		results = append(results, result)
+	}
+
+	log := sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	}
+
+	bs, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(r.Output, string(bs))
+	return err
+}
@@ -0,0 +1,389 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+var (
+	compileErrorRule = Rule{
+		Name:        "compile-error",
+		Severity:    SeverityError,
+		Description: "The policy does not compile.",
+	}
+	unusedRule = Rule{
+		Name:        "unused-rule",
+		Severity:    SeverityWarning,
+		Description: "A function is never called or a rule is not used by the entrypoints.",
+	}
+	shadowedBuiltinRule = Rule{
+		Name:        "shadowed-builtin",
+		Severity:    SeverityError,
+		Description: "A rule or function has the name of a built-in function.",
+	}
+	assignmentOperatorRule = Rule{
+		Name:        "use-assignment-operator",
+		Severity:    SeverityError,
+		Description: "A variable is compared with == before it is assigned.",
+	}
+	broadDataReferenceRule = Rule{
+		Name:        "broad-data-reference",
+		Severity:    SeverityWarning,
+		Description: "A reference reads the entire data document.",
+	}
+	missingDefaultRule = Rule{
+		Name:        "missing-default",
+		Severity:    SeverityInfo,
+		Description: "A boolean rule has no default value.",
+	}
+	longRuleBodyRule = Rule{
+		Name:        "long-rule-body",
+		Severity:    SeverityInfo,
+		Description: "A rule body contains too many expressions.",
+	}
+)
+
+type builtinRule struct {
+	Rule
+	check func(*lintContext) []Violation
+}
+
+var builtinRules = []builtinRule{
+	{compileErrorRule, checkCompileErrors},
+	{unusedRule, checkUnusedRules},
+	{shadowedBuiltinRule, checkShadowedBuiltins},
+	{assignmentOperatorRule, checkComparisonAssignments},
+	{broadDataReferenceRule, checkBroadDataReferences},
+	{missingDefaultRule, checkMissingDefaults},
+	{longRuleBodyRule, checkLongRuleBodies},
+}
+
+func (r Rule) violation(loc *ast.Location, f string, a ...interface{}) Violation {
+	return Violation{
+		Rule:     r.Name,
+		Severity: r.Severity,
+		Message:  fmt.Sprintf(f, a...),
+		Location: loc,
+	}
+}
+
+// rules calls f for each rule and else branch in the module.
+func rules(module *ast.Module, f func(*ast.Rule)) {
+	for _, rule := range module.Rules {
+		for r := rule; r != nil; r = r.Else {
+			f(r)
+		}
+	}
+}
+
+func checkCompileErrors(c *lintContext) []Violation {
+	var result []Violation
+	for _, err := range c.errs {
+		result = append(result, compileErrorRule.violation(err.Location, "%v", err.Message))
+	}
+	return result
+}
+
+// checkUnusedRules reports functions that are not called by any rule. If
+// entrypoints are set, rules and functions that the entrypoints do not depend
+// on are reported instead.
+func checkUnusedRules(c *lintContext) []Violation {
+
+	if c.compiler == nil {
+		return nil
+	}
+
+	graph := c.compiler.Graph
+	used := map[string]bool{}
+
+	if len(c.linter.entrypoints) > 0 {
+		var queue []*ast.Rule
+		for _, ref := range c.linter.entrypoints {
+			queue = append(queue, c.compiler.GetRulesWithPrefix(ref)...)
+			queue = append(queue, c.compiler.GetRulesForVirtualDocument(ref)...)
+		}
+		for len(queue) > 0 {
+			rule := queue[0]
+			queue = queue[1:]
+			path := rule.Path().String()
+			if used[path] {
+				continue
+			}
+			used[path] = true
+			for _, rs := range c.compiler.GetRulesExact(rule.Path()) {
+				for node := rs; node != nil; node = node.Else {
+					for dep := range graph.Dependencies(node) {
+						queue = append(queue, dep.(*ast.Rule))
+					}
+				}
+			}
+		}
+	} else {
+		for _, name := range c.names {
+			rules(c.compiler.Modules[name], func(rule *ast.Rule) {
+				for dep := range graph.Dependencies(rule) {
+					used[dep.(*ast.Rule).Path().String()] = true
+				}
+			})
+		}
+	}
+
+	var result []Violation
+	reported := map[string]bool{}
+
+	for _, name := range c.names {
+		for _, rule := range c.compiler.Modules[name].Rules {
+			path := rule.Path().String()
+			if used[path] || reported[path] || strings.HasPrefix(string(rule.Head.Name), "test_") {
+				continue
+			}
+			reported[path] = true
+			if len(c.linter.entrypoints) > 0 {
+				result = append(result, unusedRule.violation(rule.Location, "%v is not used by the entrypoints", path))
+			} else if len(rule.Head.Args) > 0 {
+				result = append(result, unusedRule.violation(rule.Location, "function %v is never called", path))
+			}
+		}
+	}
+
+	return result
+}
+
+func checkShadowedBuiltins(c *lintContext) []Violation {
+	var result []Violation
+	c.each(func(_ string, module *ast.Module) {
+		for _, rule := range module.Rules {
+			name := string(rule.Head.Name)
+			if _, ok := ast.BuiltinMap[name]; ok {
+				result = append(result, shadowedBuiltinRule.violation(rule.Location, "%v shadows the built-in function %v", name, name))
+			}
+		}
+	})
+	return result
+}
+
+// checkComparisonAssignments reports operands of == that are variables that
+// have not been bound by the time the comparison is evaluated. These are
+// usually meant to be assignments with :=.
+func checkComparisonAssignments(c *lintContext) []Violation {
+
+	globals := c.globals()
+
+	var result []Violation
+
+	c.each(func(_ string, module *ast.Module) {
+		rules(module, func(rule *ast.Rule) {
+			bound := ast.NewVarSet()
+			for _, v := range globals[module.Package.Path.String()] {
+				bound.Add(v)
+			}
+			for _, imp := range module.Imports {
+				bound.Add(imp.Name())
+			}
+			bound.Update(rule.Head.Args.Vars())
+			checkComparisons(rule.Body, bound, func(term *ast.Term) {
+				result = append(result, assignmentOperatorRule.violation(term.Location, "%v is compared with == but is not assigned, use := to assign it", term))
+			})
+		})
+	})
+
+	return result
+}
+
+func checkComparisons(body ast.Body, bound ast.VarSet, report func(*ast.Term)) {
+
+	bound = bound.Copy()
+
+	for _, expr := range body {
+
+		if expr.IsCall() && expr.Operator().Equal(ast.Equal.Ref()) {
+			for _, term := range expr.Operands() {
+				if v, ok := term.Value.(ast.Var); ok && !v.IsWildcard() && !bound.Contains(v) && !ast.RootDocumentNames.Contains(term) {
+					report(term)
+				}
+			}
+		}
+
+		vars := expr.Vars(ast.VarVisitorParams{SkipClosures: true})
+
+		ast.WalkClosures(expr, func(x interface{}) bool {
+			inner := bound.Copy()
+			inner.Update(vars)
+			switch x := x.(type) {
+			case *ast.ArrayComprehension:
+				checkComparisons(x.Body, inner, report)
+			case *ast.SetComprehension:
+				checkComparisons(x.Body, inner, report)
+			case *ast.ObjectComprehension:
+				checkComparisons(x.Body, inner, report)
+			}
+			return true
+		})
+
+		bound.Update(vars)
+	}
+}
+
+// globals returns the names of the rules in each package.
+func (c *lintContext) globals() map[string][]ast.Var {
+	result := map[string][]ast.Var{}
+	c.each(func(_ string, module *ast.Module) {
+		path := module.Package.Path.String()
+		for _, rule := range module.Rules {
+			result[path] = append(result[path], rule.Head.Name)
+		}
+	})
+	return result
+}
+
+// dataRefVisitor reports references whose first operand after data is not a
+// constant, e.g., data or data[x].
+type dataRefVisitor struct {
+	report func(*ast.Term)
+}
+
+func (vis *dataRefVisitor) Visit(x interface{}) ast.Visitor {
+	switch x := x.(type) {
+	case *ast.Package:
+		return nil
+	case *ast.Term:
+		switch v := x.Value.(type) {
+		case ast.Var:
+			if x.Equal(ast.DefaultRootDocument) {
+				vis.report(x)
+			}
+		case ast.Ref:
+			if v.HasPrefix(ast.DefaultRootRef) && (len(v) == 1 || !isString(v[1])) {
+				vis.report(x)
+			}
+			vis.walkOperands(v)
+			return nil
+		}
+	case ast.Ref:
+		vis.walkOperands(x)
+		return nil
+	}
+	return vis
+}
+
+// walkOperands visits the operands of the reference. The head of the
+// reference is not visited because data is only reported if it is not
+// followed by a constant.
+func (vis *dataRefVisitor) walkOperands(ref ast.Ref) {
+	for _, x := range ref[1:] {
+		ast.Walk(vis, x)
+	}
+}
+
+func isString(term *ast.Term) bool {
+	_, ok := term.Value.(ast.String)
+	return ok
+}
+
+func checkBroadDataReferences(c *lintContext) []Violation {
+	var result []Violation
+	c.each(func(_ string, module *ast.Module) {
+		ast.Walk(&dataRefVisitor{report: func(term *ast.Term) {
+			result = append(result, broadDataReferenceRule.violation(term.Location, "%v refers to the entire data document", term))
+		}}, module)
+	})
+	return result
+}
+
+// checkMissingDefaults reports boolean rules that do not have a default value
+// in any module of their package. Only decisions are checked: the rules of
+// the entrypoints if set, otherwise rules that no other rule depends on.
+// Tests are ignored.
+func checkMissingDefaults(c *lintContext) []Violation {
+
+	defaults := map[string]bool{}
+
+	c.each(func(_ string, module *ast.Module) {
+		for _, rule := range module.Rules {
+			if rule.Default {
+				defaults[rule.Path().String()] = true
+			}
+		}
+	})
+
+	decision := c.decisions()
+
+	var result []Violation
+
+	c.each(func(_ string, module *ast.Module) {
+		for _, rule := range module.Rules {
+			path := rule.Path().String()
+			name := string(rule.Head.Name)
+			if defaults[path] || rule.Head.Key != nil || len(rule.Head.Args) > 0 || !ast.BooleanTerm(true).Equal(rule.Head.Value) {
+				continue
+			}
+			if strings.HasPrefix(name, "test_") || strings.HasPrefix(name, "todo_test_") || (decision != nil && !decision[path]) {
+				continue
+			}
+			defaults[path] = true
+			result = append(result, missingDefaultRule.violation(rule.Location, "%v has no default value and is undefined if no body is true", path))
+		}
+	})
+
+	return result
+}
+
+// decisions returns the paths of rules that are queried by users of the
+// policy. If the policy does not compile, decisions returns nil.
+func (c *lintContext) decisions() map[string]bool {
+
+	if c.compiler == nil {
+		return nil
+	}
+
+	result := map[string]bool{}
+
+	if len(c.linter.entrypoints) > 0 {
+		for _, ref := range c.linter.entrypoints {
+			for _, rule := range c.compiler.GetRulesWithPrefix(ref) {
+				result[rule.Path().String()] = true
+			}
+			for _, rule := range c.compiler.GetRulesForVirtualDocument(ref) {
+				result[rule.Path().String()] = true
+			}
+		}
+		return result
+	}
+
+	dependents := map[string]bool{}
+
+	for _, name := range c.names {
+		rules(c.compiler.Modules[name], func(rule *ast.Rule) {
+			path := rule.Path().String()
+			if len(c.compiler.Graph.Dependents(rule)) > 0 {
+				dependents[path] = true
+			}
+			result[path] = true
+		})
+	}
+
+	for path := range dependents {
+		delete(result, path)
+	}
+
+	return result
+}
+
+func checkLongRuleBodies(c *lintContext) []Violation {
+	max := c.linter.maxBodyLength
+	var result []Violation
+	c.each(func(_ string, module *ast.Module) {
+		rules(module, func(rule *ast.Rule) {
+			if n := len(rule.Body); max > 0 && n > max {
+				result = append(result, longRuleBodyRule.violation(rule.Location, "body of %v has %d expressions, more than %d", rule.Head.Name, n, max))
+			}
+		})
+	})
+	return result
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/open-policy-agent/opa/bundle"
 	"github.com/open-policy-agent/opa/download"
 	"github.com/open-policy-agent/opa/plugins"
+	"github.com/open-policy-agent/opa/plugins/datasources"
 	"github.com/open-policy-agent/opa/storage"
 )
 
@@ -360,6 +361,12 @@ func (p *Plugin) activate(ctx context.Context, name string, b *bundle.Bundle) er
 		// transaction params for use by onCommit hooks.
 		compiler := ast.NewCompiler().WithPathConflictsCheck(storage.NonEmpty(ctx, p.manager.Store, txn))
 
+		// Data sources own their paths. Activating a bundle with an
+		// overlapping root would erase the data of the source.
+		if err := checkDataSources(ctx, p.manager.Store, txn, b); err != nil {
+			return err
+		}
+
 		var activateErr error
 
 		opts := &bundle.ActivateOpts{
@@ -385,6 +392,24 @@ func (p *Plugin) activate(ctx context.Context, name string, b *bundle.Bundle) er
 	return err
 }
 
+// checkDataSources returns an error if a root of the bundle overlaps the path of
+// a data source.
+func checkDataSources(ctx context.Context, store storage.Store, txn storage.Transaction, b *bundle.Bundle) error {
+	roots := []string{""}
+	if b.Manifest.Roots != nil {
+		roots = *b.Manifest.Roots
+	}
+	for _, root := range roots {
+		source, err := datasources.OverlappingSource(ctx, store, txn, root)
+		if err != nil {
+			return err
+		} else if source != "" {
+			return fmt.Errorf("bundle root %q overlaps path of data source %q", root, source)
+		}
+	}
+	return nil
+}
+
 func (p *Plugin) logError(bundleName string, fmt string, a ...interface{}) {
 	logrus.WithFields(p.logrusFields(bundleName)).Errorf(fmt, a...)
 }
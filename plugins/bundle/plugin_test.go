@@ -320,6 +320,46 @@ func TestPluginOneShotActivationConflictingRoots(t *testing.T) {
 	ensureBundleOverlapStatus(t, plugin, bundleNames, []bool{false, false, true})
 }
 
+func TestPluginOneShotActivationDataSourceOverlap(t *testing.T) {
+	ctx := context.Background()
+	manager := getTestManager()
+	plugin := New(&Config{}, manager)
+
+	bundleName := "test-bundle"
+	plugin.status[bundleName] = &Status{Name: bundleName}
+	plugin.downloaders[bundleName] = download.New(download.Config{}, plugin.manager.Client(""), bundleName)
+
+	err := storage.Txn(ctx, manager.Store, storage.WriteParams, func(txn storage.Transaction) error {
+		if err := manager.Store.Write(ctx, txn, storage.AddOp, storage.MustParsePath("/acmecorp"), map[string]interface{}{"users": []interface{}{"alice"}}); err != nil {
+			return err
+		}
+		return manager.Store.Write(ctx, txn, storage.AddOp, storage.MustParsePath("/system"), map[string]interface{}{
+			"data_sources": map[string]interface{}{"users": map[string]interface{}{"path": "acmecorp/users"}},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugin.oneShot(ctx, bundleName, download.Update{Bundle: &bundle.Bundle{
+		Manifest: bundle.Manifest{
+			Roots: &[]string{"acmecorp"},
+		},
+	}})
+
+	if msg := plugin.status[bundleName].Message; !strings.Contains(msg, `bundle root "acmecorp" overlaps path of data source "users"`) {
+		t.Fatalf("Expected data source overlap error but got %q", msg)
+	}
+
+	// The data of the source must not be erased.
+	txn := storage.NewTransactionOrDie(ctx, manager.Store)
+	defer manager.Store.Abort(ctx, txn)
+
+	if _, err := manager.Store.Read(ctx, txn, storage.MustParsePath("/acmecorp/users")); err != nil {
+		t.Fatalf("Expected data source document to remain but got %v", err)
+	}
+}
+
 func TestPluginOneShotActivationPrefixMatchingRoots(t *testing.T) {
 	ctx := context.Background()
 	manager := getTestManager()
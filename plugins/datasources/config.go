@@ -0,0 +1,125 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package datasources
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/download"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// Config represents the configuration of the plugin. Sources are keyed by
+// name.
+type Config map[string]*Source
+
+// Source represents the configuration of a data source. The document returned
+// by the resource is written to the path in data. If a transform rule is set,
+// the result of the rule with the document as input is written instead.
+type Source struct {
+	download.Config
+
+	Service   string  `json:"service"`
+	Resource  string  `json:"resource"`
+	Path      string  `json:"path"`
+	Transform *string `json:"transform,omitempty"`
+
+	path      storage.Path
+	transform ast.Ref
+}
+
+// ParseConfig validates the config and injects default values.
+func ParseConfig(config []byte, services []string) (Config, error) {
+
+	if config == nil {
+		return nil, nil
+	}
+
+	var parsedConfig Config
+
+	if err := util.Unmarshal(config, &parsedConfig); err != nil {
+		return nil, err
+	}
+
+	if err := parsedConfig.validateAndInjectDefaults(services); err != nil {
+		return nil, err
+	}
+
+	return parsedConfig, nil
+}
+
+func (c Config) validateAndInjectDefaults(services []string) error {
+
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for i, name := range names {
+
+		source := c[name]
+
+		if source == nil {
+			return fmt.Errorf("invalid data source %q: missing configuration", name)
+		}
+
+		if err := source.validateAndInjectDefaults(name, services); err != nil {
+			return err
+		}
+
+		for _, other := range names[:i] {
+			if bundle.RootPathsOverlap(source.Path, c[other].Path) {
+				return fmt.Errorf("invalid data source %q: path %v overlaps path %v of data source %q", name, source.Path, c[other].Path, other)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Source) validateAndInjectDefaults(name string, services []string) error {
+
+	if s.Service == "" {
+		return fmt.Errorf("invalid data source %q: must have a `service` target", name)
+	}
+
+	found := false
+
+	for _, svc := range services {
+		if svc == s.Service {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("invalid service name %q in data source %q", s.Service, name)
+	}
+
+	s.Path = strings.Trim(s.Path, "/")
+
+	if s.Path == "" {
+		return fmt.Errorf("invalid data source %q: must have a `path`", name)
+	}
+
+	s.path = storage.Path(strings.Split(s.Path, "/"))
+
+	if s.Transform != nil {
+		ref, err := ast.ParseRef(*s.Transform)
+		if err != nil || !ref.HasPrefix(ast.DefaultRootRef) {
+			return fmt.Errorf("invalid data source %q: `transform` must be a reference to a rule under data", name)
+		}
+		s.transform = ref
+	}
+
+	return s.Config.ValidateAndInjectDefaults()
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package datasources
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+func TestParseConfig(t *testing.T) {
+
+	tests := []struct {
+		note   string
+		config string
+		err    string
+	}{
+		{
+			note:   "missing service",
+			config: `{"users": {"path": "users"}}`,
+			err:    "invalid data source \"users\": must have a `service` target",
+		},
+		{
+			note:   "unknown service",
+			config: `{"users": {"service": "deadbeef", "path": "users"}}`,
+			err:    `invalid service name "deadbeef" in data source "users"`,
+		},
+		{
+			note:   "missing path",
+			config: `{"users": {"service": "acmecorp", "path": "/"}}`,
+			err:    "invalid data source \"users\": must have a `path`",
+		},
+		{
+			note:   "missing source",
+			config: `{"users": null}`,
+			err:    `invalid data source "users": missing configuration`,
+		},
+		{
+			note:   "bad transform",
+			config: `{"users": {"service": "acmecorp", "path": "users", "transform": "input.x"}}`,
+			err:    "invalid data source \"users\": `transform` must be a reference to a rule under data",
+		},
+		{
+			note:   "overlapping paths",
+			config: `{"a": {"service": "acmecorp", "path": "x/y"}, "b": {"service": "acmecorp", "path": "x"}}`,
+			err:    `invalid data source "b": path x overlaps path x/y of data source "a"`,
+		},
+		{
+			note:   "bad polling",
+			config: `{"users": {"service": "acmecorp", "path": "users", "polling": {"min_delay_seconds": 10, "max_delay_seconds": 1}}}`,
+			err:    "max polling delay must be >= min polling delay",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			_, err := ParseConfig([]byte(tc.config), []string{"acmecorp"})
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("Expected error containing %q but got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestParseConfigDefaults(t *testing.T) {
+
+	config, err := ParseConfig([]byte(`{
+		"users": {
+			"service": "acmecorp",
+			"resource": "/users?active=true",
+			"path": "/external/users/",
+			"transform": "data.transforms.users"
+		}
+	}`), []string{"acmecorp"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := config["users"]
+
+	if source.Path != "external/users" || !source.path.Equal(storage.MustParsePath("/external/users")) {
+		t.Fatalf("Unexpected path: %v", source.Path)
+	}
+
+	if !source.transform.Equal(ast.MustParseRef("data.transforms.users")) {
+		t.Fatalf("Unexpected transform: %v", source.transform)
+	}
+
+	if *source.Polling.MinDelaySeconds != int64(60e9) || *source.Polling.MaxDelaySeconds != int64(120e9) {
+		t.Fatalf("Unexpected polling config: %v %v", *source.Polling.MinDelaySeconds, *source.Polling.MaxDelaySeconds)
+	}
+
+	if config, err := ParseConfig(nil, nil); config != nil || err != nil {
+		t.Fatalf("Expected nil config but got %v %v", config, err)
+	}
+}
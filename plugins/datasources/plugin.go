@@ -0,0 +1,524 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package datasources implements a plugin that periodically downloads JSON
+// documents from HTTP services and writes them into data.
+package datasources
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/plugins"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// Name identifies the plugin on manager.
+const Name = "data_sources"
+
+const (
+	minRetryDelay = time.Millisecond * 100
+
+	timerDownload  = "data_source_download"
+	timerTransform = "data_source_transform"
+	timerWrite     = "data_source_write"
+)
+
+// Plugin implements the data sources. Each source is polled independently.
+// The document of a source is written to the path of the source, which is
+// owned by the source: other sources cannot use overlapping paths and writes
+// are rejected if the path overlaps the roots of an activated bundle. Once
+// written, the path is recorded under data.system.data_sources so that the
+// server rejects writes into it and bundles with overlapping roots are not
+// activated. When a source is removed from the configuration, its document
+// and path are removed from data.
+type Plugin struct {
+	manager   *plugins.Manager
+	mtx       sync.Mutex
+	config    Config
+	sources   map[string]*poller
+	status    map[string]*Status
+	listeners map[interface{}]func(map[string]*Status)
+}
+
+// poller polls one data source.
+type poller struct {
+	name   string
+	source *Source
+	etag   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New returns a new Plugin with the given config.
+func New(parsedConfig Config, manager *plugins.Manager) *Plugin {
+
+	p := &Plugin{
+		manager:   manager,
+		config:    parsedConfig,
+		sources:   map[string]*poller{},
+		status:    map[string]*Status{},
+		listeners: map[interface{}]func(map[string]*Status){},
+	}
+
+	for name := range parsedConfig {
+		p.status[name] = &Status{Name: name}
+	}
+
+	p.manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateNotReady})
+
+	return p
+}
+
+// Lookup returns the data sources plugin registered with the manager.
+func Lookup(manager *plugins.Manager) *Plugin {
+	if p := manager.Plugin(Name); p != nil {
+		return p.(*Plugin)
+	}
+	return nil
+}
+
+// Start starts the plugin.
+func (p *Plugin) Start(ctx context.Context) error {
+	p.logInfo("", "Starting data sources.")
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	for name, source := range p.config {
+		p.startSource(name, source)
+	}
+
+	p.updatePluginStatus()
+
+	return nil
+}
+
+// Stop stops the plugin.
+func (p *Plugin) Stop(ctx context.Context) {
+	p.logInfo("", "Stopping data sources.")
+
+	p.mtx.Lock()
+	sources := p.sources
+	p.sources = map[string]*poller{}
+	p.mtx.Unlock()
+
+	for _, s := range sources {
+		s.stop()
+	}
+
+	p.manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateNotReady})
+}
+
+// Reconfigure notifies the plugin with a new configuration. Sources that were
+// added or changed are (re)started. The documents of sources that were
+// removed or moved to a different path are removed from data.
+func (p *Plugin) Reconfigure(ctx context.Context, config interface{}) {
+
+	newConfig := config.(Config)
+
+	p.mtx.Lock()
+
+	if reflect.DeepEqual(p.config, newConfig) {
+		p.mtx.Unlock()
+		p.logDebug("", "Data sources configuration unchanged.")
+		return
+	}
+
+	p.logInfo("", "Data sources configuration changed.")
+
+	var stop []*poller
+	erase := map[string]storage.Path{}
+
+	for name, source := range p.config {
+
+		newSource, ok := newConfig[name]
+		if ok && reflect.DeepEqual(source, newSource) {
+			continue
+		}
+
+		if s, ok := p.sources[name]; ok {
+			stop = append(stop, s)
+			delete(p.sources, name)
+		}
+
+		if !ok || newSource.Path != source.Path {
+			erase[name] = source.path
+		}
+
+		delete(p.status, name)
+	}
+
+	// The pollers update the status while holding the lock so they must be
+	// stopped without it.
+	p.mtx.Unlock()
+
+	for _, s := range stop {
+		s.stop()
+	}
+
+	if len(erase) > 0 {
+		if err := p.erase(ctx, erase); err != nil {
+			p.logError("", "Failed to remove data of data sources: %v.", err)
+		}
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	for name, source := range newConfig {
+		if _, ok := p.sources[name]; !ok {
+			p.status[name] = &Status{Name: name}
+			p.startSource(name, source)
+		}
+	}
+
+	p.config = newConfig
+	p.updatePluginStatus()
+	p.notifyListeners()
+}
+
+// RegisterBulkListener registers a listener to receive the status of all data
+// sources whenever a source is updated.
+func (p *Plugin) RegisterBulkListener(name interface{}, listener func(map[string]*Status)) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.listeners[name] = listener
+}
+
+// Unregister unregisters a listener.
+func (p *Plugin) Unregister(name interface{}) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	delete(p.listeners, name)
+}
+
+// Status returns a copy of the status of the data sources.
+func (p *Plugin) Status() map[string]*Status {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.copyStatus()
+}
+
+func (p *Plugin) startSource(name string, source *Source) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &poller{
+		name:   name,
+		source: source,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	p.sources[name] = s
+
+	go p.loop(ctx, s)
+}
+
+func (s *poller) stop() {
+	s.cancel()
+	<-s.done
+}
+
+func (p *Plugin) loop(ctx context.Context, s *poller) {
+
+	defer close(s.done)
+
+	var retry int
+
+	for {
+
+		err := p.oneShot(ctx, s)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		var delay time.Duration
+
+		if err == nil {
+			min := float64(*s.source.Polling.MinDelaySeconds)
+			max := float64(*s.source.Polling.MaxDelaySeconds)
+			delay = time.Duration(((max - min) * rand.Float64()) + min)
+			retry = 0
+		} else {
+			delay = util.DefaultBackoff(float64(minRetryDelay), float64(*s.source.Polling.MaxDelaySeconds), retry)
+			retry++
+		}
+
+		p.logDebug(s.name, "Waiting %v before next download/retry.", delay)
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (p *Plugin) oneShot(ctx context.Context, s *poller) error {
+
+	m := metrics.New()
+	requested := time.Now().UTC()
+
+	value, etag, modified, err := p.download(ctx, s, m)
+
+	var downloaded time.Time
+
+	if err == nil && modified {
+		downloaded = time.Now().UTC()
+		err = p.activate(ctx, s, value, m)
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	p.updateStatus(s.name, func(status *Status) {
+		status.LastRequest = requested
+		status.Metrics = m
+		status.SetError(err)
+		if !downloaded.IsZero() {
+			status.LastSuccessfulDownload = downloaded
+		}
+		if err == nil {
+			status.LastSuccessfulRequest = time.Now().UTC()
+			if modified {
+				status.SetActivateSuccess(etag)
+			}
+		}
+	})
+
+	if err != nil {
+		p.logError(s.name, "%v.", err)
+		return err
+	}
+
+	if modified {
+		s.etag = etag
+		p.logInfo(s.name, "Data source updated.")
+	} else {
+		p.logDebug(s.name, "Data source download skipped, server replied with not modified.")
+	}
+
+	return nil
+}
+
+func (p *Plugin) download(ctx context.Context, s *poller, m metrics.Metrics) (value interface{}, etag string, modified bool, err error) {
+
+	m.Timer(timerDownload).Start()
+	defer m.Timer(timerDownload).Stop()
+
+	client := p.manager.Client(s.source.Service)
+
+	if s.etag != "" {
+		client = client.WithHeader("If-None-Match", s.etag)
+	}
+
+	resp, err := client.Do(ctx, "GET", s.source.Resource)
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, "Download request failed")
+	}
+
+	defer util.Close(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := util.NewJSONDecoder(resp.Body).Decode(&value); err != nil {
+			return nil, "", false, errors.Wrap(err, "Download failed")
+		}
+		return value, resp.Header.Get("ETag"), true, nil
+	case http.StatusNotModified:
+		return nil, s.etag, false, nil
+	case http.StatusNotFound:
+		return nil, "", false, fmt.Errorf("download failed, server replied with not found")
+	case http.StatusUnauthorized:
+		return nil, "", false, fmt.Errorf("download failed, server replied with not authorized")
+	default:
+		return nil, "", false, fmt.Errorf("download failed, server replied with HTTP %v", resp.StatusCode)
+	}
+}
+
+// activate transforms the document and writes it to the path of the source.
+func (p *Plugin) activate(ctx context.Context, s *poller, value interface{}, m metrics.Metrics) error {
+
+	store := p.manager.Store
+
+	return storage.Txn(ctx, store, storage.WriteParams, func(txn storage.Transaction) error {
+
+		if err := checkBundleRoots(ctx, store, txn, s.source.Path); err != nil {
+			return err
+		}
+
+		if s.source.transform != nil {
+			var err error
+			if value, err = p.transform(ctx, txn, s.source, value, m); err != nil {
+				return err
+			}
+		}
+
+		m.Timer(timerWrite).Start()
+		defer m.Timer(timerWrite).Stop()
+
+		if err := storage.MakeDir(ctx, store, txn, s.source.path[:len(s.source.path)-1]); err != nil {
+			return err
+		}
+
+		if err := store.Write(ctx, txn, storage.AddOp, s.source.path, value); err != nil {
+			return err
+		}
+
+		return writePathToStore(ctx, store, txn, s.name, s.source.Path)
+	})
+}
+
+func (p *Plugin) transform(ctx context.Context, txn storage.Transaction, source *Source, value interface{}, m metrics.Metrics) (interface{}, error) {
+
+	m.Timer(timerTransform).Start()
+	defer m.Timer(timerTransform).Stop()
+
+	rs, err := rego.New(
+		rego.Query(source.transform.String()),
+		rego.Compiler(p.manager.GetCompiler()),
+		rego.Store(p.manager.Store),
+		rego.Transaction(txn),
+		rego.Input(value),
+	).Eval(ctx)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Transform failed")
+	}
+
+	if len(rs) == 0 {
+		return nil, fmt.Errorf("transform failed, %v is undefined", source.transform)
+	}
+
+	return rs[0].Expressions[0].Value, nil
+}
+
+// checkBundleRoots returns an error if the path overlaps the roots of an
+// activated bundle.
+func checkBundleRoots(ctx context.Context, store storage.Store, txn storage.Transaction, path string) error {
+
+	names, err := bundle.ReadBundleNamesFromStore(ctx, store, txn)
+	if err != nil && !storage.IsNotFound(err) {
+		return err
+	}
+
+	for _, name := range names {
+		roots, err := bundle.ReadBundleRootsFromStore(ctx, store, txn, name)
+		if err != nil && !storage.IsNotFound(err) {
+			return err
+		}
+		for _, root := range roots {
+			if bundle.RootPathsOverlap(path, root) {
+				return fmt.Errorf("path %v overlaps root %q of bundle %q", path, root, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// erase removes the documents and recorded paths of the named sources.
+func (p *Plugin) erase(ctx context.Context, paths map[string]storage.Path) error {
+	store := p.manager.Store
+	return storage.Txn(ctx, store, storage.WriteParams, func(txn storage.Transaction) error {
+		for name, path := range paths {
+			if err := store.Write(ctx, txn, storage.RemoveOp, path, nil); err != nil && !storage.IsNotFound(err) {
+				return err
+			}
+			if err := erasePathFromStore(ctx, store, txn, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *Plugin) updateStatus(name string, f func(*Status)) {
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	status, ok := p.status[name]
+	if !ok {
+		// The source was removed in the meantime.
+		return
+	}
+
+	f(status)
+
+	p.updatePluginStatus()
+	p.notifyListeners()
+}
+
+// updatePluginStatus reports the plugin as ready once all sources have been
+// written to data.
+func (p *Plugin) updatePluginStatus() {
+	for _, status := range p.status {
+		if status.LastSuccessfulActivation.IsZero() {
+			p.manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateNotReady})
+			return
+		}
+	}
+	p.manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateOK})
+}
+
+func (p *Plugin) notifyListeners() {
+	if len(p.listeners) == 0 {
+		return
+	}
+	status := p.copyStatus()
+	for _, listener := range p.listeners {
+		listener(status)
+	}
+}
+
+func (p *Plugin) copyStatus() map[string]*Status {
+	result := make(map[string]*Status, len(p.status))
+	for name, status := range p.status {
+		cpy := *status
+		result[name] = &cpy
+	}
+	return result
+}
+
+func (p *Plugin) logError(name string, fmt string, a ...interface{}) {
+	logrus.WithFields(p.logrusFields(name)).Errorf(fmt, a...)
+}
+
+func (p *Plugin) logInfo(name string, fmt string, a ...interface{}) {
+	logrus.WithFields(p.logrusFields(name)).Infof(fmt, a...)
+}
+
+func (p *Plugin) logDebug(name string, fmt string, a ...interface{}) {
+	logrus.WithFields(p.logrusFields(name)).Debugf(fmt, a...)
+}
+
+func (p *Plugin) logrusFields(name string) logrus.Fields {
+	fields := logrus.Fields{
+		"plugin": Name,
+	}
+	if name != "" {
+		fields["name"] = name
+	}
+	return fields
+}
@@ -0,0 +1,308 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package datasources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/plugins"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+type testServer struct {
+	server   *httptest.Server
+	etag     string
+	body     string
+	requests int
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests++
+	if r.URL.Path != "/users" || r.URL.Query().Get("active") != "true" {
+		w.WriteHeader(404)
+		return
+	}
+	if s.etag != "" && r.Header.Get("If-None-Match") == s.etag {
+		w.WriteHeader(304)
+		return
+	}
+	if s.etag != "" {
+		w.Header().Add("ETag", s.etag)
+	}
+	w.WriteHeader(200)
+	fmt.Fprint(w, s.body)
+}
+
+type fixture struct {
+	t       *testing.T
+	server  *testServer
+	manager *plugins.Manager
+	plugin  *Plugin
+}
+
+func newFixture(t *testing.T, sources string) *fixture {
+
+	ts := &testServer{}
+	ts.server = httptest.NewServer(ts)
+
+	config := fmt.Sprintf(`{
+		"services": {"acmecorp": {"url": %q}},
+		"data_sources": %v
+	}`, ts.server.URL, sources)
+
+	manager, err := plugins.New([]byte(config), "test", inmem.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	pluginConfig, err := ParseConfig(manager.Config.DataSources, manager.Services())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugin := New(pluginConfig, manager)
+	manager.Register(Name, plugin)
+
+	return &fixture{t: t, server: ts, manager: manager, plugin: plugin}
+}
+
+// oneShot runs a single download for the named source. The poller is not
+// started so the result of the download is deterministic.
+func (f *fixture) oneShot(name string) error {
+	f.t.Helper()
+	s, ok := f.plugin.sources[name]
+	if !ok {
+		s = &poller{name: name, source: f.plugin.config[name]}
+		f.plugin.sources[name] = s
+	}
+	return f.plugin.oneShot(context.Background(), s)
+}
+
+func (f *fixture) assertData(path string, exp string) {
+	f.t.Helper()
+	ctx := context.Background()
+	var result interface{}
+	err := storage.Txn(ctx, f.manager.Store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+		var err error
+		result, err = f.manager.Store.Read(ctx, txn, storage.MustParsePath(path))
+		if storage.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	var expected interface{}
+	if exp != "" {
+		expected = util.MustUnmarshalJSON([]byte(exp))
+	}
+	if !reflect.DeepEqual(result, expected) {
+		f.t.Fatalf("Expected %v at %v but got %v", expected, path, result)
+	}
+}
+
+func TestPluginDownload(t *testing.T) {
+
+	f := newFixture(t, `{"users": {"service": "acmecorp", "resource": "/users?active=true", "path": "external/users"}}`)
+	defer f.server.server.Close()
+
+	f.server.etag = "v1"
+	f.server.body = `[{"name": "alice"}]`
+
+	if err := f.oneShot("users"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.assertData("/external/users", `[{"name": "alice"}]`)
+	f.assertData("/system/data_sources/users", `{"path": "external/users"}`)
+
+	status := f.plugin.Status()["users"]
+	if status.ETag != "v1" || status.LastSuccessfulActivation.IsZero() || status.Code != "" {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+
+	if s := f.manager.PluginStatus()[Name]; s == nil || s.State != plugins.StateOK {
+		t.Fatalf("Expected plugin status OK but got %v", s)
+	}
+
+	// The server replies with not modified, the data must not change.
+	f.server.body = `[{"name": "bob"}]`
+	activated := status.LastSuccessfulActivation
+
+	if err := f.oneShot("users"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.assertData("/external/users", `[{"name": "alice"}]`)
+
+	if status := f.plugin.Status()["users"]; status.LastSuccessfulActivation != activated || status.LastSuccessfulRequest.Before(activated) {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+
+	f.server.etag = "v2"
+
+	if err := f.oneShot("users"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.assertData("/external/users", `[{"name": "bob"}]`)
+
+	if f.server.requests != 3 {
+		t.Fatalf("Expected 3 requests but got %d", f.server.requests)
+	}
+}
+
+func TestPluginDownloadError(t *testing.T) {
+
+	f := newFixture(t, `{"users": {"service": "acmecorp", "resource": "/missing", "path": "users"}}`)
+	defer f.server.server.Close()
+
+	if err := f.oneShot("users"); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	status := f.plugin.Status()["users"]
+	if status.Code != errCode || !strings.Contains(status.Message, "not found") || !status.LastSuccessfulActivation.IsZero() {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+
+	if s := f.manager.PluginStatus()[Name]; s == nil || s.State != plugins.StateNotReady {
+		t.Fatalf("Expected plugin status NOT_READY but got %v", s)
+	}
+}
+
+func TestPluginTransform(t *testing.T) {
+
+	f := newFixture(t, `{"users": {"service": "acmecorp", "resource": "/users?active=true", "path": "users", "transform": "data.transforms.users"}}`)
+	defer f.server.server.Close()
+
+	module := `package transforms
+
+	users[u.name] = u.role { u := input[_] }
+
+	empty = x { x := input[_]; false }`
+
+	ctx := context.Background()
+
+	// The manager recompiles the policies from the store on commit.
+	err := storage.Txn(ctx, f.manager.Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return f.manager.Store.UpsertPolicy(ctx, txn, "transforms.rego", []byte(module))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.server.body = `[{"name": "alice", "role": "admin"}, {"name": "bob", "role": "dev"}]`
+
+	if err := f.oneShot("users"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.assertData("/users", `{"alice": "admin", "bob": "dev"}`)
+
+	f.plugin.config["users"].transform = ast.MustParseRef("data.transforms.empty")
+
+	err = f.oneShot("users")
+	if err == nil || !strings.Contains(err.Error(), "data.transforms.empty is undefined") {
+		t.Fatalf("Expected undefined transform error but got %v", err)
+	}
+
+	f.assertData("/users", `{"alice": "admin", "bob": "dev"}`)
+}
+
+func TestPluginBundleRootsOverlap(t *testing.T) {
+
+	f := newFixture(t, `{"users": {"service": "acmecorp", "resource": "/users?active=true", "path": "acmecorp/users"}}`)
+	defer f.server.server.Close()
+
+	ctx := context.Background()
+
+	err := storage.Txn(ctx, f.manager.Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return bundle.WriteManifestToStore(ctx, f.manager.Store, txn, "authz", bundle.Manifest{Roots: &[]string{"acmecorp"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.server.body = `[]`
+
+	err = f.oneShot("users")
+	if err == nil || !strings.Contains(err.Error(), `overlaps root "acmecorp" of bundle "authz"`) {
+		t.Fatalf("Expected overlap error but got %v", err)
+	}
+
+	f.assertData("/acmecorp/users", "")
+}
+
+func TestPluginReconfigure(t *testing.T) {
+
+	f := newFixture(t, `{
+		"a": {"service": "acmecorp", "resource": "/users?active=true", "path": "a"},
+		"b": {"service": "acmecorp", "resource": "/users?active=true", "path": "b"}
+	}`)
+	defer f.server.server.Close()
+
+	f.server.body = `1`
+
+	ctx := context.Background()
+
+	if err := f.plugin.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		if s := f.manager.PluginStatus()[Name]; s != nil && s.State == plugins.StateOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for data sources")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	f.assertData("/a", `1`)
+	f.assertData("/b", `1`)
+
+	config, err := ParseConfig([]byte(`{
+		"a": {"service": "acmecorp", "resource": "/users?active=true", "path": "a"},
+		"c": {"service": "acmecorp", "resource": "/users?active=true", "path": "c"}
+	}`), f.manager.Services())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.plugin.Reconfigure(ctx, config)
+
+	f.assertData("/b", "")
+	f.assertData("/system/data_sources/b", "")
+
+	if status := f.plugin.Status(); status["b"] != nil || status["c"] == nil {
+		t.Fatalf("Unexpected status: %v", status)
+	}
+
+	f.plugin.Stop(ctx)
+
+	if s := f.manager.PluginStatus()[Name]; s == nil || s.State != plugins.StateNotReady {
+		t.Fatalf("Expected plugin status NOT_READY but got %v", s)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package datasources
+
+import (
+	"time"
+
+	"github.com/open-policy-agent/opa/metrics"
+)
+
+const (
+	errCode = "data_source_error"
+)
+
+// Status represents the status of a data source.
+type Status struct {
+	Name                     string          `json:"name"`
+	ETag                     string          `json:"etag,omitempty"`
+	LastSuccessfulActivation time.Time       `json:"last_successful_activation,omitempty"`
+	LastSuccessfulDownload   time.Time       `json:"last_successful_download,omitempty"`
+	LastSuccessfulRequest    time.Time       `json:"last_successful_request,omitempty"`
+	LastRequest              time.Time       `json:"last_request,omitempty"`
+	Code                     string          `json:"code,omitempty"`
+	Message                  string          `json:"message,omitempty"`
+	Metrics                  metrics.Metrics `json:"metrics,omitempty"`
+}
+
+// SetActivateSuccess updates the status object to reflect a successful
+// write of the document to the store.
+func (s *Status) SetActivateSuccess(etag string) {
+	s.LastSuccessfulActivation = time.Now().UTC()
+	s.ETag = etag
+}
+
+// SetError updates the status object to reflect a failure to download or
+// activate. If err is nil, the error status is cleared.
+func (s *Status) SetError(err error) {
+
+	if err == nil {
+		s.Code = ""
+		s.Message = ""
+		return
+	}
+
+	s.Code = errCode
+	s.Message = err.Error()
+}
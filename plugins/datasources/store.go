@@ -0,0 +1,99 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package datasources
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+var sourcesBasePath = storage.MustParsePath("/system/data_sources")
+
+func namedSourcePath(name string) storage.Path {
+	return append(sourcesBasePath, name)
+}
+
+func sourcePathPath(name string) storage.Path {
+	return append(sourcesBasePath, name, "path")
+}
+
+// writePathToStore records the path owned by the data source so that the
+// server and the bundle plugin can reject writes into it.
+func writePathToStore(ctx context.Context, store storage.Store, txn storage.Transaction, name string, path string) error {
+	if err := storage.MakeDir(ctx, store, txn, namedSourcePath(name)); err != nil {
+		return err
+	}
+	return store.Write(ctx, txn, storage.AddOp, sourcePathPath(name), path)
+}
+
+// erasePathFromStore removes the path owned by the data source.
+func erasePathFromStore(ctx context.Context, store storage.Store, txn storage.Transaction, name string) error {
+	err := store.Write(ctx, txn, storage.RemoveOp, namedSourcePath(name), nil)
+	if err != nil && !storage.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// ReadPathsFromStore returns the paths owned by the data sources that have
+// been written to data, keyed by the names of the sources.
+func ReadPathsFromStore(ctx context.Context, store storage.Store, txn storage.Transaction) (map[string]string, error) {
+	value, err := store.Read(ctx, txn, sourcesBasePath)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("corrupt data sources")
+	}
+
+	paths := make(map[string]string, len(obj))
+
+	for name := range obj {
+		source, ok := obj[name].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("corrupt data source")
+		}
+		paths[name], ok = source["path"].(string)
+		if !ok {
+			return nil, fmt.Errorf("corrupt data source path")
+		}
+	}
+
+	return paths, nil
+}
+
+// OverlappingSource returns the name of the data source whose path overlaps
+// the path (e.g., a bundle root.) If no path overlaps, the name is empty. If
+// several paths overlap, the first name in sorted order is returned.
+func OverlappingSource(ctx context.Context, store storage.Store, txn storage.Transaction, path string) (string, error) {
+	paths, err := ReadPathsFromStore(ctx, store, txn)
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if bundle.RootPathsOverlap(path, paths[name]) {
+			return name, nil
+		}
+	}
+
+	return "", nil
+}
@@ -22,7 +22,9 @@ import (
 	cfg "github.com/open-policy-agent/opa/internal/config"
 	"github.com/open-policy-agent/opa/plugins"
 	"github.com/open-policy-agent/opa/plugins/bundle"
+	"github.com/open-policy-agent/opa/plugins/datasources"
 	"github.com/open-policy-agent/opa/plugins/logs"
+	"github.com/open-policy-agent/opa/plugins/replication"
 	"github.com/open-policy-agent/opa/plugins/status"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/storage/inmem"
@@ -362,6 +364,16 @@ func getPluginSet(factories map[string]plugins.Factory, manager *plugins.Manager
 		return nil, err
 	}
 
+	replicationConfig, err := replication.ParseConfig(config.Replication, manager.Services())
+	if err != nil {
+		return nil, err
+	}
+
+	dataSourcesConfig, err := datasources.ParseConfig(config.DataSources, manager.Services())
+	if err != nil {
+		return nil, err
+	}
+
 	// Accumulate plugins to start or reconfigure.
 	starts := []plugins.Plugin{}
 	reconfigs := []pluginreconfig{}
@@ -393,6 +405,24 @@ func getPluginSet(factories map[string]plugins.Factory, manager *plugins.Manager
 		}
 	}
 
+	if replicationConfig != nil {
+		p, created := getReplicationPlugin(manager, replicationConfig)
+		if created {
+			starts = append(starts, p)
+		} else if p != nil {
+			reconfigs = append(reconfigs, pluginreconfig{replicationConfig, p})
+		}
+	}
+
+	if dataSourcesConfig != nil {
+		p, created := getDataSourcesPlugin(manager, dataSourcesConfig)
+		if created {
+			starts = append(starts, p)
+		} else if p != nil {
+			reconfigs = append(reconfigs, pluginreconfig{dataSourcesConfig, p})
+		}
+	}
+
 	result := &pluginSet{starts, reconfigs}
 
 	getCustomPlugins(manager, pluginFactories, result)
@@ -429,12 +459,34 @@ func getStatusPlugin(m *plugins.Manager, config *status.Config, metrics metrics.
 		plugin = status.New(config, m).WithMetrics(metrics)
 		m.Register(status.Name, plugin)
 		registerBundleStatusUpdates(m)
+		registerDataSourceStatusUpdates(m)
 		created = true
 	}
 
 	return plugin, created
 }
 
+func getReplicationPlugin(m *plugins.Manager, config *replication.Config) (plugin *replication.Plugin, created bool) {
+	plugin = replication.Lookup(m)
+	if plugin == nil {
+		plugin = replication.New(config, m)
+		m.Register(replication.Name, plugin)
+		created = true
+	}
+	return plugin, created
+}
+
+func getDataSourcesPlugin(m *plugins.Manager, config datasources.Config) (plugin *datasources.Plugin, created bool) {
+	plugin = datasources.Lookup(m)
+	if plugin == nil {
+		plugin = datasources.New(config, m)
+		m.Register(datasources.Name, plugin)
+		registerDataSourceStatusUpdates(m)
+		created = true
+	}
+	return plugin, created
+}
+
 func getCustomPlugins(manager *plugins.Manager, factories []pluginfactory, result *pluginSet) {
 	for _, pf := range factories {
 		if plugin := manager.Plugin(pf.name); plugin != nil {
@@ -463,3 +515,13 @@ func registerBundleStatusUpdates(m *plugins.Manager) {
 		bp.RegisterBulkListener(pluginlistener(status.Name), sp.BulkUpdateBundleStatus)
 	}
 }
+
+func registerDataSourceStatusUpdates(m *plugins.Manager) {
+	dp := datasources.Lookup(m)
+	sp := status.Lookup(m)
+	if dp == nil || sp == nil {
+		return
+	}
+	type pluginlistener string
+	dp.RegisterBulkListener(pluginlistener(status.Name), sp.BulkUpdateDataSourceStatus)
+}
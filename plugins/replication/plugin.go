@@ -0,0 +1,493 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package replication implements a plugin that replicates the data and
+// policies of another OPA by following its change feed.
+package replication
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/plugins"
+	"github.com/open-policy-agent/opa/server/types"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// Name identifies the plugin on manager.
+const Name = "replication"
+
+const (
+	defaultLongPollingTimeoutSeconds = int64(30)
+	minRetryDelay                    = time.Millisecond * 100
+	maxRetryDelay                    = time.Second * 60
+)
+
+// Config contains configuration for the plugin.
+type Config struct {
+	Service                   string `json:"service"`
+	LongPollingTimeoutSeconds *int64 `json:"long_polling_timeout_seconds,omitempty"`
+}
+
+func (c *Config) validateAndInjectDefaults(services []string) error {
+
+	if c.Service == "" {
+		return fmt.Errorf("invalid replication config, must have a `service` target")
+	}
+
+	found := false
+
+	for _, svc := range services {
+		if svc == c.Service {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("invalid service name %q in replication", c.Service)
+	}
+
+	if c.LongPollingTimeoutSeconds == nil {
+		timeout := defaultLongPollingTimeoutSeconds
+		c.LongPollingTimeoutSeconds = &timeout
+	} else if *c.LongPollingTimeoutSeconds < 0 {
+		return fmt.Errorf("invalid replication config, `long_polling_timeout_seconds` must not be negative")
+	}
+
+	return nil
+}
+
+// ParseConfig validates the config and injects default values.
+func ParseConfig(config []byte, services []string) (*Config, error) {
+
+	if config == nil {
+		return nil, nil
+	}
+
+	var parsedConfig Config
+
+	if err := util.Unmarshal(config, &parsedConfig); err != nil {
+		return nil, err
+	}
+
+	if err := parsedConfig.validateAndInjectDefaults(services); err != nil {
+		return nil, err
+	}
+
+	return &parsedConfig, nil
+}
+
+// Plugin replicates the data and policies of a leader OPA into the local
+// store. The plugin bootstraps the store from a snapshot of the leader and then
+// applies the changes read from the leader's change feed (see the /v1/changes
+// API.) Each batch of changes is applied in one transaction. If the leader no
+// longer has the changes after the last applied change, e.g., because the
+// follower fell too far behind or the leader restarted, the plugin bootstraps
+// the store from a new snapshot.
+//
+// The snapshot replaces all data and policies in the local store, so the
+// local store should not be modified by other means.
+type Plugin struct {
+	manager *plugins.Manager
+	mtx     sync.Mutex
+	config  Config
+	feed    string // ID of the change feed, empty until the store is bootstrapped
+	seq     uint64 // sequence number of the last applied change
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// New returns a new Plugin with the given config.
+func New(parsedConfig *Config, manager *plugins.Manager) *Plugin {
+	p := &Plugin{
+		manager: manager,
+		config:  *parsedConfig,
+	}
+
+	p.manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateNotReady})
+
+	return p
+}
+
+// Lookup returns the replication plugin registered with the manager.
+func Lookup(manager *plugins.Manager) *Plugin {
+	if p := manager.Plugin(Name); p != nil {
+		return p.(*Plugin)
+	}
+	return nil
+}
+
+// Start starts the plugin.
+func (p *Plugin) Start(ctx context.Context) error {
+	p.logInfo("Starting replication.")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	p.mtx.Lock()
+	p.cancel, p.done = cancel, done
+	p.mtx.Unlock()
+
+	go p.loop(ctx, done)
+
+	return nil
+}
+
+// Stop stops the plugin.
+func (p *Plugin) Stop(ctx context.Context) {
+	p.logInfo("Stopping replication.")
+
+	p.mtx.Lock()
+	cancel, done := p.cancel, p.done
+	p.mtx.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	p.manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateNotReady})
+}
+
+// Reconfigure notifies the plugin with a new configuration. If the service
+// changes, the store is bootstrapped from the new service.
+func (p *Plugin) Reconfigure(_ context.Context, config interface{}) {
+
+	newConfig := config.(*Config)
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if reflect.DeepEqual(p.config, *newConfig) {
+		p.logDebug("Replication configuration unchanged.")
+		return
+	}
+
+	p.logInfo("Replication configuration changed.")
+
+	if p.config.Service != newConfig.Service {
+		p.feed = ""
+	}
+
+	p.config = *newConfig
+}
+
+func (p *Plugin) loop(ctx context.Context, done chan struct{}) {
+
+	defer close(done)
+
+	var retry int
+
+	for {
+
+		err := p.oneShot(ctx)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		var delay time.Duration
+
+		if err != nil {
+			p.logError("%v.", err)
+			delay = util.DefaultBackoff(float64(minRetryDelay), float64(maxRetryDelay), retry)
+			retry++
+		} else {
+			retry = 0
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Plugin) oneShot(ctx context.Context) error {
+
+	p.mtx.Lock()
+	config, feed, seq := p.config, p.feed, p.seq
+	p.mtx.Unlock()
+
+	if feed == "" {
+		return p.bootstrap(ctx, config)
+	}
+
+	return p.poll(ctx, config, feed, seq)
+}
+
+func (p *Plugin) bootstrap(ctx context.Context, config Config) error {
+
+	p.logDebug("Downloading snapshot.")
+
+	resp, err := p.manager.Client(config.Service).Do(ctx, "GET", "/v1/changes/snapshot")
+	if err != nil {
+		return errors.Wrap(err, "Snapshot download failed")
+	}
+
+	defer util.Close(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("snapshot download failed, server replied with HTTP %v", resp.StatusCode)
+	}
+
+	var snapshot types.ChangesSnapshotResponseV1
+
+	if err := util.NewJSONDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return errors.Wrap(err, "Snapshot download failed")
+	}
+
+	params := storage.WriteParams
+	params.Context = storage.NewContext()
+
+	err = storage.Txn(ctx, p.manager.Store, params, func(txn storage.Transaction) error {
+
+		if err := p.manager.Store.Write(ctx, txn, storage.AddOp, storage.Path{}, snapshot.Data); err != nil {
+			return err
+		}
+
+		ids, err := p.manager.Store.ListPolicies(ctx, txn)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := p.manager.Store.DeletePolicy(ctx, txn, id); err != nil {
+				return err
+			}
+		}
+
+		for _, policy := range snapshot.Policies {
+			if err := p.manager.Store.UpsertPolicy(ctx, txn, policy.ID, []byte(policy.Raw)); err != nil {
+				return err
+			}
+		}
+
+		return p.compile(ctx, txn, params.Context)
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "Snapshot activation failed")
+	}
+
+	p.mtx.Lock()
+	if p.config.Service == config.Service {
+		p.feed, p.seq = snapshot.Feed, snapshot.Sequence
+	}
+	p.mtx.Unlock()
+
+	p.logInfo("Store bootstrapped from snapshot at sequence %d.", snapshot.Sequence)
+	p.manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateOK})
+
+	return nil
+}
+
+func (p *Plugin) poll(ctx context.Context, config Config, feed string, seq uint64) error {
+
+	path := fmt.Sprintf("/v1/changes?%v=%v&%v=%d&%v=%d",
+		types.ParamFeedV1, feed, types.ParamSinceV1, seq, types.ParamWaitV1, *config.LongPollingTimeoutSeconds)
+
+	resp, err := p.manager.Client(config.Service).Do(ctx, "GET", path)
+	if err != nil {
+		return errors.Wrap(err, "Change feed request failed")
+	}
+
+	defer util.Close(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		p.logInfo("Changes after sequence %d are no longer available, bootstrapping from snapshot.", seq)
+		p.reset(config, feed)
+		return nil
+	default:
+		return fmt.Errorf("change feed request failed, server replied with HTTP %v", resp.StatusCode)
+	}
+
+	var changes types.ChangesResponseV1
+
+	if err := util.NewJSONDecoder(resp.Body).Decode(&changes); err != nil {
+		return errors.Wrap(err, "Change feed request failed")
+	}
+
+	if changes.Feed != feed {
+		p.logInfo("Change feed %v replaced by %v, bootstrapping from snapshot.", feed, changes.Feed)
+		p.reset(config, feed)
+		return nil
+	}
+
+	if len(changes.Changes) == 0 {
+		return nil
+	}
+
+	for i, change := range changes.Changes {
+		if change.Sequence != seq+uint64(i)+1 {
+			return fmt.Errorf("change feed request failed, expected sequence %d but got %d", seq+uint64(i)+1, change.Sequence)
+		}
+	}
+
+	if err := p.apply(ctx, changes.Changes); err != nil {
+		return errors.Wrap(err, "Change activation failed")
+	}
+
+	last := changes.Changes[len(changes.Changes)-1].Sequence
+
+	p.mtx.Lock()
+	if p.feed == feed && p.seq == seq {
+		p.seq = last
+	}
+	p.mtx.Unlock()
+
+	p.logDebug("Applied changes up to sequence %d.", last)
+
+	return nil
+}
+
+// reset causes the next iteration to bootstrap the store from a snapshot
+// unless the plugin was reconfigured in the meantime.
+func (p *Plugin) reset(config Config, feed string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.config.Service == config.Service && p.feed == feed {
+		p.feed = ""
+	}
+}
+
+func (p *Plugin) apply(ctx context.Context, changes []types.ChangeV1) error {
+
+	params := storage.WriteParams
+	params.Context = storage.NewContext()
+
+	return storage.Txn(ctx, p.manager.Store, params, func(txn storage.Transaction) error {
+
+		var policyChanged bool
+
+		for _, change := range changes {
+
+			for _, dc := range change.Data {
+				if err := applyDataChange(ctx, p.manager.Store, txn, dc); err != nil {
+					return err
+				}
+			}
+
+			for _, pc := range change.Policies {
+				var err error
+				if pc.Removed {
+					err = p.manager.Store.DeletePolicy(ctx, txn, pc.ID)
+				} else {
+					err = p.manager.Store.UpsertPolicy(ctx, txn, pc.ID, []byte(pc.Raw))
+				}
+				if err != nil && !storage.IsNotFound(err) {
+					return err
+				}
+				policyChanged = true
+			}
+		}
+
+		if !policyChanged {
+			return nil
+		}
+
+		return p.compile(ctx, txn, params.Context)
+	})
+}
+
+// applyDataChange writes the change to the store. Changes contain the new
+// value of objects keys and array elements, so existing values are replaced
+// and missing object keys are added.
+func applyDataChange(ctx context.Context, store storage.Store, txn storage.Transaction, dc types.DataChangeV1) error {
+
+	path := storage.Path(dc.Path)
+
+	if dc.Removed {
+		if err := store.Write(ctx, txn, storage.RemoveOp, path, nil); err != nil && !storage.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+
+	var value interface{}
+	if dc.Value != nil {
+		value = *dc.Value
+	}
+
+	if len(path) == 0 {
+		return store.Write(ctx, txn, storage.AddOp, path, value)
+	}
+
+	err := store.Write(ctx, txn, storage.ReplaceOp, path, value)
+	if err == nil || !storage.IsNotFound(err) {
+		return err
+	}
+
+	if err := storage.MakeDir(ctx, store, txn, path[:len(path)-1]); err != nil {
+		return err
+	}
+
+	return store.Write(ctx, txn, storage.AddOp, path, value)
+}
+
+// compile compiles the policies in the store and sets the compiler on the
+// storage context for use by the manager. Changes that contain policies that
+// do not compile are rejected.
+func (p *Plugin) compile(ctx context.Context, txn storage.Transaction, storeCtx *storage.Context) error {
+
+	ids, err := p.manager.Store.ListPolicies(ctx, txn)
+	if err != nil {
+		return err
+	}
+
+	modules := map[string]*ast.Module{}
+
+	for _, id := range ids {
+		bs, err := p.manager.Store.GetPolicy(ctx, txn, id)
+		if err != nil {
+			return err
+		}
+		module, err := ast.ParseModule(id, string(bs))
+		if err != nil {
+			return err
+		}
+		modules[id] = module
+	}
+
+	compiler := ast.NewCompiler().WithPathConflictsCheck(storage.NonEmpty(ctx, p.manager.Store, txn))
+
+	if compiler.Compile(modules); compiler.Failed() {
+		return compiler.Errors
+	}
+
+	plugins.SetCompilerOnContext(storeCtx, compiler)
+
+	return nil
+}
+
+func (p *Plugin) logError(fmt string, a ...interface{}) {
+	logrus.WithFields(p.logrusFields()).Errorf(fmt, a...)
+}
+
+func (p *Plugin) logInfo(fmt string, a ...interface{}) {
+	logrus.WithFields(p.logrusFields()).Infof(fmt, a...)
+}
+
+func (p *Plugin) logDebug(fmt string, a ...interface{}) {
+	logrus.WithFields(p.logrusFields()).Debugf(fmt, a...)
+}
+
+func (p *Plugin) logrusFields() logrus.Fields {
+	return logrus.Fields{
+		"plugin": Name,
+	}
+}
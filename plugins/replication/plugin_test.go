@@ -0,0 +1,271 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package replication
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/plugins"
+	"github.com/open-policy-agent/opa/server"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+func TestParseConfig(t *testing.T) {
+
+	tests := []struct {
+		note    string
+		config  string
+		timeout int64
+		err     string
+	}{
+		{
+			note:    "defaults",
+			config:  `{"service": "leader"}`,
+			timeout: defaultLongPollingTimeoutSeconds,
+		},
+		{
+			note:    "timeout",
+			config:  `{"service": "leader", "long_polling_timeout_seconds": 5}`,
+			timeout: 5,
+		},
+		{
+			note:   "missing service",
+			config: `{}`,
+			err:    "invalid replication config, must have a `service` target",
+		},
+		{
+			note:   "unknown service",
+			config: `{"service": "deadbeef"}`,
+			err:    `invalid service name "deadbeef" in replication`,
+		},
+		{
+			note:   "negative timeout",
+			config: `{"service": "leader", "long_polling_timeout_seconds": -1}`,
+			err:    "`long_polling_timeout_seconds` must not be negative",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			config, err := ParseConfig([]byte(tc.config), []string{"leader"})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected error containing %q but got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *config.LongPollingTimeoutSeconds != tc.timeout {
+				t.Fatalf("Expected timeout %d but got %d", tc.timeout, *config.LongPollingTimeoutSeconds)
+			}
+		})
+	}
+}
+
+type fixture struct {
+	t        *testing.T
+	leader   *httptest.Server
+	follower *plugins.Manager
+	plugin   *Plugin
+}
+
+func newFixture(t *testing.T, size int) *fixture {
+
+	ctx := context.Background()
+	store := inmem.New()
+
+	m, err := plugins.New([]byte{}, "leader", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := server.New().WithStore(store).WithManager(m).WithChangeFeed(size).Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leader := httptest.NewServer(s.Handler)
+
+	config := fmt.Sprintf(`{
+		"services": {"leader": {"url": %q}},
+		"replication": {"service": "leader", "long_polling_timeout_seconds": 1}
+	}`, leader.URL)
+
+	follower, err := plugins.New([]byte(config), "follower", inmem.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := follower.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	pluginConfig, err := ParseConfig(follower.Config.Replication, follower.Services())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugin := New(pluginConfig, follower)
+	follower.Register(Name, plugin)
+
+	return &fixture{t: t, leader: leader, follower: follower, plugin: plugin}
+}
+
+func (f *fixture) request(method, path, body string) {
+	f.t.Helper()
+	req, err := http.NewRequest(method, f.leader.URL+path, bytes.NewBufferString(body))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		f.t.Fatalf("Unexpected status for %v %v: %v", method, path, resp.StatusCode)
+	}
+}
+
+func (f *fixture) oneShot() {
+	f.t.Helper()
+	if err := f.plugin.oneShot(context.Background()); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fixture) read(path string) interface{} {
+	f.t.Helper()
+	ctx := context.Background()
+	var result interface{}
+	err := storage.Txn(ctx, f.follower.Store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+		var err error
+		result, err = f.follower.Store.Read(ctx, txn, storage.MustParsePath(path))
+		if storage.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return result
+}
+
+func (f *fixture) assertData(path string, exp string) {
+	f.t.Helper()
+	var expected interface{}
+	if exp != "" {
+		expected = util.MustUnmarshalJSON([]byte(exp))
+	}
+	if result := f.read(path); !reflect.DeepEqual(result, expected) {
+		f.t.Fatalf("Expected %v at %v but got %v", expected, path, result)
+	}
+}
+
+func TestPluginReplicates(t *testing.T) {
+
+	f := newFixture(t, 2)
+	defer f.leader.Close()
+
+	f.request(http.MethodPut, "/v1/data/a", `{"b": [1], "c": "x"}`)
+	f.request(http.MethodPut, "/v1/policies/test", "package test\n\np = data.a.c")
+
+	f.oneShot()
+
+	if f.plugin.feed == "" || f.plugin.seq != 2 {
+		t.Fatalf("Expected plugin to be bootstrapped at sequence 2 but got %q %d", f.plugin.feed, f.plugin.seq)
+	}
+
+	if status := f.follower.PluginStatus()[Name]; status == nil || status.State != plugins.StateOK {
+		t.Fatalf("Expected plugin status OK but got %v", status)
+	}
+
+	f.assertData("/a", `{"b": [1], "c": "x"}`)
+
+	if f.follower.GetCompiler().Modules["test"] == nil {
+		t.Fatal("Expected follower compiler to contain the replicated policy")
+	}
+
+	f.request(http.MethodPatch, "/v1/data/a/b", `[{"op": "add", "path": "-", "value": 2}]`)
+	f.request(http.MethodDelete, "/v1/data/a/c", "")
+
+	f.oneShot()
+
+	if f.plugin.seq != 4 {
+		t.Fatalf("Expected sequence 4 but got %d", f.plugin.seq)
+	}
+
+	f.assertData("/a", `{"b": [1, 2]}`)
+
+	// Expire the changes the follower has not applied yet. The follower has
+	// to bootstrap from a new snapshot.
+	f.request(http.MethodPut, "/v1/data/d/e", `1`)
+	f.request(http.MethodPut, "/v1/data/d/f", `2`)
+	f.request(http.MethodDelete, "/v1/policies/test", "")
+
+	f.oneShot()
+
+	if f.plugin.feed != "" {
+		t.Fatal("Expected plugin to reset the feed")
+	}
+
+	f.oneShot()
+
+	if f.plugin.seq != 7 {
+		t.Fatalf("Expected sequence 7 but got %d", f.plugin.seq)
+	}
+
+	f.assertData("/d", `{"e": 1, "f": 2}`)
+
+	if f.follower.GetCompiler().Modules["test"] != nil {
+		t.Fatal("Expected follower compiler not to contain the removed policy")
+	}
+}
+
+func TestPluginStartStop(t *testing.T) {
+
+	f := newFixture(t, 10)
+	defer f.leader.Close()
+
+	ctx := context.Background()
+
+	if err := f.plugin.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	f.request(http.MethodPut, "/v1/data/x", `1`)
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for f.read("/x") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for replication")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	f.plugin.Stop(ctx)
+
+	if status := f.follower.PluginStatus()[Name]; status == nil || status.State != plugins.StateNotReady {
+		t.Fatalf("Expected plugin status NOT_READY but got %v", status)
+	}
+}
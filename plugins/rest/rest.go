@@ -33,6 +33,7 @@ type HTTPAuthPlugin interface {
 type Config struct {
 	Name           string            `json:"name"`
 	URL            string            `json:"url"`
+	Type           string            `json:"type,omitempty"`
 	Headers        map[string]string `json:"headers"`
 	AllowInsureTLS bool              `json:"allow_insecure_tls,omitempty"`
 	Credentials    struct {
@@ -142,6 +143,14 @@ func (c Client) WithHeader(k, v string) Client {
 	return c
 }
 
+// WithURL returns a shallow copy of the client that sends requests to the
+// given base URL instead of the configured one. The credentials and TLS
+// settings of the client are unchanged.
+func (c Client) WithURL(url string) Client {
+	c.config.URL = strings.TrimRight(url, "/")
+	return c
+}
+
 // WithJSON returns a shallow copy of the client with the JSON value set as the
 // message body to include the requests. This function sets the Content-Type
 // header.
@@ -18,18 +18,20 @@ import (
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/plugins"
 	"github.com/open-policy-agent/opa/plugins/bundle"
+	"github.com/open-policy-agent/opa/plugins/datasources"
 	"github.com/open-policy-agent/opa/util"
 )
 
 // UpdateRequestV1 represents the status update message that OPA sends to
 // remote HTTP endpoints.
 type UpdateRequestV1 struct {
-	Labels    map[string]string          `json:"labels"`
-	Bundle    *bundle.Status             `json:"bundle,omitempty"` // Deprecated: Use bulk `bundles` status updates instead
-	Bundles   map[string]*bundle.Status  `json:"bundles,omitempty"`
-	Discovery *bundle.Status             `json:"discovery,omitempty"`
-	Metrics   map[string]interface{}     `json:"metrics,omitempty"`
-	Plugins   map[string]*plugins.Status `json:"plugins,omitempty"`
+	Labels      map[string]string              `json:"labels"`
+	Bundle      *bundle.Status                 `json:"bundle,omitempty"` // Deprecated: Use bulk `bundles` status updates instead
+	Bundles     map[string]*bundle.Status      `json:"bundles,omitempty"`
+	Discovery   *bundle.Status                 `json:"discovery,omitempty"`
+	DataSources map[string]*datasources.Status `json:"data_sources,omitempty"`
+	Metrics     map[string]interface{}         `json:"metrics,omitempty"`
+	Plugins     map[string]*plugins.Status     `json:"plugins,omitempty"`
 }
 
 // Plugin implements status reporting. Updates can be triggered by the caller.
@@ -42,6 +44,8 @@ type Plugin struct {
 	lastBundleStatuses map[string]*bundle.Status
 	discoCh            chan bundle.Status
 	lastDiscoStatus    *bundle.Status
+	dataSourceCh       chan map[string]*datasources.Status
+	lastDataSources    map[string]*datasources.Status
 	stop               chan chan struct{}
 	reconfig           chan interface{}
 	metrics            metrics.Metrics
@@ -115,6 +119,7 @@ func New(parsedConfig *Config, manager *plugins.Manager) *Plugin {
 		bundleCh:       make(chan bundle.Status),
 		bulkBundleCh:   make(chan map[string]*bundle.Status),
 		discoCh:        make(chan bundle.Status),
+		dataSourceCh:   make(chan map[string]*datasources.Status),
 		stop:           make(chan chan struct{}),
 		reconfig:       make(chan interface{}),
 		pluginStatusCh: make(chan map[string]*plugins.Status),
@@ -185,6 +190,11 @@ func (p *Plugin) UpdateDiscoveryStatus(status bundle.Status) {
 	p.discoCh <- status
 }
 
+// BulkUpdateDataSourceStatus notifies the plugin that a data source was updated.
+func (p *Plugin) BulkUpdateDataSourceStatus(status map[string]*datasources.Status) {
+	p.dataSourceCh <- status
+}
+
 // UpdatePluginStatus notifies the plugin that a plugin status was updated.
 func (p *Plugin) UpdatePluginStatus(status map[string]*plugins.Status) {
 	p.pluginStatusCh <- status
@@ -233,6 +243,14 @@ func (p *Plugin) loop() {
 			} else {
 				p.logInfo("Status update sent successfully in response to discovery update.")
 			}
+		case statuses := <-p.dataSourceCh:
+			p.lastDataSources = statuses
+			err := p.oneShot(ctx)
+			if err != nil {
+				p.logError("%v.", err)
+			} else {
+				p.logInfo("Status update sent successfully in response to data source update.")
+			}
 
 		case newConfig := <-p.reconfig:
 			p.reconfigure(newConfig)
@@ -248,11 +266,12 @@ func (p *Plugin) loop() {
 func (p *Plugin) oneShot(ctx context.Context) error {
 
 	req := &UpdateRequestV1{
-		Labels:    p.manager.Labels(),
-		Discovery: p.lastDiscoStatus,
-		Bundle:    p.lastBundleStatus,
-		Bundles:   p.lastBundleStatuses,
-		Plugins:   p.lastPluginStatuses,
+		Labels:      p.manager.Labels(),
+		Discovery:   p.lastDiscoStatus,
+		Bundle:      p.lastBundleStatus,
+		Bundles:     p.lastBundleStatuses,
+		DataSources: p.lastDataSources,
+		Plugins:     p.lastPluginStatuses,
 	}
 
 	if p.metrics != nil {
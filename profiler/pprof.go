@@ -0,0 +1,345 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+// Sample represents the time spent on expressions evaluated with the same
+// call stack. The innermost frame of the stack is first.
+type Sample struct {
+	Stack      []Frame `json:"stack"`
+	ExprTimeNs int64   `json:"total_time_ns"`
+	NumEval    int64   `json:"num_eval"`
+	NumRedo    int64   `json:"num_redo"`
+}
+
+// Profile represents the time spent on expressions aggregated by call stack.
+// Profiles can be written in the pprof format and in the folded stack format
+// read by flame graph tools.
+type Profile struct {
+	samples map[string]*Sample
+}
+
+// NewProfile returns an empty Profile.
+func NewProfile() *Profile {
+	return &Profile{
+		samples: map[string]*Sample{},
+	}
+}
+
+// Samples returns the samples in the profile sorted by call stack.
+func (p *Profile) Samples() []Sample {
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]Sample, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, *p.samples[key])
+	}
+	return result
+}
+
+// Copy returns a deep copy of p.
+func (p *Profile) Copy() *Profile {
+	cpy := NewProfile()
+	cpy.Merge(p)
+	return cpy
+}
+
+// Reset removes all samples from p.
+func (p *Profile) Reset() {
+	p.samples = map[string]*Sample{}
+}
+
+// Merge adds the samples from other to p.
+func (p *Profile) Merge(other *Profile) {
+	for key, s := range other.samples {
+		existing, ok := p.samples[key]
+		if !ok {
+			existing = &Sample{Stack: append([]Frame(nil), s.Stack...)}
+			p.samples[key] = existing
+		}
+		existing.ExprTimeNs += s.ExprTimeNs
+		existing.NumEval += s.NumEval
+		existing.NumRedo += s.NumRedo
+	}
+}
+
+func (p *Profile) add(stack []Frame, timeNs int64, op topdown.Op) {
+	key := stackKey(stack)
+	s, ok := p.samples[key]
+	if !ok {
+		s = &Sample{Stack: stack}
+		p.samples[key] = s
+	}
+	s.ExprTimeNs += timeNs
+	switch op {
+	case topdown.EvalOp:
+		s.NumEval++
+	case topdown.RedoOp:
+		s.NumRedo++
+	}
+}
+
+func stackKey(stack []Frame) string {
+	var buf strings.Builder
+	for _, f := range stack {
+		fmt.Fprintf(&buf, "%s\x00%s\x00%d\x00%d\x00", f.Function, f.File, f.StartLine, f.Line)
+	}
+	return buf.String()
+}
+
+// WriteFolded writes the profile in the folded stack format used by flame
+// graph tools. Each line contains the semicolon separated names of the rules
+// and functions on a call stack, outermost first, followed by the time in
+// nanoseconds spent on expressions evaluated with that stack.
+func (p *Profile) WriteFolded(w io.Writer) error {
+
+	totals := map[string]int64{}
+	var lines []string
+
+	for _, s := range p.samples {
+		names := make([]string, len(s.Stack))
+		for i, f := range s.Stack {
+			names[len(s.Stack)-1-i] = f.Function
+		}
+		line := strings.Join(names, ";")
+		if _, ok := totals[line]; !ok {
+			lines = append(lines, line)
+		}
+		totals[line] += s.ExprTimeNs
+	}
+
+	sort.Strings(lines)
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(w, "%s %d\n", line, totals[line]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WritePprof writes the profile as a gzip compressed protocol buffer in the
+// format read by the pprof tool. Samples carry the time spent on expressions
+// in nanoseconds and the number of eval and redo events. Locations refer to
+// lines in policy files and functions refer to rules.
+func (p *Profile) WritePprof(w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	if _, err := gw.Write(p.encodePprof()); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// Field numbers from the pprof profile.proto definition.
+const (
+	pprofProfileSampleType        = 1
+	pprofProfileSample            = 2
+	pprofProfileLocation          = 4
+	pprofProfileFunction          = 5
+	pprofProfileStringTable       = 6
+	pprofProfileDefaultSampleType = 14
+
+	pprofValueTypeType = 1
+	pprofValueTypeUnit = 2
+
+	pprofSampleLocationID = 1
+	pprofSampleValue      = 2
+
+	pprofLocationID   = 1
+	pprofLocationLine = 4
+
+	pprofLineFunctionID = 1
+	pprofLineLine       = 2
+
+	pprofFunctionID         = 1
+	pprofFunctionName       = 2
+	pprofFunctionSystemName = 3
+	pprofFunctionFilename   = 4
+	pprofFunctionStartLine  = 5
+)
+
+type pprofFunction struct {
+	name      string
+	file      string
+	startLine int
+}
+
+type pprofLocation struct {
+	function uint64
+	line     int
+}
+
+// pprofEncoder assigns IDs to the strings, functions and locations of a
+// profile. IDs are assigned in order of first use starting at 1; index 0 of
+// the string table is the empty string.
+type pprofEncoder struct {
+	strings      []string
+	stringIdx    map[string]int64
+	functions    []pprofFunction
+	functionIdx  map[pprofFunction]uint64
+	locations    []pprofLocation
+	locationIdx  map[pprofLocation]uint64
+	sampleStacks [][]uint64
+}
+
+func (p *Profile) encodePprof() []byte {
+
+	enc := &pprofEncoder{
+		stringIdx:   map[string]int64{},
+		functionIdx: map[pprofFunction]uint64{},
+		locationIdx: map[pprofLocation]uint64{},
+	}
+
+	enc.str("")
+
+	var buf protoBuffer
+
+	for _, typ := range [][2]string{{"time", "nanoseconds"}, {"eval", "count"}, {"redo", "count"}} {
+		var vt protoBuffer
+		vt.int64Field(pprofValueTypeType, enc.str(typ[0]))
+		vt.int64Field(pprofValueTypeUnit, enc.str(typ[1]))
+		buf.bytesField(pprofProfileSampleType, vt.Bytes())
+	}
+
+	for _, s := range p.Samples() {
+		ids := make([]uint64, len(s.Stack))
+		for i, f := range s.Stack {
+			ids[i] = enc.location(f)
+		}
+		var sample protoBuffer
+		sample.packedUint64s(pprofSampleLocationID, ids)
+		sample.packedUint64s(pprofSampleValue, []uint64{uint64(s.ExprTimeNs), uint64(s.NumEval), uint64(s.NumRedo)})
+		buf.bytesField(pprofProfileSample, sample.Bytes())
+	}
+
+	for i, l := range enc.locations {
+		var line protoBuffer
+		line.uint64Field(pprofLineFunctionID, l.function)
+		line.int64Field(pprofLineLine, int64(l.line))
+		var loc protoBuffer
+		loc.uint64Field(pprofLocationID, uint64(i+1))
+		loc.bytesField(pprofLocationLine, line.Bytes())
+		buf.bytesField(pprofProfileLocation, loc.Bytes())
+	}
+
+	for i, f := range enc.functions {
+		var fn protoBuffer
+		fn.uint64Field(pprofFunctionID, uint64(i+1))
+		fn.int64Field(pprofFunctionName, enc.str(f.name))
+		fn.int64Field(pprofFunctionSystemName, enc.str(f.name))
+		fn.int64Field(pprofFunctionFilename, enc.str(f.file))
+		fn.int64Field(pprofFunctionStartLine, int64(f.startLine))
+		buf.bytesField(pprofProfileFunction, fn.Bytes())
+	}
+
+	// The default sample type is added to the string table before it is
+	// written.
+	defaultType := enc.str("time")
+
+	for _, s := range enc.strings {
+		buf.bytesField(pprofProfileStringTable, []byte(s))
+	}
+
+	buf.int64Field(pprofProfileDefaultSampleType, defaultType)
+
+	return buf.Bytes()
+}
+
+func (enc *pprofEncoder) str(s string) int64 {
+	if idx, ok := enc.stringIdx[s]; ok {
+		return idx
+	}
+	idx := int64(len(enc.strings))
+	enc.strings = append(enc.strings, s)
+	enc.stringIdx[s] = idx
+	return idx
+}
+
+func (enc *pprofEncoder) location(f Frame) uint64 {
+	fn := pprofFunction{name: f.Function, file: f.File, startLine: f.StartLine}
+	fnID, ok := enc.functionIdx[fn]
+	if !ok {
+		enc.functions = append(enc.functions, fn)
+		fnID = uint64(len(enc.functions))
+		enc.functionIdx[fn] = fnID
+	}
+	loc := pprofLocation{function: fnID, line: f.Line}
+	id, ok := enc.locationIdx[loc]
+	if !ok {
+		enc.locations = append(enc.locations, loc)
+		id = uint64(len(enc.locations))
+		enc.locationIdx[loc] = id
+	}
+	return id
+}
+
+// protoBuffer implements the subset of the protocol buffer wire format needed
+// to encode pprof profiles. Fields set to zero values are omitted.
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+const (
+	protoWireVarint = 0
+	protoWireBytes  = 2
+)
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	b.WriteByte(byte(x))
+}
+
+func (b *protoBuffer) key(field int, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (b *protoBuffer) uint64Field(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, protoWireVarint)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64Field(field int, x int64) {
+	b.uint64Field(field, uint64(x))
+}
+
+// bytesField writes a length delimited field. Unlike scalar fields, empty
+// values are written because repeated fields such as the string table must
+// retain their position.
+func (b *protoBuffer) bytesField(field int, x []byte) {
+	b.key(field, protoWireBytes)
+	b.varint(uint64(len(x)))
+	b.Write(x)
+}
+
+func (b *protoBuffer) packedUint64s(field int, xs []uint64) {
+	if len(xs) == 0 {
+		return
+	}
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytesField(field, packed.Bytes())
+}
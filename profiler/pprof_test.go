@@ -0,0 +1,246 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+const stackModule = `package test
+
+f(x) = y {
+	y := x * 2
+}
+
+p {
+	v := [1, 2, 3][_]
+	f(v) > 4
+	q
+}
+
+q {
+	count([1, 2]) == 2
+}`
+
+func profileStackModule(t *testing.T) *Profile {
+	t.Helper()
+
+	profiler := New()
+
+	_, err := rego.New(
+		rego.Module("test.rego", stackModule),
+		rego.Query("data.test.p"),
+		rego.QueryTracer(profiler),
+	).Eval(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return profiler.Profile()
+}
+
+func TestProfileStacks(t *testing.T) {
+
+	profile := profileStackModule(t)
+
+	var result []string
+	for _, s := range profile.Samples() {
+		frames := make([]string, len(s.Stack))
+		for i, f := range s.Stack {
+			frames[i] = fmt.Sprintf("%v:%v", f.Function, f.Line)
+		}
+		result = append(result, strings.Join(frames, " "))
+	}
+
+	sort.Strings(result)
+
+	exp := []string{
+		"data.test.f:4 data.test.p:9 query:1",
+		"data.test.p:10 query:1",
+		"data.test.p:8 query:1",
+		"data.test.p:9 query:1",
+		"data.test.q:14 data.test.p:10 query:1",
+		"query:1",
+	}
+
+	if !reflect.DeepEqual(result, exp) {
+		t.Fatalf("Expected stacks:\n\n%v\n\nGot:\n\n%v", strings.Join(exp, "\n"), strings.Join(result, "\n"))
+	}
+
+	for _, s := range profile.Samples() {
+		if s.Stack[0].Function == "data.test.f" && (s.NumEval == 0 || s.Stack[0].StartLine != 3 || s.Stack[0].File != "test.rego") {
+			t.Fatalf("Unexpected sample for f: %+v", s)
+		}
+	}
+}
+
+func TestProfileMerge(t *testing.T) {
+
+	profile := profileStackModule(t)
+	merged := profile.Copy()
+	merged.Merge(profile)
+
+	a, b := profile.Samples(), merged.Samples()
+
+	if len(a) != len(b) {
+		t.Fatalf("Expected %d samples but got %d", len(a), len(b))
+	}
+
+	for i := range a {
+		if b[i].NumEval != 2*a[i].NumEval || b[i].NumRedo != 2*a[i].NumRedo || b[i].ExprTimeNs != 2*a[i].ExprTimeNs {
+			t.Fatalf("Expected doubled sample %+v but got %+v", a[i], b[i])
+		}
+	}
+
+	merged.Reset()
+
+	if samples := merged.Samples(); len(samples) != 0 {
+		t.Fatalf("Expected no samples after reset but got %v", samples)
+	}
+}
+
+func TestWriteFolded(t *testing.T) {
+
+	var buf bytes.Buffer
+	if err := profileStackModule(t).WriteFolded(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var stacks []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		stacks = append(stacks, line[:strings.LastIndex(line, " ")])
+	}
+
+	exp := []string{
+		"query",
+		"query;data.test.p",
+		"query;data.test.p;data.test.f",
+		"query;data.test.p;data.test.q",
+	}
+
+	if !reflect.DeepEqual(stacks, exp) {
+		t.Fatalf("Expected %v but got %v", exp, stacks)
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+
+	profile := profileStackModule(t)
+
+	var buf bytes.Buffer
+	if err := profile.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := decodeProtoFields(t, bs)
+
+	var strs []string
+	for _, f := range fields[pprofProfileStringTable] {
+		strs = append(strs, string(f.bytes))
+	}
+
+	if strs[0] != "" {
+		t.Fatalf("Expected empty string at index 0 but got %q", strs[0])
+	}
+
+	for _, s := range []string{"time", "nanoseconds", "eval", "redo", "count", "query", "data.test.p", "data.test.f", "data.test.q", "test.rego"} {
+		found := false
+		for _, x := range strs {
+			found = found || x == s
+		}
+		if !found {
+			t.Errorf("Expected %q in string table %v", s, strs)
+		}
+	}
+
+	if len(fields[pprofProfileSampleType]) != 3 {
+		t.Fatalf("Expected 3 sample types but got %d", len(fields[pprofProfileSampleType]))
+	}
+
+	if len(fields[pprofProfileSample]) != len(profile.Samples()) {
+		t.Fatalf("Expected %d samples but got %d", len(profile.Samples()), len(fields[pprofProfileSample]))
+	}
+
+	if len(fields[pprofProfileFunction]) != 4 {
+		t.Fatalf("Expected 4 functions but got %d", len(fields[pprofProfileFunction]))
+	}
+
+	if idx := fields[pprofProfileDefaultSampleType][0].varint; strs[idx] != "time" {
+		t.Fatalf("Expected default sample type time but got %q", strs[idx])
+	}
+
+	for _, f := range fields[pprofProfileFunction] {
+		fn := decodeProtoFields(t, f.bytes)
+		name := strs[fn[pprofFunctionName][0].varint]
+		if name == "data.test.f" {
+			if strs[fn[pprofFunctionFilename][0].varint] != "test.rego" || fn[pprofFunctionStartLine][0].varint != 3 {
+				t.Fatalf("Unexpected function: %v", fn)
+			}
+		}
+	}
+}
+
+type protoField struct {
+	varint uint64
+	bytes  []byte
+}
+
+func decodeProtoFields(t *testing.T, bs []byte) map[int][]protoField {
+	t.Helper()
+
+	result := map[int][]protoField{}
+
+	varint := func() uint64 {
+		var x uint64
+		for shift := uint(0); ; shift += 7 {
+			if len(bs) == 0 {
+				t.Fatal("Unexpected end of buffer")
+			}
+			b := bs[0]
+			bs = bs[1:]
+			x |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				return x
+			}
+		}
+	}
+
+	for len(bs) > 0 {
+		key := varint()
+		field := int(key >> 3)
+		switch key & 7 {
+		case protoWireVarint:
+			result[field] = append(result[field], protoField{varint: varint()})
+		case protoWireBytes:
+			n := varint()
+			result[field] = append(result[field], protoField{bytes: bs[:n]})
+			bs = bs[n:]
+		default:
+			t.Fatalf("Unexpected wire type in key %d", key)
+		}
+	}
+
+	return result
+}
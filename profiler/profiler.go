@@ -18,18 +18,23 @@ type Profiler struct {
 	hits        map[string]map[int]ExprStats
 	activeTimer time.Time
 	prevExpr    exprInfo
+	stacks      *callStacks
+	samples     *Profile
 }
 
 // exprInfo stores information about an expression.
 type exprInfo struct {
 	location *ast.Location
 	op       topdown.Op
+	stack    []Frame
 }
 
 // New returns a new Profiler object.
 func New() *Profiler {
 	return &Profiler{
-		hits: map[string]map[int]ExprStats{},
+		hits:    map[string]map[int]ExprStats{},
+		stacks:  newCallStacks(),
+		samples: NewProfile(),
 	}
 }
 
@@ -122,6 +127,13 @@ func (p *Profiler) ReportTopNResults(numResults int, criteria []string) []ExprSt
 
 }
 
+// Profile returns the time spent on expressions aggregated by the call stack
+// of rules and functions that the expressions were evaluated in.
+func (p *Profiler) Profile() *Profile {
+	p.processLastExpr()
+	return p.samples.Copy()
+}
+
 // Trace updates the profiler state.
 // Deprecated: Use TraceEvent instead.
 func (p *Profiler) Trace(event *topdown.Event) {
@@ -130,34 +142,45 @@ func (p *Profiler) Trace(event *topdown.Event) {
 
 // TraceEvent updates the coverage state.
 func (p *Profiler) TraceEvent(event topdown.Event) {
+	p.stacks.trace(event)
 	switch event.Op {
-	case topdown.EvalOp:
-		if expr, ok := event.Node.(*ast.Expr); ok && expr != nil {
-			p.processExpr(expr, event.Op)
-		}
-	case topdown.RedoOp:
+	case topdown.EvalOp, topdown.RedoOp:
 		if expr, ok := event.Node.(*ast.Expr); ok && expr != nil {
-			p.processExpr(expr, event.Op)
+			// the expression is part of the compiled policy that may be evaluated
+			// concurrently, so it must not be modified
+			loc := exprLocation(expr.Location)
+			p.processExpr(loc, event.Op, p.stacks.stack(event.QueryID, loc))
 		}
 	}
 }
 
-func (p *Profiler) processExpr(expr *ast.Expr, eventType topdown.Op) {
-	if expr.Location == nil {
-		// add fake location to group expressions without a location
-		expr.Location = ast.NewLocation([]byte("???"), "", 0, 0)
+// exprLocation returns loc or, if loc is nil, a fake location to group
+// expressions without a location.
+func exprLocation(loc *ast.Location) *ast.Location {
+	if loc == nil {
+		return ast.NewLocation([]byte("???"), "", 0, 0)
 	}
+	return loc
+}
+
+func (p *Profiler) processExpr(loc *ast.Location, eventType topdown.Op, stack []Frame) {
+	loc = exprLocation(loc)
 
 	// set the active timer on the first expression
 	if p.activeTimer.IsZero() {
 		p.activeTimer = time.Now()
 		p.prevExpr = exprInfo{
 			op:       eventType,
-			location: expr.Location,
+			location: loc,
+			stack:    stack,
 		}
 		return
 	}
 
+	if p.prevExpr.stack != nil {
+		p.samples.add(p.prevExpr.stack, time.Since(p.activeTimer).Nanoseconds(), p.prevExpr.op)
+	}
+
 	// record the profiler results for the previous expression
 	file := p.prevExpr.location.File
 	hits, ok := p.hits[file]
@@ -187,15 +210,13 @@ func (p *Profiler) processExpr(expr *ast.Expr, eventType topdown.Op) {
 	p.activeTimer = time.Now()
 	p.prevExpr = exprInfo{
 		op:       eventType,
-		location: expr.Location,
+		location: loc,
+		stack:    stack,
 	}
 }
 
 func (p *Profiler) processLastExpr() {
-	expr := ast.Expr{
-		Location: p.prevExpr.location,
-	}
-	p.processExpr(&expr, p.prevExpr.op)
+	p.processExpr(p.prevExpr.location, p.prevExpr.op, p.prevExpr.stack)
 }
 
 func getProfilerStats(expr exprInfo, timer time.Time) ExprStats {
@@ -493,3 +493,21 @@ func TestProfilerTraceConfig(t *testing.T) {
 		t.Fatalf("Expected config: %+v, got %+v", expected, conf)
 	}
 }
+
+func TestProfilerDoesNotModifyExpr(t *testing.T) {
+	profiler := New()
+	expr := ast.MustParseExpr("x = 1")
+	expr.Location = nil
+
+	profiler.TraceEvent(topdown.Event{Op: topdown.EvalOp, Node: expr})
+	profiler.TraceEvent(topdown.Event{Op: topdown.EvalOp, Node: expr})
+
+	if expr.Location != nil {
+		t.Fatalf("Expected expression location to be unchanged but got %v", expr.Location)
+	}
+
+	stats := profiler.ReportTopNResults(-1, []string{})
+	if len(stats) != 1 || string(stats[0].Location.Text) != "???" {
+		t.Fatalf("Expected expressions without location to be grouped under a fake location but got %v", stats)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package profiler
+
+import (
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+// rootFunction is the name of the frame at the bottom of every call stack. It
+// represents the query that was evaluated.
+const rootFunction = "query"
+
+// Frame represents a single entry in a call stack of rules and functions.
+type Frame struct {
+	Function  string `json:"function"`
+	File      string `json:"file"`
+	StartLine int    `json:"start_line"`
+	Line      int    `json:"line"`
+}
+
+// callStacks reconstructs the call stacks of rules and functions from trace
+// events. Each query evaluated by topdown is identified by a query ID and
+// refers to the query that it was evaluated from by a parent ID. Rule and
+// function bodies are entered as new queries.
+type callStacks struct {
+	parents  map[uint64]uint64
+	frames   map[uint64]Frame
+	lastExpr map[uint64]*ast.Location
+}
+
+func newCallStacks() *callStacks {
+	s := &callStacks{}
+	s.reset()
+	return s
+}
+
+func (s *callStacks) reset() {
+	s.parents = map[uint64]uint64{}
+	s.frames = map[uint64]Frame{}
+	s.lastExpr = map[uint64]*ast.Location{}
+}
+
+func (s *callStacks) trace(event topdown.Event) {
+
+	if event.Op == topdown.EnterOp && event.QueryID == 0 && event.ParentID == 0 {
+		// Query IDs are reused across evaluations.
+		s.reset()
+	}
+
+	if event.QueryID != 0 {
+		if _, ok := s.parents[event.QueryID]; !ok {
+			s.parents[event.QueryID] = event.ParentID
+		}
+	}
+
+	switch event.Op {
+	case topdown.EnterOp:
+		if rule, ok := event.Node.(*ast.Rule); ok {
+			frame := Frame{Function: rule.Path().String()}
+			if rule.Location != nil {
+				frame.File = rule.Location.File
+				frame.StartLine = rule.Location.Row
+			}
+			s.frames[event.QueryID] = frame
+		}
+	case topdown.EvalOp, topdown.RedoOp:
+		if expr, ok := event.Node.(*ast.Expr); ok && expr.Location != nil {
+			s.lastExpr[event.QueryID] = expr.Location
+		}
+	}
+}
+
+// stack returns the call stack of an expression at loc evaluated in the query
+// identified by queryID. The innermost frame is first.
+func (s *callStacks) stack(queryID uint64, loc *ast.Location) []Frame {
+
+	var stack []Frame
+	line, file := loc.Row, loc.File
+
+	for {
+		owner, frame, ok := s.owner(queryID)
+		if !ok {
+			return append(stack, Frame{Function: rootFunction, File: file, Line: line})
+		}
+
+		frame.Line = line
+		stack = append(stack, frame)
+
+		queryID = s.parents[owner]
+		line, file = 0, ""
+		if callsite, ok := s.lastExpr[queryID]; ok {
+			line, file = callsite.Row, callsite.File
+		}
+	}
+}
+
+// owner returns the query that entered the rule or function that contains
+// queryID.
+func (s *callStacks) owner(queryID uint64) (uint64, Frame, bool) {
+	for {
+		if frame, ok := s.frames[queryID]; ok {
+			return queryID, frame, true
+		}
+		parent, ok := s.parents[queryID]
+		if !ok || parent == queryID {
+			return 0, Frame{}, false
+		}
+		queryID = parent
+	}
+}
@@ -16,6 +16,7 @@ import (
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/filter"
 	"github.com/open-policy-agent/opa/internal/compiler/wasm"
 	"github.com/open-policy-agent/opa/internal/ir"
 	"github.com/open-policy-agent/opa/internal/planner"
@@ -44,6 +45,17 @@ type PartialQueries struct {
 	Support []*ast.Module `json:"modules,omitempty"`
 }
 
+// Filter converts the queries into a condition tree that can be translated
+// into the query languages of data stores. If the result contains support
+// modules, the queries refer to them and an error is returned unless
+// unsupported expressions are tagged. See filter.FromQueries for details.
+func (pq *PartialQueries) Filter(opts filter.Options) (*filter.Node, error) {
+	if len(pq.Support) > 0 && !opts.TagUnsupported {
+		return nil, fmt.Errorf("support modules cannot be converted into a filter, inline the rules in package %v", pq.Support[0].Package.Path)
+	}
+	return filter.FromQueries(pq.Queries, opts)
+}
+
 // PartialResult represents the result of partial evaluation. The result can be
 // used to generate a new query that can be run when inputs are known.
 type PartialResult struct {
@@ -494,6 +506,7 @@ type Rego struct {
 	bundlePaths            []string
 	bundles                map[string]*bundle.Bundle
 	skipBundleVerification bool
+	bundleVerification     *bundle.VerificationConfig
 }
 
 // Function represents a built-in function that is callable in Rego.
@@ -976,6 +989,14 @@ func SkipBundleVerification(yes bool) func(r *Rego) {
 	}
 }
 
+// BundleVerificationConfig sets the key configuration used to verify the
+// signatures of the bundles loaded with LoadBundle.
+func BundleVerificationConfig(config *bundle.VerificationConfig) func(r *Rego) {
+	return func(r *Rego) {
+		r.bundleVerification = config
+	}
+}
+
 // New returns a new Rego object.
 func New(options ...func(r *Rego)) *Rego {
 
@@ -1517,7 +1538,11 @@ func (r *Rego) loadBundles(ctx context.Context, txn storage.Transaction, m metri
 	defer m.Timer(metrics.RegoLoadBundles).Stop()
 
 	for _, path := range r.bundlePaths {
-		bndl, err := loader.NewFileLoader().WithMetrics(m).WithSkipBundleVerification(r.skipBundleVerification).AsBundle(path)
+		bndl, err := loader.NewFileLoader().
+			WithMetrics(m).
+			WithBundleVerificationConfig(r.bundleVerification).
+			WithSkipBundleVerification(r.skipBundleVerification).
+			AsBundle(path)
 		if err != nil {
 			return fmt.Errorf("loading error: %s", err)
 		}
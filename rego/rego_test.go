@@ -16,6 +16,7 @@ import (
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/filter"
 	"github.com/open-policy-agent/opa/internal/storage/mock"
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/storage"
@@ -925,6 +926,40 @@ func TestPrepareAndPartial(t *testing.T) {
 	}
 }
 
+func TestPartialQueriesFilter(t *testing.T) {
+
+	ctx := context.Background()
+
+	pq, err := New(
+		Query("data.test.p == true"),
+		Module("test.rego", `package test
+
+		p { input.rows.owner == input.user }
+		p { input.rows.public }`),
+		Input(map[string]interface{}{"user": "alice"}),
+		Unknowns([]string{"input.rows"}),
+	).Partial(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := pq.Filter(filter.Options{Unknowns: []ast.Ref{ast.MustParseRef("input.rows")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `(input.rows.owner eq "alice" or input.rows.public eq true)`
+	if node.String() != exp {
+		t.Fatalf("Expected %v but got %v", exp, node)
+	}
+
+	pq.Support = []*ast.Module{ast.MustParseModule("package partial.test")}
+
+	if _, err := pq.Filter(filter.Options{}); err == nil {
+		t.Fatal("Expected error for support modules")
+	}
+}
+
 func TestPartialNamespace(t *testing.T) {
 
 	r := New(
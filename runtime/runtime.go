@@ -40,6 +40,7 @@ import (
 	"github.com/open-policy-agent/opa/plugins/logs"
 	"github.com/open-policy-agent/opa/repl"
 	"github.com/open-policy-agent/opa/server"
+	"github.com/open-policy-agent/opa/server/identifier"
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/inmem"
 	"github.com/open-policy-agent/opa/version"
@@ -79,6 +80,9 @@ type Params struct {
 	// for read-only diagnostic API's (/health, /metrics, etc)
 	DiagnosticAddrs *[]string
 
+	// UnixSocketPerm specifies the permission for the Unix domain socket if used to listen for connections
+	UnixSocketPerm *string
+
 	// InsecureAddr is the listening address that the OPA server will bind to
 	// in addition to Addr if TLS is enabled.
 	InsecureAddr string
@@ -86,16 +90,41 @@ type Params struct {
 	// Authentication is the type of authentication scheme to use.
 	Authentication server.AuthenticationScheme
 
+	// JWTConfig configures bearer token verification when Authentication is
+	// set to the JWT scheme.
+	JWTConfig identifier.JWTConfig
+
 	// Authorization is the type of authorization scheme to use.
 	Authorization server.AuthorizationScheme
 
+	// AuthorizationBody controls whether the request body is included in the
+	// input to the authorization policy (input.body).
+	AuthorizationBody bool
+
+	// AuthorizationCertificates controls whether the verified client
+	// certificate chain is included in the input to the authorization policy
+	// (input.client_certificates).
+	AuthorizationCertificates bool
+
 	// Certificate is the certificate to use in server-mode. If the certificate
 	// is nil, the server will NOT use TLS.
 	Certificate *tls.Certificate
 
+	// CertificateFile and CertificateKeyFile are the paths of the certificate
+	// and its private key. If CertificateRefresh is greater than zero, the
+	// server re-reads the files on that interval and swaps in the new
+	// certificate when they have changed.
+	CertificateFile    string
+	CertificateKeyFile string
+	CertificateRefresh time.Duration
+
 	// CertPool holds the CA certs trusted by the OPA server.
 	CertPool *x509.CertPool
 
+	// CertPoolFile is the path to the CA certs file. It is reloaded along with
+	// the certificate if CertificateRefresh is set.
+	CertPoolFile string
+
 	// HistoryPath is the filename to store the interactive shell user
 	// input history.
 	HistoryPath string
@@ -129,6 +158,14 @@ type Params struct {
 	// PprofEnabled flag controls whether pprof endpoints are enabled
 	PprofEnabled bool
 
+	// ProfileSampleRate is the fraction of decisions that are profiled. The
+	// profiles are served on /debug/pprof/rego.
+	ProfileSampleRate float64
+
+	// ChangeFeedSize is the number of changes the server keeps for clients
+	// of the Changes API. The Changes API is disabled if the size is zero.
+	ChangeFeedSize int
+
 	// DecisionIDFactory generates decision IDs to include in API responses
 	// sent by the server (in response to Data API queries.)
 	DecisionIDFactory func() string
@@ -309,12 +346,20 @@ func (rt *Runtime) Serve(ctx context.Context) error {
 		WithManager(rt.Manager).
 		WithCompilerErrorLimit(rt.Params.ErrorLimit).
 		WithPprofEnabled(rt.Params.PprofEnabled).
+		WithProfileSampleRate(rt.Params.ProfileSampleRate).
+		WithChangeFeed(rt.Params.ChangeFeedSize).
 		WithAddresses(*rt.Params.Addrs).
+		WithUnixSocketPermission(rt.Params.UnixSocketPerm).
 		WithInsecureAddress(rt.Params.InsecureAddr).
 		WithCertificate(rt.Params.Certificate).
+		WithCertificatePaths(rt.Params.CertificateFile, rt.Params.CertificateKeyFile, rt.Params.CertificateRefresh).
 		WithCertPool(rt.Params.CertPool).
+		WithCertPoolPath(rt.Params.CertPoolFile).
 		WithAuthentication(rt.Params.Authentication).
+		WithJWTConfig(rt.Params.JWTConfig).
 		WithAuthorization(rt.Params.Authorization).
+		WithAuthorizationBody(rt.Params.AuthorizationBody).
+		WithAuthorizationCertificates(rt.Params.AuthorizationCertificates).
 		WithDecisionIDFactory(rt.decisionIDFactory).
 		WithDecisionLoggerWithErr(rt.decisionLogger).
 		WithRuntime(rt.Manager.Info).
@@ -610,7 +655,7 @@ func (rt *Runtime) gracefulServerShutdown(s *server.Server) error {
 
 func getWatcher(rootPaths []string) (*fsnotify.Watcher, error) {
 
-	watchPaths, err := getWatchPaths(rootPaths)
+	watchPaths, err := initload.WatchPaths(rootPaths)
 	if err != nil {
 		return nil, err
 	}
@@ -629,23 +674,6 @@ func getWatcher(rootPaths []string) (*fsnotify.Watcher, error) {
 	return watcher, nil
 }
 
-func getWatchPaths(rootPaths []string) ([]string, error) {
-	paths := []string{}
-
-	for _, path := range rootPaths {
-
-		_, path = loader.SplitPrefix(path)
-		result, err := loader.Paths(path, true)
-		if err != nil {
-			return nil, err
-		}
-
-		paths = append(paths, result...)
-	}
-
-	return paths, nil
-}
-
 func onReloadLogger(d time.Duration, err error) {
 	logrus.WithFields(logrus.Fields{
 		"duration": d,
@@ -29,31 +29,6 @@ import (
 	"github.com/open-policy-agent/opa/version"
 )
 
-func TestWatchPaths(t *testing.T) {
-
-	fs := map[string]string{
-		"/foo/bar/baz.json": "true",
-	}
-
-	expected := []string{
-		"/foo", "/foo/bar", "/foo/bar/baz.json",
-	}
-
-	test.WithTempFS(fs, func(rootDir string) {
-		paths, err := getWatchPaths([]string{"prefix:" + rootDir + "/foo"})
-		if err != nil {
-			t.Fatalf("Unexpected error: %v", err)
-		}
-		result := []string{}
-		for _, path := range paths {
-			result = append(result, strings.TrimPrefix(path, rootDir))
-		}
-		if !reflect.DeepEqual(expected, result) {
-			t.Fatalf("Expected %v but got: %v", expected, result)
-		}
-	})
-}
-
 func TestRuntimeProcessWatchEvents(t *testing.T) {
 	testRuntimeProcessWatchEvents(t, false)
 }
@@ -6,6 +6,11 @@
 package authorizer
 
 import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
@@ -16,8 +21,14 @@ import (
 	"github.com/open-policy-agent/opa/server/types"
 	"github.com/open-policy-agent/opa/server/writer"
 	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/util"
 )
 
+// BodyLimitBytes is the maximum size of request bodies that are read into the
+// input of the authorization policy. The input of requests with larger bodies
+// does not contain the body.
+const BodyLimitBytes = 8 * 1024 * 1024
+
 // Basic provides policy-based authorization over incoming requests.
 type Basic struct {
 	inner    http.Handler
@@ -25,6 +36,8 @@ type Basic struct {
 	store    storage.Store
 	runtime  *ast.Term
 	decision func() ast.Ref
+	body     bool
+	certs    bool
 }
 
 // Runtime returns an argument that sets the runtime on the authorizer.
@@ -42,6 +55,27 @@ func Decision(ref func() ast.Ref) func(*Basic) {
 	}
 }
 
+// Body returns an argument that enables the request body in the input to the
+// authorization policy. The body of PUT, PATCH and POST requests is parsed as
+// JSON (or YAML, depending on the Content-Type) and provided as input.body.
+// Bodies that cannot be parsed are provided as strings. The body is only read
+// when enabled.
+func Body(yes bool) func(*Basic) {
+	return func(b *Basic) {
+		b.body = yes
+	}
+}
+
+// Certificates returns an argument that enables the verified client
+// certificate chain in the input to the authorization policy. The chain is
+// provided as input.client_certificates in the same format as the
+// crypto.x509.parse_certificates built-in function returns.
+func Certificates(yes bool) func(*Basic) {
+	return func(b *Basic) {
+		b.certs = yes
+	}
+}
+
 // NewBasic returns a new Basic object.
 func NewBasic(inner http.Handler, compiler func() *ast.Compiler, store storage.Store, opts ...func(*Basic)) http.Handler {
 	b := &Basic{
@@ -59,7 +93,7 @@ func NewBasic(inner http.Handler, compiler func() *ast.Compiler, store storage.S
 
 func (h *Basic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	input, err := makeInput(r)
+	r, input, err := h.makeInput(r)
 	if err != nil {
 		writer.ErrorString(w, http.StatusBadRequest, types.CodeInvalidParameter, err)
 		return
@@ -97,7 +131,38 @@ func (h *Basic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	writer.Error(w, http.StatusUnauthorized, types.NewErrorV1(types.CodeUnauthorized, types.MsgUnauthorizedError))
 }
 
-func makeInput(r *http.Request) (interface{}, error) {
+func (h *Basic) makeInput(r *http.Request) (*http.Request, interface{}, error) {
+	input, err := makeInput(r)
+	if err != nil {
+		return r, nil, err
+	}
+
+	if h.body {
+		switch r.Method {
+		case http.MethodPut, http.MethodPatch, http.MethodPost:
+			var body interface{}
+			r, body, err = readBody(r)
+			if err != nil {
+				return r, nil, err
+			}
+			if body != nil {
+				input["body"] = body
+			}
+		}
+	}
+
+	if h.certs && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		certs, err := parseCertificates(r.TLS.VerifiedChains[0])
+		if err != nil {
+			return r, nil, err
+		}
+		input["client_certificates"] = certs
+	}
+
+	return r, input, nil
+}
+
+func makeInput(r *http.Request) (map[string]interface{}, error) {
 	path, err := parsePath(r.URL.Path)
 	if err != nil {
 		return nil, err
@@ -113,8 +178,10 @@ func makeInput(r *http.Request) (interface{}, error) {
 		"headers": r.Header,
 	}
 
-	identity, ok := identifier.Identity(r)
-	if ok {
+	// Verified token claims are more useful to policy than the subject alone.
+	if claims, ok := identifier.Claims(r); ok {
+		input["identity"] = claims
+	} else if identity, ok := identifier.Identity(r); ok {
 		input["identity"] = identity
 	}
 
@@ -139,3 +206,68 @@ func parsePath(path string) ([]interface{}, error) {
 	}
 	return sl, nil
 }
+
+// readBody reads and parses the request body. The body is replaced so that
+// the inner handler can read it again. Bodies larger than BodyLimitBytes are
+// not buffered and not returned.
+func readBody(r *http.Request) (*http.Request, interface{}, error) {
+	if r.Body == nil || r.ContentLength > BodyLimitBytes {
+		return r, nil, nil
+	}
+
+	bs, err := ioutil.ReadAll(io.LimitReader(r.Body, BodyLimitBytes+1))
+	if err != nil {
+		r.Body.Close()
+		return r, nil, err
+	}
+
+	if len(bs) > BodyLimitBytes {
+		// The length of the body was not declared. Pass the bytes that were
+		// read and the rest of the body on to the inner handler.
+		r.Body = &bodyReader{Reader: io.MultiReader(bytes.NewReader(bs), r.Body), Closer: r.Body}
+		return r, nil, nil
+	}
+
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(bs))
+
+	trimmed := bytes.TrimSpace(bs)
+	if len(trimmed) == 0 {
+		return r, nil, nil
+	}
+
+	var x interface{}
+
+	// There is no standard for yaml mime-type so we just look for
+	// anything related
+	if strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+		if err := util.Unmarshal(trimmed, &x); err == nil {
+			return r, x, nil
+		}
+	} else if err := util.UnmarshalJSON(trimmed, &x); err == nil {
+		return r, x, nil
+	}
+
+	// Non-structured bodies (e.g., policy modules) are provided verbatim.
+	return r, string(bs), nil
+}
+
+type bodyReader struct {
+	io.Reader
+	io.Closer
+}
+
+func parseCertificates(certs []*x509.Certificate) (interface{}, error) {
+	bs, err := json.Marshal(certs)
+	if err != nil {
+		return nil, err
+	}
+
+	var x interface{}
+
+	if err := util.UnmarshalJSON(bs, &x); err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
@@ -5,7 +5,11 @@
 package authorizer
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
 	"net/http"
 	"reflect"
 	"strings"
@@ -275,3 +279,213 @@ func TestMakeInput(t *testing.T) {
 	}
 
 }
+
+func TestMakeInputWithClaims(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8181/v1/data", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = identifier.SetIdentity(req, "bob")
+	req = identifier.SetClaims(req, map[string]interface{}{"sub": "bob", "groups": []interface{}{"admin"}})
+
+	result, err := makeInput(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := util.MustUnmarshalJSON([]byte(`{"sub": "bob", "groups": ["admin"]}`))
+	identity := result["identity"]
+
+	if !reflect.DeepEqual(util.MustMarshalJSON(expected), util.MustMarshalJSON(identity)) {
+		t.Fatalf("Expected identity %v but got %v", expected, identity)
+	}
+}
+
+type bodyHandler struct {
+	body string
+}
+
+func (h *bodyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bs, _ := ioutil.ReadAll(r.Body)
+	h.body = string(bs)
+	w.WriteHeader(200)
+}
+
+func TestBasicBody(t *testing.T) {
+
+	compiler := func() *ast.Compiler {
+		module := `
+		package system.authz
+
+		default allow = false
+
+		allow {
+			input.method = "GET"
+			not input.body
+		}
+
+		allow {
+			input.method = "PUT"
+			input.body.size < 10
+		}
+
+		allow {
+			input.method = "POST"
+			startswith(input.body, "package")
+		}
+		`
+		c := ast.NewCompiler()
+		c.Compile(map[string]*ast.Module{
+			"test.rego": ast.MustParseModule(module),
+		})
+		if c.Failed() {
+			t.Fatalf("Unexpected error compiling test module: %v", c.Errors)
+		}
+		return c
+	}
+
+	tests := []struct {
+		note           string
+		enabled        bool
+		method         string
+		contentType    string
+		body           string
+		expectedStatus int
+	}{
+		{"get", true, http.MethodGet, "", "", http.StatusOK},
+		{"json allowed", true, http.MethodPut, "application/json", `{"size": 5}`, http.StatusOK},
+		{"json denied", true, http.MethodPut, "application/json", `{"size": 50}`, http.StatusUnauthorized},
+		{"yaml allowed", true, http.MethodPut, "application/x-yaml", "size: 5", http.StatusOK},
+		{"raw body", true, http.MethodPost, "text/plain", "package foo", http.StatusOK},
+		{"disabled", false, http.MethodPut, "application/json", `{"size": 5}`, http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		test.Subtest(t, tc.note, func(t *testing.T) {
+
+			recorder := httptest.NewRecorder()
+			req, err := http.NewRequest(tc.method, "http://localhost:8181/v1/data/foo", strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+
+			inner := &bodyHandler{}
+
+			NewBasic(inner, compiler, inmem.New(), Decision(func() ast.Ref {
+				return ast.MustParseRef("data.system.authz.allow")
+			}), Body(tc.enabled)).ServeHTTP(recorder, req)
+
+			if recorder.Code != tc.expectedStatus {
+				t.Fatalf("Expected status code %v but got: %v", tc.expectedStatus, recorder)
+			}
+
+			// The inner handler must still be able to read the body.
+			if recorder.Code == http.StatusOK && inner.body != tc.body {
+				t.Fatalf("Expected inner handler to read body %q but got %q", tc.body, inner.body)
+			}
+		})
+	}
+}
+
+func TestBasicBodyLimit(t *testing.T) {
+
+	compiler := func() *ast.Compiler {
+		c := ast.NewCompiler()
+		c.Compile(map[string]*ast.Module{
+			"test.rego": ast.MustParseModule(`package system.authz
+
+			allow { not input.body }`),
+		})
+		if c.Failed() {
+			t.Fatalf("Unexpected error compiling test module: %v", c.Errors)
+		}
+		return c
+	}
+
+	body := strings.Repeat("x", BodyLimitBytes+1)
+
+	// The limit applies whether or not the client declares the content length.
+	// Larger bodies are not provided to the policy but still passed on to the
+	// inner handler.
+	for _, contentLength := range []int64{int64(len(body)), -1} {
+
+		recorder := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8181/v1/data/foo", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.ContentLength = contentLength
+
+		inner := &bodyHandler{}
+
+		NewBasic(inner, compiler, inmem.New(), Decision(func() ast.Ref {
+			return ast.MustParseRef("data.system.authz.allow")
+		}), Body(true)).ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status code %v but got: %v", http.StatusOK, recorder.Code)
+		}
+
+		if inner.body != body {
+			t.Fatalf("Expected inner handler to read the body (%d bytes) but got %d bytes", len(body), len(inner.body))
+		}
+	}
+}
+
+func TestMakeInputWithCertificates(t *testing.T) {
+
+	certPEM, err := ioutil.ReadFile("../testdata/client-cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8181/v1/data", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+
+	b := &Basic{certs: true}
+
+	_, input, err := b.makeInput(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs, ok := input.(map[string]interface{})["client_certificates"].([]interface{})
+	if !ok || len(certs) != 1 {
+		t.Fatalf("Expected one client certificate but got: %v", input)
+	}
+
+	subject := certs[0].(map[string]interface{})["Subject"].(map[string]interface{})
+	if subject["CommonName"] != cert.Subject.CommonName {
+		t.Fatalf("Expected subject %v but got: %v", cert.Subject.CommonName, subject)
+	}
+
+	b.certs = false
+
+	_, input, err = b.makeInput(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := input.(map[string]interface{})["client_certificates"]; ok {
+		t.Fatal("Expected client certificates to be excluded")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithCertificatePaths sets the paths of the TLS certificate and private key
+// that the server will use. If refresh is greater than zero, the files are
+// re-read periodically and new material replaces the current certificate
+// without restarting the listeners.
+func (s *Server) WithCertificatePaths(certFile, keyFile string, refresh time.Duration) *Server {
+	s.certFile = certFile
+	s.certKeyFile = keyFile
+	s.certRefresh = refresh
+	return s
+}
+
+// WithCertPoolPath sets the path of the CA certificates file that the server
+// uses to verify client certificates. The file is refreshed on the same
+// interval as the certificate (see WithCertificatePaths.)
+func (s *Server) WithCertPoolPath(caCertFile string) *Server {
+	s.certPoolFile = caCertFile
+	return s
+}
+
+func (s *Server) getTLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		GetCertificate: s.getCertificate,
+	}
+
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		// Return a fresh config so that client CA changes apply to new
+		// connections without mutating configs in use by existing ones.
+		c := cfg.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = s.getCertPool()
+		if s.authentication == AuthenticationTLS {
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return c, nil
+	}
+
+	return cfg
+}
+
+func (s *Server) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.certMtx.RLock()
+	defer s.certMtx.RUnlock()
+	return s.cert, nil
+}
+
+func (s *Server) getCertPool() *x509.CertPool {
+	s.certMtx.RLock()
+	defer s.certMtx.RUnlock()
+	return s.certPool
+}
+
+// certLoop returns a loop that periodically reloads the TLS certificate and
+// client CA pool from disk until stop is closed. Failures are logged and the
+// previous material is kept in place.
+func (s *Server) certLoop(stop <-chan struct{}) Loop {
+	return func() error {
+		ticker := time.NewTicker(s.certRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.reloadCertificates()
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
+
+func (s *Server) reloadCertificates() {
+
+	if s.certFile != "" && s.certKeyFile != "" {
+		if err := s.reloadCertificate(); err != nil {
+			logrus.WithField("err", err).Error("Failed to reload server certificate. Keeping previous certificate.")
+		}
+	}
+
+	if s.certPoolFile != "" {
+		if err := s.reloadCertPool(); err != nil {
+			logrus.WithField("err", err).Error("Failed to reload CA certificates. Keeping previous CA certificates.")
+		}
+	}
+}
+
+func (s *Server) reloadCertificate() error {
+
+	certPEM, err := ioutil.ReadFile(s.certFile)
+	if err != nil {
+		return err
+	}
+
+	keyPEM, err := ioutil.ReadFile(s.certKeyFile)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	hash.Write(certPEM)
+	hash.Write(keyPEM)
+	sum := hash.Sum(nil)
+
+	if bytes.Equal(sum, s.certHash) {
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	s.certMtx.Lock()
+	s.cert = &cert
+	s.certMtx.Unlock()
+
+	// Record the hash of the initially loaded material without logging.
+	if s.certHash != nil {
+		logrus.WithField("cert-file", s.certFile).Info("Server certificate reloaded.")
+	}
+
+	s.certHash = sum
+
+	return nil
+}
+
+func (s *Server) reloadCertPool() error {
+
+	caPEM, err := ioutil.ReadFile(s.certPoolFile)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(caPEM)
+
+	if bytes.Equal(sum[:], s.certPoolHash) {
+		return nil
+	}
+
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM(caPEM); !ok {
+		return fmt.Errorf("failed to parse CA cert %q", s.certPoolFile)
+	}
+
+	s.certMtx.Lock()
+	s.certPool = pool
+	s.certMtx.Unlock()
+
+	if s.certPoolHash != nil {
+		logrus.WithField("ca-cert-file", s.certPoolFile).Info("CA certificates reloaded.")
+	}
+
+	s.certPoolHash = sum[:]
+
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestCertificateReload(t *testing.T) {
+
+	cert1, key1 := generateTestCert(t, "server-1")
+	cert2, key2 := generateTestCert(t, "server-2")
+
+	files := map[string]string{
+		"cert.pem": string(cert1),
+		"key.pem":  string(key1),
+		"ca.pem":   string(cert1),
+	}
+
+	test.WithTempFS(files, func(path string) {
+		certFile := filepath.Join(path, "cert.pem")
+		keyFile := filepath.Join(path, "key.pem")
+		caFile := filepath.Join(path, "ca.pem")
+
+		initial, err := tls.X509KeyPair(cert1, key1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := New().
+			WithCertificate(&initial).
+			WithCertificatePaths(certFile, keyFile, time.Minute).
+			WithCertPoolPath(caFile)
+
+		s.reloadCertificates()
+
+		if subject := leafSubject(t, s); subject != "server-1" {
+			t.Fatalf("expected server-1 certificate but got %v", subject)
+		}
+
+		if n := len(s.getCertPool().Subjects()); n != 1 {
+			t.Fatalf("expected one CA certificate but got %d", n)
+		}
+
+		// New material is picked up.
+		writeFile(t, certFile, cert2)
+		writeFile(t, keyFile, key2)
+		writeFile(t, caFile, append(cert1, cert2...))
+
+		s.reloadCertificates()
+
+		if subject := leafSubject(t, s); subject != "server-2" {
+			t.Fatalf("expected server-2 certificate but got %v", subject)
+		}
+
+		if n := len(s.getCertPool().Subjects()); n != 2 {
+			t.Fatalf("expected two CA certificates but got %d", n)
+		}
+
+		// Invalid material leaves the previous certificate and pool in place.
+		writeFile(t, certFile, []byte("bad cert"))
+		writeFile(t, caFile, []byte("bad ca"))
+
+		s.reloadCertificates()
+
+		if subject := leafSubject(t, s); subject != "server-2" {
+			t.Fatalf("expected server-2 certificate but got %v", subject)
+		}
+
+		if n := len(s.getCertPool().Subjects()); n != 2 {
+			t.Fatalf("expected two CA certificates but got %d", n)
+		}
+
+		// The TLS config served to clients reflects the current material.
+		cfg, err := s.getTLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		} else if cfg.ClientCAs != s.getCertPool() {
+			t.Fatal("expected client config to use current CA pool")
+		}
+	})
+}
+
+func leafSubject(t *testing.T, s *Server) string {
+	t.Helper()
+	cert, err := s.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func writeFile(t *testing.T, path string, bs []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func generateTestCert(t *testing.T, cn string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return certPEM, keyPEM
+}
@@ -0,0 +1,241 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/internal/uuid"
+	"github.com/open-policy-agent/opa/server/types"
+	"github.com/open-policy-agent/opa/server/writer"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// WithChangeFeed enables the Changes API. The server records the changes
+// committed to the store with increasing sequence numbers and keeps the last
+// size changes so that clients can resume reading the feed. The Changes API is
+// disabled if size is zero.
+func (s *Server) WithChangeFeed(size int) *Server {
+	s.changeFeedSize = size
+	return s
+}
+
+// changeFeed records the changes committed to the store. Changes are encoded
+// when they are committed because the store may modify the committed values in
+// place afterwards.
+type changeFeed struct {
+	id      string
+	size    int
+	mtx     sync.Mutex
+	seq     uint64
+	changes []json.RawMessage // changes with sequence numbers seq-len(changes)+1 to seq
+	notify  chan struct{}     // closed when a change is recorded
+}
+
+// changesResponse is encoded like types.ChangesResponseV1.
+type changesResponse struct {
+	Feed     string            `json:"feed"`
+	Sequence uint64            `json:"sequence"`
+	Changes  []json.RawMessage `json:"changes"`
+}
+
+func newChangeFeed(size int) (*changeFeed, error) {
+	id, err := uuid.New(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &changeFeed{
+		id:     id,
+		size:   size,
+		notify: make(chan struct{}),
+	}, nil
+}
+
+func (f *changeFeed) onCommit(ctx context.Context, txn storage.Transaction, event storage.TriggerEvent) {
+
+	if event.IsZero() {
+		return
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	change := types.ChangeV1{Sequence: f.seq + 1}
+
+	for _, e := range event.Data {
+		dc := types.DataChangeV1{Path: e.Path, Removed: e.Removed}
+		if !e.Removed {
+			value := e.Data
+			dc.Value = &value
+		}
+		change.Data = append(change.Data, dc)
+	}
+
+	for _, e := range event.Policy {
+		change.Policies = append(change.Policies, types.PolicyChangeV1{
+			ID:      e.ID,
+			Raw:     string(e.Data),
+			Removed: e.Removed,
+		})
+	}
+
+	// Policy events are not ordered by the store.
+	sort.Slice(change.Policies, func(i, j int) bool {
+		return change.Policies[i].ID < change.Policies[j].ID
+	})
+
+	bs, err := json.Marshal(change)
+	if err != nil {
+		// The store only contains JSON values.
+		panic(err)
+	}
+
+	f.seq = change.Sequence
+	f.changes = append(f.changes, bs)
+
+	if len(f.changes) > f.size {
+		f.changes = f.changes[len(f.changes)-f.size:]
+	}
+
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// since returns the changes after the sequence number and the sequence number
+// of the last change. If the feed no longer contains all changes after the
+// sequence number, ok is false.
+func (f *changeFeed) since(seq uint64) (changes []json.RawMessage, last uint64, notify chan struct{}, ok bool) {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	first := f.seq - uint64(len(f.changes)) + 1
+
+	if seq > f.seq || seq+1 < first {
+		return nil, f.seq, f.notify, false
+	}
+
+	return f.changes[len(f.changes)-int(f.seq-seq):], f.seq, f.notify, true
+}
+
+func (f *changeFeed) sequence() uint64 {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.seq
+}
+
+func (s *Server) v1ChangesGet(w http.ResponseWriter, r *http.Request) {
+
+	pretty := getBoolParam(r.URL, types.ParamPrettyV1, true)
+	query := r.URL.Query()
+
+	var since uint64
+	var wait time.Duration
+
+	if p := query.Get(types.ParamSinceV1); p != "" {
+		var err error
+		if since, err = strconv.ParseUint(p, 10, 64); err != nil {
+			writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, "invalid %v parameter: %v", types.ParamSinceV1, p))
+			return
+		}
+	}
+
+	if p := query.Get(types.ParamWaitV1); p != "" {
+		seconds, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, "invalid %v parameter: %v", types.ParamWaitV1, p))
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+
+	if feed := query.Get(types.ParamFeedV1); feed != "" && feed != s.changes.id {
+		writer.Error(w, http.StatusGone, types.NewErrorV1(types.CodeChangesExpired, "change feed %v does not exist", feed))
+		return
+	}
+
+	changes, last, notify, ok := s.changes.since(since)
+
+	if ok && len(changes) == 0 && wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-notify:
+			changes, last, _, ok = s.changes.since(since)
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+		timer.Stop()
+	}
+
+	if !ok {
+		writer.Error(w, http.StatusGone, types.NewErrorV1(types.CodeChangesExpired, "changes after sequence %d are not available", since))
+		return
+	}
+
+	if changes == nil {
+		changes = []json.RawMessage{}
+	}
+
+	writer.JSON(w, http.StatusOK, changesResponse{
+		Feed:     s.changes.id,
+		Sequence: last,
+		Changes:  changes,
+	}, pretty)
+}
+
+func (s *Server) v1ChangesSnapshotGet(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	pretty := getBoolParam(r.URL, types.ParamPrettyV1, true)
+
+	// The read transaction blocks commits, so the snapshot and the sequence
+	// number are consistent. The response has to be written before the
+	// transaction is closed because the store may modify the values in place.
+	txn, err := s.store.NewTransaction(ctx)
+	if err != nil {
+		writer.ErrorAuto(w, err)
+		return
+	}
+
+	defer s.store.Abort(ctx, txn)
+
+	data, err := s.store.Read(ctx, txn, storage.Path{})
+	if err != nil {
+		writer.ErrorAuto(w, err)
+		return
+	}
+
+	ids, err := s.store.ListPolicies(ctx, txn)
+	if err != nil {
+		writer.ErrorAuto(w, err)
+		return
+	}
+
+	sort.Strings(ids)
+	policies := make([]types.PolicyChangeV1, len(ids))
+
+	for i, id := range ids {
+		bs, err := s.store.GetPolicy(ctx, txn, id)
+		if err != nil {
+			writer.ErrorAuto(w, err)
+			return
+		}
+		policies[i] = types.PolicyChangeV1{ID: id, Raw: string(bs)}
+	}
+
+	writer.JSON(w, http.StatusOK, types.ChangesSnapshotResponseV1{
+		Feed:     s.changes.id,
+		Sequence: s.changes.sequence(),
+		Data:     data,
+		Policies: policies,
+	}, pretty)
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/server/types"
+	"github.com/open-policy-agent/opa/util"
+)
+
+func newChangesFixture(t *testing.T, size int) *fixture {
+	return newFixture(t, func(s *Server) {
+		s.WithChangeFeed(size)
+	})
+}
+
+func (f *fixture) changes(path string) types.ChangesResponseV1 {
+	f.t.Helper()
+	if err := f.v1(http.MethodGet, path, "", 200, ""); err != nil {
+		f.t.Fatal(err)
+	}
+	var resp types.ChangesResponseV1
+	if err := util.NewJSONDecoder(f.recorder.Body).Decode(&resp); err != nil {
+		f.t.Fatal(err)
+	}
+	return resp
+}
+
+func TestChangesDisabled(t *testing.T) {
+	f := newFixture(t)
+	if err := f.v1(http.MethodGet, "/changes", "", 404, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChanges(t *testing.T) {
+
+	f := newChangesFixture(t, 10)
+
+	for _, tr := range []tr{
+		{http.MethodPut, "/data/a", `{"b": [1]}`, 204, ""},
+		{http.MethodPatch, "/data/a/b", `[{"op": "add", "path": "-", "value": 2}]`, 204, ""},
+		{http.MethodPut, "/policies/test", `package test
+
+p = data.a.b`, 200, ""},
+		{http.MethodDelete, "/data/a/b", "", 204, ""},
+	} {
+		if err := f.v1(tr.method, tr.path, tr.body, tr.code, tr.resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := f.changes("/changes")
+
+	if resp.Feed != f.server.changes.id || resp.Sequence != 4 || len(resp.Changes) != 4 {
+		t.Fatalf("Unexpected response: %+v", resp)
+	}
+
+	exp := []types.ChangeV1{
+		{Sequence: 1, Data: []types.DataChangeV1{{Path: []string{"a"}, Value: value(`{"b": [1]}`)}}},
+		{Sequence: 2, Data: []types.DataChangeV1{{Path: []string{"a", "b"}, Value: value(`[1, 2]`)}}},
+		{Sequence: 3, Policies: []types.PolicyChangeV1{{ID: "test", Raw: "package test\n\np = data.a.b"}}},
+		{Sequence: 4, Data: []types.DataChangeV1{{Path: []string{"a", "b"}, Removed: true}}},
+	}
+
+	if !reflect.DeepEqual(resp.Changes, exp) {
+		t.Fatalf("Expected changes %+v but got %+v", exp, resp.Changes)
+	}
+
+	resp = f.changes(fmt.Sprintf("/changes?since=2&feed=%v", resp.Feed))
+
+	if resp.Sequence != 4 || len(resp.Changes) != 2 || resp.Changes[0].Sequence != 3 {
+		t.Fatalf("Unexpected response: %+v", resp)
+	}
+
+	resp = f.changes("/changes?since=4")
+
+	if resp.Sequence != 4 || len(resp.Changes) != 0 {
+		t.Fatalf("Unexpected response: %+v", resp)
+	}
+}
+
+func TestChangesExpired(t *testing.T) {
+
+	f := newChangesFixture(t, 2)
+
+	for i := 0; i < 3; i++ {
+		if err := f.v1(http.MethodPut, "/data/x", fmt.Sprint(i), 204, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := f.changes("/changes?since=1")
+
+	if resp.Sequence != 3 || len(resp.Changes) != 2 || resp.Changes[0].Sequence != 2 {
+		t.Fatalf("Unexpected response: %+v", resp)
+	}
+
+	expired := `{"code": "changes_expired", "message": "changes after sequence %d are not available"}`
+
+	for _, tr := range []tr{
+		{http.MethodGet, "/changes?since=0", "", 410, fmt.Sprintf(expired, 0)},
+		{http.MethodGet, "/changes?since=4", "", 410, fmt.Sprintf(expired, 4)},
+		{http.MethodGet, "/changes?since=3&feed=deadbeef", "", 410, `{"code": "changes_expired", "message": "change feed deadbeef does not exist"}`},
+		{http.MethodGet, "/changes?since=x", "", 400, `{"code": "invalid_parameter", "message": "invalid since parameter: x"}`},
+		{http.MethodGet, "/changes?wait=-1", "", 400, `{"code": "invalid_parameter", "message": "invalid wait parameter: -1"}`},
+	} {
+		if err := f.v1(tr.method, tr.path, tr.body, tr.code, tr.resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestChangesWait(t *testing.T) {
+
+	f := newChangesFixture(t, 10)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		req := newReqV1(http.MethodPut, "/data/x", "1")
+		f.server.Handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	resp := f.changes("/changes?since=0&wait=10")
+
+	if resp.Sequence != 1 || len(resp.Changes) != 1 {
+		t.Fatalf("Unexpected response: %+v", resp)
+	}
+}
+
+func TestChangesSnapshot(t *testing.T) {
+
+	f := newChangesFixture(t, 10)
+
+	for _, tr := range []tr{
+		{http.MethodPut, "/data/a", `{"b": 1}`, 204, ""},
+		{http.MethodPut, "/policies/test", `package test`, 200, ""},
+		{http.MethodGet, "/changes/snapshot", "", 200, ""},
+	} {
+		if err := f.v1(tr.method, tr.path, tr.body, tr.code, tr.resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var resp types.ChangesSnapshotResponseV1
+	if err := util.NewJSONDecoder(f.recorder.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Feed != f.server.changes.id || resp.Sequence != 2 {
+		t.Fatalf("Unexpected snapshot: %+v", resp)
+	}
+
+	if data, ok := resp.Data.(map[string]interface{}); !ok || !reflect.DeepEqual(data["a"], *value(`{"b": 1}`)) {
+		t.Fatalf("Unexpected snapshot data: %v", resp.Data)
+	}
+
+	if exp := []types.PolicyChangeV1{{ID: "test", Raw: "package test"}}; !reflect.DeepEqual(resp.Policies, exp) {
+		t.Fatalf("Expected policies %+v but got %+v", exp, resp.Policies)
+	}
+}
+
+func value(s string) *interface{} {
+	var x interface{}
+	if err := util.UnmarshalJSON([]byte(s), &x); err != nil {
+		panic(err)
+	}
+	return &x
+}
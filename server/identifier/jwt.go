@@ -0,0 +1,299 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package identifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
+	"github.com/open-policy-agent/opa/internal/jwx/jwk"
+	"github.com/open-policy-agent/opa/internal/jwx/jws"
+	"github.com/open-policy-agent/opa/internal/jwx/jws/verify"
+	"github.com/open-policy-agent/opa/server/types"
+	"github.com/open-policy-agent/opa/server/writer"
+)
+
+// Claims returns the verified JWT claims of the caller associated with ctx.
+func Claims(r *http.Request) (map[string]interface{}, bool) {
+	v, ok := r.Context().Value(claims).(map[string]interface{})
+	return v, ok
+}
+
+// SetClaims returns a new http.Request with the verified JWT claims set to v.
+func SetClaims(r *http.Request, v map[string]interface{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), claims, v))
+}
+
+const claims = identityKey("org.openpolicyagent/claims")
+
+// JWTConfig contains the settings used to verify JWT bearer tokens.
+type JWTConfig struct {
+	// Key is the secret (HMAC) or the PEM encoded public key (RSA and ECDSA)
+	// used to verify token signatures. If Key names an existing file, the key
+	// is read from the file.
+	Key string
+
+	// Algorithm is the signing algorithm of Key (e.g., RS256.)
+	Algorithm string
+
+	// JWKSFile is the path of a JSON Web Key Set used to verify token
+	// signatures. It may be used instead of or in addition to Key.
+	JWKSFile string
+
+	// Audience, if set, must be contained in the "aud" claim of the token.
+	Audience string
+
+	// Issuer, if set, must be equal to the "iss" claim of the token.
+	Issuer string
+}
+
+// JWTVerifier verifies the signature and registered claims of JWTs.
+type JWTVerifier struct {
+	keys     []jwtKey
+	audience string
+	issuer   string
+	now      func() time.Time
+}
+
+type jwtKey struct {
+	kid string
+	alg jwa.SignatureAlgorithm
+	key interface{}
+}
+
+// NewJWTVerifier returns a new JWTVerifier for config. An error is returned if
+// no keys are configured or the keys cannot be loaded.
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+
+	v := &JWTVerifier{
+		audience: config.Audience,
+		issuer:   config.Issuer,
+		now:      time.Now,
+	}
+
+	if config.Key != "" {
+		raw, err := readKey(config.Key)
+		if err != nil {
+			return nil, err
+		}
+		alg := jwa.SignatureAlgorithm(config.Algorithm)
+		key, err := verify.GetSigningKey(raw, alg)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, jwtKey{alg: alg, key: key})
+	}
+
+	if config.JWKSFile != "" {
+		bs, err := ioutil.ReadFile(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		set, err := jwk.ParseBytes(bs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWKS file %v: %v", config.JWKSFile, err)
+		}
+		for _, k := range set.Keys {
+			key, err := k.Materialize()
+			if err != nil {
+				return nil, err
+			}
+			v.keys = append(v.keys, jwtKey{kid: k.GetKeyID(), alg: k.GetAlgorithm(), key: key})
+		}
+	}
+
+	if len(v.keys) == 0 {
+		return nil, errors.New("JWT authentication requires a verification key or JWKS file")
+	}
+
+	return v, nil
+}
+
+func readKey(key string) (string, error) {
+	if _, err := os.Stat(key); err == nil {
+		bs, err := ioutil.ReadFile(key)
+		if err != nil {
+			return "", err
+		}
+		return string(bs), nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	return key, nil
+}
+
+// Verify checks the signature of the compact serialized token and validates
+// the "exp", "nbf", "aud" and "iss" claims. If the token is valid, the claims
+// are returned.
+func (v *JWTVerifier) Verify(token string) (map[string]interface{}, error) {
+
+	parts, err := jws.SplitCompact(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		Algorithm jwa.SignatureAlgorithm `json:"alg"`
+		KeyID     string                 `json:"kid"`
+	}
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %v", err)
+	}
+
+	if err := v.verifySignature(token, header.Algorithm, header.KeyID); err != nil {
+		return nil, err
+	}
+
+	var payload map[string]interface{}
+	if err := decodeSegment(parts[1], &payload); err != nil {
+		return nil, fmt.Errorf("invalid token payload: %v", err)
+	}
+
+	if err := v.validateClaims(payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+func (v *JWTVerifier) verifySignature(token string, alg jwa.SignatureAlgorithm, kid string) error {
+	for _, k := range v.keys {
+		if kid != "" && k.kid != "" && kid != k.kid {
+			continue
+		}
+		// Keys without an algorithm (e.g., JWKs without "alg") accept any
+		// algorithm in the family of the key type. The verifier rejects
+		// algorithms that do not match the type of the key.
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+		if _, err := jws.Verify([]byte(token), alg, k.key); err == nil {
+			return nil
+		}
+	}
+	return errors.New("token signature verification failed")
+}
+
+func (v *JWTVerifier) validateClaims(payload map[string]interface{}) error {
+	now := v.now()
+
+	if exp, ok := payload["exp"]; ok {
+		t, err := numericDate(exp)
+		if err != nil {
+			return fmt.Errorf("invalid exp claim: %v", err)
+		}
+		if !now.Before(t) {
+			return errors.New("token is expired")
+		}
+	}
+
+	if nbf, ok := payload["nbf"]; ok {
+		t, err := numericDate(nbf)
+		if err != nil {
+			return fmt.Errorf("invalid nbf claim: %v", err)
+		}
+		if now.Before(t) {
+			return errors.New("token is not valid yet")
+		}
+	}
+
+	if v.issuer != "" {
+		if iss, ok := payload["iss"].(string); !ok || iss != v.issuer {
+			return errors.New("token issuer mismatch")
+		}
+	}
+
+	if v.audience != "" && !containsAudience(payload["aud"], v.audience) {
+		return errors.New("token audience mismatch")
+	}
+
+	return nil
+}
+
+func numericDate(x interface{}) (time.Time, error) {
+	n, ok := x.(json.Number)
+	if !ok {
+		return time.Time{}, errors.New("expected number")
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), nil
+}
+
+func containsAudience(aud interface{}, expected string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == expected
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(s string, x interface{}) error {
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(bs))
+	decoder.UseNumber()
+	return decoder.Decode(x)
+}
+
+// JWTBased verifies JWT bearer tokens on incoming requests. Requests with a
+// valid token have the token claims and subject set as the identity. Requests
+// with an invalid token are rejected. Requests without a bearer token are
+// passed through without an identity.
+type JWTBased struct {
+	inner    http.Handler
+	verifier *JWTVerifier
+}
+
+// NewJWTBased returns a new JWTBased object.
+func NewJWTBased(inner http.Handler, verifier *JWTVerifier) *JWTBased {
+	return &JWTBased{
+		inner:    inner,
+		verifier: verifier,
+	}
+}
+
+func (h *JWTBased) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	value := r.Header.Get("Authorization")
+	if len(value) > 0 {
+		match := bearerTokenRegexp.FindStringSubmatch(value)
+		if len(match) > 0 {
+			payload, err := h.verifier.Verify(match[1])
+			if err != nil {
+				writer.Error(w, http.StatusUnauthorized, types.NewErrorV1(types.CodeUnauthorized, "invalid bearer token: %v", strings.TrimSpace(err.Error())))
+				return
+			}
+			if sub, ok := payload["sub"].(string); ok {
+				r = SetIdentity(r, sub)
+			}
+			r = SetClaims(r, payload)
+		}
+	}
+
+	h.inner.ServeHTTP(w, r)
+}
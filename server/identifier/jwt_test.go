@@ -0,0 +1,247 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package identifier_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
+	"github.com/open-policy-agent/opa/internal/jwx/jws"
+	"github.com/open-policy-agent/opa/server/identifier"
+	"github.com/open-policy-agent/opa/util"
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestJWTVerifierHMAC(t *testing.T) {
+
+	verifier, err := identifier.NewJWTVerifier(identifier.JWTConfig{
+		Key:       "secret",
+		Algorithm: "HS256",
+		Audience:  "opa",
+		Issuer:    "https://issuer.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+
+	tests := []struct {
+		note    string
+		key     string
+		claims  map[string]interface{}
+		wantErr string
+	}{
+		{
+			note:   "valid",
+			key:    "secret",
+			claims: map[string]interface{}{"sub": "alice", "aud": "opa", "iss": "https://issuer.example.com", "exp": now + 60, "nbf": now - 60},
+		},
+		{
+			note:   "audience list",
+			key:    "secret",
+			claims: map[string]interface{}{"aud": []string{"other", "opa"}, "iss": "https://issuer.example.com"},
+		},
+		{
+			note:    "bad signature",
+			key:     "not-the-secret",
+			claims:  map[string]interface{}{"aud": "opa", "iss": "https://issuer.example.com"},
+			wantErr: "token signature verification failed",
+		},
+		{
+			note:    "expired",
+			key:     "secret",
+			claims:  map[string]interface{}{"aud": "opa", "iss": "https://issuer.example.com", "exp": now - 60},
+			wantErr: "token is expired",
+		},
+		{
+			note:    "not before",
+			key:     "secret",
+			claims:  map[string]interface{}{"aud": "opa", "iss": "https://issuer.example.com", "nbf": now + 60},
+			wantErr: "token is not valid yet",
+		},
+		{
+			note:    "audience mismatch",
+			key:     "secret",
+			claims:  map[string]interface{}{"aud": "other", "iss": "https://issuer.example.com"},
+			wantErr: "token audience mismatch",
+		},
+		{
+			note:    "issuer mismatch",
+			key:     "secret",
+			claims:  map[string]interface{}{"aud": "opa", "iss": "https://other.example.com"},
+			wantErr: "token issuer mismatch",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			token := signToken(t, tc.claims, jwa.HS256, []byte(tc.key), "")
+			claims, err := verifier.Verify(token)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("Expected error %q but got: %v", tc.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if claims["aud"] == nil {
+				t.Fatalf("Expected claims but got: %v", claims)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierJWKS(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := fmt.Sprintf(`{"keys": [{"kty": "RSA", "kid": "k1", "n": %q, "e": %q}]}`,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()))
+
+	test.WithTempFS(map[string]string{"jwks.json": jwks}, func(path string) {
+
+		verifier, err := identifier.NewJWTVerifier(identifier.JWTConfig{
+			JWKSFile: filepath.Join(path, "jwks.json"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		claims := map[string]interface{}{"sub": "alice"}
+
+		if _, err := verifier.Verify(signToken(t, claims, jwa.RS256, key, "k1")); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if _, err := verifier.Verify(signToken(t, claims, jwa.RS256, other, "k1")); err == nil {
+			t.Fatal("Expected error for token signed with unknown key")
+		}
+
+		if _, err := verifier.Verify(signToken(t, claims, jwa.RS256, key, "k2")); err == nil {
+			t.Fatal("Expected error for token with unknown key ID")
+		}
+	})
+}
+
+func TestJWTVerifierNoKeys(t *testing.T) {
+	if _, err := identifier.NewJWTVerifier(identifier.JWTConfig{}); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestJWTBased(t *testing.T) {
+
+	verifier, err := identifier.NewJWTVerifier(identifier.JWTConfig{
+		Key:       "secret",
+		Algorithm: "HS256",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		note     string
+		value    string
+		status   int
+		identity string
+		defined  bool
+	}{
+		{
+			note:   "no token",
+			status: http.StatusOK,
+		},
+		{
+			note:     "valid token",
+			value:    "Bearer " + signToken(t, map[string]interface{}{"sub": "alice"}, jwa.HS256, []byte("secret"), ""),
+			status:   http.StatusOK,
+			identity: "alice",
+			defined:  true,
+		},
+		{
+			note:   "invalid token",
+			value:  "Bearer " + signToken(t, map[string]interface{}{"sub": "alice"}, jwa.HS256, []byte("wrong"), ""),
+			status: http.StatusUnauthorized,
+		},
+		{
+			note:   "malformed token",
+			value:  "Bearer not-a-jwt",
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			mock := &mockHandler{}
+
+			req, err := http.NewRequest(http.MethodGet, "/v1/data", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.value != "" {
+				req.Header.Set("Authorization", tc.value)
+			}
+
+			var claims map[string]interface{}
+			var claimsDefined bool
+			inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mock.ServeHTTP(w, r)
+				claims, claimsDefined = identifier.Claims(r)
+			})
+			handler := identifier.NewJWTBased(inner, verifier)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Fatalf("Expected status %v but got: %v", tc.status, rec.Code)
+			}
+
+			if mock.defined != tc.defined || mock.identity != tc.identity {
+				t.Fatalf("Expected identity %q (defined: %v) but got: %q (defined: %v)", tc.identity, tc.defined, mock.identity, mock.defined)
+			}
+
+			if claimsDefined != tc.defined {
+				t.Fatalf("Expected claims defined to be %v but got: %v", tc.defined, claims)
+			}
+		})
+	}
+}
+
+func signToken(t *testing.T, claims map[string]interface{}, alg jwa.SignatureAlgorithm, key interface{}, kid string) string {
+	t.Helper()
+
+	headers := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		headers["kid"] = kid
+	}
+
+	token, err := jws.SignLiteral(util.MustMarshalJSON(claims), alg, key, util.MustMarshalJSON(headers))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(token)
+}
@@ -14,6 +14,7 @@ import (
 	"html/template"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -30,9 +31,13 @@ import (
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/filter"
+	"github.com/open-policy-agent/opa/internal/activation"
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/plugins"
 	bundlePlugin "github.com/open-policy-agent/opa/plugins/bundle"
+	"github.com/open-policy-agent/opa/plugins/datasources"
+	"github.com/open-policy-agent/opa/profiler"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/server/authorizer"
 	"github.com/open-policy-agent/opa/server/identifier"
@@ -55,6 +60,7 @@ const (
 	AuthenticationOff AuthenticationScheme = iota
 	AuthenticationToken
 	AuthenticationTLS
+	AuthenticationJWT
 )
 
 // AuthorizationScheme enumerates the supported authorization schemes. The authorization
@@ -74,6 +80,7 @@ const (
 	PromHandlerV1Query    = "v1/query"
 	PromHandlerV1Policies = "v1/policies"
 	PromHandlerV1Compile  = "v1/compile"
+	PromHandlerV1Changes  = "v1/changes"
 	PromHandlerIndex      = "index"
 	PromHandlerCatch      = "catchall"
 	PromHandlerHealth     = "health"
@@ -94,9 +101,21 @@ type Server struct {
 	diagAddrs           []string
 	insecureAddr        string
 	authentication      AuthenticationScheme
+	jwtConfig           identifier.JWTConfig
+	jwtVerifier         *identifier.JWTVerifier
 	authorization       AuthorizationScheme
+	authzBody           bool
+	authzCerts          bool
 	cert                *tls.Certificate
+	certMtx             sync.RWMutex
+	certHash            []byte
+	certFile            string
+	certKeyFile         string
+	certRefresh         time.Duration
 	certPool            *x509.CertPool
+	certPoolFile        string
+	certPoolHash        []byte
+	certLoopStop        chan struct{}
 	mtx                 sync.RWMutex
 	partials            map[string]rego.PartialResult
 	preparedEvalQueries *cache
@@ -110,10 +129,17 @@ type Server struct {
 	logger              func(context.Context, *Info) error
 	errLimit            int
 	pprofEnabled        bool
+	profileSampleRate   float64
+	profileMtx          sync.Mutex
+	profile             *profiler.Profile // set once in Init, guarded by profileMtx afterwards
 	runtime             *ast.Term
 	httpListeners       []httpListener
 	metrics             Metrics
 	defaultDecisionPath string
+	unixSocketPerm      *string
+	activated           []activation.Listener
+	changeFeedSize      int
+	changes             *changeFeed
 }
 
 // Metrics defines the interface that the server requires for recording HTTP
@@ -134,6 +160,21 @@ func New() *Server {
 
 // Init initializes the server. This function MUST be called before Loop.
 func (s *Server) Init(ctx context.Context) (*Server, error) {
+	if s.authentication == AuthenticationJWT {
+		verifier, err := identifier.NewJWTVerifier(s.jwtConfig)
+		if err != nil {
+			return nil, err
+		}
+		s.jwtVerifier = verifier
+	}
+
+	if s.changeFeedSize > 0 {
+		var err error
+		if s.changes, err = newChangeFeed(s.changeFeedSize); err != nil {
+			return nil, err
+		}
+	}
+
 	s.initRouters()
 	s.Handler = s.initHandlerAuth(s.Handler)
 	s.DiagnosticHandler = s.initHandlerAuth(s.DiagnosticHandler)
@@ -153,6 +194,18 @@ func (s *Server) Init(ctx context.Context) (*Server, error) {
 		return nil, err
 	}
 
+	// Register the change feed in the same transaction so that it records
+	// all changes committed after the server is initialized.
+	if s.changes != nil {
+		config := storage.TriggerConfig{
+			OnCommit: s.changes.onCommit,
+		}
+		if _, err := s.store.Register(ctx, txn, config); err != nil {
+			s.store.Abort(ctx, txn)
+			return nil, err
+		}
+	}
+
 	s.manager.RegisterCompilerTrigger(s.migrateWatcher)
 
 	s.watcher, err = watch.New(ctx, s.store, s.getCompiler(), txn)
@@ -178,6 +231,11 @@ func (s *Server) Init(ctx context.Context) (*Server, error) {
 // currently in use by the OPA Server. If any exceed the deadline specified
 // by the context an error will be returned.
 func (s *Server) Shutdown(ctx context.Context) error {
+	if s.certLoopStop != nil {
+		close(s.certLoopStop)
+		s.certLoopStop = nil
+	}
+
 	errChan := make(chan error)
 	for _, srvr := range s.httpListeners {
 		go func(s httpListener) {
@@ -222,18 +280,47 @@ func (s *Server) WithInsecureAddress(addr string) *Server {
 	return s
 }
 
+// WithUnixSocketPermission sets the permission for the Unix domain socket if
+// used to listen for incoming connections. Applies to the sockets the server
+// creates for unix:// addresses only.
+func (s *Server) WithUnixSocketPermission(unixSocketPerm *string) *Server {
+	s.unixSocketPerm = unixSocketPerm
+	return s
+}
+
 // WithAuthentication sets authentication scheme to use on the server.
 func (s *Server) WithAuthentication(scheme AuthenticationScheme) *Server {
 	s.authentication = scheme
 	return s
 }
 
+// WithJWTConfig sets the configuration used to verify bearer tokens when the
+// JWT authentication scheme is used.
+func (s *Server) WithJWTConfig(config identifier.JWTConfig) *Server {
+	s.jwtConfig = config
+	return s
+}
+
 // WithAuthorization sets authorization scheme to use on the server.
 func (s *Server) WithAuthorization(scheme AuthorizationScheme) *Server {
 	s.authorization = scheme
 	return s
 }
 
+// WithAuthorizationBody sets whether the request body is included in the input
+// to the authorization policy for PUT, PATCH and POST requests.
+func (s *Server) WithAuthorizationBody(enabled bool) *Server {
+	s.authzBody = enabled
+	return s
+}
+
+// WithAuthorizationCertificates sets whether the verified client certificate
+// chain is included in the input to the authorization policy.
+func (s *Server) WithAuthorizationCertificates(enabled bool) *Server {
+	s.authzCerts = enabled
+	return s
+}
+
 // WithCertificate sets the server-side certificate that the server will use.
 func (s *Server) WithCertificate(cert *tls.Certificate) *Server {
 	s.cert = cert
@@ -277,6 +364,15 @@ func (s *Server) WithPprofEnabled(pprofEnabled bool) *Server {
 	return s
 }
 
+// WithProfileSampleRate sets the fraction of decisions requested through the
+// data API that are profiled. The profiles are aggregated and served in the
+// pprof format on /debug/pprof/rego. Profiling is disabled if the rate is
+// zero.
+func (s *Server) WithProfileSampleRate(rate float64) *Server {
+	s.profileSampleRate = rate
+	return s
+}
+
 // WithDecisionLogger sets the decision logger used by the
 // server. DEPRECATED. Use WithDecisionLoggerWithErr instead.
 func (s *Server) WithDecisionLogger(logger func(context.Context, *Info)) *Server {
@@ -316,6 +412,17 @@ func (s *Server) WithRouter(router *mux.Router) *Server {
 func (s *Server) Listeners() ([]Loop, error) {
 	loops := []Loop{}
 
+	activated, err := activation.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	s.activated = activated
+
+	// Sockets passed by the service manager that are not bound to any of the
+	// addresses are closed so that connections are refused instead of hanging.
+	// This also happens if an error is returned below.
+	defer s.closeUnclaimedListeners()
+
 	handlerBindings := map[httpListenerType]struct {
 		addrs   []string
 		handler http.Handler
@@ -348,9 +455,27 @@ func (s *Server) Listeners() ([]Loop, error) {
 		loops = append(loops, loop)
 	}
 
+	if s.certRefresh > 0 {
+		s.reloadCertificates()
+		stop := make(chan struct{})
+		s.certLoopStop = stop
+		loops = append(loops, s.certLoop(stop))
+	}
+
 	return loops, nil
 }
 
+// closeUnclaimedListeners closes the inherited listeners that have not been
+// claimed by any of the configured addresses.
+func (s *Server) closeUnclaimedListeners() {
+	for _, l := range s.activated {
+		if l.Listener != nil {
+			l.Close()
+		}
+	}
+	s.activated = nil
+}
+
 // Addrs returns a list of addresses that the server is listening on.
 // If the server hasn't been started it will not return an address.
 func (s *Server) Addrs() []string {
@@ -420,7 +545,19 @@ func newHTTPListener(srvr *http.Server, t httpListenerType) httpListener {
 }
 
 func newHTTPUnixSocketListener(srvr *http.Server, l net.Listener, t httpListenerType) httpListener {
-	return &baseHTTPListener{s: srvr, l: l, t: t}
+	return &baseHTTPListener{s: srvr, l: l, t: t, addr: listenerAddr(l)}
+}
+
+func newHTTPInheritedListener(srvr *http.Server, l net.Listener, t httpListenerType) httpListener {
+	return &baseHTTPListener{s: srvr, l: l, t: t, addr: listenerAddr(l)}
+}
+
+func listenerAddr(l net.Listener) string {
+	addr := l.Addr()
+	if addr.Network() == "unix" {
+		return "unix://" + addr.String()
+	}
+	return addr.String()
 }
 
 func (b *baseHTTPListener) ListenAndServe() error {
@@ -495,6 +632,8 @@ func (s *Server) getListener(addr string, h http.Handler, t httpListenerType) (L
 		loop, listener, err = s.getListenerForHTTPServer(parsedURL, h, t)
 	case "https":
 		loop, listener, err = s.getListenerForHTTPSServer(parsedURL, h, t)
+	case "fd":
+		loop, listener, err = s.getListenerForInheritedSocket(parsedURL, h, t)
 	default:
 		err = fmt.Errorf("invalid url scheme %q", parsedURL.Scheme)
 	}
@@ -520,15 +659,9 @@ func (s *Server) getListenerForHTTPSServer(u *url.URL, h http.Handler, t httpLis
 	}
 
 	httpsServer := http.Server{
-		Addr:    u.Host,
-		Handler: h,
-		TLSConfig: &tls.Config{
-			Certificates: []tls.Certificate{*s.cert},
-			ClientCAs:    s.certPool,
-		},
-	}
-	if s.authentication == AuthenticationTLS {
-		httpsServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		Addr:      u.Host,
+		Handler:   h,
+		TLSConfig: s.getTLSConfig(),
 	}
 
 	l := newHTTPListener(&httpsServer, t)
@@ -550,12 +683,61 @@ func (s *Server) getListenerForUNIXSocket(u *url.URL, h http.Handler, t httpList
 		return nil, nil, err
 	}
 
+	if s.unixSocketPerm != nil {
+		modeVal, err := strconv.ParseUint(*s.unixSocketPerm, 8, 32)
+		if err != nil {
+			unixListener.Close()
+			return nil, nil, fmt.Errorf("invalid unix socket permission %q: %v", *s.unixSocketPerm, err)
+		}
+
+		if err := os.Chmod(socketPath, os.FileMode(modeVal)); err != nil {
+			unixListener.Close()
+			return nil, nil, err
+		}
+	}
+
 	l := newHTTPUnixSocketListener(&domainSocketServer, unixListener, t)
 
 	domainSocketLoop := func() error { return domainSocketServer.Serve(unixListener) }
 	return domainSocketLoop, l, nil
 }
 
+// getListenerForInheritedSocket returns a loop serving on a socket passed to
+// OPA by the service manager (e.g., systemd socket activation.) The socket is
+// identified by its name or file descriptor number, e.g., fd://http or fd://3.
+// The number can be used even if the service manager named the sockets.
+// If a TLS certificate is configured, connections are served over TLS.
+func (s *Server) getListenerForInheritedSocket(u *url.URL, h http.Handler, t httpListenerType) (Loop, httpListener, error) {
+	name := u.Host + u.Path
+
+	var inherited net.Listener
+	for i := range s.activated {
+		if s.activated[i].Listener == nil {
+			continue
+		}
+		if s.activated[i].Name == name || strconv.Itoa(s.activated[i].FD) == name {
+			inherited = s.activated[i].Listener
+			s.activated[i].Listener = nil
+			break
+		}
+	}
+
+	if inherited == nil {
+		return nil, nil, fmt.Errorf("socket activation: no socket named %q was passed to the process", name)
+	}
+
+	srvr := http.Server{Handler: h}
+	l := newHTTPInheritedListener(&srvr, inherited, t)
+
+	if s.cert == nil {
+		return func() error { return srvr.Serve(inherited) }, l, nil
+	}
+
+	srvr.TLSConfig = s.getTLSConfig()
+
+	return func() error { return srvr.ServeTLS(inherited, "", "") }, l, nil
+}
+
 func (s *Server) initHandlerAuth(handler http.Handler) http.Handler {
 	// Add authorization handler. This must come BEFORE authentication handler
 	// so that the latter can run first.
@@ -566,7 +748,9 @@ func (s *Server) initHandlerAuth(handler http.Handler) http.Handler {
 			s.getCompiler,
 			s.store,
 			authorizer.Runtime(s.runtime),
-			authorizer.Decision(s.manager.Config.DefaultAuthorizationDecisionRef))
+			authorizer.Decision(s.manager.Config.DefaultAuthorizationDecisionRef),
+			authorizer.Body(s.authzBody),
+			authorizer.Certificates(s.authzCerts))
 	}
 
 	switch s.authentication {
@@ -574,6 +758,8 @@ func (s *Server) initHandlerAuth(handler http.Handler) http.Handler {
 		handler = identifier.NewTokenBased(handler)
 	case AuthenticationTLS:
 		handler = identifier.NewTLSBased(handler)
+	case AuthenticationJWT:
+		handler = identifier.NewJWTBased(handler, s.jwtVerifier)
 	}
 
 	return handler
@@ -614,6 +800,11 @@ func (s *Server) initRouters() {
 		mainRouter.HandleFunc("/debug/pprof/trace", pprof.Trace)
 	}
 
+	if s.profileSampleRate > 0 {
+		s.profile = profiler.NewProfile()
+		mainRouter.HandleFunc("/debug/pprof/rego", s.debugPprofRego).Methods(http.MethodGet)
+	}
+
 	// Only the main mainRouter gets the OPA API's (data, policies, query, etc)
 	s.registerHandler(mainRouter, 0, "/data/{path:.+}", http.MethodPost, s.instrumentHandler(s.v0DataPost, PromHandlerV0Data))
 	s.registerHandler(mainRouter, 0, "/data", http.MethodPost, s.instrumentHandler(s.v0DataPost, PromHandlerV0Data))
@@ -633,6 +824,10 @@ func (s *Server) initRouters() {
 	s.registerHandler(mainRouter, 1, "/query", http.MethodGet, s.instrumentHandler(s.v1QueryGet, PromHandlerV1Query))
 	s.registerHandler(mainRouter, 1, "/query", http.MethodPost, s.instrumentHandler(s.v1QueryPost, PromHandlerV1Query))
 	s.registerHandler(mainRouter, 1, "/compile", http.MethodPost, s.instrumentHandler(s.v1CompilePost, PromHandlerV1Compile))
+	if s.changes != nil {
+		s.registerHandler(mainRouter, 1, "/changes", http.MethodGet, s.instrumentHandler(s.v1ChangesGet, PromHandlerV1Changes))
+		s.registerHandler(mainRouter, 1, "/changes/snapshot", http.MethodGet, s.instrumentHandler(s.v1ChangesSnapshotGet, PromHandlerV1Changes))
+	}
 	mainRouter.Handle("/", s.instrumentHandler(s.unversionedPost, PromHandlerIndex)).Methods(http.MethodPost)
 	mainRouter.Handle("/", s.instrumentHandler(s.indexGet, PromHandlerIndex)).Methods(http.MethodGet)
 
@@ -1045,6 +1240,11 @@ func (s *Server) v1CompilePost(w http.ResponseWriter, r *http.Request) {
 	explainMode := getExplain(r.URL.Query()[types.ParamExplainV1], types.ExplainOffV1)
 	includeMetrics := getBoolParam(r.URL, types.ParamMetricsV1, true)
 	includeInstrumentation := getBoolParam(r.URL, types.ParamInstrumentV1, true)
+	filterMode, err := getFilterMode(r.URL.Query()[types.ParamFilterV1])
+	if err != nil {
+		writer.ErrorString(w, http.StatusBadRequest, types.CodeInvalidParameter, err)
+		return
+	}
 
 	m := metrics.New()
 
@@ -1106,11 +1306,30 @@ func (s *Server) v1CompilePost(w http.ResponseWriter, r *http.Request) {
 		result.Explanation = s.getExplainResponse(explainMode, *buf, pretty)
 	}
 
-	var i interface{} = types.PartialEvaluationResultV1{
+	partial := types.PartialEvaluationResultV1{
 		Queries: pq.Queries,
 		Support: pq.Support,
 	}
 
+	if filterMode != types.FilterOffV1 {
+		opts := filter.Options{TagUnsupported: filterMode == types.FilterTagV1}
+		for _, u := range request.Unknowns {
+			switch v := u.Value.(type) {
+			case ast.Ref:
+				opts.Unknowns = append(opts.Unknowns, v)
+			case ast.Var:
+				opts.Unknowns = append(opts.Unknowns, ast.Ref{u})
+			}
+		}
+		partial.Filter, err = pq.Filter(opts)
+		if err != nil {
+			writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, "error(s) occurred while converting result into filter: %v", err))
+			return
+		}
+	}
+
+	var i interface{} = partial
+
 	result.Result = &i
 
 	writer.JSON(w, 200, result, pretty)
@@ -1208,14 +1427,19 @@ func (s *Server) v1DataGet(w http.ResponseWriter, r *http.Request) {
 		s.preparedEvalQueries.Insert(pqID, preparedQuery)
 	}
 
+	prof := s.sampleProfiler()
+
 	rs, err := preparedQuery.Eval(
 		ctx,
 		rego.EvalTransaction(txn),
 		rego.EvalParsedInput(input),
 		rego.EvalMetrics(m),
 		rego.EvalQueryTracer(buf),
+		rego.EvalQueryTracer(prof),
 	)
 
+	s.recordProfile(prof)
+
 	m.Timer(metrics.ServerHandler).Stop()
 
 	// Handle results.
@@ -1403,14 +1627,19 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 		s.preparedEvalQueries.Insert(pqID, preparedQuery)
 	}
 
+	prof := s.sampleProfiler()
+
 	rs, err := preparedQuery.Eval(
 		ctx,
 		rego.EvalTransaction(txn),
 		rego.EvalParsedInput(input),
 		rego.EvalMetrics(m),
 		rego.EvalQueryTracer(buf),
+		rego.EvalQueryTracer(prof),
 	)
 
+	s.recordProfile(prof)
+
 	m.Timer(metrics.ServerHandler).Stop()
 
 	// Handle results.
@@ -2032,6 +2261,15 @@ func (s *Server) checkPolicyPackageScope(ctx context.Context, txn storage.Transa
 
 func (s *Server) checkPathScope(ctx context.Context, txn storage.Transaction, path storage.Path) error {
 
+	spath := strings.Trim(path.String(), "/")
+
+	source, err := datasources.OverlappingSource(ctx, s.store, txn, spath)
+	if err != nil {
+		return err
+	} else if source != "" {
+		return types.BadRequestErr(fmt.Sprintf("path %v is owned by data source %q", spath, source))
+	}
+
 	names, err := bundle.ReadBundleNamesFromStore(ctx, s.store, txn)
 	if err != nil {
 		if !storage.IsNotFound(err) {
@@ -2049,8 +2287,6 @@ func (s *Server) checkPathScope(ctx context.Context, txn storage.Transaction, pa
 		bundleRoots[name] = roots
 	}
 
-	spath := strings.Trim(path.String(), "/")
-
 	for name, roots := range bundleRoots {
 		for _, root := range roots {
 			if strings.HasPrefix(spath, root) || strings.HasPrefix(root, spath) {
@@ -2074,6 +2310,57 @@ func (s *Server) getDecisionLogger() (logger decisionLogger) {
 	return logger
 }
 
+// sampleProfiler returns a profiler for a decision if it is selected by the
+// profile sample rate. Otherwise, nil is returned.
+func (s *Server) sampleProfiler() topdown.QueryTracer {
+	if s.profileSampleRate <= 0 || rand.Float64() >= s.profileSampleRate {
+		return nil
+	}
+	return profiler.New()
+}
+
+// recordProfile adds the profile of a sampled decision to the server profile.
+func (s *Server) recordProfile(t topdown.QueryTracer) {
+	p, ok := t.(*profiler.Profiler)
+	if !ok {
+		return
+	}
+	profile := p.Profile()
+	s.profileMtx.Lock()
+	defer s.profileMtx.Unlock()
+	s.profile.Merge(profile)
+}
+
+func (s *Server) debugPprofRego(w http.ResponseWriter, r *http.Request) {
+
+	s.profileMtx.Lock()
+	profile := s.profile.Copy()
+	if getBoolParam(r.URL, "reset", false) {
+		s.profile.Reset()
+	}
+	s.profileMtx.Unlock()
+
+	var buf bytes.Buffer
+	var err error
+
+	if r.URL.Query().Get("format") == "folded" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err = profile.WriteFolded(&buf)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+		err = profile.WritePprof(&buf)
+	}
+
+	if err != nil {
+		writer.ErrorAuto(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
 func (s *Server) getExplainResponse(explainMode types.ExplainModeV1, trace []*topdown.Event, pretty bool) (explanation types.TraceV1) {
 	switch explainMode {
 	case types.ExplainNotesV1:
@@ -2359,6 +2646,22 @@ func getBoolParam(url *url.URL, name string, ifEmpty bool) bool {
 	return false
 }
 
+func getFilterMode(p []string) (types.FilterModeV1, error) {
+	for _, x := range p {
+		switch strings.ToLower(x) {
+		case "", "true", string(types.FilterStrictV1):
+			return types.FilterStrictV1, nil
+		case string(types.FilterTagV1):
+			return types.FilterTagV1, nil
+		case "false", string(types.FilterOffV1):
+			return types.FilterOffV1, nil
+		default:
+			return "", fmt.Errorf("invalid %v parameter %q: must be one of %v, %v or %v", types.ParamFilterV1, x, types.FilterStrictV1, types.FilterTagV1, types.FilterOffV1)
+		}
+	}
+	return types.FilterOffV1, nil
+}
+
 func getWatch(p []string) (watch bool) {
 	return len(p) > 0
 }
@@ -18,6 +18,8 @@ import (
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
@@ -28,6 +30,7 @@ import (
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/internal/activation"
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/plugins"
 	pluginBundle "github.com/open-policy-agent/opa/plugins/bundle"
@@ -705,6 +708,117 @@ func TestCompileV1(t *testing.T) {
 	}
 }
 
+func TestCompileV1Filter(t *testing.T) {
+
+	mod := `package test
+
+	p {
+		input.rows.owner = input.user
+	}
+
+	p {
+		input.rows.public
+		count(input.rows.tags) > 1
+	}
+
+	default r = true
+
+	r { input.rows.x = 1 }
+	`
+
+	expFilter := func(q []string, filter string) string {
+		var queries []string
+		for _, s := range q {
+			queries = append(queries, string(util.MustMarshalJSON(ast.MustParseBody(s))))
+		}
+		return fmt.Sprintf(`{"result": {"queries": [%v], "filter": %v}}`, strings.Join(queries, ","), filter)
+	}
+
+	tests := []struct {
+		note string
+		trs  []tr
+	}{
+		{
+			note: "strict",
+			trs: []tr{
+				{http.MethodPut, "/policies/test", mod, 200, ""},
+				{http.MethodPost, "/compile?filter", `{
+					"unknowns": ["input.rows"],
+					"input": {"user": "alice"},
+					"query": "input.rows.owner = input.user; input.rows.size < 10"
+				}`, 200, expFilter([]string{`"alice" = input.rows.owner; input.rows.size < 10`}, `{"type": "and", "children": [
+					{"type": "compare", "unknown": "input.rows", "field": "owner", "op": "eq", "value": "alice"},
+					{"type": "compare", "unknown": "input.rows", "field": "size", "op": "lt", "value": 10}
+				]}`)},
+			},
+		},
+		{
+			note: "default unknowns",
+			trs: []tr{
+				{http.MethodPost, "/compile?filter=true", `{"query": "input.x = 1"}`, 200, expFilter([]string{`input.x = 1`}, `{"type": "compare", "unknown": "input", "field": "x", "op": "eq", "value": 1}`)},
+			},
+		},
+		{
+			note: "never defined",
+			trs: []tr{
+				{http.MethodPost, "/compile?filter", `{"query": "1 = 2"}`, 200, `{"result": {"filter": {"type": "false"}}}`},
+			},
+		},
+		{
+			note: "tag",
+			trs: []tr{
+				{http.MethodPost, "/compile?filter=tag", `{"query": "input.x = 1; count(input.y) > 1"}`, 200, expFilter([]string{`input.x = 1; count(input.y) > 1`}, `{"type": "and", "children": [
+					{"type": "compare", "unknown": "input", "field": "x", "op": "eq", "value": 1},
+					{"type": "unsupported", "expr": "gt(count(input.y), 1)", "location": {"file": "", "row": 1, "col": 14}}
+				]}`)},
+			},
+		},
+		{
+			note: "off",
+			trs: []tr{
+				{http.MethodPost, "/compile?filter=false", `{"query": "1 = 1"}`, 200, `{"result": {"queries": [[]]}}`},
+				{http.MethodPost, "/compile?filter=off", `{"query": "1 = 1"}`, 200, `{"result": {"queries": [[]]}}`},
+			},
+		},
+		{
+			note: "error: invalid filter mode",
+			trs: []tr{
+				{http.MethodPost, "/compile?filter=tags", `{"query": "input.x = 1"}`, 400, `{
+					"code": "invalid_parameter",
+					"message": "invalid filter parameter \"tags\": must be one of strict, tag or off"
+				}`},
+			},
+		},
+		{
+			note: "error: unsupported expression",
+			trs: []tr{
+				{http.MethodPut, "/policies/test", mod, 200, ""},
+				{http.MethodPost, "/compile?filter", `{
+					"unknowns": ["input.rows"],
+					"input": {"user": "alice"},
+					"query": "data.test.p = true"
+				}`, 400, ""},
+			},
+		},
+		{
+			note: "error: support modules",
+			trs: []tr{
+				{http.MethodPut, "/policies/test", mod, 200, ""},
+				{http.MethodPost, "/compile?filter", `{
+					"unknowns": ["input.rows"],
+					"query": "data.test.r = true"
+				}`, 400, ""},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		test.Subtest(t, tc.note, func(t *testing.T) {
+			executeRequests(t, tc.trs)
+		})
+	}
+}
+
 func TestCompileV1Observability(t *testing.T) {
 
 	f := newFixture(t)
@@ -1434,6 +1548,52 @@ func TestBundleScopeMultiBundle(t *testing.T) {
 	}
 }
 
+func TestDataSourceScope(t *testing.T) {
+
+	ctx := context.Background()
+
+	f := newFixture(t)
+
+	txn := storage.NewTransactionOrDie(ctx, f.server.store, storage.WriteParams)
+
+	if err := f.server.store.Write(ctx, txn, storage.AddOp, storage.MustParsePath("/system"), map[string]interface{}{
+		"data_sources": map[string]interface{}{"users": map[string]interface{}{"path": "acmecorp/users"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.server.store.Commit(ctx, txn); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []tr{
+		{
+			method: "PUT",
+			path:   "/data/acmecorp/users",
+			body:   "1",
+			code:   http.StatusBadRequest,
+			resp:   `{"code": "invalid_parameter", "message": "path acmecorp/users is owned by data source \"users\""}`,
+		},
+		{
+			method: "PATCH",
+			path:   "/data/acmecorp",
+			body:   `[{"op": "add", "path": "users", "value": 1}]`,
+			code:   http.StatusBadRequest,
+			resp:   `{"code": "invalid_parameter", "message": "path acmecorp/users is owned by data source \"users\""}`,
+		},
+		{
+			method: "PUT",
+			path:   "/data/acmecorp/groups",
+			body:   "1",
+			code:   http.StatusNoContent,
+		},
+	}
+
+	if err := f.v1TestRequests(cases); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestDataWatch(t *testing.T) {
 	f := newFixture(t)
 
@@ -4097,6 +4257,171 @@ func TestShutdown(t *testing.T) {
 	}
 }
 
+func TestUnixSocketListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "opa.sock")
+	perm := "700"
+
+	f := newFixture(t, func(s *Server) {
+		s.WithAddresses([]string{"unix://" + socketPath}).WithUnixSocketPermission(&perm)
+	})
+
+	loops, err := f.server.Listeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, loop := range loops {
+		go loop()
+	}
+
+	defer f.server.Shutdown(context.Background())
+
+	fi, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode().Perm() != 0700 {
+		t.Fatalf("expected socket mode 0700 but got %v", fi.Mode().Perm())
+	}
+
+	addrs := f.server.Addrs()
+	if len(addrs) != 1 || addrs[0] != "unix://"+socketPath {
+		t.Fatalf("expected unix socket address but got %v", addrs)
+	}
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+	}
+
+	resp, err := client.Get("http://unix/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 but got %v", resp.StatusCode)
+	}
+}
+
+func TestUnixSocketListenerInvalidPermission(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	perm := "rwx"
+
+	f := newFixture(t, func(s *Server) {
+		s.WithAddresses([]string{"unix://" + filepath.Join(dir, "opa.sock")}).WithUnixSocketPermission(&perm)
+	})
+
+	if _, err := f.server.Listeners(); err == nil || !strings.Contains(err.Error(), "invalid unix socket permission") {
+		t.Fatalf("expected invalid permission error but got: %v", err)
+	}
+}
+
+func TestInheritedSocketListener(t *testing.T) {
+	f := newFixture(t)
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.server.activated = []activation.Listener{{Listener: tcpListener, Name: "http"}}
+
+	if _, _, err := f.server.getListener("fd://diag", f.server.DiagnosticHandler, diagnosticListenerType); err == nil {
+		t.Fatal("expected error for unknown socket name")
+	}
+
+	loop, listener, err := f.server.getListener("fd://http", f.server.Handler, defaultListenerType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.server.httpListeners = append(f.server.httpListeners, listener)
+	go loop()
+	defer f.server.Shutdown(context.Background())
+
+	if addrs := f.server.Addrs(); len(addrs) != 1 || addrs[0] != tcpListener.Addr().String() {
+		t.Fatalf("expected inherited socket address but got %v", addrs)
+	}
+
+	resp, err := http.Get("http://" + tcpListener.Addr().String() + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 but got %v", resp.StatusCode)
+	}
+
+	// Sockets are handed out at most once.
+	if _, _, err := f.server.getListener("fd://http", f.server.Handler, defaultListenerType); err == nil {
+		t.Fatal("expected error for socket already in use")
+	}
+}
+
+func TestInheritedSocketListenerByNumber(t *testing.T) {
+	f := newFixture(t)
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+
+	// systemd always names the sockets. The file descriptor number must
+	// resolve regardless.
+	f.server.activated = []activation.Listener{{Listener: tcpListener, Name: "http", FD: 3}}
+
+	if _, _, err := f.server.getListener("fd://4", f.server.Handler, defaultListenerType); err == nil {
+		t.Fatal("expected error for unknown file descriptor")
+	}
+
+	if _, _, err := f.server.getListener("fd://3", f.server.Handler, defaultListenerType); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.server.activated[0].Listener != nil {
+		t.Fatal("expected socket to be claimed")
+	}
+}
+
+func TestCloseUnclaimedListeners(t *testing.T) {
+	f := newFixture(t)
+
+	unclaimed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.server.activated = []activation.Listener{{Listener: unclaimed, Name: "http"}, {Name: "claimed"}}
+	f.server.closeUnclaimedListeners()
+
+	if f.server.activated != nil {
+		t.Fatalf("expected activated listeners to be cleared but got %v", f.server.activated)
+	}
+
+	if _, err := unclaimed.Accept(); err == nil {
+		t.Fatal("expected unclaimed listener to be closed")
+	}
+}
+
 func TestShutdownError(t *testing.T) {
 	f := newFixture(t, func(s *Server) {
 		s.WithDiagnosticAddresses([]string{":8443"})
@@ -4347,6 +4672,57 @@ func TestDiagnosticRoutes(t *testing.T) {
 
 }
 
+func TestProfileSampling(t *testing.T) {
+
+	f := newFixture(t, func(s *Server) {
+		s.WithProfileSampleRate(1)
+	})
+
+	err := f.v1(http.MethodPut, "/policies/test", `package test
+
+p { q }
+q { input.x == 1 }`, 200, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.v1(http.MethodPost, "/data/test/p", `{"input": {"x": 1}}`, 200, `{"result": true}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.v1(http.MethodGet, "/data/test/p?input=%7B%22x%22%3A1%7D", "", 200, `{"result": true}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// The profile is only cleared if reset=true is passed explicitly.
+	for _, path := range []string{"/debug/pprof/rego?format=folded", "/debug/pprof/rego?format=folded&reset", "/debug/pprof/rego?format=folded&reset=true"} {
+		f.reset()
+		f.server.Handler.ServeHTTP(f.recorder, newReqUnversioned(http.MethodGet, path, ""))
+
+		if f.recorder.Code != 200 {
+			t.Fatalf("Expected 200 but got %v", f.recorder.Code)
+		}
+
+		if !strings.Contains(f.recorder.Body.String(), "query;data.test.p;data.test.q ") {
+			t.Fatalf("Expected call stack in profile from %v but got: %v", path, f.recorder.Body.String())
+		}
+	}
+
+	f.reset()
+	f.server.Handler.ServeHTTP(f.recorder, newReqUnversioned(http.MethodGet, "/debug/pprof/rego?format=folded", ""))
+
+	if f.recorder.Code != 200 || f.recorder.Body.Len() != 0 {
+		t.Fatalf("Expected empty profile after reset but got %v: %v", f.recorder.Code, f.recorder.Body.String())
+	}
+
+	f.reset()
+	f.server.Handler.ServeHTTP(f.recorder, newReqUnversioned(http.MethodGet, "/debug/pprof/rego", ""))
+
+	if ct := f.recorder.Header().Get("Content-Type"); f.recorder.Code != 200 || ct != "application/octet-stream" {
+		t.Fatalf("Expected pprof profile but got %v (%v)", f.recorder.Code, ct)
+	}
+}
+
 type mockHTTPHandler struct{}
 
 func (m *mockHTTPHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/filter"
 	"github.com/open-policy-agent/opa/topdown"
 	"github.com/open-policy-agent/opa/util"
 )
@@ -26,6 +27,7 @@ const (
 	CodeResourceNotFound  = "resource_not_found"
 	CodeResourceConflict  = "resource_conflict"
 	CodeUndefinedDocument = "undefined_document"
+	CodeChangesExpired    = "changes_expired"
 )
 
 // ErrorV1 models an error response sent to the client.
@@ -110,6 +112,47 @@ type PolicyDeleteResponseV1 struct {
 	Metrics MetricsV1 `json:"metrics,omitempty"`
 }
 
+// ChangesResponseV1 models the response message for the Changes API. The
+// changes are ordered by sequence number. Sequence is the sequence number of
+// the last change committed to the store.
+type ChangesResponseV1 struct {
+	Feed     string     `json:"feed"`
+	Sequence uint64     `json:"sequence"`
+	Changes  []ChangeV1 `json:"changes"`
+}
+
+// ChangesSnapshotResponseV1 models the response message for the Changes API
+// snapshot operation. The snapshot contains the data and policies of the store
+// after the change with the sequence number was committed.
+type ChangesSnapshotResponseV1 struct {
+	Feed     string           `json:"feed"`
+	Sequence uint64           `json:"sequence"`
+	Data     interface{}      `json:"data"`
+	Policies []PolicyChangeV1 `json:"policies"`
+}
+
+// ChangeV1 models the changes committed to the store in one transaction.
+type ChangeV1 struct {
+	Sequence uint64           `json:"sequence"`
+	Data     []DataChangeV1   `json:"data,omitempty"`
+	Policies []PolicyChangeV1 `json:"policies,omitempty"`
+}
+
+// DataChangeV1 models a document that was written or removed. The path is
+// the sequence of keys of the document, starting at the root of data.
+type DataChangeV1 struct {
+	Path    []string     `json:"path"`
+	Value   *interface{} `json:"value,omitempty"`
+	Removed bool         `json:"removed,omitempty"`
+}
+
+// PolicyChangeV1 models a policy module that was written or removed.
+type PolicyChangeV1 struct {
+	ID      string `json:"id"`
+	Raw     string `json:"raw,omitempty"`
+	Removed bool   `json:"removed,omitempty"`
+}
+
 // PolicyV1 models a policy module in OPA.
 type PolicyV1 struct {
 	ID  string      `json:"id"`
@@ -377,8 +420,26 @@ type CompileResponseV1 struct {
 type PartialEvaluationResultV1 struct {
 	Queries []ast.Body    `json:"queries,omitempty"`
 	Support []*ast.Module `json:"support,omitempty"`
+	Filter  *filter.Node  `json:"filter,omitempty"`
 }
 
+// FilterModeV1 defines the mode of the condition tree returned by the Compile
+// API.
+type FilterModeV1 string
+
+const (
+	// FilterOffV1 disables the condition tree.
+	FilterOffV1 FilterModeV1 = "off"
+
+	// FilterStrictV1 rejects requests whose queries cannot be converted into
+	// a condition tree.
+	FilterStrictV1 FilterModeV1 = "strict"
+
+	// FilterTagV1 replaces expressions that cannot be converted with
+	// unsupported nodes.
+	FilterTagV1 FilterModeV1 = "tag"
+)
+
 // QueryRequestV1 models the request message for Query API operations.
 type QueryRequestV1 struct {
 	Query string `json:"query"`
@@ -416,6 +477,11 @@ const (
 	// query evaluation.
 	ParamPartialV1 = "partial"
 
+	// ParamFilterV1 defines the name of the HTTP URL parameter that indicates
+	// the client wants to receive the result of partial evaluation as a
+	// condition tree. See FilterModeV1 for the supported values.
+	ParamFilterV1 = "filter"
+
 	// ParamProvenanceV1 defines the name of the HTTP URL parameter that indicates
 	// the client wants build and version information in addition to the result.
 	ParamProvenanceV1 = "provenance"
@@ -439,6 +505,18 @@ const (
 	// indicates the client wants to include bundle status in the results
 	// of the health API.
 	ParamPluginsV1 = "plugins"
+
+	// ParamSinceV1 defines the name of the HTTP URL parameter that specifies
+	// the sequence number of the last change the client has seen.
+	ParamSinceV1 = "since"
+
+	// ParamFeedV1 defines the name of the HTTP URL parameter that specifies
+	// the ID of the change feed the sequence number belongs to.
+	ParamFeedV1 = "feed"
+
+	// ParamWaitV1 defines the name of the HTTP URL parameter that specifies
+	// the number of seconds to wait for new changes.
+	ParamWaitV1 = "wait"
 )
 
 // BadRequestErr represents an error condition raised if the caller passes
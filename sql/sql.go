@@ -0,0 +1,500 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package sql translates the results of partial evaluation into SQL
+// predicates that can be used in WHERE clauses to filter rows.
+//
+// Each residual query becomes a conjunction of predicates and the queries are
+// combined with OR. References to unknowns are mapped to columns and constants
+// are passed as parameters. For example, with input.rows mapped to the table
+// rows, the residual queries
+//
+//	"alice" = input.rows.owner
+//
+//	input.rows.public
+//	startswith(input.rows.name, "pub_")
+//
+// are translated into the following Postgres predicate:
+//
+//	("rows"."owner" = $1) OR ("rows"."public" IS TRUE AND "rows"."name" LIKE $2 ESCAPE '!')
+//
+// with the parameters "alice" and "pub!_%".
+//
+// Negated expressions become "(...) IS NOT TRUE" so that rows where the
+// predicate is NULL are included, like in Rego where "not x == 1" is true if
+// x is null. String comparisons are case-sensitive in all dialects: SQLite
+// uses GLOB instead of LIKE and MySQL compares strings as binary strings.
+package sql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// Dialect defines the SQL dialect of the generated predicates.
+type Dialect string
+
+const (
+	// Postgres generates numbered parameters ($1, $2, ...) and double quoted
+	// identifiers.
+	Postgres Dialect = "postgres"
+
+	// MySQL generates ? parameters and backtick quoted identifiers. String
+	// parameters are cast to binary strings because the default collations
+	// compare strings case-insensitively.
+	MySQL Dialect = "mysql"
+
+	// SQLite generates ? parameters and double quoted identifiers. GLOB is
+	// used instead of LIKE because LIKE is case-insensitive in SQLite.
+	SQLite Dialect = "sqlite"
+)
+
+// Dialects returns the supported SQL dialects.
+func Dialects() []Dialect {
+	return []Dialect{Postgres, MySQL, SQLite}
+}
+
+func (d Dialect) valid() bool {
+	for _, x := range Dialects() {
+		if d == x {
+			return true
+		}
+	}
+	return false
+}
+
+// Placeholder returns the parameter placeholder for the i-th parameter. The
+// first parameter has index 1.
+func (d Dialect) Placeholder(i int) string {
+	if d == Postgres {
+		return fmt.Sprintf("$%d", i)
+	}
+	return "?"
+}
+
+// QuoteIdentifier quotes each dot separated part of the identifier, e.g.,
+// rows.owner becomes "rows"."owner" in Postgres.
+func (d Dialect) QuoteIdentifier(s string) string {
+	q := `"`
+	if d == MySQL {
+		q = "`"
+	}
+	parts := strings.Split(s, ".")
+	for i := range parts {
+		parts[i] = q + strings.Replace(parts[i], q, q+q, -1) + q
+	}
+	return strings.Join(parts, ".")
+}
+
+// likeEscape is the escape character of LIKE patterns. It is not a backslash
+// because backslashes are escape characters in MySQL string literals.
+const likeEscape = '!'
+
+// Predicate is a parameterized SQL predicate.
+type Predicate struct {
+	SQL  string        `json:"sql"`
+	Args []interface{} `json:"args"`
+}
+
+// Error is returned if an expression cannot be translated.
+type Error struct {
+	Expr    *ast.Expr
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Expr == nil {
+		return e.Message
+	}
+	msg := fmt.Sprintf("%v: %v", e.Message, e.Expr)
+	if loc := e.Expr.Location; loc != nil {
+		if len(loc.File) > 0 {
+			return fmt.Sprintf("%v:%v: %v", loc.File, loc.Row, msg)
+		}
+		return fmt.Sprintf("%v:%v: %v", loc.Row, loc.Col, msg)
+	}
+	return msg
+}
+
+// Translator translates residual queries into SQL predicates.
+type Translator struct {
+	dialect Dialect
+	columns []column
+}
+
+type column struct {
+	ref  ast.Ref
+	name string
+}
+
+// New returns a new Translator that generates Postgres predicates.
+func New() *Translator {
+	return &Translator{dialect: Postgres}
+}
+
+// WithDialect sets the SQL dialect of the generated predicates.
+func (t *Translator) WithDialect(d Dialect) *Translator {
+	t.dialect = d
+	return t
+}
+
+// WithColumn maps references with the prefix ref to the column name. If a
+// reference is longer than the prefix, the remaining path is appended to the
+// name, e.g., if input.rows is mapped to rows, input.rows.owner refers to the
+// column rows.owner. Variables in ref match any variable in the reference,
+// e.g., data.posts[_] matches data.posts[x].owner. The longest prefix that
+// matches a reference is used.
+func (t *Translator) WithColumn(ref ast.Ref, name string) *Translator {
+	t.columns = append(t.columns, column{ref: ref, name: name})
+	return t
+}
+
+// WithColumns parses the keys of the map as references and maps them to the
+// column names. See WithColumn for how references are mapped.
+func (t *Translator) WithColumns(columns map[string]string) (*Translator, error) {
+	for k, v := range columns {
+		ref, err := ast.ParseRef(k)
+		if err != nil {
+			return nil, err
+		}
+		t.WithColumn(ref, v)
+	}
+	return t, nil
+}
+
+// Translate translates the partial evaluation result into a predicate. The
+// result must not contain support modules.
+func (t *Translator) Translate(pq *rego.PartialQueries) (*Predicate, error) {
+	if len(pq.Support) > 0 {
+		return nil, &Error{Message: fmt.Sprintf("support modules cannot be translated (%v), disable shallow inlining or inline the rules in package %v", len(pq.Support), pq.Support[0].Package.Path)}
+	}
+	return t.TranslateQueries(pq.Queries)
+}
+
+// TranslateQueries translates the residual queries into a predicate. The
+// predicate is false if there are no queries and true if any query is empty.
+func (t *Translator) TranslateQueries(queries []ast.Body) (*Predicate, error) {
+
+	if !t.dialect.valid() {
+		return nil, fmt.Errorf("unknown SQL dialect: %v", t.dialect)
+	}
+
+	w := &writer{t: t, args: []interface{}{}}
+
+	if len(queries) == 0 {
+		w.WriteString("1 = 0")
+		return w.predicate(), nil
+	}
+
+	for _, body := range queries {
+		if len(body) == 0 {
+			return &Predicate{SQL: "1 = 1", Args: []interface{}{}}, nil
+		}
+	}
+
+	for i, body := range queries {
+		if i > 0 {
+			w.WriteString(" OR ")
+		}
+		if len(queries) > 1 {
+			w.WriteString("(")
+		}
+		for j, expr := range body {
+			if j > 0 {
+				w.WriteString(" AND ")
+			}
+			if err := w.expr(expr); err != nil {
+				return nil, err
+			}
+		}
+		if len(queries) > 1 {
+			w.WriteString(")")
+		}
+	}
+
+	return w.predicate(), nil
+}
+
+// column returns the column that the reference refers to.
+func (t *Translator) column(ref ast.Ref) (string, bool) {
+
+	var match *column
+
+	for i := range t.columns {
+		c := &t.columns[i]
+		if len(c.ref) > len(ref) || (match != nil && len(match.ref) >= len(c.ref)) {
+			continue
+		}
+		if refPrefixMatches(c.ref, ref) {
+			match = c
+		}
+	}
+
+	if match == nil {
+		return "", false
+	}
+
+	name := match.name
+
+	for _, x := range ref[len(match.ref):] {
+		s, ok := x.Value.(ast.String)
+		if !ok {
+			return "", false
+		}
+		name += "." + string(s)
+	}
+
+	return name, true
+}
+
+func refPrefixMatches(prefix, ref ast.Ref) bool {
+	for i := range prefix {
+		if _, ok := prefix[i].Value.(ast.Var); ok && i > 0 {
+			if _, ok := ref[i].Value.(ast.Var); !ok {
+				return false
+			}
+		} else if !prefix[i].Equal(ref[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+type writer struct {
+	strings.Builder
+	t    *Translator
+	args []interface{}
+}
+
+func (w *writer) predicate() *Predicate {
+	return &Predicate{SQL: w.String(), Args: w.args}
+}
+
+func (w *writer) param(v interface{}) {
+	w.args = append(w.args, v)
+	placeholder := w.t.dialect.Placeholder(len(w.args))
+	if _, ok := v.(string); ok && w.t.dialect == MySQL {
+		placeholder = "CAST(" + placeholder + " AS BINARY)"
+	}
+	w.WriteString(placeholder)
+}
+
+var comparisons = map[string]string{
+	ast.Equality.Name:      "=",
+	ast.Equal.Name:         "=",
+	ast.NotEqual.Name:      "<>",
+	ast.LessThan.Name:      "<",
+	ast.LessThanEq.Name:    "<=",
+	ast.GreaterThan.Name:   ">",
+	ast.GreaterThanEq.Name: ">=",
+}
+
+// flipped contains the operators to use when the operands are swapped.
+var flipped = map[string]string{
+	"=":  "=",
+	"<>": "<>",
+	"<":  ">",
+	"<=": ">=",
+	">":  "<",
+	">=": "<=",
+}
+
+// pattern contains the LIKE and GLOB patterns of a string function.
+type pattern struct {
+	like string
+	glob string
+}
+
+var patterns = map[string]pattern{
+	ast.StartsWith.Name: {like: "%s%%", glob: "%s*"},
+	ast.EndsWith.Name:   {like: "%%%s", glob: "*%s"},
+	ast.Contains.Name:   {like: "%%%s%%", glob: "*%s*"},
+}
+
+func (w *writer) expr(expr *ast.Expr) error {
+
+	if len(expr.With) > 0 {
+		return &Error{Expr: expr, Message: "with modifiers cannot be translated"}
+	}
+
+	switch terms := expr.Terms.(type) {
+	case *ast.Term:
+		ref, ok := terms.Value.(ast.Ref)
+		if !ok {
+			return &Error{Expr: expr, Message: "expression cannot be translated"}
+		}
+		name, ok := w.t.column(ref)
+		if !ok {
+			return &Error{Expr: expr, Message: fmt.Sprintf("reference %v is not mapped to a column", ref)}
+		}
+		w.WriteString(w.t.dialect.QuoteIdentifier(name))
+		if expr.Negated {
+			w.WriteString(" IS NOT TRUE")
+		} else {
+			w.WriteString(" IS TRUE")
+		}
+		return nil
+	case []*ast.Term:
+		if expr.Negated {
+			// NOT (...) is NULL if the operands are NULL and would exclude
+			// the row.
+			w.WriteString("(")
+			defer w.WriteString(") IS NOT TRUE")
+		}
+		name := expr.Operator().String()
+		if op, ok := comparisons[name]; ok {
+			return w.comparison(expr, op)
+		}
+		if pattern, ok := patterns[name]; ok {
+			return w.like(expr, pattern)
+		}
+		return &Error{Expr: expr, Message: fmt.Sprintf("function %v cannot be translated", name)}
+	}
+
+	return &Error{Expr: expr, Message: "expression cannot be translated"}
+}
+
+// operand is a column name or a parameter value.
+type operand struct {
+	column string
+	value  interface{}
+}
+
+func (w *writer) operand(expr *ast.Expr, term *ast.Term) (operand, error) {
+	switch v := term.Value.(type) {
+	case ast.Ref:
+		name, ok := w.t.column(v)
+		if !ok {
+			return operand{}, &Error{Expr: expr, Message: fmt.Sprintf("reference %v is not mapped to a column", v)}
+		}
+		return operand{column: name}, nil
+	case ast.Null:
+		return operand{}, nil
+	case ast.Boolean:
+		return operand{value: bool(v)}, nil
+	case ast.String:
+		return operand{value: string(v)}, nil
+	case ast.Number:
+		if i, ok := v.Int64(); ok {
+			return operand{value: i}, nil
+		}
+		f, ok := v.Float64()
+		if !ok {
+			return operand{}, &Error{Expr: expr, Message: fmt.Sprintf("number %v cannot be translated", v)}
+		}
+		return operand{value: f}, nil
+	case ast.Var:
+		return operand{}, &Error{Expr: expr, Message: fmt.Sprintf("variable %v cannot be translated", v)}
+	}
+	return operand{}, &Error{Expr: expr, Message: fmt.Sprintf("%v %v cannot be translated", ast.TypeName(term.Value), term)}
+}
+
+func (w *writer) comparison(expr *ast.Expr, op string) error {
+
+	operands := expr.Operands()
+	if len(operands) != 2 {
+		return &Error{Expr: expr, Message: "expression cannot be translated"}
+	}
+
+	a, err := w.operand(expr, operands[0])
+	if err != nil {
+		return err
+	}
+
+	b, err := w.operand(expr, operands[1])
+	if err != nil {
+		return err
+	}
+
+	// Keep columns on the left hand side.
+	if a.column == "" && b.column != "" {
+		a, b = b, a
+		op = flipped[op]
+	}
+
+	if a.column == "" {
+		return &Error{Expr: expr, Message: "expression does not refer to a column"}
+	}
+
+	w.WriteString(w.t.dialect.QuoteIdentifier(a.column))
+
+	switch {
+	case b.column != "":
+		w.WriteString(" " + op + " ")
+		w.WriteString(w.t.dialect.QuoteIdentifier(b.column))
+	case b.value == nil && op == "=":
+		w.WriteString(" IS NULL")
+	case b.value == nil && op == "<>":
+		w.WriteString(" IS NOT NULL")
+	case b.value == nil:
+		return &Error{Expr: expr, Message: "null can only be compared with = or !="}
+	default:
+		w.WriteString(" " + op + " ")
+		w.param(b.value)
+	}
+
+	return nil
+}
+
+func (w *writer) like(expr *ast.Expr, pattern pattern) error {
+
+	operands := expr.Operands()
+	if len(operands) != 2 {
+		return &Error{Expr: expr, Message: "expression cannot be translated"}
+	}
+
+	a, err := w.operand(expr, operands[0])
+	if err != nil {
+		return err
+	}
+
+	s, ok := operands[1].Value.(ast.String)
+	if a.column == "" || !ok {
+		return &Error{Expr: expr, Message: fmt.Sprintf("%v requires a column and a string", expr.Operator())}
+	}
+
+	w.WriteString(w.t.dialect.QuoteIdentifier(a.column))
+
+	if w.t.dialect == SQLite {
+		w.WriteString(" GLOB ")
+		w.param(fmt.Sprintf(pattern.glob, escapeGlob(string(s))))
+		return nil
+	}
+
+	w.WriteString(" LIKE ")
+	w.param(fmt.Sprintf(pattern.like, escapeLike(string(s))))
+	w.WriteString(fmt.Sprintf(" ESCAPE '%c'", likeEscape))
+
+	return nil
+}
+
+func escapeLike(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if r == '%' || r == '_' || r == likeEscape {
+			sb.WriteRune(likeEscape)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// escapeGlob escapes the wildcards of GLOB patterns by enclosing them in
+// brackets. GLOB does not support escape characters.
+func escapeGlob(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if r == '*' || r == '?' || r == '[' {
+			sb.WriteRune('[')
+			sb.WriteRune(r)
+			sb.WriteRune(']')
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,269 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package sql
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+func TestTranslateQueries(t *testing.T) {
+
+	tests := []struct {
+		note     string
+		dialect  Dialect
+		queries  []string
+		sql      string
+		args     []interface{}
+		expError string
+	}{
+		{
+			note:    "no queries",
+			queries: []string{},
+			sql:     "1 = 0",
+			args:    []interface{}{},
+		},
+		{
+			note:    "empty query",
+			queries: []string{`input.rows.owner = "alice"`, `true`},
+			sql:     "1 = 1",
+			args:    []interface{}{},
+		},
+		{
+			note:    "comparisons",
+			queries: []string{`"alice" = input.rows.owner; input.rows.size < 10; 1.5 <= input.rows.size; input.rows.owner != input.rows.author`},
+			sql:     `"rows"."owner" = $1 AND "rows"."size" < $2 AND "rows"."size" >= $3 AND "rows"."owner" <> "rows"."author"`,
+			args:    []interface{}{"alice", int64(10), 1.5},
+		},
+		{
+			note:    "disjunction",
+			queries: []string{`input.rows.owner == "alice"`, `input.rows.public; not input.rows.deleted`},
+			sql:     `("rows"."owner" = $1) OR ("rows"."public" IS TRUE AND "rows"."deleted" IS NOT TRUE)`,
+			args:    []interface{}{"alice"},
+		},
+		{
+			// The negated comparison must be true if the column is NULL.
+			note:    "negation",
+			queries: []string{`not input.rows.owner == "alice"; not startswith(input.rows.name, "x")`},
+			sql:     `("rows"."owner" = $1) IS NOT TRUE AND ("rows"."name" LIKE $2 ESCAPE '!') IS NOT TRUE`,
+			args:    []interface{}{"alice", "x%"},
+		},
+		{
+			note:    "null",
+			queries: []string{`input.rows.owner != null; null = input.rows.deleted`},
+			sql:     `"rows"."owner" IS NOT NULL AND "rows"."deleted" IS NULL`,
+			args:    []interface{}{},
+		},
+		{
+			note:    "like",
+			queries: []string{`startswith(input.rows.name, "pub_"); endswith(input.rows.name, "100%"); contains(input.rows.name, "!")`},
+			sql:     `"rows"."name" LIKE $1 ESCAPE '!' AND "rows"."name" LIKE $2 ESCAPE '!' AND "rows"."name" LIKE $3 ESCAPE '!'`,
+			args:    []interface{}{"pub!_%", "%100!%", "%!!%"},
+		},
+		{
+			note:    "mysql",
+			dialect: MySQL,
+			queries: []string{`input.rows.owner = "alice"; input.rows.public = true`},
+			sql:     "`rows`.`owner` = CAST(? AS BINARY) AND `rows`.`public` = ?",
+			args:    []interface{}{"alice", true},
+		},
+		{
+			note:    "mysql like",
+			dialect: MySQL,
+			queries: []string{`startswith(input.rows.name, "pub_"); not contains(input.rows.name, "x")`},
+			sql:     "`rows`.`name` LIKE CAST(? AS BINARY) ESCAPE '!' AND (`rows`.`name` LIKE CAST(? AS BINARY) ESCAPE '!') IS NOT TRUE",
+			args:    []interface{}{"pub!_%", "%x%"},
+		},
+		{
+			note:    "sqlite",
+			dialect: SQLite,
+			queries: []string{`input.rows.owner = "alice"`},
+			sql:     `"rows"."owner" = ?`,
+			args:    []interface{}{"alice"},
+		},
+		{
+			note:    "sqlite glob",
+			dialect: SQLite,
+			queries: []string{`startswith(input.rows.name, "pub_"); endswith(input.rows.name, "*?"); contains(input.rows.name, "[a]%")`},
+			sql:     `"rows"."name" GLOB ? AND "rows"."name" GLOB ? AND "rows"."name" GLOB ?`,
+			args:    []interface{}{"pub_*", "*[*][?]", "*[[]a]%*"},
+		},
+		{
+			note:    "iteration",
+			queries: []string{`data.posts[x].owner = "alice"; data.posts[x].meta.draft = false`},
+			sql:     `"posts"."owner" = $1 AND "posts"."meta"."draft" = $2`,
+			args:    []interface{}{"alice", false},
+		},
+		{
+			note:     "unmapped reference",
+			queries:  []string{`input.user.name = "alice"`},
+			expError: `1:1: reference input.user.name is not mapped to a column: input.user.name = "alice"`,
+		},
+		{
+			note:     "iteration in column",
+			queries:  []string{`input.rows.tags[_] = "x"`},
+			expError: `reference input.rows.tags[_] is not mapped to a column`,
+		},
+		{
+			note:     "unsupported function",
+			queries:  []string{`re_match("^a", input.rows.name)`},
+			expError: `function re_match cannot be translated`,
+		},
+		{
+			note:     "unsupported operand",
+			queries:  []string{`count(input.rows.tags) > 1`},
+			expError: `call count(input.rows.tags) cannot be translated`,
+		},
+		{
+			note:     "unsupported value",
+			queries:  []string{`input.rows.tags = ["x"]`},
+			expError: `array ["x"] cannot be translated`,
+		},
+		{
+			note:     "variable",
+			queries:  []string{`input.rows.owner = x`},
+			expError: `variable x cannot be translated`,
+		},
+		{
+			note:     "no column",
+			queries:  []string{`1 = 1`},
+			expError: `expression does not refer to a column`,
+		},
+		{
+			note:     "with",
+			queries:  []string{`input.rows.public with input.rows.public as true`},
+			expError: `with modifiers cannot be translated`,
+		},
+		{
+			note:     "like without column",
+			queries:  []string{`startswith("abc", input.rows.name)`},
+			expError: `startswith requires a column and a string`,
+		},
+		{
+			note:     "unknown dialect",
+			dialect:  Dialect("oracle"),
+			queries:  []string{`input.rows.public`},
+			expError: `unknown SQL dialect: oracle`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+
+			var queries []ast.Body
+			for _, q := range tc.queries {
+				body := ast.MustParseBody(q)
+				if q == "true" {
+					body = ast.Body{}
+				}
+				queries = append(queries, body)
+			}
+
+			translator, err := New().WithColumns(map[string]string{
+				"input.rows":    "rows",
+				"data.posts[_]": "posts",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.dialect != "" {
+				translator.WithDialect(tc.dialect)
+			}
+
+			pred, err := translator.TranslateQueries(queries)
+
+			if tc.expError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expError) {
+					t.Fatalf("Expected error containing %q but got %v", tc.expError, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if pred.SQL != tc.sql {
+				t.Errorf("Expected SQL:\n\n%v\n\nGot:\n\n%v", tc.sql, pred.SQL)
+			}
+
+			if !reflect.DeepEqual(pred.Args, tc.args) {
+				t.Errorf("Expected args %#v but got %#v", tc.args, pred.Args)
+			}
+		})
+	}
+}
+
+func TestTranslateLongestPrefix(t *testing.T) {
+
+	translator := New().
+		WithColumn(ast.MustParseRef("input.rows"), "rows").
+		WithColumn(ast.MustParseRef("input.rows.owner.name"), "owners.name")
+
+	pred, err := translator.TranslateQueries([]ast.Body{ast.MustParseBody(`input.rows.owner.name = "alice"; input.rows.owner.id = 1`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `"owners"."name" = $1 AND "rows"."owner"."id" = $2`
+	if pred.SQL != exp {
+		t.Fatalf("Expected %v but got %v", exp, pred.SQL)
+	}
+}
+
+func TestTranslatePartialResult(t *testing.T) {
+
+	ctx := context.Background()
+
+	pq, err := rego.New(
+		rego.Query("data.filters.allow == true"),
+		rego.Module("filters.rego", `package filters
+
+allow {
+	input.rows.owner == input.user
+}
+
+allow {
+	input.rows.public
+	startswith(input.rows.name, "pub_")
+}`),
+		rego.Input(map[string]interface{}{"user": "alice"}),
+		rego.Unknowns([]string{"input.rows"}),
+	).Partial(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pred, err := New().WithColumn(ast.MustParseRef("input.rows"), "rows").Translate(pq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `("rows"."owner" = $1) OR ("rows"."public" IS TRUE AND "rows"."name" LIKE $2 ESCAPE '!')`
+	if pred.SQL != exp {
+		t.Fatalf("Expected %v but got %v", exp, pred.SQL)
+	}
+
+	if !reflect.DeepEqual(pred.Args, []interface{}{"alice", "pub!_%"}) {
+		t.Fatalf("Unexpected args: %v", pred.Args)
+	}
+}
+
+func TestTranslateSupportModules(t *testing.T) {
+
+	pq := &rego.PartialQueries{
+		Queries: []ast.Body{ast.MustParseBody("data.partial.p")},
+		Support: []*ast.Module{ast.MustParseModule("package partial\np { input.rows.x }")},
+	}
+
+	_, err := New().Translate(pq)
+	if err == nil || !strings.Contains(err.Error(), "support modules cannot be translated") {
+		t.Fatalf("Expected error but got %v", err)
+	}
+}
@@ -0,0 +1,375 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// Mutant represents a single modification of a module under test.
+type Mutant struct {
+	Location    *ast.Location `json:"location"`
+	Description string        `json:"description"`
+	Killed      bool          `json:"killed"`
+	KilledBy    string        `json:"killed_by,omitempty"`
+}
+
+func (m *Mutant) String() string {
+	return fmt.Sprintf("%v: %v", m.Location, m.Description)
+}
+
+// MutationReport contains the outcome of mutation testing. Mutants that do not
+// compile are counted as invalid and excluded from the score.
+type MutationReport struct {
+	Mutants  []*Mutant `json:"mutants"`
+	Killed   int       `json:"killed"`
+	Survived int       `json:"survived"`
+	Invalid  int       `json:"invalid"`
+	Score    float64   `json:"score"`
+}
+
+// Surviving returns the mutants that were not detected by any test.
+func (r *MutationReport) Surviving() []*Mutant {
+	var result []*Mutant
+	for _, m := range r.Mutants {
+		if !m.Killed {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// MutationScoreError represents an error raised when the mutation score is
+// lower than the specified threshold.
+type MutationScoreError struct {
+	Score     float64
+	Threshold float64
+}
+
+func (e *MutationScoreError) Error() string {
+	return fmt.Sprintf("Mutation score threshold not met: got %.2f instead of %.2f", e.Score, e.Threshold)
+}
+
+// RunMutations runs the tests against mutants of the modules loaded on the
+// runner. Mutants are generated from modules that do not contain tests by
+// flipping comparison operators, negating expressions, removing expressions
+// from rule bodies and replacing constants. A mutant is killed if at least one
+// test fails or encounters an error when evaluated against it. The tests must
+// pass against the unmodified modules.
+//
+// The modules loaded on the runner are not compiled by RunMutations. Bundles
+// are not supported.
+func (r *Runner) RunMutations(ctx context.Context, txn storage.Transaction) (*MutationReport, error) {
+
+	if len(r.bundles) > 0 {
+		return nil, errors.New("mutation testing is not supported with bundles")
+	}
+
+	originals := copyModules(r.modules)
+
+	filenames := make([]string, 0, len(originals))
+	for name := range originals {
+		filenames = append(filenames, name)
+	}
+
+	sort.Strings(filenames)
+
+	baseline, err := r.runMutant(ctx, txn, copyModules(originals), nil)
+	if err != nil {
+		return nil, err
+	} else if baseline != nil {
+		return nil, fmt.Errorf("tests must pass before mutation testing: %v", baseline)
+	}
+
+	report := &MutationReport{}
+
+	for _, name := range filenames {
+		module := originals[name]
+		if isTestModule(module) {
+			continue
+		}
+		for i := 0; ; i++ {
+			mutated := module.Copy()
+			mutant := mutateModule(mutated, i)
+			if mutant == nil {
+				break
+			}
+
+			modules := copyModules(originals)
+			modules[name] = mutated
+
+			killedBy, err := r.runMutant(ctx, txn, modules, []ast.Ref{module.Package.Path})
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				report.Invalid++
+				continue
+			}
+
+			if killedBy != nil {
+				mutant.Killed = true
+				mutant.KilledBy = fmt.Sprintf("%v.%v", killedBy.Package, killedBy.Name)
+				report.Killed++
+			} else {
+				report.Survived++
+			}
+
+			report.Mutants = append(report.Mutants, mutant)
+		}
+	}
+
+	if total := report.Killed + report.Survived; total > 0 {
+		report.Score = math.Round(10000*float64(report.Killed)/float64(total)) / 100
+	}
+
+	return report, nil
+}
+
+// runMutant runs the tests affected by pkgs against modules and returns the
+// first test that did not pass. An error is returned if the modules cannot be
+// compiled.
+func (r *Runner) runMutant(ctx context.Context, txn storage.Transaction, modules map[string]*ast.Module, pkgs []ast.Ref) (*Result, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	runner := NewRunner().
+		SetStore(r.store).
+		SetRuntime(r.runtime).
+		SetTimeout(r.timeout).
+		SetModules(modules).
+		SetAffectedPackages(pkgs).
+		Filter(r.filter).
+		Parallel(r.parallel)
+
+	ch, err := runner.RunTests(ctx, txn)
+	if err != nil {
+		return nil, err
+	}
+
+	var failed *Result
+
+	for tr := range ch {
+		if failed == nil && !tr.Pass() && !tr.Skip {
+			failed = tr
+			// The remaining tests are canceled; their results are discarded.
+			cancel()
+		}
+	}
+
+	return failed, nil
+}
+
+func copyModules(modules map[string]*ast.Module) map[string]*ast.Module {
+	result := make(map[string]*ast.Module, len(modules))
+	for name, module := range modules {
+		result[name] = module.Copy()
+	}
+	return result
+}
+
+func isTestModule(module *ast.Module) bool {
+	for _, rule := range module.Rules {
+		name := string(rule.Head.Name)
+		if strings.HasPrefix(name, TestPrefix) || strings.HasPrefix(name, SkipTestPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var flippedComparisons = map[string]*ast.Builtin{
+	ast.Equal.Name:         ast.NotEqual,
+	ast.NotEqual.Name:      ast.Equal,
+	ast.LessThan.Name:      ast.LessThanEq,
+	ast.LessThanEq.Name:    ast.LessThan,
+	ast.GreaterThan.Name:   ast.GreaterThanEq,
+	ast.GreaterThanEq.Name: ast.GreaterThan,
+}
+
+// mutateModule applies the n-th mutation of module in place. If module has n
+// or fewer mutation sites, nil is returned.
+func mutateModule(module *ast.Module, n int) *Mutant {
+	m := &mutator{target: n}
+	for _, rule := range module.Rules {
+		for ; rule != nil; rule = rule.Else {
+			if m.mutateRule(rule) {
+				return m.result
+			}
+		}
+	}
+	return nil
+}
+
+// mutator enumerates the mutation sites of a module in a deterministic order
+// and applies the mutation at the target site.
+type mutator struct {
+	target int
+	count  int
+	result *Mutant
+}
+
+func (m *mutator) site(loc *ast.Location, desc string, apply func()) bool {
+	if m.count == m.target {
+		apply()
+		m.result = &Mutant{Location: loc, Description: desc}
+		return true
+	}
+	m.count++
+	return false
+}
+
+func (m *mutator) mutateRule(rule *ast.Rule) bool {
+
+	if rule.Head.Value != nil && !isImplicitTrue(rule.Head.Value) {
+		if m.mutateConstants(rule.Head.Value) {
+			return true
+		}
+	}
+
+	// The bodies of default rules and rules without a body (e.g., "p = 1")
+	// are generated by the parser.
+	if len(rule.Body) == 1 && isImplicitTrue(rule.Body[0]) {
+		return false
+	}
+
+	for i := range rule.Body {
+		if m.mutateExpr(rule, i) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isImplicitTrue returns true if x is a true value that was generated by the
+// parser, e.g., the value of "p { ... }" or the body of "p = 1".
+func isImplicitTrue(x interface{}) bool {
+	var term *ast.Term
+	switch x := x.(type) {
+	case *ast.Term:
+		term = x
+	case *ast.Expr:
+		t, ok := x.Terms.(*ast.Term)
+		if !ok || x.Negated || len(x.With) > 0 {
+			return false
+		}
+		term = t
+	}
+	if !ast.Boolean(true).Equal(term.Value) {
+		return false
+	}
+	return term.Location == nil || string(term.Location.Text) != "true"
+}
+
+func (m *mutator) mutateExpr(rule *ast.Rule, i int) bool {
+
+	expr := rule.Body[i]
+
+	if _, ok := expr.Terms.(*ast.SomeDecl); ok {
+		return false
+	}
+
+	if expr.IsCall() {
+		if flipped, ok := flippedComparisons[expr.Operator().String()]; ok {
+			desc := fmt.Sprintf("replace %v with %v", ast.BuiltinMap[expr.Operator().String()].Infix, flipped.Infix)
+			if m.site(expr.Location, desc, func() { expr.SetOperator(ast.NewTerm(flipped.Ref())) }) {
+				return true
+			}
+		}
+	}
+
+	if !expr.IsAssignment() {
+		desc := "negate expression"
+		if expr.Negated {
+			desc = "remove negation"
+		}
+		if m.site(expr.Location, desc, func() { expr.Negated = !expr.Negated }) {
+			return true
+		}
+	}
+
+	if len(rule.Body) > 1 {
+		if m.site(expr.Location, "remove expression", func() {
+			rule.Body = append(rule.Body[:i:i], rule.Body[i+1:]...)
+			for j := range rule.Body {
+				rule.Body[j].Index = j
+			}
+		}) {
+			return true
+		}
+	}
+
+	switch terms := expr.Terms.(type) {
+	case *ast.Term:
+		return m.mutateConstants(terms)
+	case []*ast.Term:
+		for _, term := range terms[1:] {
+			if m.mutateConstants(term) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// mutateConstants replaces scalar constants contained in term. References are
+// not mutated.
+func (m *mutator) mutateConstants(term *ast.Term) bool {
+	switch v := term.Value.(type) {
+	case ast.Boolean:
+		replacement := !v
+		return m.replaceConstant(term, ast.Boolean(replacement))
+	case ast.Number:
+		if i, ok := v.Int64(); ok {
+			return m.replaceConstant(term, ast.IntNumberTerm(int(i+1)).Value)
+		}
+		if f, ok := v.Float64(); ok {
+			return m.replaceConstant(term, ast.FloatNumberTerm(f+1).Value)
+		}
+	case ast.String:
+		replacement := ""
+		if v == "" {
+			replacement = "mutated"
+		}
+		return m.replaceConstant(term, ast.String(replacement))
+	case ast.Array:
+		for _, elem := range v {
+			if m.mutateConstants(elem) {
+				return true
+			}
+		}
+	case ast.Object:
+		stop := false
+		v.Foreach(func(_, value *ast.Term) {
+			if !stop {
+				stop = m.mutateConstants(value)
+			}
+		})
+		return stop
+	case ast.Call:
+		for _, operand := range v[1:] {
+			if m.mutateConstants(operand) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *mutator) replaceConstant(term *ast.Term, value ast.Value) bool {
+	desc := fmt.Sprintf("replace %v with %v", term.Value, value)
+	return m.site(term.Location, desc, func() { term.Value = value })
+}
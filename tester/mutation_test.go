@@ -0,0 +1,149 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestMutateModule(t *testing.T) {
+
+	module := ast.MustParseModule(`package a
+
+default allow = false
+
+allow {
+	input.x >= 1
+	not input.y
+	input.role == "admin"
+}
+
+limit = 10
+
+roles := ["admin", 7]
+`)
+
+	exp := []string{
+		"replace false with true",
+		"replace >= with >",
+		"negate expression",
+		"remove expression",
+		"replace 1 with 2",
+		"remove negation",
+		"remove expression",
+		"replace == with !=",
+		"negate expression",
+		"remove expression",
+		`replace "admin" with ""`,
+		"replace 10 with 11",
+		`replace "admin" with ""`,
+		"replace 7 with 8",
+	}
+
+	var result []string
+
+	for i := 0; ; i++ {
+		mutated := module.Copy()
+		mutant := mutateModule(mutated, i)
+		if mutant == nil {
+			break
+		}
+		if mutated.Equal(module) {
+			t.Errorf("Expected mutant %d (%v) to differ from module", i, mutant)
+		}
+		result = append(result, mutant.Description)
+	}
+
+	if !reflect.DeepEqual(result, exp) {
+		t.Fatalf("Expected mutants:\n\n%v\n\nGot:\n\n%v", exp, result)
+	}
+}
+
+func TestRunMutations(t *testing.T) {
+
+	files := map[string]string{
+		"/a.rego": `package a
+
+allow {
+	input.x >= 1
+	input.role == "admin"
+}
+`,
+		"/a_test.rego": `package a
+
+test_allow { allow with input as {"x": 1, "role": "admin"} }
+test_not_allow { not allow with input as {"x": 0, "role": "admin"} }
+`,
+	}
+
+	test.WithTempFS(files, func(d string) {
+		ctx := context.Background()
+
+		modules, store, err := Load([]string{d}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		txn := storage.NewTransactionOrDie(ctx, store)
+		defer store.Abort(ctx, txn)
+
+		report, err := NewRunner().SetStore(store).SetModules(modules).RunMutations(ctx, txn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Removing the role check is the only mutant that no test detects.
+		if report.Killed != 7 || report.Survived != 1 || report.Invalid != 0 {
+			t.Fatalf("Unexpected report: %+v", report)
+		}
+
+		surviving := report.Surviving()
+		if len(surviving) != 1 || surviving[0].Description != "remove expression" || surviving[0].Location.Row != 5 {
+			t.Fatalf("Unexpected surviving mutants: %v", surviving)
+		}
+
+		if report.Score != 87.5 {
+			t.Fatalf("Expected score 87.5 but got %v", report.Score)
+		}
+
+		for _, m := range report.Mutants {
+			if m.Killed && m.KilledBy == "" {
+				t.Fatalf("Expected killing test for %v", m)
+			}
+		}
+	})
+}
+
+func TestRunMutationsFailingBaseline(t *testing.T) {
+
+	files := map[string]string{
+		"/a.rego": `package a
+allow { false }`,
+		"/a_test.rego": `package a
+test_allow { allow }`,
+	}
+
+	test.WithTempFS(files, func(d string) {
+		ctx := context.Background()
+
+		modules, store, err := Load([]string{d}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		txn := storage.NewTransactionOrDie(ctx, store)
+		defer store.Abort(ctx, txn)
+
+		if _, err := NewRunner().SetStore(store).SetModules(modules).RunMutations(ctx, txn); err == nil {
+			t.Fatal("Expected error")
+		}
+	})
+}
@@ -5,11 +5,15 @@
 package tester
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/open-policy-agent/opa/topdown"
 
@@ -38,12 +42,14 @@ type PrettyReporter struct {
 func (r PrettyReporter) Report(ch chan *Result) error {
 
 	dirty := false
-	var pass, fail, errs int
+	var pass, fail, skip, errs int
 
 	var results, failures []*Result
 	for tr := range ch {
 		if tr.Pass() {
 			pass++
+		} else if tr.Skip {
+			skip++
 		} else if tr.Error != nil {
 			errs++
 		} else if tr.Fail {
@@ -73,7 +79,7 @@ func (r PrettyReporter) Report(ch chan *Result) error {
 		if tr.Pass() && r.BenchmarkResults {
 			dirty = true
 			fmt.Fprintln(r.Output, r.fmtBenchmark(tr))
-		} else if r.Verbose {
+		} else if r.Verbose || tr.Skip {
 			dirty = true
 			fmt.Fprintln(r.Output, tr)
 		} else if !tr.Pass() {
@@ -98,7 +104,7 @@ func (r PrettyReporter) Report(ch chan *Result) error {
 		r.hl()
 	}
 
-	total := pass + fail + errs
+	total := pass + fail + skip + errs
 
 	if pass != 0 {
 		fmt.Fprintln(r.Output, "PASS:", fmt.Sprintf("%d/%d", pass, total))
@@ -108,6 +114,10 @@ func (r PrettyReporter) Report(ch chan *Result) error {
 		fmt.Fprintln(r.Output, "FAIL:", fmt.Sprintf("%d/%d", fail, total))
 	}
 
+	if skip != 0 {
+		fmt.Fprintln(r.Output, "SKIPPED:", fmt.Sprintf("%d/%d", skip, total))
+	}
+
 	if errs != 0 {
 		fmt.Fprintln(r.Output, "ERROR:", fmt.Sprintf("%d/%d", errs, total))
 	}
@@ -177,26 +187,296 @@ type JSONCoverageReporter struct {
 // Report prints the test report to the reporter's output. If any tests fail or
 // encounter errors, this function returns an error.
 func (r JSONCoverageReporter) Report(ch chan *Result) error {
+	report, err := coverageReport(ch, r.Cover, r.Modules)
+	if err != nil {
+		return err
+	}
+
+	if err := report.CheckThreshold(r.Threshold); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(r.Output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// LCOVCoverageReporter reports coverage in the LCOV tracefile format.
+type LCOVCoverageReporter struct {
+	Cover     *cover.Cover
+	Modules   map[string]*ast.Module
+	Output    io.Writer
+	Threshold float64
+}
+
+// Report prints the coverage report to the reporter's output. If any tests
+// fail or encounter errors, this function returns an error. If the coverage
+// is lower than the threshold, the report is printed and a
+// cover.CoverageThresholdError is returned.
+func (r LCOVCoverageReporter) Report(ch chan *Result) error {
+	report, err := coverageReport(ch, r.Cover, r.Modules)
+	if err != nil {
+		return err
+	}
+
+	if err := report.WriteLCOV(r.Output); err != nil {
+		return err
+	}
+
+	return report.CheckThreshold(r.Threshold)
+}
+
+// CoberturaCoverageReporter reports coverage in the Cobertura XML format.
+type CoberturaCoverageReporter struct {
+	Cover     *cover.Cover
+	Modules   map[string]*ast.Module
+	Output    io.Writer
+	Threshold float64
+}
+
+// Report prints the coverage report to the reporter's output. If any tests
+// fail or encounter errors, this function returns an error. If the coverage
+// is lower than the threshold, the report is printed and a
+// cover.CoverageThresholdError is returned.
+func (r CoberturaCoverageReporter) Report(ch chan *Result) error {
+	report, err := coverageReport(ch, r.Cover, r.Modules)
+	if err != nil {
+		return err
+	}
+
+	if err := report.WriteCobertura(r.Output); err != nil {
+		return err
+	}
+
+	return report.CheckThreshold(r.Threshold)
+}
+
+func coverageReport(ch chan *Result, c *cover.Cover, modules map[string]*ast.Module) (cover.Report, error) {
 	for tr := range ch {
+		if tr.Skip {
+			continue
+		}
 		if !tr.Pass() {
 			if tr.Error != nil {
-				return tr.Error
+				return cover.Report{}, tr.Error
+			}
+			return cover.Report{}, errors.New(tr.String())
+		}
+	}
+	return c.Report(modules), nil
+}
+
+// JUnitReporter reports test results in the JUnit XML format. Tests are
+// grouped into test suites by package.
+type JUnitReporter struct {
+	Output io.Writer
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name          `xml:"testsuites"`
+	Tests      int               `xml:"tests,attr"`
+	Failures   int               `xml:"failures,attr"`
+	Errors     int               `xml:"errors,attr"`
+	Skipped    int               `xml:"skipped,attr"`
+	Time       string            `xml:"time,attr"`
+	TestSuites []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	Time      string           `xml:"time,attr"`
+	TestCases []*junitTestCase `xml:"testcase"`
+	duration  time.Duration
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Content string `xml:",chardata"`
+}
+
+type junitSkipped struct{}
+
+// Report prints the test report to the reporter's output.
+func (r JUnitReporter) Report(ch chan *Result) error {
+
+	report := junitTestSuites{}
+	suites := map[string]*junitTestSuite{}
+	var total time.Duration
+
+	for tr := range ch {
+
+		suite, ok := suites[tr.Package]
+		if !ok {
+			suite = &junitTestSuite{Name: tr.Package}
+			suites[tr.Package] = suite
+			report.TestSuites = append(report.TestSuites, suite)
+		}
+
+		tc := &junitTestCase{
+			Name:      tr.Name,
+			Classname: tr.Package,
+			Time:      junitTime(tr.Duration),
+		}
+
+		if tr.Location != nil {
+			tc.File = tr.Location.File
+			tc.Line = tr.Location.Row
+		}
+
+		if tr.Skip {
+			tc.Skipped = &junitSkipped{}
+			suite.Skipped++
+			report.Skipped++
+		} else if tr.Error != nil {
+			tc.Error = &junitMessage{
+				Message: tr.Error.Error(),
+				Type:    "error",
+			}
+			suite.Errors++
+			report.Errors++
+		} else if tr.Fail {
+			tc.Failure = &junitMessage{
+				Message: failureMessage(tr),
+				Type:    "failure",
+				Content: failureDetails(tr),
 			}
-			return errors.New(tr.String())
+			suite.Failures++
+			report.Failures++
+		}
+
+		suite.Tests++
+		suite.duration += tr.Duration
+		suite.TestCases = append(suite.TestCases, tc)
+		report.Tests++
+		total += tr.Duration
+	}
+
+	for _, suite := range report.TestSuites {
+		suite.Time = junitTime(suite.duration)
+	}
+
+	report.Time = junitTime(total)
+
+	bs, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(r.Output, xml.Header)
+	fmt.Fprintln(r.Output, string(bs))
+	return nil
+}
+
+func junitTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// TAPReporter reports test results in the Test Anything Protocol (TAP)
+// version 13 format. Failures and errors include a YAML diagnostic block.
+type TAPReporter struct {
+	Output io.Writer
+}
+
+// Report prints the test report to the reporter's output.
+func (r TAPReporter) Report(ch chan *Result) error {
+
+	fmt.Fprintln(r.Output, "TAP version 13")
+
+	var n int
+
+	for tr := range ch {
+		n++
+
+		name := fmt.Sprintf("%v.%v", tr.Package, tr.Name)
+
+		switch {
+		case tr.Skip:
+			fmt.Fprintf(r.Output, "ok %d - %v # SKIP\n", n, name)
+		case tr.Pass():
+			fmt.Fprintf(r.Output, "ok %d - %v\n", n, name)
+		case tr.Error != nil:
+			fmt.Fprintf(r.Output, "not ok %d - %v\n", n, name)
+			r.diagnostic(tr, "error", tr.Error.Error())
+		default:
+			fmt.Fprintf(r.Output, "not ok %d - %v\n", n, name)
+			r.diagnostic(tr, "fail", failureMessage(tr))
 		}
 	}
-	report := r.Cover.Report(r.Modules)
 
-	if report.Coverage < r.Threshold {
-		return &cover.CoverageThresholdError{
-			Coverage:  report.Coverage,
-			Threshold: r.Threshold,
+	// The plan is allowed to follow the test lines so that results can be
+	// reported as they arrive.
+	fmt.Fprintf(r.Output, "1..%d\n", n)
+
+	return nil
+}
+
+func (r TAPReporter) diagnostic(tr *Result, severity, message string) {
+	fmt.Fprintln(r.Output, "  ---")
+	fmt.Fprintf(r.Output, "  message: %q\n", message)
+	fmt.Fprintf(r.Output, "  severity: %v\n", severity)
+	if tr.Location != nil {
+		fmt.Fprintln(r.Output, "  at:")
+		fmt.Fprintf(r.Output, "    file: %q\n", tr.Location.File)
+		fmt.Fprintf(r.Output, "    line: %d\n", tr.Location.Row)
+	}
+	if severity == "fail" {
+		if details := failureDetails(tr); details != "" {
+			fmt.Fprintln(r.Output, "  data: |")
+			for _, line := range strings.Split(strings.TrimRight(details, "\n"), "\n") {
+				if line == "" {
+					fmt.Fprintln(r.Output)
+				} else {
+					fmt.Fprintf(r.Output, "    %v\n", line)
+				}
+			}
 		}
 	}
+	fmt.Fprintln(r.Output, "  ...")
+}
 
-	encoder := json.NewEncoder(r.Output)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(report)
+// failureMessage returns a one line description of the failed test including
+// the location of the expression that failed, if known.
+func failureMessage(tr *Result) string {
+	if tr.FailedAt != nil && tr.FailedAt.Location != nil {
+		return fmt.Sprintf("%v.%v failed at %v:%d", tr.Package, tr.Name, tr.FailedAt.Location.File, tr.FailedAt.Location.Row)
+	}
+	return fmt.Sprintf("%v.%v failed", tr.Package, tr.Name)
+}
+
+// failureDetails returns the expression that failed and the trace of the
+// failed test, if available.
+func failureDetails(tr *Result) string {
+	var buf bytes.Buffer
+	if tr.FailedAt != nil {
+		if tr.FailedAt.Location != nil && len(tr.FailedAt.Location.Text) > 0 {
+			fmt.Fprintln(&buf, string(tr.FailedAt.Location.Text))
+		} else {
+			fmt.Fprintln(&buf, tr.FailedAt)
+		}
+	}
+	if len(tr.Trace) > 0 {
+		if buf.Len() > 0 {
+			fmt.Fprintln(&buf)
+		}
+		topdown.PrettyTraceWithLocation(&buf, tr.Trace)
+	}
+	return buf.String()
 }
 
 type indentingWriter struct {
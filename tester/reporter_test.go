@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/topdown"
@@ -764,6 +765,116 @@ func TestPrettyReporterFmtBenchmark(t *testing.T) {
 	}
 }
 
+func getJUnitTAPResults() []*Result {
+	failedAt := ast.MustParseExpr("x == 2")
+	failedAt.Location = &ast.Location{File: "policy.rego", Row: 5, Text: []byte("x == 2")}
+
+	return []*Result{
+		{
+			Package:  "data.foo.bar",
+			Name:     "test_baz",
+			Location: &ast.Location{File: "policy.rego", Row: 3},
+			Duration: 1500 * time.Microsecond,
+		},
+		{
+			Package:  "data.foo.bar",
+			Name:     "test_qux",
+			Location: &ast.Location{File: "policy.rego", Row: 4},
+			Error:    fmt.Errorf("some err"),
+		},
+		{
+			Package:  "data.foo.bar",
+			Name:     "test_corge",
+			Location: &ast.Location{File: "policy.rego", Row: 5},
+			Fail:     true,
+			FailedAt: failedAt,
+			Trace:    getFakeTraceEvents(),
+		},
+		{
+			Package: "data.foo.baz",
+			Name:    "todo_test_grault",
+			Skip:    true,
+		},
+	}
+}
+
+func TestJUnitReporter(t *testing.T) {
+	var buf bytes.Buffer
+
+	r := JUnitReporter{
+		Output: &buf,
+	}
+
+	if err := r.Report(resultsChan(getJUnitTAPResults())); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites tests="4" failures="1" errors="1" skipped="1" time="0.002">
+  <testsuite name="data.foo.bar" tests="3" failures="1" errors="1" skipped="0" time="0.002">
+    <testcase name="test_baz" classname="data.foo.bar" time="0.002" file="policy.rego" line="3"></testcase>
+    <testcase name="test_qux" classname="data.foo.bar" time="0.000" file="policy.rego" line="4">
+      <error message="some err" type="error"></error>
+    </testcase>
+    <testcase name="test_corge" classname="data.foo.bar" time="0.000" file="policy.rego" line="5">
+      <failure message="data.foo.bar.test_corge failed at policy.rego:5" type="failure">x == 2&#xA;&#xA;query:1     | Fail true = false&#xA;</failure>
+    </testcase>
+  </testsuite>
+  <testsuite name="data.foo.baz" tests="1" failures="0" errors="0" skipped="1" time="0.000">
+    <testcase name="todo_test_grault" classname="data.foo.baz" time="0.000">
+      <skipped></skipped>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+
+	if exp != buf.String() {
+		t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp, buf.String())
+	}
+}
+
+func TestTAPReporter(t *testing.T) {
+	var buf bytes.Buffer
+
+	r := TAPReporter{
+		Output: &buf,
+	}
+
+	if err := r.Report(resultsChan(getJUnitTAPResults())); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `TAP version 13
+ok 1 - data.foo.bar.test_baz
+not ok 2 - data.foo.bar.test_qux
+  ---
+  message: "some err"
+  severity: error
+  at:
+    file: "policy.rego"
+    line: 4
+  ...
+not ok 3 - data.foo.bar.test_corge
+  ---
+  message: "data.foo.bar.test_corge failed at policy.rego:5"
+  severity: fail
+  at:
+    file: "policy.rego"
+    line: 5
+  data: |
+    x == 2
+
+    query:1     | Fail true = false
+  ...
+ok 4 - data.foo.baz.todo_test_grault # SKIP
+1..4
+`
+
+	if exp != buf.String() {
+		t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp, buf.String())
+	}
+}
+
 func resultsChan(ts []*Result) chan *Result {
 	ch := make(chan *Result)
 	go func() {
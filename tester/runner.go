@@ -11,6 +11,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -29,6 +30,9 @@ import (
 // TestPrefix declares the prefix for all test rules.
 const TestPrefix = "test_"
 
+// SkipTestPrefix declares the prefix for tests that should be skipped.
+const SkipTestPrefix = "todo_test_"
+
 // Run executes all test cases found under files in path.
 func Run(ctx context.Context, paths ...string) ([]*Result, error) {
 	return RunWithFilter(ctx, nil, paths...)
@@ -58,6 +62,7 @@ type Result struct {
 	Package         string                   `json:"package"`
 	Name            string                   `json:"name"`
 	Fail            bool                     `json:"fail,omitempty"`
+	Skip            bool                     `json:"skip,omitempty"`
 	Error           error                    `json:"error,omitempty"`
 	Duration        time.Duration            `json:"duration"`
 	Trace           []*topdown.Event         `json:"trace,omitempty"`
@@ -77,7 +82,7 @@ func newResult(loc *ast.Location, pkg, name string, duration time.Duration, trac
 
 // Pass returns true if the test case passed.
 func (r Result) Pass() bool {
-	return !r.Fail && r.Error == nil
+	return !r.Fail && !r.Skip && r.Error == nil
 }
 
 func (r *Result) String() string {
@@ -91,6 +96,9 @@ func (r *Result) outcome() string {
 	if r.Fail {
 		return "FAIL"
 	}
+	if r.Skip {
+		return "SKIPPED"
+	}
 	return "ERROR"
 }
 
@@ -111,6 +119,9 @@ type Runner struct {
 	modules     map[string]*ast.Module
 	bundles     map[string]*bundle.Bundle
 	filter      string
+	parallel    int
+	coverMtx    sync.Mutex
+	affected    []ast.Ref
 }
 
 // NewRunner returns a new runner.
@@ -206,6 +217,25 @@ func (r *Runner) Filter(regex string) *Runner {
 	return r
 }
 
+// SetAffectedPackages restricts the tests that are run to tests in the given
+// packages and tests that depend on rules or documents in the given packages.
+// Dependencies are determined from the rule graph of the compiled modules. If
+// pkgs is nil, all tests are run.
+func (r *Runner) SetAffectedPackages(pkgs []ast.Ref) *Runner {
+	r.affected = pkgs
+	return r
+}
+
+// Parallel sets the number of test cases that are evaluated concurrently. Test
+// cases share the compiler and the transaction passed to RunTests, so the
+// transaction must not be written to while tests are running. Results are
+// delivered in the same order as when tests are run sequentially. Benchmarks
+// are always run sequentially.
+func (r *Runner) Parallel(n int) *Runner {
+	r.parallel = n
+	return r
+}
+
 func getFailedAtFromTrace(bufFailureLineTracer *topdown.BufferTracer) *ast.Expr {
 	events := *bufFailureLineTracer
 	const SecondToLast = 2
@@ -230,18 +260,25 @@ func (r *Runner) Run(ctx context.Context, modules map[string]*ast.Module) (ch ch
 
 // RunTests executes tests found in either modules or bundles loaded on the runner.
 func (r *Runner) RunTests(ctx context.Context, txn storage.Transaction) (ch chan *Result, err error) {
-	return r.runTests(ctx, txn, r.runTest)
+	return r.runTests(ctx, txn, r.parallel, r.runTest)
 }
 
 // RunBenchmarks executes tests similar to tester.Runner#RunTests but will repeat
 // a number of times to get stable performance metrics.
 func (r *Runner) RunBenchmarks(ctx context.Context, txn storage.Transaction, options BenchmarkOptions) (ch chan *Result, err error) {
-	return r.runTests(ctx, txn, func(ctx context.Context, txn storage.Transaction, module *ast.Module, rule *ast.Rule) (result *Result, b bool) {
+	return r.runTests(ctx, txn, 1, func(ctx context.Context, txn storage.Transaction, module *ast.Module, rule *ast.Rule) (result *Result, b bool) {
 		return r.runBenchmark(ctx, txn, module, rule, options)
 	})
 }
 
-func (r *Runner) runTests(ctx context.Context, txn storage.Transaction, runFunc func(context.Context, storage.Transaction, *ast.Module, *ast.Rule) (*Result, bool)) (ch chan *Result, err error) {
+type runFunc func(context.Context, storage.Transaction, *ast.Module, *ast.Rule) (*Result, bool)
+
+type testCase struct {
+	module *ast.Module
+	rule   *ast.Rule
+}
+
+func (r *Runner) runTests(ctx context.Context, txn storage.Transaction, parallel int, runFunc runFunc) (ch chan *Result, err error) {
 	var testRegex *regexp.Regexp
 	if r.filter != "" {
 		var err error
@@ -310,37 +347,111 @@ func (r *Runner) runTests(ctx context.Context, txn storage.Transaction, runFunc
 
 	sort.Strings(filenames)
 
+	var tests []testCase
+	var affected func(*ast.Rule) bool
+
+	if r.affected != nil {
+		affected = newAffectedRules(r.compiler, r.affected)
+	}
+
+	for _, name := range filenames {
+		module := r.compiler.Modules[name]
+		for _, rule := range module.Rules {
+			if !r.shouldRun(rule, testRegex) {
+				continue
+			}
+			if affected != nil && !affected(rule) {
+				continue
+			}
+			tests = append(tests, testCase{module: module, rule: rule})
+		}
+	}
+
 	ch = make(chan *Result)
 
-	go func() {
-		defer close(ch)
-		for _, name := range filenames {
-			module := r.compiler.Modules[name]
-			for _, rule := range module.Rules {
-				if !r.shouldRun(rule, testRegex) {
-					continue
-				}
-				tr, stop := func() (*Result, bool) {
-					runCtx, cancel := context.WithTimeout(ctx, r.timeout)
-					defer cancel()
-					return runFunc(runCtx, txn, module, rule)
-				}()
+	if parallel > 1 {
+		go r.runParallel(ctx, txn, parallel, tests, runFunc, ch)
+	} else {
+		go func() {
+			defer close(ch)
+			for _, tc := range tests {
+				tr, stop := r.runTestCase(ctx, txn, tc, runFunc)
 				ch <- tr
 				if stop {
 					return
 				}
 			}
+		}()
+	}
+
+	return ch, nil
+}
+
+// runParallel evaluates tests on n goroutines. Results are sent on ch in the
+// order of tests. If a test requests the run to stop, the results of
+// subsequent tests are discarded and the remaining tests are canceled.
+func (r *Runner) runParallel(ctx context.Context, txn storage.Transaction, n int, tests []testCase, runFunc runFunc, ch chan *Result) {
+	defer close(ch)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type outcome struct {
+		result *Result
+		stop   bool
+	}
+
+	outcomes := make([]chan outcome, len(tests))
+	for i := range outcomes {
+		outcomes[i] = make(chan outcome, 1)
+	}
+
+	next := make(chan int)
+
+	go func() {
+		defer close(next)
+		for i := range tests {
+			next <- i
 		}
 	}()
 
-	return ch, nil
+	for w := 0; w < n; w++ {
+		go func() {
+			for i := range next {
+				tr, stop := r.runTestCase(ctx, txn, tests[i], runFunc)
+				outcomes[i] <- outcome{result: tr, stop: stop}
+			}
+		}()
+	}
+
+	for i := range outcomes {
+		o := <-outcomes[i]
+		ch <- o.result
+		if o.stop {
+			return
+		}
+	}
+}
+
+func (r *Runner) runTestCase(ctx context.Context, txn storage.Transaction, tc testCase, runFunc runFunc) (*Result, bool) {
+	if strings.HasPrefix(string(tc.rule.Head.Name), SkipTestPrefix) {
+		return &Result{
+			Location: tc.rule.Loc(),
+			Package:  tc.module.Package.Path.String(),
+			Name:     string(tc.rule.Head.Name),
+			Skip:     true,
+		}, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return runFunc(ctx, txn, tc.module, tc.rule)
 }
 
 func (r *Runner) shouldRun(rule *ast.Rule, testRegex *regexp.Regexp) bool {
 	ruleName := string(rule.Head.Name)
 
 	// All tests must have the right prefix
-	if !strings.HasPrefix(ruleName, TestPrefix) {
+	if !strings.HasPrefix(ruleName, TestPrefix) && !strings.HasPrefix(ruleName, SkipTestPrefix) {
 		return false
 	}
 
@@ -353,6 +464,67 @@ func (r *Runner) shouldRun(rule *ast.Rule, testRegex *regexp.Regexp) bool {
 	return true
 }
 
+// newAffectedRules returns a function that reports whether a rule is affected by
+// changes to pkgs. A rule is affected if it is defined in one of the packages,
+// refers to a document in one of the packages (e.g., a rule that was removed),
+// or depends on an affected rule.
+func newAffectedRules(compiler *ast.Compiler, pkgs []ast.Ref) func(*ast.Rule) bool {
+
+	memo := map[*ast.Rule]bool{}
+
+	var affected func(*ast.Rule) bool
+
+	affected = func(rule *ast.Rule) bool {
+		if result, ok := memo[rule]; ok {
+			return result
+		}
+
+		// Rule graphs are acyclic so there is no risk of the dependency walk
+		// below visiting rule again before the result is recorded.
+		result := refersToPackages(rule, pkgs)
+
+		if !result {
+			for dep := range compiler.Graph.Dependencies(rule) {
+				if affected(dep.(*ast.Rule)) {
+					result = true
+					break
+				}
+			}
+		}
+
+		memo[rule] = result
+		return result
+	}
+
+	return affected
+}
+
+func refersToPackages(rule *ast.Rule, pkgs []ast.Ref) bool {
+	for _, pkg := range pkgs {
+		if rule.Module.Package.Path.Equal(pkg) {
+			return true
+		}
+	}
+
+	found := false
+
+	ast.WalkRefs(rule, func(ref ast.Ref) bool {
+		if found || !ref[0].Equal(ast.DefaultRootDocument) {
+			return found
+		}
+		prefix := ref.ConstantPrefix()
+		for _, pkg := range pkgs {
+			if prefix.HasPrefix(pkg) || pkg.HasPrefix(prefix) {
+				found = true
+				break
+			}
+		}
+		return found
+	})
+
+	return found
+}
+
 // rewriteDuplicateTestNames will rewrite duplicate test names to have a numbered suffix.
 // This uses a global "count" of each to ensure compiling more than once as new modules
 // are added can't introduce duplicates again.
@@ -361,7 +533,7 @@ func rewriteDuplicateTestNames(compiler *ast.Compiler) *ast.Error {
 	for _, mod := range compiler.Modules {
 		for _, rule := range mod.Rules {
 			name := rule.Head.Name.String()
-			if !strings.HasPrefix(name, TestPrefix) {
+			if !strings.HasPrefix(name, TestPrefix) && !strings.HasPrefix(name, SkipTestPrefix) {
 				continue
 			}
 			key := rule.Path().String()
@@ -382,6 +554,9 @@ func (r *Runner) runTest(ctx context.Context, txn storage.Transaction, mod *ast.
 
 	if r.cover != nil {
 		tracer = r.cover
+		if r.parallel > 1 {
+			tracer = &syncQueryTracer{mtx: &r.coverMtx, tracer: r.cover}
+		}
 	} else if r.trace {
 		bufferTracer = topdown.NewBufferTracer()
 		tracer = bufferTracer
@@ -421,6 +596,8 @@ func (r *Runner) runTest(ctx context.Context, txn storage.Transaction, mod *ast.
 		tr.Fail = true
 		if bufFailureLineTracer != nil {
 			tr.FailedAt = getFailedAtFromTrace(bufFailureLineTracer)
+		} else if r.failureLine && bufferTracer != nil {
+			tr.FailedAt = getFailedAtFromTrace(bufferTracer)
 		}
 	} else if b, ok := rs[0].Expressions[0].Value.(bool); !ok || !b {
 		tr.Fail = true
@@ -429,6 +606,27 @@ func (r *Runner) runTest(ctx context.Context, txn storage.Transaction, mod *ast.
 	return tr, stop
 }
 
+// syncQueryTracer serializes calls to a tracer shared by concurrently evaluated
+// tests (e.g., the coverage tracer.)
+type syncQueryTracer struct {
+	mtx    *sync.Mutex
+	tracer topdown.QueryTracer
+}
+
+func (t *syncQueryTracer) Enabled() bool {
+	return t.tracer.Enabled()
+}
+
+func (t *syncQueryTracer) TraceEvent(evt topdown.Event) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.tracer.TraceEvent(evt)
+}
+
+func (t *syncQueryTracer) Config() topdown.TraceConfig {
+	return t.tracer.Config()
+}
+
 func (r *Runner) runBenchmark(ctx context.Context, txn storage.Transaction, mod *ast.Module, rule *ast.Rule, options BenchmarkOptions) (*Result, bool) {
 	tr := &Result{
 		Location: rule.Loc(),
@@ -534,9 +732,19 @@ func Load(args []string, filter loader.Filter) (map[string]*ast.Module, storage.
 
 // LoadBundles will load the given args as bundles, either tarball or directory is OK.
 func LoadBundles(args []string, filter loader.Filter) (map[string]*bundle.Bundle, error) {
+	return LoadBundlesWithVerification(args, filter, nil)
+}
+
+// LoadBundlesWithVerification will load the given args as bundles and verify
+// their signatures with the given key configuration. If the configuration is
+// nil, signatures are not verified.
+func LoadBundlesWithVerification(args []string, filter loader.Filter, bvc *bundle.VerificationConfig) (map[string]*bundle.Bundle, error) {
 	bundles := map[string]*bundle.Bundle{}
 	for _, bundleDir := range args {
-		b, err := loader.NewFileLoader().WithSkipBundleVerification(true).AsBundle(bundleDir)
+		b, err := loader.NewFileLoader().
+			WithBundleVerificationConfig(bvc).
+			WithSkipBundleVerification(bvc == nil).
+			AsBundle(bundleDir)
 		if err != nil {
 			return nil, fmt.Errorf("unable to load bundle %s: %s", bundleDir, err)
 		}
@@ -6,6 +6,8 @@ package tester_test
 
 import (
 	"context"
+	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
@@ -110,6 +112,148 @@ func TestRunWithCoverage(t *testing.T) {
 	}
 }
 
+func TestRunParallel(t *testing.T) {
+	testRun(t, testRunConfig{parallel: 4})
+}
+
+func TestRunParallelOrder(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 10; i++ {
+		files[fmt.Sprintf("/%d_test.rego", i)] = fmt.Sprintf(`package p%d
+			test_a { true }
+			test_b { false }
+			todo_test_c { true }
+			test_d { x := 1; x > 0 }
+			`, i)
+	}
+
+	test.WithTempFS(files, func(d string) {
+		exp, _ := doTestRunWithTmpDir(t, d, testRunConfig{})
+		got, _ := doTestRunWithTmpDir(t, d, testRunConfig{parallel: 8})
+
+		if len(exp) != len(got) {
+			t.Fatalf("Expected %d results but got %d", len(exp), len(got))
+		}
+
+		for i := range exp {
+			if exp[i].Package != got[i].Package || exp[i].Name != got[i].Name || exp[i].Pass() != got[i].Pass() {
+				t.Fatalf("Expected result %d to be %v but got %v", i, exp[i], got[i])
+			}
+		}
+	})
+}
+
+func TestRunAffectedPackages(t *testing.T) {
+	files := map[string]string{
+		"/a.rego": `package a
+			allow { input.x == 1 }
+			`,
+		"/a_test.rego": `package a
+			test_allow { allow with input as {"x": 1} }
+			`,
+		"/b_test.rego": `package b
+			test_uses_a { data.a.allow with input as {"x": 1} }
+			test_independent { true }
+			`,
+		"/c_test.rego": `package c
+			import data.b
+			helper { b.test_uses_a }
+			test_transitive { helper }
+			test_removed { not data.removed.p }
+			`,
+	}
+
+	tests := []struct {
+		note string
+		pkgs []ast.Ref
+		exp  []string
+	}{
+		{
+			note: "all",
+			exp:  []string{"test_allow", "test_uses_a", "test_independent", "test_transitive", "test_removed"},
+		},
+		{
+			note: "dependents",
+			pkgs: []ast.Ref{ast.MustParseRef("data.a")},
+			exp:  []string{"test_allow", "test_uses_a", "test_transitive"},
+		},
+		{
+			note: "removed package",
+			pkgs: []ast.Ref{ast.MustParseRef("data.removed")},
+			exp:  []string{"test_removed"},
+		},
+		{
+			note: "none",
+			pkgs: []ast.Ref{},
+			exp:  nil,
+		},
+	}
+
+	test.WithTempFS(files, func(d string) {
+		for _, tc := range tests {
+			t.Run(tc.note, func(t *testing.T) {
+				ctx := context.Background()
+				modules, store, err := tester.Load([]string{d}, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				txn := storage.NewTransactionOrDie(ctx, store)
+				defer store.Abort(ctx, txn)
+
+				ch, err := tester.NewRunner().
+					SetStore(store).
+					SetModules(modules).
+					SetAffectedPackages(tc.pkgs).
+					RunTests(ctx, txn)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var names []string
+				for r := range ch {
+					if !r.Pass() {
+						t.Errorf("Expected %v to pass", r)
+					}
+					names = append(names, r.Name)
+				}
+
+				if !reflect.DeepEqual(names, tc.exp) {
+					t.Fatalf("Expected %v but got %v", tc.exp, names)
+				}
+			})
+		}
+	})
+}
+
+func TestRunWithCoverageParallel(t *testing.T) {
+	files := map[string]string{
+		"/a.rego": `package foo
+			allow { input.x == 1 }
+			deny { input.x == 2 }
+			`,
+		"/a_test.rego": `package foo
+			test_allow { allow with input as {"x": 1} }
+			test_not_allow { not allow with input as {"x": 2} }
+			test_deny { deny with input as {"x": 2} }
+			`,
+	}
+
+	test.WithTempFS(files, func(d string) {
+		sequential := cover.New()
+		_, modules := doTestRunWithTmpDir(t, d, testRunConfig{coverTracer: sequential})
+		parallel := cover.New()
+		doTestRunWithTmpDir(t, d, testRunConfig{coverTracer: parallel, parallel: 4})
+
+		exp := sequential.Report(modules)
+		got := parallel.Report(modules)
+
+		if !reflect.DeepEqual(exp, got) {
+			t.Fatalf("Expected coverage report:\n\n%v\n\nGot:\n\n%v", exp, got)
+		}
+	})
+}
+
 type expectedTestResult struct {
 	wantErr  bool
 	wantFail bool
@@ -119,6 +263,7 @@ type testRunConfig struct {
 	bench       bool
 	filter      string
 	coverTracer topdown.QueryTracer
+	parallel    int
 }
 
 type expectedTestResults map[[2]string]expectedTestResult
@@ -184,7 +329,8 @@ func doTestRunWithTmpDir(t *testing.T, dir string, conf testRunConfig) ([]*teste
 		SetModules(modules).
 		Filter(conf.filter).
 		SetTimeout(60 * time.Second).
-		SetCoverageQueryTracer(conf.coverTracer)
+		SetCoverageQueryTracer(conf.coverTracer).
+		Parallel(conf.parallel)
 
 	var ch chan *tester.Result
 	if conf.bench {
@@ -230,6 +376,36 @@ func validateTestResults(t *testing.T, tests expectedTestResults, rs []*tester.R
 	}
 }
 
+func TestRunSkip(t *testing.T) {
+	files := map[string]string{
+		"/a_test.rego": `package foo
+			test_pass { true }
+			todo_test_skip { false }
+			`,
+	}
+
+	test.WithTempFS(files, func(d string) {
+		rs, _ := doTestRunWithTmpDir(t, d, testRunConfig{})
+		if len(rs) != 2 {
+			t.Fatalf("Expected 2 results but got: %v", rs)
+		}
+		for _, r := range rs {
+			switch r.Name {
+			case "test_pass":
+				if !r.Pass() || r.Skip {
+					t.Errorf("Expected test_pass to pass but got: %v", r)
+				}
+			case "todo_test_skip":
+				if !r.Skip || r.Pass() || r.Fail {
+					t.Errorf("Expected todo_test_skip to be skipped but got: %v", r)
+				}
+			default:
+				t.Errorf("Unexpected result: %v", r)
+			}
+		}
+	})
+}
+
 func TestRunWithFilterRegex(t *testing.T) {
 	files := map[string]string{
 		"/a.rego": `package foo
@@ -356,14 +532,18 @@ func TestRunWithFilterRegex(t *testing.T) {
 }
 
 func TestRunnerCancel(t *testing.T) {
-	testCancel(t, false)
+	testCancel(t, false, 0)
+}
+
+func TestRunnerCancelParallel(t *testing.T) {
+	testCancel(t, false, 4)
 }
 
 func TestRunnerCancelBenchmark(t *testing.T) {
-	testCancel(t, true)
+	testCancel(t, true, 0)
 }
 
-func testCancel(t *testing.T, bench bool) {
+func testCancel(t *testing.T, bench bool, parallel int) {
 
 	registerSleepBuiltin()
 
@@ -386,7 +566,7 @@ func testCancel(t *testing.T, bench bool) {
 		}
 
 		txn := storage.NewTransactionOrDie(ctx, store)
-		runner := tester.NewRunner().SetStore(store).SetModules(modules)
+		runner := tester.NewRunner().SetStore(store).SetModules(modules).Parallel(parallel)
 
 		// Everything below uses a canceled context..
 		cancel()
@@ -416,14 +596,18 @@ func testCancel(t *testing.T, bench bool) {
 }
 
 func TestRunnerTimeout(t *testing.T) {
-	testTimeout(t, false)
+	testTimeout(t, false, 0)
+}
+
+func TestRunnerTimeoutParallel(t *testing.T) {
+	testTimeout(t, false, 2)
 }
 
 func TestRunnerTimeoutBenchmark(t *testing.T) {
-	testTimeout(t, true)
+	testTimeout(t, true, 0)
 }
 
-func testTimeout(t *testing.T, bench bool) {
+func testTimeout(t *testing.T, bench bool, parallel int) {
 	registerSleepBuiltin()
 
 	ctx := context.Background()
@@ -450,7 +634,7 @@ func testTimeout(t *testing.T, bench bool) {
 		}
 
 		txn := storage.NewTransactionOrDie(ctx, store)
-		runner := tester.NewRunner().SetTimeout(duration).SetStore(store).SetModules(modules)
+		runner := tester.NewRunner().SetTimeout(duration).SetStore(store).SetModules(modules).Parallel(parallel)
 
 		var ch chan *tester.Result
 		if bench {
@@ -165,6 +165,55 @@ func (s *refStack) Prefixed(ref ast.Ref) bool {
 	return false
 }
 
+// functionMocksStack tracks the built-in and user-defined functions replaced by
+// with modifiers. Frames are pushed for each with modifier. Elements are
+// pushed when a replacement function is evaluated so that the replacement
+// does not observe the mocks that are in effect at the call site.
+type functionMocksStack struct {
+	stack []*functionMocksElem
+}
+
+type functionMocksElem []frame
+
+type frame map[string]*ast.Term
+
+func newFunctionMocksStack() *functionMocksStack {
+	stack := &functionMocksStack{}
+	stack.Push()
+	return stack
+}
+
+func (s *functionMocksStack) Push() {
+	s.stack = append(s.stack, &functionMocksElem{})
+}
+
+func (s *functionMocksStack) Pop() {
+	s.stack = s.stack[:len(s.stack)-1]
+}
+
+func (s *functionMocksStack) PushPairs(p [][2]*ast.Term) {
+	current := s.stack[len(s.stack)-1]
+	*current = append(*current, frame{})
+	for i := range p {
+		(*current)[len(*current)-1][p[i][0].Value.String()] = p[i][1]
+	}
+}
+
+func (s *functionMocksStack) PopPairs() {
+	current := s.stack[len(s.stack)-1]
+	*current = (*current)[:len(*current)-1]
+}
+
+func (s *functionMocksStack) Get(f ast.Ref) (*ast.Term, bool) {
+	current := *s.stack[len(s.stack)-1]
+	for i := len(current) - 1; i >= 0; i-- {
+		if r, ok := current[i][f.String()]; ok {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
 type comprehensionCache struct {
 	stack []map[*ast.Term]*comprehensionCacheElem
 }
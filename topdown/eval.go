@@ -50,6 +50,7 @@ type eval struct {
 	input              *ast.Term
 	data               *ast.Term
 	targetStack        *refStack
+	functionMocks      *functionMocksStack
 	tracers            []QueryTracer
 	traceEnabled       bool
 	plugTraceVars      bool
@@ -228,6 +229,12 @@ func (e *eval) traceEvent(op Op, x ast.Node, msg string) {
 	for i := range e.tracers {
 		e.tracers[i].TraceEvent(evt)
 	}
+
+	for i := range e.tracers {
+		if st, ok := e.tracers[i].(StepTracer); ok {
+			st.TraceStep(evt, &StepState{e: e})
+		}
+	}
 }
 
 func (e *eval) eval(iter evalIterator) error {
@@ -353,6 +360,26 @@ func (e *eval) evalNot(iter evalIterator) error {
 func (e *eval) evalWith(iter evalIterator) error {
 
 	expr := e.query[e.index]
+
+	pairsInput := [][2]*ast.Term{}
+	pairsData := [][2]*ast.Term{}
+	functionMocks := [][2]*ast.Term{}
+	targets := []ast.Ref{}
+
+	for i := range expr.With {
+		target := expr.With[i].Target
+		plugged := e.bindings.Plug(expr.With[i].Value)
+		if isFunction(e.compiler, target.Value.(ast.Ref)) {
+			functionMocks = append(functionMocks, [...]*ast.Term{target, plugged})
+			continue
+		} else if isInputRef(target) {
+			pairsInput = append(pairsInput, [...]*ast.Term{target, plugged})
+		} else if isDataRef(target) {
+			pairsData = append(pairsData, [...]*ast.Term{target, plugged})
+		}
+		targets = append(targets, target.Value.(ast.Ref))
+	}
+
 	var disable []ast.Ref
 
 	if e.partial() {
@@ -371,25 +398,14 @@ func (e *eval) evalWith(iter evalIterator) error {
 
 		// Disable inlining on all references in the expression so the result of
 		// partial evaluation has the same semamntics w/ the with statements
-		// preserved.
-		ast.WalkRefs(expr, func(x ast.Ref) bool {
-			disable = append(disable, x.GroundPrefix())
-			return false
-		})
-	}
-
-	pairsInput := [][2]*ast.Term{}
-	pairsData := [][2]*ast.Term{}
-	targets := []ast.Ref{}
-
-	for i := range expr.With {
-		plugged := e.bindings.Plug(expr.With[i].Value)
-		if isInputRef(expr.With[i].Target) {
-			pairsInput = append(pairsInput, [...]*ast.Term{expr.With[i].Target, plugged})
-		} else if isDataRef(expr.With[i].Target) {
-			pairsData = append(pairsData, [...]*ast.Term{expr.With[i].Target, plugged})
+		// preserved. Function mocks are applied during partial evaluation so
+		// they do not require inlining to be disabled.
+		if len(targets) > 0 {
+			ast.WalkRefs(expr, func(x ast.Ref) bool {
+				disable = append(disable, x.GroundPrefix())
+				return false
+			})
 		}
-		targets = append(targets, expr.With[i].Target.Value.(ast.Ref))
 	}
 
 	input, err := mergeTermWithValues(e.input, pairsInput)
@@ -410,12 +426,12 @@ func (e *eval) evalWith(iter evalIterator) error {
 		}
 	}
 
-	oldInput, oldData := e.evalWithPush(input, data, targets, disable)
+	oldInput, oldData := e.evalWithPush(input, data, functionMocks, targets, disable)
 
 	err = e.evalStep(func(e *eval) error {
 		e.evalWithPop(oldInput, oldData)
 		err := e.next(iter)
-		oldInput, oldData = e.evalWithPush(input, data, targets, disable)
+		oldInput, oldData = e.evalWithPush(input, data, functionMocks, targets, disable)
 		return err
 	})
 
@@ -424,7 +440,7 @@ func (e *eval) evalWith(iter evalIterator) error {
 	return err
 }
 
-func (e *eval) evalWithPush(input *ast.Term, data *ast.Term, targets []ast.Ref, disable []ast.Ref) (*ast.Term, *ast.Term) {
+func (e *eval) evalWithPush(input, data *ast.Term, functionMocks [][2]*ast.Term, targets, disable []ast.Ref) (*ast.Term, *ast.Term) {
 
 	var oldInput *ast.Term
 
@@ -444,11 +460,13 @@ func (e *eval) evalWithPush(input *ast.Term, data *ast.Term, targets []ast.Ref,
 	e.virtualCache.Push()
 	e.targetStack.Push(targets)
 	e.inliningControl.PushDisable(disable, true)
+	e.functionMocks.PushPairs(functionMocks)
 
 	return oldInput, oldData
 }
 
 func (e *eval) evalWithPop(input *ast.Term, data *ast.Term) {
+	e.functionMocks.PopPairs()
 	e.inliningControl.PopDisable()
 	e.targetStack.Pop()
 	e.virtualCache.Pop()
@@ -588,6 +606,10 @@ func (e *eval) evalCall(terms []*ast.Term, iter unifyIterator) error {
 
 	ref := terms[0].Value.(ast.Ref)
 
+	if mock, ok := e.functionMocks.Get(ref); ok {
+		return e.evalCallMock(ref, mock, terms, iter)
+	}
+
 	if ref[0].Equal(ast.DefaultRootDocument) {
 		eval := evalFunc{
 			e:     e,
@@ -635,6 +657,49 @@ func (e *eval) evalCall(terms []*ast.Term, iter unifyIterator) error {
 	return eval.eval(iter)
 }
 
+// evalCallMock evaluates a call to a function that has been replaced by a with
+// modifier. If the replacement is a function, it is called with the operands
+// of the original call. Otherwise, the replacement is the result of the call.
+func (e *eval) evalCallMock(ref ast.Ref, mock *ast.Term, terms []*ast.Term, iter unifyIterator) error {
+
+	if mref, ok := mock.Value.(ast.Ref); ok && isFunction(e.compiler, mref) {
+		call := make([]*ast.Term, len(terms))
+		call[0] = ast.NewTerm(mref)
+		copy(call[1:], terms[1:])
+
+		// The replacement function is evaluated without the mocks in effect at
+		// the call site. This allows replacements to call the function they
+		// replace.
+		e.functionMocks.Push()
+		err := e.evalCall(call, func() error {
+			e.functionMocks.Pop()
+			err := iter()
+			e.functionMocks.Push()
+			return err
+		})
+		e.functionMocks.Pop()
+		return err
+	}
+
+	arity := e.compiler.GetArity(ref)
+	if arity < 0 {
+		bi, _, ok := e.builtinFunc(ref.String())
+		if !ok {
+			return unsupportedBuiltinErr(e.query[e.index].Location)
+		}
+		arity = len(bi.Decl.Args())
+	}
+
+	if len(terms)-1 == arity {
+		if mock.Value.Compare(ast.Boolean(false)) != 0 {
+			return iter()
+		}
+		return nil
+	}
+
+	return e.unify(terms[len(terms)-1], mock, iter)
+}
+
 func (e *eval) unify(a, b *ast.Term, iter unifyIterator) error {
 	return e.biunify(a, b, e.bindings, e.bindings, iter)
 }
@@ -2718,6 +2783,15 @@ func isDataRef(term *ast.Term) bool {
 	return false
 }
 
+// isFunction returns true if ref refers to a built-in or user-defined function.
+func isFunction(c *ast.Compiler, ref ast.Ref) bool {
+	if ref.HasPrefix(ast.DefaultRootRef) {
+		rules := c.GetRulesExact(ref)
+		return len(rules) > 0 && len(rules[0].Head.Args) > 0
+	}
+	return !ref.HasPrefix(ast.InputRootRef) && c.GetArity(ref) >= 0
+}
+
 func merge(a, b ast.Value) (ast.Value, bool) {
 	aObj, ok1 := a.(ast.Object)
 	bObj, ok2 := b.(ast.Object)
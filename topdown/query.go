@@ -250,6 +250,7 @@ func (q *Query) PartialRun(ctx context.Context) (partials []ast.Body, support []
 		store:              q.store,
 		baseCache:          newBaseCache(),
 		targetStack:        newRefStack(),
+		functionMocks:      newFunctionMocksStack(),
 		txn:                q.txn,
 		input:              q.input,
 		tracers:            q.tracers,
@@ -365,6 +366,7 @@ func (q *Query) Iter(ctx context.Context, iter func(QueryResult) error) error {
 		store:              q.store,
 		baseCache:          newBaseCache(),
 		targetStack:        newRefStack(),
+		functionMocks:      newFunctionMocksStack(),
 		txn:                q.txn,
 		input:              q.input,
 		tracers:            q.tracers,
@@ -0,0 +1,142 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"sort"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// StepTracer defines the interface for tracers that inspect the state of
+// evaluation, e.g., debuggers. For each event, TraceStep is called after
+// TraceEvent. Evaluation does not continue until TraceStep returns so the
+// tracer can pause evaluation by blocking. Evaluation can be stopped while
+// paused by cancelling the query.
+type StepTracer interface {
+	QueryTracer
+	TraceStep(Event, *StepState)
+}
+
+// StepState provides access to the state of evaluation when an event is
+// traced. The state is only valid until TraceStep returns.
+type StepState struct {
+	e *eval
+}
+
+// Frames returns the queries being evaluated. The query that emitted the
+// event is first and the query passed to evaluation is last.
+func (s *StepState) Frames() []StepFrame {
+	var frames []StepFrame
+	for e := s.e; e != nil; e = e.parent {
+		frames = append(frames, StepFrame{e: e})
+	}
+	return frames
+}
+
+// Depth returns the number of queries being evaluated.
+func (s *StepState) Depth() int {
+	n := 0
+	for e := s.e; e != nil; e = e.parent {
+		n++
+	}
+	return n
+}
+
+// VirtualCache returns the virtual documents that have been evaluated and
+// cached so far. The documents are sorted by reference.
+func (s *StepState) VirtualCache() []CachedDocument {
+
+	var result []CachedDocument
+	var walk func(ast.Ref, *virtualCacheElem)
+
+	walk = func(ref ast.Ref, node *virtualCacheElem) {
+		if node.value != nil {
+			result = append(result, CachedDocument{Ref: ref.Copy(), Value: node.value})
+		}
+		node.children.Iter(func(k, v util.T) bool {
+			walk(append(ref, k.(*ast.Term)), v.(*virtualCacheElem))
+			return false
+		})
+	}
+
+	cache := s.e.virtualCache
+	walk(nil, cache.stack[len(cache.stack)-1])
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Ref.Compare(result[j].Ref) < 0
+	})
+
+	return result
+}
+
+// CachedDocument is a virtual document stored in the evaluation cache.
+type CachedDocument struct {
+	Ref   ast.Ref
+	Value *ast.Term
+}
+
+// StepFrame represents a query on the evaluation stack.
+type StepFrame struct {
+	e *eval
+}
+
+// QueryID returns the identifier of the query. The identifier matches the
+// QueryID of the events emitted by the query.
+func (f StepFrame) QueryID() uint64 {
+	return f.e.queryID
+}
+
+// Query returns the query being evaluated.
+func (f StepFrame) Query() ast.Body {
+	return f.e.query
+}
+
+// Expr returns the expression of the query being evaluated. If all
+// expressions of the query have been evaluated, Expr returns nil.
+func (f StepFrame) Expr() *ast.Expr {
+	if f.e.index < len(f.e.query) {
+		return f.e.query[f.e.index]
+	}
+	return nil
+}
+
+// Bindings returns the variables bound in the query. The values are plugged
+// and the bindings are sorted by name.
+func (f StepFrame) Bindings() []Binding {
+
+	var result []Binding
+
+	f.e.bindings.Iter(nil, func(k, _ *ast.Term) error {
+		v := k.Value.(ast.Var)
+		name, _ := f.e.rewrittenVar(v)
+		result = append(result, Binding{
+			Var:      v,
+			Name:     name,
+			Value:    f.e.bindings.Plug(k),
+			Location: k.Loc(),
+		})
+		return nil
+	})
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name == result[j].Name {
+			return result[i].Var.Compare(result[j].Var) < 0
+		}
+		return result[i].Name.Compare(result[j].Name) < 0
+	})
+
+	return result
+}
+
+// Binding is a variable bound during evaluation. Var is the variable in the
+// compiled policy and Name is the variable as written by the user.
+type Binding struct {
+	Var      ast.Var
+	Name     ast.Var
+	Value    *ast.Term
+	Location *ast.Location
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+type testStepTracer struct {
+	steps []testStep
+}
+
+type testStep struct {
+	expr     *ast.Expr
+	depth    int
+	frames   []uint64
+	bindings map[string]string
+	cache    map[string]string
+}
+
+func (*testStepTracer) Enabled() bool        { return true }
+func (*testStepTracer) Config() TraceConfig  { return TraceConfig{} }
+func (*testStepTracer) TraceEvent(evt Event) {}
+func (t *testStepTracer) TraceStep(evt Event, state *StepState) {
+
+	if evt.Op != EvalOp {
+		return
+	}
+
+	step := testStep{
+		expr:     evt.Node.(*ast.Expr),
+		depth:    state.Depth(),
+		bindings: map[string]string{},
+		cache:    map[string]string{},
+	}
+
+	frames := state.Frames()
+	for _, f := range frames {
+		step.frames = append(step.frames, f.QueryID())
+	}
+
+	if frames[0].Expr() != step.expr {
+		panic(fmt.Sprintf("expected %v to be evaluated but got %v", step.expr, frames[0].Expr()))
+	}
+
+	for _, b := range frames[0].Bindings() {
+		step.bindings[string(b.Name)] = b.Value.String()
+	}
+
+	for _, doc := range state.VirtualCache() {
+		step.cache[doc.Ref.String()] = doc.Value.String()
+	}
+
+	t.steps = append(t.steps, step)
+}
+
+func TestStepTracer(t *testing.T) {
+
+	module := `package test
+
+	p = y { q; x := input.x; y := f(x) }
+	q { true }
+	f(a) = b { b := a + 1 }`
+
+	ctx := context.Background()
+	compiler := compileModules([]string{module})
+	store := inmem.New()
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	tracer := &testStepTracer{}
+	query := NewQuery(ast.MustParseBody("data.test.p = z")).
+		WithCompiler(compiler).
+		WithStore(store).
+		WithTransaction(txn).
+		WithInput(ast.MustParseTerm(`{"x": 1}`)).
+		WithQueryTracer(tracer)
+
+	rs, err := query.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rs) != 1 || !rs[0][ast.Var("z")].Equal(ast.IntNumberTerm(2)) {
+		t.Fatalf("Unexpected result: %v", rs)
+	}
+
+	var step *testStep
+	for i := range tracer.steps {
+		if tracer.steps[i].expr.Location.Row == 5 {
+			step = &tracer.steps[i]
+			break
+		}
+	}
+
+	if step == nil {
+		t.Fatal("Expected expression in function to be evaluated")
+	}
+
+	// The query, the rule and the function are on the stack.
+	if step.depth != 3 || len(step.frames) != 3 || step.frames[2] != 0 {
+		t.Fatalf("Unexpected stack: depth %d, frames %v", step.depth, step.frames)
+	}
+
+	if step.bindings["a"] != "1" {
+		t.Fatalf("Expected function argument to be bound but got %v", step.bindings)
+	}
+
+	if step.cache["data.test.q"] != "true" {
+		t.Fatalf("Expected rule to be cached but got %v", step.cache)
+	}
+}
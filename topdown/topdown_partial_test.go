@@ -614,6 +614,18 @@ func TestTopDownPartialEval(t *testing.T) {
 				q { 2 = input }`,
 			},
 		},
+		{
+			note:  "with: function mocks",
+			query: "data.test.p = true",
+			modules: []string{
+				`package test
+
+				p { q with http.send as mock }
+				q { http.send({"url": input.url}).status_code = 200 }
+				mock(req) = {"status_code": 200} { req.url = "a" }`,
+			},
+			wantQueries: []string{`"a" = input.url`},
+		},
 		{
 			note:  "with: unknown value",
 			query: "data.test.p = true",
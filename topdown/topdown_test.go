@@ -2406,6 +2406,70 @@ func TestTopDownWithKeyword(t *testing.T) {
 				y = data.ex.s with input as {"a": "b"}
 			}`},
 		},
+		{
+			note: "with mock function",
+			exp:  `[2, 10]`,
+			modules: []string{`package ex
+			f(x) = y { y = x + 1 }
+			g(x) = y { y = x * 10 }
+			h = y { y = data.ex.f(1) }`},
+			rules: []string{`p = [x, y] { x = data.ex.h; y = data.ex.h with data.ex.f as data.ex.g }`},
+		},
+		{
+			note: "with mock function value",
+			exp:  `7`,
+			modules: []string{`package ex
+			f(x) = y { y = x + 1 }
+			h = y { y = data.ex.f(1) }`},
+			rules: []string{`p = x { x = data.ex.h with data.ex.f as 7 }`},
+		},
+		{
+			note: "with mock function value false",
+			exp:  `true`,
+			modules: []string{`package ex
+			f(x) { x = 1 }
+			h { data.ex.f(1) }`},
+			rules: []string{`p { not data.ex.h with data.ex.f as false }`},
+		},
+		{
+			note: "with mock builtin",
+			exp:  `[1, 100]`,
+			modules: []string{`package ex
+			c = n { n = count([1]) }
+			mock_count(x) = 100`},
+			rules: []string{`p = [x, y] { x = data.ex.c; y = data.ex.c with count as data.ex.mock_count }`},
+		},
+		{
+			note: "with mock builtin value",
+			exp:  `{"status_code": 200}`,
+			modules: []string{`package ex
+			resp = r { r = http.send({"method": "GET", "url": "http://localhost:1"}) }`},
+			rules: []string{`p = x { x = data.ex.resp with http.send as {"status_code": 200} }`},
+		},
+		{
+			note: "with mock builtin with builtin",
+			exp:  `6`,
+			modules: []string{`package ex
+			c = n { n = count([1, 2, 3]) }`},
+			rules: []string{`p = x { x = data.ex.c with count as sum }`},
+		},
+		{
+			note: "with mock replacement calls replaced function",
+			exp:  `101`,
+			modules: []string{`package ex
+			c = n { n = count([1]) }
+			wrap_count(x) = n { n = count(x) + 100 }`},
+			rules: []string{`p = x { x = data.ex.c with count as data.ex.wrap_count }`},
+		},
+		{
+			note: "with mock nested",
+			exp:  `[3, 7]`,
+			modules: []string{`package ex
+			f(x) = 1
+			h = y { y = data.ex.f(1) }
+			i = [x, y] { x = data.ex.h with data.ex.f as 3; y = data.ex.h }`},
+			rules: []string{`p = x { x = data.ex.i with data.ex.f as 7 }`},
+		},
 	}
 
 	for _, tc := range tests {